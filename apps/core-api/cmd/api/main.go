@@ -12,6 +12,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
@@ -26,20 +28,37 @@ import (
 	shareddb "github.com/kalen1o/iphone-storage/shared/db"
 	"github.com/kalen1o/iphone-storage/shared/kafka"
 	"github.com/kalen1o/iphone-storage/shared/logging"
+	sharedredis "github.com/kalen1o/iphone-storage/shared/redis"
 
+	accountcontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/account/controller"
+	accountrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/account/repo"
+	accountservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/account/service"
+	apikeycontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/controller"
+	apikeyrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/repo"
+	apikeyservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/service"
 	authcontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/controller"
 	authrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/repo"
 	authservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/service"
+	giftcardcontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/giftcards/controller"
+	giftcardrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/giftcards/repo"
+	giftcardservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/giftcards/service"
 	inventorycontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/controller"
 	inventoryrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
 	inventoryservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/service"
 	ordercontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/controller"
 	orderrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/repo"
 	orderservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/cache"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/storage"
 	productcontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/products/controller"
 	productrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
 	productservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/products/service"
+	rafflecontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/raffles/controller"
+	rafflerepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/raffles/repo"
+	raffleservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/raffles/service"
+	queuecontroller "github.com/kalen1o/iphone-storage/apps/core-api/internal/waitingroom/controller"
+	queueservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/waitingroom/service"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
 )
 
@@ -66,24 +85,91 @@ func main() {
 	jwt := authservice.NewJWT(cfg.JWT.Secret, cfg.JWT.Expiry)
 
 	authRepo := authrepo.NewPostgres(pool)
-	authSvc := authservice.New(authRepo, jwt)
+	authSvc := authservice.New(authRepo, jwt, authservice.Options{
+		TOTPIssuer:             cfg.TwoFactor.Issuer,
+		TwoFactorRequiredRoles: cfg.TwoFactor.RequiredRoles,
+		ChallengeTTL:           cfg.TwoFactor.ChallengeTTL,
+		MaxTwoFactorFailures:   cfg.TwoFactor.MaxFailures,
+		TwoFactorLockout:       cfg.TwoFactor.Lockout,
+	})
 	authCtrl := authcontroller.New(authSvc)
 
+	accountRepo := accountrepo.NewPostgres(pool)
+	accountSvc := accountservice.New(accountRepo)
+	accountCtrl := accountcontroller.New(accountSvc)
+
+	apiKeysRepo := apikeyrepo.NewPostgres(pool)
+	apiKeysSvc := apikeyservice.New(apiKeysRepo)
+	apiKeysCtrl := apikeycontroller.New(apiKeysSvc)
+
+	producer := kafka.NewProducer(cfg.Kafka.Brokers, cfg.Kafka.ClientID)
+	defer func() { _ = producer.Close() }()
+
+	runCtx, stopRun := context.WithCancel(context.Background())
+	defer stopRun()
+
+	redisClient := sharedredis.New(cfg.Redis)
+	defer func() { _ = redisClient.Close() }()
+	if err := sharedredis.Ping(ctx, redisClient); err != nil {
+		log.Error("redis unavailable; cache reads will fall through to postgres and the waiting room is unavailable", map[string]any{"err": err.Error()})
+	}
+
+	var readCache *cache.Cache
+	if cfg.Cache.Enabled {
+		readCache = cache.New(redisClient, cfg.Cache.RefreshAhead)
+
+		invalidator := cache.NewInvalidator(readCache, cfg.Kafka.Brokers, cfg.Cache.InvalidationGroupID, log)
+		go func() {
+			if err := invalidator.Run(runCtx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Error("cache invalidator stopped", map[string]any{"err": err.Error()})
+			}
+		}()
+	}
+
+	media, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Error("failed to configure media storage", map[string]any{"err": err.Error()})
+		os.Exit(1)
+	}
+
 	productsRepo := productrepo.NewPostgres(pool)
-	productsSvc := productservice.New(productsRepo)
+	productsSvc := productservice.New(productsRepo, producer, productservice.Options{
+		Cache:         readCache,
+		CacheTTL:      cfg.Cache.CatalogTTL,
+		Media:         media,
+		MaxImageBytes: cfg.Storage.MaxImageBytes,
+	})
 	productsCtrl := productcontroller.New(productsSvc)
+	go func() {
+		if err := productsSvc.RunPriceScheduler(runCtx, cfg.Pricing.SchedulerInterval, log); err != nil && !errors.Is(err, context.Canceled) {
+			log.Error("price scheduler stopped", map[string]any{"err": err.Error()})
+		}
+	}()
 
 	invRepo := inventoryrepo.NewPostgres(pool)
-	invSvc := inventoryservice.New(invRepo)
+	invSvc := inventoryservice.New(invRepo, producer, readCache, cfg.Cache.AvailabilityTTL)
 	invCtrl := inventorycontroller.New(invSvc)
 
-	producer := kafka.NewProducer(cfg.Kafka.Brokers, cfg.Kafka.ClientID)
-	defer func() { _ = producer.Close() }()
-
 	ordersRepo := orderrepo.NewPostgres(pool)
 	ordersSvc := orderservice.New(ordersRepo, producer)
 	ordersCtrl := ordercontroller.New(ordersSvc)
 
+	giftCardsRepo := giftcardrepo.NewPostgres(pool)
+	giftCardsSvc := giftcardservice.New(giftCardsRepo, producer)
+	giftCardsCtrl := giftcardcontroller.New(giftCardsSvc)
+
+	rafflesRepo := rafflerepo.NewPostgres(pool)
+	rafflesSvc := raffleservice.New(rafflesRepo)
+	rafflesCtrl := rafflecontroller.New(rafflesSvc)
+
+	queueSvc := queueservice.New(redisClient, queueservice.Options{
+		Secret:         cfg.WaitingRoom.Secret,
+		AdmitPerSecond: cfg.WaitingRoom.AdmitPerSecond,
+		CheckoutWindow: cfg.WaitingRoom.CheckoutWindow,
+		FailOpen:       cfg.WaitingRoom.FailOpen,
+	})
+	queueCtrl := queuecontroller.New(queueSvc, log)
+
 	router := mux.NewRouter()
 	router.Use(middleware.Logging(log))
 	router.Use(middleware.CORS())
@@ -105,16 +191,143 @@ func main() {
 	api := router.PathPrefix("/api").Subrouter()
 	api.HandleFunc("/auth/register", authCtrl.Register).Methods(http.MethodPost)
 	api.HandleFunc("/auth/login", authCtrl.Login).Methods(http.MethodPost)
+	api.HandleFunc("/auth/login/2fa", authCtrl.VerifyTwoFactorLogin).Methods(http.MethodPost)
 	api.HandleFunc("/products", productsCtrl.GetProducts).Methods(http.MethodGet)
+	api.HandleFunc("/products/suggest", productsCtrl.SuggestProducts).Methods(http.MethodGet)
 	api.HandleFunc("/products/{id}", productsCtrl.GetProductByID).Methods(http.MethodGet)
+	api.HandleFunc("/products/{id}/prices", productsCtrl.GetProductPrices).Methods(http.MethodGet)
 	api.HandleFunc("/inventory", invCtrl.GetInventory).Methods(http.MethodGet)
 	api.HandleFunc("/inventory/{id}", invCtrl.GetInventoryByProductID).Methods(http.MethodGet)
+	api.HandleFunc("/queue", queueCtrl.GetRoom).Methods(http.MethodGet)
+	api.HandleFunc("/raffles", rafflesCtrl.ListRaffles).Methods(http.MethodGet)
+	api.HandleFunc("/raffles/{id}", rafflesCtrl.GetRaffle).Methods(http.MethodGet)
+	api.HandleFunc("/raffles/{id}/draw", rafflesCtrl.GetDraw).Methods(http.MethodGet)
+	api.HandleFunc("/gift-cards/balance", giftCardsCtrl.CheckBalance).Methods(http.MethodPost)
+	// With the local backend core-api serves uploads itself; point
+	// STORAGE_PUBLIC_BASE_URL at /api/media for this to be reachable.
+	if local, ok := media.(*storage.Local); ok {
+		api.PathPrefix("/media/").Handler(http.StripPrefix("/api/media", local.Handler())).Methods(http.MethodGet, http.MethodHead)
+	}
 
 	protected := api.PathPrefix("").Subrouter()
-	protected.Use(middleware.NewAuthMiddleware(jwt).Authenticate)
+	protected.Use(middleware.NewAuthMiddleware(jwt, apiKeysSvc, authSvc).Authenticate)
 	protected.HandleFunc("/auth/me", authCtrl.Me).Methods(http.MethodGet)
-	protected.HandleFunc("/orders", ordersCtrl.CreateOrder).Methods(http.MethodPost)
-	protected.HandleFunc("/orders/{id}", ordersCtrl.GetOrder).Methods(http.MethodGet)
+	protected.HandleFunc("/auth/2fa/setup", authCtrl.SetupTwoFactor).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/enable", authCtrl.EnableTwoFactor).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/disable", authCtrl.DisableTwoFactor).Methods(http.MethodPost)
+	protected.HandleFunc("/auth/2fa/recovery-codes", authCtrl.RegenerateRecoveryCodes).Methods(http.MethodPost)
+
+	// Routes below require a completed second factor for roles listed in AUTH_2FA_REQUIRED_ROLES.
+	secured := protected.PathPrefix("").Subrouter()
+	secured.Use(middleware.RequireTwoFactor(authSvc.TwoFactorRequired))
+	secured.HandleFunc("/me", accountCtrl.UpdateProfile).Methods(http.MethodPatch)
+	secured.HandleFunc("/me", accountCtrl.DeleteAccount).Methods(http.MethodDelete)
+	secured.HandleFunc("/me/password", accountCtrl.ChangePassword).Methods(http.MethodPost)
+	secured.HandleFunc("/me/export", accountCtrl.ExportData).Methods(http.MethodGet)
+	secured.HandleFunc("/me/gift-cards", giftCardsCtrl.ListMyGiftCards).Methods(http.MethodGet)
+	secured.HandleFunc("/me/store-credit", giftCardsCtrl.GetMyStoreCredit).Methods(http.MethodGet)
+	secured.HandleFunc("/orders", ordersCtrl.ListOrders).Methods(http.MethodGet)
+	secured.Handle("/orders", queueCtrl.RequireAdmission(http.HandlerFunc(ordersCtrl.CreateOrder))).Methods(http.MethodPost)
+	secured.HandleFunc("/orders/{id}", ordersCtrl.GetOrder).Methods(http.MethodGet)
+	secured.HandleFunc("/orders/{id}/cancel", ordersCtrl.CancelOrder).Methods(http.MethodPost)
+	secured.HandleFunc("/queue/tickets", queueCtrl.JoinQueue).Methods(http.MethodPost)
+	secured.HandleFunc("/queue/status", queueCtrl.GetTicketStatus).Methods(http.MethodGet)
+	secured.HandleFunc("/raffles/{id}/entries", rafflesCtrl.EnterRaffle).Methods(http.MethodPost)
+	secured.HandleFunc("/raffles/{id}/entry", rafflesCtrl.GetMyEntry).Methods(http.MethodGet)
+	secured.HandleFunc("/gift-cards", giftCardsCtrl.PurchaseGiftCard).Methods(http.MethodPost)
+
+	// Cache hit/miss counters and runtime stats.
+	adminDebug := secured.PathPrefix("/admin/debug").Subrouter()
+	adminDebug.Use(middleware.RequireAccess("admin", apikeyservice.ScopeMetricsRead))
+	adminDebug.Handle("/vars", expvar.Handler()).Methods(http.MethodGet)
+
+	adminAPIKeys := secured.PathPrefix("/admin/api-keys").Subrouter()
+	adminAPIKeys.Use(middleware.RequireAccess("admin", apikeyservice.ScopeAPIKeysManage))
+	adminAPIKeys.HandleFunc("", apiKeysCtrl.ListAPIKeys).Methods(http.MethodGet)
+	adminAPIKeys.HandleFunc("", apiKeysCtrl.CreateAPIKey).Methods(http.MethodPost)
+	adminAPIKeys.HandleFunc("/{id}/rotate", apiKeysCtrl.RotateAPIKey).Methods(http.MethodPost)
+	adminAPIKeys.HandleFunc("/{id}", apiKeysCtrl.RevokeAPIKey).Methods(http.MethodDelete)
+
+	adminProducts := secured.PathPrefix("/admin/products").Subrouter()
+	adminProducts.Use(middleware.RequireAccess("admin", apikeyservice.ScopeProductsWrite))
+	adminProducts.HandleFunc("", productsCtrl.CreateProduct).Methods(http.MethodPost)
+	adminProducts.HandleFunc("/import", productsCtrl.ImportProducts).Methods(http.MethodPost)
+	adminProducts.HandleFunc("/export", productsCtrl.ExportProducts).Methods(http.MethodGet)
+	adminProducts.HandleFunc("/{id}", productsCtrl.AdminGetProduct).Methods(http.MethodGet)
+	adminProducts.HandleFunc("/{id}", productsCtrl.UpdateProduct).Methods(http.MethodPatch)
+	adminProducts.HandleFunc("/{id}", productsCtrl.DeleteProduct).Methods(http.MethodDelete)
+	adminProducts.HandleFunc("/{id}/options", productsCtrl.SetProductOptions).Methods(http.MethodPut)
+	adminProducts.HandleFunc("/{id}/variants", productsCtrl.CreateVariant).Methods(http.MethodPost)
+	adminProducts.HandleFunc("/{id}/images", productsCtrl.UploadProductImage).Methods(http.MethodPost)
+	adminProducts.HandleFunc("/{id}/prices", productsCtrl.AdminGetProductPrices).Methods(http.MethodGet)
+	adminProducts.HandleFunc("/{id}/prices", productsCtrl.ScheduleProductPrice).Methods(http.MethodPost)
+	adminProducts.HandleFunc("/{id}/prices/{priceId}", productsCtrl.CancelProductPrice).Methods(http.MethodDelete)
+	adminProducts.HandleFunc("/{id}/activate", productsCtrl.ActivateProduct).Methods(http.MethodPost)
+	adminProducts.HandleFunc("/{id}/deactivate", productsCtrl.DeactivateProduct).Methods(http.MethodPost)
+
+	adminPurchaseLimits := secured.PathPrefix("/admin/purchase-limits").Subrouter()
+	adminPurchaseLimits.Use(middleware.RequireAccess("admin", apikeyservice.ScopeOrdersWrite))
+	adminPurchaseLimits.HandleFunc("", ordersCtrl.ListPurchaseLimits).Methods(http.MethodGet)
+	adminPurchaseLimits.HandleFunc("", ordersCtrl.CreatePurchaseLimit).Methods(http.MethodPost)
+	adminPurchaseLimits.HandleFunc("/{id}", ordersCtrl.UpdatePurchaseLimit).Methods(http.MethodPatch)
+	adminPurchaseLimits.HandleFunc("/{id}", ordersCtrl.DeletePurchaseLimit).Methods(http.MethodDelete)
+
+	adminRaffles := secured.PathPrefix("/admin/raffles").Subrouter()
+	adminRaffles.Use(middleware.RequireAccess("admin", apikeyservice.ScopeOrdersWrite))
+	adminRaffles.HandleFunc("", rafflesCtrl.AdminListRaffles).Methods(http.MethodGet)
+	adminRaffles.HandleFunc("", rafflesCtrl.CreateRaffle).Methods(http.MethodPost)
+	adminRaffles.HandleFunc("/{id}/cancel", rafflesCtrl.CancelRaffle).Methods(http.MethodPost)
+
+	adminGiftCards := secured.PathPrefix("/admin/gift-cards").Subrouter()
+	adminGiftCards.Use(middleware.RequireAccess("admin", apikeyservice.ScopePaymentsWrite))
+	adminGiftCards.HandleFunc("", giftCardsCtrl.AdminListGiftCards).Methods(http.MethodGet)
+	adminGiftCards.HandleFunc("", giftCardsCtrl.IssueGiftCard).Methods(http.MethodPost)
+	adminGiftCards.HandleFunc("/{id}", giftCardsCtrl.AdminGetGiftCard).Methods(http.MethodGet)
+	adminGiftCards.HandleFunc("/{id}/disable", giftCardsCtrl.DisableGiftCard).Methods(http.MethodPost)
+
+	adminOrders := secured.PathPrefix("/admin/orders").Subrouter()
+	adminOrders.Use(middleware.RequireAccess("admin", apikeyservice.ScopePaymentsWrite))
+	adminOrders.HandleFunc("/{id}/refunds", giftCardsCtrl.RefundOrder).Methods(http.MethodPost)
+
+	adminQueue := secured.PathPrefix("/admin/queue").Subrouter()
+	adminQueue.Use(middleware.RequireAccess("admin", apikeyservice.ScopeOrdersWrite))
+	adminQueue.HandleFunc("", queueCtrl.GetRoom).Methods(http.MethodGet)
+	adminQueue.HandleFunc("/open", queueCtrl.OpenRoom).Methods(http.MethodPost)
+	adminQueue.HandleFunc("/pause", queueCtrl.PauseRoom).Methods(http.MethodPost)
+	adminQueue.HandleFunc("/drain", queueCtrl.DrainRoom).Methods(http.MethodPost)
+	adminQueue.HandleFunc("/close", queueCtrl.CloseRoom).Methods(http.MethodPost)
+
+	adminInventory := secured.PathPrefix("/admin/inventory").Subrouter()
+	adminInventory.Use(middleware.RequireAccess("admin", apikeyservice.ScopeInventoryWrite))
+	adminInventory.HandleFunc("/low-stock", invCtrl.GetReplenishmentReport).Methods(http.MethodGet)
+	adminInventory.HandleFunc("/{product_id}/ledger", invCtrl.GetLedger).Methods(http.MethodGet)
+	adminInventory.HandleFunc("/{product_id}/receipts", invCtrl.ReceiveStock).Methods(http.MethodPost)
+	adminInventory.HandleFunc("/{product_id}/corrections", invCtrl.CorrectCount).Methods(http.MethodPost)
+	adminInventory.HandleFunc("/{product_id}/write-offs", invCtrl.WriteOffStock).Methods(http.MethodPost)
+	adminInventory.HandleFunc("/{product_id}/warehouses", invCtrl.GetWarehouseStock).Methods(http.MethodGet)
+	adminInventory.HandleFunc("/{product_id}/transfers", invCtrl.TransferStock).Methods(http.MethodPost)
+
+	adminWarehouses := secured.PathPrefix("/admin/warehouses").Subrouter()
+	adminWarehouses.Use(middleware.RequireAccess("admin", apikeyservice.ScopeInventoryWrite))
+	adminWarehouses.HandleFunc("", invCtrl.ListWarehouses).Methods(http.MethodGet)
+	adminWarehouses.HandleFunc("", invCtrl.CreateWarehouse).Methods(http.MethodPost)
+	adminWarehouses.HandleFunc("/{id}", invCtrl.UpdateWarehouse).Methods(http.MethodPatch)
+
+	adminSuppliers := secured.PathPrefix("/admin/suppliers").Subrouter()
+	adminSuppliers.Use(middleware.RequireAccess("admin", apikeyservice.ScopeInventoryWrite))
+	adminSuppliers.HandleFunc("", invCtrl.ListSuppliers).Methods(http.MethodGet)
+	adminSuppliers.HandleFunc("", invCtrl.CreateSupplier).Methods(http.MethodPost)
+	adminSuppliers.HandleFunc("/{id}", invCtrl.UpdateSupplier).Methods(http.MethodPatch)
+
+	adminPurchaseOrders := secured.PathPrefix("/admin/purchase-orders").Subrouter()
+	adminPurchaseOrders.Use(middleware.RequireAccess("admin", apikeyservice.ScopeInventoryWrite))
+	adminPurchaseOrders.HandleFunc("", invCtrl.ListPurchaseOrders).Methods(http.MethodGet)
+	adminPurchaseOrders.HandleFunc("", invCtrl.CreatePurchaseOrder).Methods(http.MethodPost)
+	adminPurchaseOrders.HandleFunc("/{id}", invCtrl.GetPurchaseOrder).Methods(http.MethodGet)
+	adminPurchaseOrders.HandleFunc("/{id}", invCtrl.UpdatePurchaseOrder).Methods(http.MethodPatch)
+	adminPurchaseOrders.HandleFunc("/{id}/order", invCtrl.PlacePurchaseOrder).Methods(http.MethodPost)
+	adminPurchaseOrders.HandleFunc("/{id}/cancel", invCtrl.CancelPurchaseOrder).Methods(http.MethodPost)
+	adminPurchaseOrders.HandleFunc("/{id}/receipts", invCtrl.ReceivePurchaseOrder).Methods(http.MethodPost)
 
 	srv := &http.Server{
 		Addr:              fmt.Sprintf(":%d", cfg.Service.Port),
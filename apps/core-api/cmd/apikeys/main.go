@@ -0,0 +1,163 @@
+// Command apikeys manages service-to-service API keys directly against the
+// database, for bootstrapping before any admin user or key exists.
+//
+//	apikeys create -name inventory-service -scopes orders:read,orders:write [-expires 720h]
+//	apikeys list
+//	apikeys rotate -id <uuid> [-grace 24h]
+//	apikeys revoke -id <uuid>
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	apikeyrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/repo"
+	apikeyservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/service"
+	"github.com/kalen1o/iphone-storage/shared/config"
+	shareddb "github.com/kalen1o/iphone-storage/shared/db"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pool, err := shareddb.NewPool(ctx, cfg.Database)
+	if err != nil {
+		fatal(err)
+	}
+	defer pool.Close()
+
+	svc := apikeyservice.New(apikeyrepo.NewPostgres(pool))
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "create":
+		err = runCreate(ctx, svc, args)
+	case "list":
+		err = runList(ctx, svc)
+	case "rotate":
+		err = runRotate(ctx, svc, args)
+	case "revoke":
+		err = runRevoke(ctx, svc, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runCreate(ctx context.Context, svc *apikeyservice.Service, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	name := fs.String("name", "", "key name, e.g. the calling service")
+	scopes := fs.String("scopes", "", "comma-separated scopes")
+	expires := fs.Duration("expires", 0, "lifetime, e.g. 720h (0 = never)")
+	_ = fs.Parse(args)
+
+	in := apikeyservice.CreateInput{
+		Name:   *name,
+		Scopes: strings.Split(*scopes, ","),
+	}
+	if *expires > 0 {
+		t := time.Now().Add(*expires)
+		in.ExpiresAt = &t
+	}
+
+	plaintext, k, err := svc.Create(ctx, in)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("id:     %s\nscopes: %s\nkey:    %s\n", k.ID, strings.Join(k.Scopes, ","), plaintext)
+	fmt.Fprintln(os.Stderr, "store the key now; it cannot be shown again")
+	return nil
+}
+
+func runList(ctx context.Context, svc *apikeyservice.Service) error {
+	keys, err := svc.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tSCOPES\tSTATUS\tLAST USED")
+	now := time.Now()
+	for _, k := range keys {
+		status := "active"
+		switch {
+		case k.RevokedAt != nil:
+			status = "revoked"
+		case !k.Active(now):
+			status = "expired"
+		case k.ExpiresAt != nil:
+			status = "expires " + k.ExpiresAt.Format(time.RFC3339)
+		}
+		lastUsed := "-"
+		if k.LastUsedAt != nil {
+			lastUsed = k.LastUsedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Prefix, strings.Join(k.Scopes, ","), status, lastUsed)
+	}
+	return tw.Flush()
+}
+
+func runRotate(ctx context.Context, svc *apikeyservice.Service, args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	rawID := fs.String("id", "", "API key ID")
+	grace := fs.Duration("grace", apikeyservice.DefaultRotationGrace, "how long the old key stays valid")
+	_ = fs.Parse(args)
+
+	id, err := uuid.Parse(*rawID)
+	if err != nil {
+		return fmt.Errorf("invalid -id: %w", err)
+	}
+	plaintext, k, err := svc.Rotate(ctx, id, *grace, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("id:     %s\nscopes: %s\nkey:    %s\n", k.ID, strings.Join(k.Scopes, ","), plaintext)
+	fmt.Fprintf(os.Stderr, "old key %s stays valid for %s\n", id, *grace)
+	return nil
+}
+
+func runRevoke(ctx context.Context, svc *apikeyservice.Service, args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	rawID := fs.String("id", "", "API key ID")
+	_ = fs.Parse(args)
+
+	id, err := uuid.Parse(*rawID)
+	if err != nil {
+		return fmt.Errorf("invalid -id: %w", err)
+	}
+	if err := svc.Revoke(ctx, id); err != nil {
+		return err
+	}
+	fmt.Printf("revoked %s\n", id)
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: apikeys <create|list|rotate|revoke> [flags]")
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
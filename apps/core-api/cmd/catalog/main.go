@@ -0,0 +1,181 @@
+// Command catalog bulk-loads and dumps products directly against the
+// database, with the same validation and events as the admin endpoints.
+//
+//	catalog import -file iphone-17.csv [-format csv|ndjson] [-dry-run] [-batch 200]
+//	catalog export [-format csv|ndjson] [-out catalog.csv]
+//
+// The file format defaults from its extension (.csv, .ndjson or .jsonl).
+// import exits with status 1 when any row was rejected.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	productrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
+	productservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/products/service"
+	"github.com/kalen1o/iphone-storage/shared/config"
+	shareddb "github.com/kalen1o/iphone-storage/shared/db"
+	"github.com/kalen1o/iphone-storage/shared/kafka"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := shareddb.NewPool(ctx, cfg.Database)
+	if err != nil {
+		fatal(err)
+	}
+	defer pool.Close()
+
+	// products.updated keeps the core-api caches and downstream consumers
+	// in step with what was imported.
+	producer := kafka.NewProducer(cfg.Kafka.Brokers, "catalog-cli")
+	defer func() { _ = producer.Close() }()
+
+	svc := productservice.New(productrepo.NewPostgres(pool), producer, productservice.Options{})
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "import":
+		err = runImport(ctx, svc, args)
+	case "export":
+		err = runExport(ctx, svc, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func runImport(ctx context.Context, svc *productservice.Service, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	file := fs.String("file", "", "CSV or NDJSON file (- for stdin)")
+	format := fs.String("format", "", "csv or ndjson (default from the file extension)")
+	dryRun := fs.Bool("dry-run", false, "validate and report without committing")
+	batch := fs.Int("batch", productservice.DefaultImportBatchSize, "rows per transaction")
+	_ = fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	if *format == "" {
+		*format = formatFromExt(*file)
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+
+	rr, err := productservice.NewRecordReader(*format, in)
+	if err != nil {
+		return err
+	}
+	report, err := svc.Import(ctx, rr, productservice.ImportOptions{DryRun: *dryRun, BatchSize: *batch})
+	if report != nil {
+		printReport(report)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d rows rejected", report.Failed)
+	}
+	return nil
+}
+
+func printReport(r *productservice.ImportReport) {
+	mode := "committed"
+	if r.DryRun {
+		mode = "dry run, nothing committed"
+	}
+	fmt.Printf("rows: %d  created: %d  updated: %d  failed: %d  (%s)\n", r.Rows, r.Created, r.Updated, r.Failed, mode)
+	if len(r.Errors) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROW\tSKU\tERROR")
+	for _, e := range r.Errors {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", e.Row, e.SKU, e.Message)
+	}
+	_ = tw.Flush()
+	if r.ErrorsTruncated {
+		fmt.Fprintf(os.Stderr, "... %d more\n", r.Failed-len(r.Errors))
+	}
+}
+
+func runExport(ctx context.Context, svc *productservice.Service, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "", "csv or ndjson (default from -out, else csv)")
+	out := fs.String("out", "-", "output file (- for stdout)")
+	_ = fs.Parse(args)
+
+	if *format == "" {
+		*format = formatFromExt(*out)
+		if *format == "" {
+			*format = productservice.FormatCSV
+		}
+	}
+
+	if *out == "-" {
+		return svc.Export(ctx, *format, os.Stdout)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := svc.Export(ctx, *format, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func formatFromExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return productservice.FormatCSV
+	case ".ndjson", ".jsonl":
+		return productservice.FormatNDJSON
+	default:
+		return ""
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  catalog import -file <path|-> [-format csv|ndjson] [-dry-run] [-batch 200]
+  catalog export [-format csv|ndjson] [-out <path|->]`)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
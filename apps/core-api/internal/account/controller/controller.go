@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/account/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/http/middleware"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+type Controller struct {
+	svc *service.Service
+}
+
+func New(svc *service.Service) *Controller {
+	return &Controller{svc: svc}
+}
+
+type UpdateProfileRequest struct {
+	FirstName *string `json:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// UpdateProfile godoc
+// @Summary Update current user profile
+// @Tags account
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body UpdateProfileRequest true "Profile fields to change"
+// @Success 200 {object} repo.Profile
+// @Failure 400 {object} map[string]any
+// @Router /api/me [patch]
+func (c *Controller) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	p, err := c.svc.UpdateProfile(r.Context(), userID, service.UpdateProfileInput{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+	})
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, p)
+}
+
+// ChangePassword godoc
+// @Summary Change current user password
+// @Description Signs out every session, this one included; log in again with the new password.
+// @Tags account
+// @Accept json
+// @Security BearerAuth
+// @Param body body ChangePasswordRequest true "Passwords"
+// @Success 204
+// @Failure 400 {object} map[string]any
+// @Failure 403 {object} map[string]any
+// @Router /api/me/password [post]
+func (c *Controller) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := c.svc.ChangePassword(r.Context(), userID, req.CurrentPassword, req.NewPassword); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportData godoc
+// @Summary Export personal data (GDPR)
+// @Description Returns a JSON archive of the user, their orders, addresses and payments.
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} repo.Export
+// @Router /api/me/export [get]
+func (c *Controller) ExportData(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	export, err := c.svc.Export(r.Context(), userID)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	filename := fmt.Sprintf("account-export-%s-%s.json", userID, export.ExportedAt.Format("20060102T150405Z"))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	httpjson.WriteJSON(w, http.StatusOK, export)
+}
+
+// DeleteAccount godoc
+// @Summary Erase account (GDPR)
+// @Description Soft-deletes the user and anonymizes PII on historical orders. Financial records are kept.
+// @Tags account
+// @Accept json
+// @Security BearerAuth
+// @Param body body DeleteAccountRequest true "Password confirmation"
+// @Success 204
+// @Failure 403 {object} map[string]any
+// @Router /api/me [delete]
+func (c *Controller) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := c.svc.Erase(r.Context(), userID, req.Password); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func userIDFromRequest(r *http.Request) (uuid.UUID, bool) {
+	raw, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidProfile):
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid profile")
+	case errors.Is(err, service.ErrPasswordTooShort):
+		httpjson.WriteError(w, http.StatusBadRequest, "password must be at least 8 characters")
+	case errors.Is(err, service.ErrWrongPassword):
+		httpjson.WriteError(w, http.StatusForbidden, "current password is incorrect")
+	case util.IsNotFound(err):
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, "account request failed")
+	}
+}
@@ -0,0 +1,319 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// erasedMarker replaces free-text PII that must stay non-empty.
+const erasedMarker = "[erased]"
+
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+const profileColumns = `id, email, password_hash, COALESCE(first_name, ''), COALESCE(last_name, ''), role, is_active, created_at, updated_at`
+
+func scanProfile(row pgx.Row) (*Profile, error) {
+	var p Profile
+	if err := row.Scan(
+		&p.ID,
+		&p.Email,
+		&p.PasswordHash,
+		&p.FirstName,
+		&p.LastName,
+		&p.Role,
+		&p.IsActive,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *Postgres) GetProfile(ctx context.Context, userID uuid.UUID) (*Profile, error) {
+	return getProfile(ctx, r.pool, userID)
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func getProfile(ctx context.Context, q querier, userID uuid.UUID) (*Profile, error) {
+	row := q.QueryRow(ctx, `
+		SELECT `+profileColumns+`
+		FROM users
+		WHERE id = $1 AND deleted_at IS NULL
+	`, userID)
+	return scanProfile(row)
+}
+
+func (r *Postgres) UpdateProfile(ctx context.Context, userID uuid.UUID, input UpdateProfileInput) (*Profile, error) {
+	row := r.pool.QueryRow(ctx, `
+		UPDATE users
+		SET first_name = COALESCE($2, first_name),
+		    last_name = COALESCE($3, last_name)
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+profileColumns+`
+	`, userID, input.FirstName, input.LastName)
+	return scanProfile(row)
+}
+
+func (r *Postgres) UpdatePasswordHash(ctx context.Context, userID uuid.UUID, hash string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET password_hash = $2,
+		    token_version = token_version + 1
+		WHERE id = $1 AND deleted_at IS NULL
+	`, userID, hash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Postgres) Export(ctx context.Context, userID uuid.UUID) (*Export, error) {
+	// A repeatable-read snapshot keeps orders, items and payments consistent
+	// with each other while the archive is assembled.
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	profile, err := getProfile(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := &Export{ExportedAt: time.Now().UTC(), User: *profile}
+
+	if out.Orders, err = exportOrders(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if out.Addresses, err = exportAddresses(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if out.Payments, err = exportPayments(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func exportOrders(ctx context.Context, tx pgx.Tx, userID uuid.UUID) ([]ExportOrder, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, status, subtotal::float8, tax::float8, total::float8, currency, COALESCE(customer_notes, ''), shipping_address_text, created_at, updated_at
+		FROM orders
+		WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make([]ExportOrder, 0)
+	index := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var o ExportOrder
+		if err := rows.Scan(
+			&o.ID,
+			&o.Status,
+			&o.Subtotal,
+			&o.Tax,
+			&o.Total,
+			&o.Currency,
+			&o.CustomerNotes,
+			&o.ShippingAddressText,
+			&o.CreatedAt,
+			&o.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		o.Items = make([]ExportOrderItem, 0)
+		index[o.ID] = len(orders)
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	itemRows, err := tx.Query(ctx, `
+		SELECT oi.order_id, oi.product_id, oi.product_name, oi.product_sku, oi.quantity, oi.unit_price::float8, oi.total_price::float8
+		FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		WHERE o.user_id = $1 AND o.deleted_at IS NULL
+		ORDER BY oi.created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var orderID uuid.UUID
+		var it ExportOrderItem
+		if err := itemRows.Scan(&orderID, &it.ProductID, &it.ProductName, &it.ProductSKU, &it.Quantity, &it.UnitPrice, &it.TotalPrice); err != nil {
+			return nil, err
+		}
+		if i, ok := index[orderID]; ok {
+			orders[i].Items = append(orders[i].Items, it)
+		}
+	}
+	if err := itemRows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func exportAddresses(ctx context.Context, tx pgx.Tx, userID uuid.UUID) ([]ExportAddress, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT a.id, a.order_id, a.address_type, COALESCE(a.full_name, ''), COALESCE(a.company, ''), a.line1, COALESCE(a.line2, ''),
+		       a.city, COALESCE(a.state, ''), a.postal_code, a.country, COALESCE(a.phone, ''), COALESCE(a.is_default, false), a.created_at
+		FROM addresses a
+		LEFT JOIN orders o ON o.id = a.order_id
+		WHERE (a.user_id = $1 OR o.user_id = $1) AND a.deleted_at IS NULL
+		ORDER BY a.created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ExportAddress, 0)
+	for rows.Next() {
+		var a ExportAddress
+		if err := rows.Scan(
+			&a.ID,
+			&a.OrderID,
+			&a.AddressType,
+			&a.FullName,
+			&a.Company,
+			&a.Line1,
+			&a.Line2,
+			&a.City,
+			&a.State,
+			&a.PostalCode,
+			&a.Country,
+			&a.Phone,
+			&a.IsDefault,
+			&a.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func exportPayments(ctx context.Context, tx pgx.Tx, userID uuid.UUID) ([]ExportPayment, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT p.id, p.order_id, p.provider, p.amount::float8, COALESCE(p.currency, 'USD'), p.status, p.created_at
+		FROM payments p
+		JOIN orders o ON o.id = p.order_id
+		WHERE o.user_id = $1
+		ORDER BY p.created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ExportPayment, 0)
+	for rows.Next() {
+		var p ExportPayment
+		if err := rows.Scan(&p.ID, &p.OrderID, &p.Provider, &p.Amount, &p.Currency, &p.Status, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *Postgres) Erase(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// The email is unique, so it is replaced with a per-user placeholder
+	// rather than cleared; this also frees the address for re-registration.
+	tag, err := tx.Exec(ctx, `
+		UPDATE users
+		SET email = 'erased+' || id::text || '@erased.invalid',
+		    password_hash = '!',
+		    first_name = NULL,
+		    last_name = NULL,
+		    is_active = false,
+		    email_verified = false,
+		    totp_secret = NULL,
+		    totp_enabled = false,
+		    totp_enabled_at = NULL,
+		    token_version = token_version + 1,
+		    deleted_at = NOW(),
+		    erased_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return pgx.ErrNoRows
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE orders
+		SET shipping_address_text = $2,
+		    customer_notes = NULL,
+		    internal_notes = NULL,
+		    anonymized_at = NOW()
+		WHERE user_id = $1
+	`, userID, erasedMarker); err != nil {
+		return err
+	}
+
+	// City, region, postal code and country are kept for tax records.
+	if _, err := tx.Exec(ctx, `
+		UPDATE addresses
+		SET full_name = NULL,
+		    company = NULL,
+		    line1 = $2,
+		    line2 = NULL,
+		    phone = NULL,
+		    metadata = '{}'::jsonb,
+		    deleted_at = COALESCE(deleted_at, NOW())
+		WHERE user_id = $1 OR order_id IN (SELECT id FROM orders WHERE user_id = $1)
+	`, userID, erasedMarker); err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM user_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+	} {
+		if _, err := tx.Exec(ctx, stmt, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/testdb"
+)
+
+// TestTokenVersion_BumpedByPasswordChangeAndErase checks the writes the
+// auth middleware relies on to revoke sessions.
+func TestTokenVersion_BumpedByPasswordChangeAndErase(t *testing.T) {
+	ctx, pool := testdb.New(t, "users", "user_recovery_codes", "sessions")
+	var hasColumn bool
+	if err := pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'token_version')
+	`).Scan(&hasColumn); err != nil || !hasColumn {
+		t.Skip("skipping integration test: users.token_version is missing, run migrations first")
+	}
+	r := NewPostgres(pool)
+
+	userID := uuid.New()
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO users (id, email, password_hash) VALUES ($1, $2, 'x')
+	`, userID, "tokens-"+userID.String()+"@example.test"); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, _ = pool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	})
+	version := func() int {
+		t.Helper()
+		var v int
+		if err := pool.QueryRow(ctx, `SELECT token_version FROM users WHERE id = $1`, userID).Scan(&v); err != nil {
+			t.Fatalf("read token version: %v", err)
+		}
+		return v
+	}
+
+	if v := version(); v != 0 {
+		t.Fatalf("new user token version = %d, want 0", v)
+	}
+	if err := r.UpdatePasswordHash(ctx, userID, "y"); err != nil {
+		t.Fatalf("update password: %v", err)
+	}
+	if v := version(); v != 1 {
+		t.Fatalf("token version after password change = %d, want 1", v)
+	}
+	if err := r.Erase(ctx, userID); err != nil {
+		t.Fatalf("erase: %v", err)
+	}
+	if v := version(); v != 2 {
+		t.Fatalf("token version after erase = %d, want 2", v)
+	}
+	if err := r.UpdatePasswordHash(ctx, userID, "z"); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("password change after erase: err = %v, want pgx.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Profile struct {
+	ID           uuid.UUID `json:"id"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"-"`
+	FirstName    string    `json:"first_name,omitempty"`
+	LastName     string    `json:"last_name,omitempty"`
+	Role         string    `json:"role"`
+	IsActive     bool      `json:"is_active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type UpdateProfileInput struct {
+	FirstName *string
+	LastName  *string
+}
+
+// Export is the GDPR data archive for one user.
+type Export struct {
+	ExportedAt time.Time       `json:"exported_at"`
+	User       Profile         `json:"user"`
+	Orders     []ExportOrder   `json:"orders"`
+	Addresses  []ExportAddress `json:"addresses"`
+	Payments   []ExportPayment `json:"payments"`
+}
+
+type ExportOrder struct {
+	ID                  uuid.UUID         `json:"id"`
+	Status              string            `json:"status"`
+	Subtotal            float64           `json:"subtotal"`
+	Tax                 float64           `json:"tax"`
+	Total               float64           `json:"total"`
+	Currency            string            `json:"currency"`
+	CustomerNotes       string            `json:"customer_notes,omitempty"`
+	ShippingAddressText string            `json:"shipping_address_text,omitempty"`
+	Items               []ExportOrderItem `json:"items"`
+	CreatedAt           time.Time         `json:"created_at"`
+	UpdatedAt           time.Time         `json:"updated_at"`
+}
+
+type ExportOrderItem struct {
+	ProductID   uuid.UUID `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	ProductSKU  string    `json:"product_sku"`
+	Quantity    int       `json:"quantity"`
+	UnitPrice   float64   `json:"unit_price"`
+	TotalPrice  float64   `json:"total_price"`
+}
+
+type ExportAddress struct {
+	ID          uuid.UUID  `json:"id"`
+	OrderID     *uuid.UUID `json:"order_id,omitempty"`
+	AddressType string     `json:"address_type"`
+	FullName    string     `json:"full_name,omitempty"`
+	Company     string     `json:"company,omitempty"`
+	Line1       string     `json:"line1"`
+	Line2       string     `json:"line2,omitempty"`
+	City        string     `json:"city"`
+	State       string     `json:"state,omitempty"`
+	PostalCode  string     `json:"postal_code"`
+	Country     string     `json:"country"`
+	Phone       string     `json:"phone,omitempty"`
+	IsDefault   bool       `json:"is_default"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type ExportPayment struct {
+	ID        uuid.UUID `json:"id"`
+	OrderID   uuid.UUID `json:"order_id"`
+	Provider  string    `json:"provider"`
+	Amount    float64   `json:"amount"`
+	Currency  string    `json:"currency"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Repository interface {
+	GetProfile(ctx context.Context, userID uuid.UUID) (*Profile, error)
+	UpdateProfile(ctx context.Context, userID uuid.UUID, input UpdateProfileInput) (*Profile, error)
+	// UpdatePasswordHash sets the password and revokes every access token
+	// issued so far.
+	UpdatePasswordHash(ctx context.Context, userID uuid.UUID, hash string) error
+	Export(ctx context.Context, userID uuid.UUID) (*Export, error)
+	// Erase soft-deletes the user and anonymizes PII on their orders and
+	// addresses, and revokes the user's access tokens. Order totals, line
+	// items and payments are kept.
+	Erase(ctx context.Context, userID uuid.UUID) error
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/account/repo"
+)
+
+const (
+	minPasswordLen = 8
+	maxNameLen     = 100
+)
+
+var (
+	ErrInvalidProfile   = errors.New("invalid profile")
+	ErrWrongPassword    = errors.New("current password is incorrect")
+	ErrPasswordTooShort = errors.New("password too short")
+)
+
+type Service struct {
+	repo repo.Repository
+}
+
+func New(r repo.Repository) *Service {
+	return &Service{repo: r}
+}
+
+type UpdateProfileInput struct {
+	FirstName *string
+	LastName  *string
+}
+
+func (s *Service) GetProfile(ctx context.Context, userID uuid.UUID) (*repo.Profile, error) {
+	return s.repo.GetProfile(ctx, userID)
+}
+
+func (s *Service) UpdateProfile(ctx context.Context, userID uuid.UUID, in UpdateProfileInput) (*repo.Profile, error) {
+	first, err := normalizeName(in.FirstName)
+	if err != nil {
+		return nil, err
+	}
+	last, err := normalizeName(in.LastName)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.UpdateProfile(ctx, userID, repo.UpdateProfileInput{FirstName: first, LastName: last})
+}
+
+func (s *Service) ChangePassword(ctx context.Context, userID uuid.UUID, current, next string) error {
+	p, err := s.repo.GetProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(p.PasswordHash), []byte(current)); err != nil {
+		return ErrWrongPassword
+	}
+	if len(next) < minPasswordLen {
+		return ErrPasswordTooShort
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(next), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.repo.UpdatePasswordHash(ctx, userID, string(hash))
+}
+
+func (s *Service) Export(ctx context.Context, userID uuid.UUID) (*repo.Export, error) {
+	return s.repo.Export(ctx, userID)
+}
+
+// Erase deletes the account after re-checking the password. It cannot be undone.
+func (s *Service) Erase(ctx context.Context, userID uuid.UUID, password string) error {
+	p, err := s.repo.GetProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(p.PasswordHash), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+	return s.repo.Erase(ctx, userID)
+}
+
+func normalizeName(v *string) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	n := strings.TrimSpace(*v)
+	if len(n) > maxNameLen {
+		return nil, ErrInvalidProfile
+	}
+	return &n, nil
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/http/middleware"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+type Controller struct {
+	svc *service.Service
+}
+
+func New(svc *service.Service) *Controller {
+	return &Controller{svc: svc}
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type RotateAPIKeyRequest struct {
+	// GraceSeconds is how long the old key stays valid. Defaults to 24h.
+	GraceSeconds *int `json:"grace_seconds,omitempty"`
+}
+
+type APIKeySecretResponse struct {
+	// Key is the plaintext key. It is only returned once.
+	Key    string      `json:"key"`
+	APIKey repo.APIKey `json:"api_key"`
+}
+
+type APIKeysListResponse struct {
+	Items []repo.APIKey `json:"items"`
+}
+
+// CreateAPIKey godoc
+// @Summary Create service API key
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body CreateAPIKeyRequest true "API key"
+// @Success 201 {object} APIKeySecretResponse
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/api-keys [post]
+func (c *Controller) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	plaintext, k, err := c.svc.Create(r.Context(), service.CreateInput{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: actorID(r),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidInput) {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid name, scopes or expires_at")
+			return
+		}
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to create api key")
+		return
+	}
+
+	httpjson.WriteJSON(w, http.StatusCreated, APIKeySecretResponse{Key: plaintext, APIKey: *k})
+}
+
+// ListAPIKeys godoc
+// @Summary List service API keys
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} APIKeysListResponse
+// @Router /api/admin/api-keys [get]
+func (c *Controller) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := c.svc.List(r.Context())
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list api keys")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, APIKeysListResponse{Items: keys})
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate service API key
+// @Description Issues a replacement key with the same scopes; the old key stays valid for the grace period.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "API key ID (uuid)"
+// @Param body body RotateAPIKeyRequest false "Rotation"
+// @Success 201 {object} APIKeySecretResponse
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/api-keys/{id}/rotate [post]
+func (c *Controller) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var req RotateAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+	}
+	grace := service.DefaultRotationGrace
+	if req.GraceSeconds != nil {
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+
+	plaintext, k, err := c.svc.Rotate(r.Context(), id, grace, actorID(r))
+	if err != nil {
+		switch {
+		case util.IsNotFound(err):
+			httpjson.WriteError(w, http.StatusNotFound, "not found")
+		case errors.Is(err, service.ErrInvalidAPIKey):
+			httpjson.WriteError(w, http.StatusConflict, "api key is revoked or expired")
+		case errors.Is(err, service.ErrInvalidInput):
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid grace_seconds")
+		default:
+			httpjson.WriteError(w, http.StatusInternalServerError, "failed to rotate api key")
+		}
+		return
+	}
+
+	httpjson.WriteJSON(w, http.StatusCreated, APIKeySecretResponse{Key: plaintext, APIKey: *k})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke service API key
+// @Tags admin
+// @Security BearerAuth
+// @Param id path string true "API key ID (uuid)"
+// @Success 204
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/api-keys/{id} [delete]
+func (c *Controller) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	if err := c.svc.Revoke(r.Context(), id); err != nil {
+		if util.IsNotFound(err) {
+			httpjson.WriteError(w, http.StatusNotFound, "not found")
+			return
+		}
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to revoke api key")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func actorID(r *http.Request) *uuid.UUID {
+	raw, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
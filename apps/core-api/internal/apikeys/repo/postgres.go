@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, created_by, rotated_from, last_used_at, expires_at, revoked_at, created_at, updated_at`
+
+func scanAPIKey(row pgx.Row) (*APIKey, error) {
+	var k APIKey
+	if err := row.Scan(
+		&k.ID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&k.Scopes,
+		&k.CreatedBy,
+		&k.RotatedFrom,
+		&k.LastUsedAt,
+		&k.ExpiresAt,
+		&k.RevokedAt,
+		&k.CreatedAt,
+		&k.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *Postgres) Create(ctx context.Context, input CreateAPIKeyInput) (*APIKey, error) {
+	return insertAPIKey(ctx, r.pool, input)
+}
+
+func (r *Postgres) Rotate(ctx context.Context, oldID uuid.UUID, oldExpiresAt time.Time, input CreateAPIKeyInput) (*APIKey, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE api_keys
+		SET expires_at = LEAST(COALESCE(expires_at, $2), $2)
+		WHERE id = $1 AND revoked_at IS NULL
+	`, oldID, oldExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() != 1 {
+		return nil, pgx.ErrNoRows
+	}
+
+	k, err := insertAPIKey(ctx, tx, input)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func insertAPIKey(ctx context.Context, q querier, input CreateAPIKeyInput) (*APIKey, error) {
+	row := q.QueryRow(ctx, `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, rotated_from, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+apiKeyColumns+`
+	`, input.Name, input.Prefix, input.KeyHash, input.Scopes, input.CreatedBy, input.RotatedFrom, input.ExpiresAt)
+	return scanAPIKey(row)
+}
+
+func (r *Postgres) List(ctx context.Context) ([]APIKey, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *Postgres) GetByID(ctx context.Context, id uuid.UUID) (*APIKey, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE id = $1
+	`, id)
+	return scanAPIKey(row)
+}
+
+func (r *Postgres) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE prefix = $1
+	`, prefix)
+	return scanAPIKey(row)
+}
+
+func (r *Postgres) Revoke(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+	`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// TouchLastUsed records usage at most once a minute per key to keep hot keys
+// from turning every request into a row update.
+func (r *Postgres) TouchLastUsed(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`, id)
+	return err
+}
@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKey struct {
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	KeyHash     string     `json:"-"`
+	Scopes      []string   `json:"scopes"`
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty"`
+	RotatedFrom *uuid.UUID `json:"rotated_from,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Active reports whether the key may authenticate at t.
+func (k *APIKey) Active(t time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || t.Before(*k.ExpiresAt)
+}
+
+type CreateAPIKeyInput struct {
+	Name        string
+	Prefix      string
+	KeyHash     string
+	Scopes      []string
+	CreatedBy   *uuid.UUID
+	RotatedFrom *uuid.UUID
+	ExpiresAt   *time.Time
+}
+
+type Repository interface {
+	Create(ctx context.Context, input CreateAPIKeyInput) (*APIKey, error)
+	// Rotate creates the replacement key and shortens the old key's lifetime to
+	// oldExpiresAt in a single transaction.
+	Rotate(ctx context.Context, oldID uuid.UUID, oldExpiresAt time.Time, input CreateAPIKeyInput) (*APIKey, error)
+	List(ctx context.Context) ([]APIKey, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
+	TouchLastUsed(ctx context.Context, id uuid.UUID) error
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/repo"
+)
+
+// KeyPrefix marks API keys so they can be told apart from JWTs in an
+// Authorization header.
+const KeyPrefix = "isk_"
+
+const DefaultRotationGrace = 24 * time.Hour
+
+// Scopes known to the system. Keys may only be issued with these.
+const (
+	ScopeOrdersRead     = "orders:read"
+	ScopeOrdersWrite    = "orders:write"
+	ScopeInventoryRead  = "inventory:read"
+	ScopeInventoryWrite = "inventory:write"
+	ScopeProductsRead   = "products:read"
+	ScopeProductsWrite  = "products:write"
+	ScopePaymentsWrite  = "payments:write"
+	ScopeAPIKeysManage  = "api_keys:manage"
+	ScopeMetricsRead    = "metrics:read"
+)
+
+var knownScopes = map[string]struct{}{
+	ScopeOrdersRead:     {},
+	ScopeOrdersWrite:    {},
+	ScopeInventoryRead:  {},
+	ScopeInventoryWrite: {},
+	ScopeProductsRead:   {},
+	ScopeProductsWrite:  {},
+	ScopePaymentsWrite:  {},
+	ScopeAPIKeysManage:  {},
+	ScopeMetricsRead:    {},
+}
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrInvalidInput  = errors.New("invalid api key input")
+)
+
+type Service struct {
+	repo repo.Repository
+	now  func() time.Time
+}
+
+func New(r repo.Repository) *Service {
+	return &Service{repo: r, now: time.Now}
+}
+
+type CreateInput struct {
+	Name      string
+	Scopes    []string
+	ExpiresAt *time.Time
+	CreatedBy *uuid.UUID
+}
+
+// Create issues a new key. The plaintext key is returned once and never stored.
+func (s *Service) Create(ctx context.Context, in CreateInput) (string, *repo.APIKey, error) {
+	name := strings.TrimSpace(in.Name)
+	if name == "" || len(name) > 100 {
+		return "", nil, ErrInvalidInput
+	}
+	scopes, err := normalizeScopes(in.Scopes)
+	if err != nil {
+		return "", nil, err
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(s.now()) {
+		return "", nil, ErrInvalidInput
+	}
+
+	plaintext, prefix, hash, err := generateKey()
+	if err != nil {
+		return "", nil, err
+	}
+	k, err := s.repo.Create(ctx, repo.CreateAPIKeyInput{
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		CreatedBy: in.CreatedBy,
+		ExpiresAt: in.ExpiresAt,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return plaintext, k, nil
+}
+
+// Rotate issues a replacement with the same name and scopes. The old key keeps
+// working for grace so callers can roll over without downtime.
+func (s *Service) Rotate(ctx context.Context, id uuid.UUID, grace time.Duration, rotatedBy *uuid.UUID) (string, *repo.APIKey, error) {
+	if grace < 0 {
+		return "", nil, ErrInvalidInput
+	}
+	old, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if !old.Active(s.now()) {
+		return "", nil, ErrInvalidAPIKey
+	}
+
+	plaintext, prefix, hash, err := generateKey()
+	if err != nil {
+		return "", nil, err
+	}
+	k, err := s.repo.Rotate(ctx, old.ID, s.now().Add(grace), repo.CreateAPIKeyInput{
+		Name:        old.Name,
+		Prefix:      prefix,
+		KeyHash:     hash,
+		Scopes:      old.Scopes,
+		CreatedBy:   rotatedBy,
+		RotatedFrom: &old.ID,
+		ExpiresAt:   old.ExpiresAt,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return plaintext, k, nil
+}
+
+func (s *Service) List(ctx context.Context) ([]repo.APIKey, error) {
+	return s.repo.List(ctx)
+}
+
+func (s *Service) Revoke(ctx context.Context, id uuid.UUID) error {
+	return s.repo.Revoke(ctx, id)
+}
+
+// Authenticate resolves a plaintext key to an active API key.
+func (s *Service) Authenticate(ctx context.Context, plaintext string) (*repo.APIKey, error) {
+	prefix, ok := parsePrefix(plaintext)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	k, err := s.repo.GetByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashKey(plaintext)), []byte(k.KeyHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if !k.Active(s.now()) {
+		return nil, ErrInvalidAPIKey
+	}
+	_ = s.repo.TouchLastUsed(ctx, k.ID)
+	return k, nil
+}
+
+// IsAPIKey reports whether token has the API key shape rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, KeyPrefix)
+}
+
+// generateKey returns isk_<prefix>_<secret>; only the prefix is stored in clear.
+func generateKey() (plaintext, prefix, hash string, err error) {
+	p := make([]byte, 6)
+	if _, err := rand.Read(p); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(p)
+	plaintext = KeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return plaintext, prefix, hashKey(plaintext), nil
+}
+
+func parsePrefix(plaintext string) (string, bool) {
+	rest, ok := strings.CutPrefix(plaintext, KeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeScopes(in []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		s = strings.TrimSpace(strings.ToLower(s))
+		if s == "" {
+			continue
+		}
+		if _, ok := knownScopes[s]; !ok {
+			return nil, ErrInvalidInput
+		}
+		if _, dup := seen[s]; dup {
+			continue
+		}
+		seen[s] = struct{}{}
+		out = append(out, s)
+	}
+	if len(out) == 0 {
+		return nil, ErrInvalidInput
+	}
+	sort.Strings(out)
+	return out, nil
+}
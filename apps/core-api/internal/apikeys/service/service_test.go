@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/repo"
+)
+
+func TestParsePrefix(t *testing.T) {
+	for in, want := range map[string]string{
+		"isk_a1b2c3d4e5f6_c2VjcmV0":    "a1b2c3d4e5f6",
+		"isk_a1b2c3d4e5f6_c2Vj_cmV0":   "a1b2c3d4e5f6",
+		"isk_a1b2c3d4e5f6_":            "",
+		"isk__c2VjcmV0":                "",
+		"isk_a1b2c3d4e5f6":             "",
+		"a1b2c3d4e5f6_c2VjcmV0":        "",
+		"eyJhbGciOiJIUzI1NiJ9.e30.sig": "",
+		"ISK_a1b2c3d4e5f6_c2VjcmV0":    "",
+		"":                             "",
+	} {
+		got, ok := parsePrefix(in)
+		if got != want || ok != (want != "") {
+			t.Errorf("parsePrefix(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+
+	plaintext, prefix, _, err := generateKey()
+	if err != nil {
+		t.Fatalf("generateKey: %v", err)
+	}
+	if got, ok := parsePrefix(plaintext); !ok || got != prefix || !IsAPIKey(plaintext) {
+		t.Errorf("parsePrefix of a generated key = %q, %v; want %q", got, ok, prefix)
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	for name, tc := range map[string]struct {
+		in      []string
+		want    []string
+		wantErr bool
+	}{
+		"sorted":         {in: []string{"orders:write", "orders:read"}, want: []string{"orders:read", "orders:write"}},
+		"case and space": {in: []string{" Metrics:Read ", "PRODUCTS:read"}, want: []string{"metrics:read", "products:read"}},
+		"duplicates":     {in: []string{"orders:read", "orders:read", "ORDERS:READ"}, want: []string{"orders:read"}},
+		"blank skipped":  {in: []string{"", " ", "inventory:read"}, want: []string{"inventory:read"}},
+		"unknown":        {in: []string{"orders:read", "orders:delete"}, wantErr: true},
+		"none":           {in: nil, wantErr: true},
+		"only blanks":    {in: []string{" "}, wantErr: true},
+	} {
+		got, err := normalizeScopes(tc.in)
+		if tc.wantErr {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("%s: err = %v, want ErrInvalidInput", name, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%s: normalizeScopes = %v, %v; want %v", name, got, err, tc.want)
+		}
+	}
+}
+
+// memRepo keeps keys in memory; Rotate shortens the old key like the
+// Postgres implementation does.
+type memRepo struct {
+	repo.Repository
+	keys map[uuid.UUID]*repo.APIKey
+}
+
+func (m *memRepo) Create(_ context.Context, in repo.CreateAPIKeyInput) (*repo.APIKey, error) {
+	k := &repo.APIKey{ID: uuid.New(), Name: in.Name, Prefix: in.Prefix, KeyHash: in.KeyHash, Scopes: in.Scopes,
+		CreatedBy: in.CreatedBy, RotatedFrom: in.RotatedFrom, ExpiresAt: in.ExpiresAt}
+	m.keys[k.ID] = k
+	return k, nil
+}
+
+func (m *memRepo) Rotate(ctx context.Context, oldID uuid.UUID, oldExpiresAt time.Time, in repo.CreateAPIKeyInput) (*repo.APIKey, error) {
+	old, ok := m.keys[oldID]
+	if !ok || old.RevokedAt != nil {
+		return nil, pgx.ErrNoRows
+	}
+	if old.ExpiresAt == nil || oldExpiresAt.Before(*old.ExpiresAt) {
+		old.ExpiresAt = &oldExpiresAt
+	}
+	return m.Create(ctx, in)
+}
+
+func (m *memRepo) GetByID(_ context.Context, id uuid.UUID) (*repo.APIKey, error) {
+	if k, ok := m.keys[id]; ok {
+		return k, nil
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (m *memRepo) GetByPrefix(_ context.Context, prefix string) (*repo.APIKey, error) {
+	for _, k := range m.keys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return nil, pgx.ErrNoRows
+}
+
+func (m *memRepo) TouchLastUsed(context.Context, uuid.UUID) error { return nil }
+
+func TestRotate_OldKeyWorksForTheGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	s := New(&memRepo{keys: map[uuid.UUID]*repo.APIKey{}})
+	s.now = func() time.Time { return now }
+
+	oldKey, old, err := s.Create(ctx, CreateInput{Name: "warehouse sync", Scopes: []string{"inventory:write", "metrics:read"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, _, err := s.Rotate(ctx, old.ID, -time.Second, nil); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("Rotate with a negative grace: err = %v, want ErrInvalidInput", err)
+	}
+	newKey, k, err := s.Rotate(ctx, old.ID, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if k.Name != old.Name || !slices.Equal(k.Scopes, old.Scopes) || k.RotatedFrom == nil || *k.RotatedFrom != old.ID {
+		t.Fatalf("replacement = %+v, want the old key's name and scopes, rotated from it", k)
+	}
+	if newKey == oldKey || k.Prefix == old.Prefix {
+		t.Fatal("replacement reuses the old key")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		at      time.Duration
+		key     string
+		wantErr bool
+	}{
+		{name: "old key within the grace period", at: 59 * time.Minute, key: oldKey},
+		{name: "new key within the grace period", at: 59 * time.Minute, key: newKey},
+		{name: "old key after the grace period", at: time.Hour, key: oldKey, wantErr: true},
+		{name: "new key after the grace period", at: 48 * time.Hour, key: newKey},
+	} {
+		now = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC).Add(tc.at)
+		_, err := s.Authenticate(ctx, tc.key)
+		if tc.wantErr && !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("%s: err = %v, want ErrInvalidAPIKey", tc.name, err)
+		}
+		if !tc.wantErr && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+
+	if _, _, err := s.Rotate(ctx, old.ID, time.Hour, nil); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("Rotate an expired key: err = %v, want ErrInvalidAPIKey", err)
+	}
+}
+
+func TestAuthenticate_RejectsWrongSecrets(t *testing.T) {
+	ctx := context.Background()
+	s := New(&memRepo{keys: map[uuid.UUID]*repo.APIKey{}})
+	key, _, err := s.Create(ctx, CreateInput{Name: "reports", Scopes: []string{"orders:read"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	prefix, _ := parsePrefix(key)
+
+	for _, token := range []string{KeyPrefix + prefix + "_forged", KeyPrefix + "000000000000_secret", "not-a-key"} {
+		if _, err := s.Authenticate(ctx, token); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("Authenticate(%q): err = %v, want ErrInvalidAPIKey", token, err)
+		}
+	}
+	if _, err := s.Authenticate(ctx, key); err != nil {
+		t.Errorf("Authenticate with the issued key: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/repo"
@@ -34,8 +35,34 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token string    `json:"token"`
-	User  repo.User `json:"user"`
+	Token                  string    `json:"token"`
+	User                   repo.User `json:"user"`
+	TwoFactorSetupRequired bool      `json:"two_factor_setup_required,omitempty"`
+}
+
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TwoFactorEnabledResponse struct {
+	Token         string   `json:"token,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 // Register godoc
@@ -77,8 +104,10 @@ func (c *Controller) Register(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param body body LoginRequest true "Login"
+// @Description Returns a session token, or a TwoFactorChallengeResponse when the account has two-factor authentication enabled.
 // @Success 200 {object} AuthResponse
 // @Failure 401 {object} map[string]any
+// @Failure 429 {object} map[string]any
 // @Router /api/auth/login [post]
 func (c *Controller) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
@@ -87,8 +116,12 @@ func (c *Controller) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, user, err := c.svc.Login(r.Context(), service.LoginInput{Email: req.Email, Password: req.Password})
+	res, err := c.svc.Login(r.Context(), service.LoginInput{Email: req.Email, Password: req.Password})
 	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorLocked) {
+			httpjson.WriteError(w, http.StatusTooManyRequests, "too many failed two-factor codes")
+			return
+		}
 		if errors.Is(err, pgx.ErrNoRows) {
 			httpjson.WriteError(w, http.StatusUnauthorized, "invalid credentials")
 			return
@@ -97,12 +130,221 @@ func (c *Controller) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if res.TwoFactorRequired() {
+		httpjson.WriteJSON(w, http.StatusOK, TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    res.ChallengeToken,
+			ExpiresIn:         int(res.ChallengeExpiresIn.Seconds()),
+		})
+		return
+	}
+
+	resp := map[string]any{
+		"token": res.Token,
+		"user":  res.User,
+	}
+	if res.TwoFactorSetupRequired {
+		resp["two_factor_setup_required"] = true
+	}
+	httpjson.WriteJSON(w, http.StatusOK, resp)
+}
+
+// VerifyTwoFactorLogin godoc
+// @Summary Complete login with a two-factor code
+// @Description Exchanges the challenge token returned by login for a session. The code may be a TOTP code or a recovery code.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param body body TwoFactorLoginRequest true "Challenge"
+// @Success 200 {object} AuthResponse
+// @Failure 401 {object} map[string]any
+// @Failure 429 {object} map[string]any
+// @Router /api/auth/login/2fa [post]
+func (c *Controller) VerifyTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	res, err := c.svc.VerifyTwoFactorLogin(r.Context(), req.ChallengeToken, req.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorLocked) {
+			httpjson.WriteError(w, http.StatusTooManyRequests, "too many failed two-factor codes")
+			return
+		}
+		httpjson.WriteError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
 	httpjson.WriteJSON(w, http.StatusOK, map[string]any{
-		"token": token,
-		"user":  user,
+		"token": res.Token,
+		"user":  res.User,
+	})
+}
+
+// SetupTwoFactor godoc
+// @Summary Start two-factor enrollment
+// @Description Generates a TOTP secret and otpauth:// provisioning URI to render as a QR code. The secret is inactive until confirmed.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} TwoFactorSetupResponse
+// @Failure 409 {object} map[string]any
+// @Router /api/auth/2fa/setup [post]
+func (c *Controller) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	setup, err := c.svc.BeginTwoFactorSetup(r.Context(), userID)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	httpjson.WriteJSON(w, http.StatusOK, TwoFactorSetupResponse{
+		Secret:          setup.Secret,
+		ProvisioningURI: setup.ProvisioningURI,
+	})
+}
+
+// EnableTwoFactor godoc
+// @Summary Confirm two-factor enrollment
+// @Description Verifies a code for the pending secret, enables 2FA and returns one-time recovery codes plus a 2FA-verified token.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body TwoFactorCodeRequest true "Code"
+// @Success 200 {object} TwoFactorEnabledResponse
+// @Failure 400 {object} map[string]any
+// @Router /api/auth/2fa/enable [post]
+func (c *Controller) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	codes, token, err := c.svc.EnableTwoFactor(r.Context(), userID, req.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	httpjson.WriteJSON(w, http.StatusOK, TwoFactorEnabledResponse{
+		Token:         token,
+		RecoveryCodes: codes,
 	})
 }
 
+// DisableTwoFactor godoc
+// @Summary Disable two-factor authentication
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body TwoFactorCodeRequest true "TOTP or recovery code"
+// @Success 204
+// @Failure 400 {object} map[string]any
+// @Failure 403 {object} map[string]any
+// @Failure 429 {object} map[string]any
+// @Router /api/auth/2fa/disable [post]
+func (c *Controller) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := c.svc.DisableTwoFactor(r.Context(), userID, req.Code); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate two-factor recovery codes
+// @Description Invalidates all previous recovery codes. Requires a current TOTP code.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body TwoFactorCodeRequest true "TOTP code"
+// @Success 200 {object} TwoFactorEnabledResponse
+// @Failure 400 {object} map[string]any
+// @Failure 429 {object} map[string]any
+// @Router /api/auth/2fa/recovery-codes [post]
+func (c *Controller) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	codes, err := c.svc.RegenerateRecoveryCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, TwoFactorEnabledResponse{RecoveryCodes: codes})
+}
+
+func userIDFromRequest(r *http.Request) (uuid.UUID, bool) {
+	raw, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidTwoFactorCode):
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid code")
+	case errors.Is(err, service.ErrTwoFactorLocked):
+		httpjson.WriteError(w, http.StatusTooManyRequests, "too many failed two-factor codes")
+	case errors.Is(err, service.ErrTwoFactorAlreadyEnabled):
+		httpjson.WriteError(w, http.StatusConflict, "two-factor authentication already enabled")
+	case errors.Is(err, service.ErrTwoFactorNotEnabled):
+		httpjson.WriteError(w, http.StatusConflict, "two-factor authentication not enabled")
+	case errors.Is(err, service.ErrTwoFactorNotPending):
+		httpjson.WriteError(w, http.StatusConflict, "two-factor setup not started")
+	case errors.Is(err, service.ErrTwoFactorRequiredForRole):
+		httpjson.WriteError(w, http.StatusForbidden, "two-factor authentication is required for this account")
+	case errors.Is(err, pgx.ErrNoRows):
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, "two-factor request failed")
+	}
+}
+
 // Me godoc
 // @Summary Current user
 // @Tags auth
@@ -2,7 +2,10 @@ package repo
 
 import (
 	"context"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -14,30 +17,215 @@ func NewPostgres(pool *pgxpool.Pool) *Postgres {
 	return &Postgres{pool: pool}
 }
 
-func (r *Postgres) CreateUser(ctx context.Context, input CreateUserInput) (*User, error) {
-	row := r.pool.QueryRow(ctx, `
-		INSERT INTO users (email, password_hash, first_name, last_name, role, is_active, email_verified)
-		VALUES ($1, $2, $3, $4, $5, true, true)
-		RETURNING id, email, password_hash, first_name, last_name, role, is_active, created_at, updated_at
-	`, input.Email, input.PasswordHash, input.FirstName, input.LastName, input.Role)
+const userColumns = `id, email, password_hash, COALESCE(first_name, ''), COALESCE(last_name, ''), role, is_active,
+	totp_enabled, COALESCE(totp_secret, ''), totp_last_step, token_version,
+	COALESCE(two_factor_locked_until > NOW(), false), created_at, updated_at`
 
+func scanUser(row pgx.Row) (*User, error) {
 	var u User
-	if err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.Role, &u.IsActive, &u.CreatedAt, &u.UpdatedAt); err != nil {
+	if err := row.Scan(
+		&u.ID,
+		&u.Email,
+		&u.PasswordHash,
+		&u.FirstName,
+		&u.LastName,
+		&u.Role,
+		&u.IsActive,
+		&u.TwoFactorEnabled,
+		&u.TOTPSecret,
+		&u.TOTPLastStep,
+		&u.TokenVersion,
+		&u.TwoFactorLocked,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	); err != nil {
 		return nil, err
 	}
 	return &u, nil
 }
 
+func (r *Postgres) CreateUser(ctx context.Context, input CreateUserInput) (*User, error) {
+	row := r.pool.QueryRow(ctx, `
+		INSERT INTO users (email, password_hash, first_name, last_name, role, is_active, email_verified)
+		VALUES ($1, $2, $3, $4, $5, true, true)
+		RETURNING `+userColumns+`
+	`, input.Email, input.PasswordHash, input.FirstName, input.LastName, input.Role)
+	return scanUser(row)
+}
+
 func (r *Postgres) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	row := r.pool.QueryRow(ctx, `
-		SELECT id, email, password_hash, first_name, last_name, role, is_active, created_at, updated_at
+		SELECT `+userColumns+`
 		FROM users
 		WHERE email = $1 AND deleted_at IS NULL
 	`, email)
+	return scanUser(row)
+}
 
-	var u User
-	if err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.Role, &u.IsActive, &u.CreatedAt, &u.UpdatedAt); err != nil {
-		return nil, err
+func (r *Postgres) GetUserByID(ctx context.Context, id uuid.UUID) (*User, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id)
+	return scanUser(row)
+}
+
+func (r *Postgres) TokenVersion(ctx context.Context, userID uuid.UUID) (int, error) {
+	var version int
+	err := r.pool.QueryRow(ctx, `
+		SELECT token_version
+		FROM users
+		WHERE id = $1 AND deleted_at IS NULL AND is_active
+	`, userID).Scan(&version)
+	return version, err
+}
+
+func (r *Postgres) SetPendingTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET totp_secret = $2, totp_enabled = false, totp_enabled_at = NULL, totp_last_step = 0
+		WHERE id = $1 AND deleted_at IS NULL AND totp_enabled = false
+	`, userID, secret)
+	if err != nil {
+		return err
 	}
-	return &u, nil
+	if tag.RowsAffected() != 1 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *Postgres) EnableTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE users
+		SET totp_enabled = true, totp_enabled_at = NOW(), totp_last_step = $2
+		WHERE id = $1 AND deleted_at IS NULL AND totp_enabled = false AND totp_secret IS NOT NULL
+	`, userID, step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return pgx.ErrNoRows
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *Postgres) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled = false, totp_enabled_at = NULL, totp_last_step = 0
+		WHERE id = $1
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *Postgres) AdvanceTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users
+		SET totp_last_step = $2
+		WHERE id = $1 AND totp_last_step < $2
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *Postgres) CreateTwoFactorChallenge(ctx context.Context, id, userID uuid.UUID, expiresAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO two_factor_challenges (id, user_id, expires_at) VALUES ($1, $2, $3)
+	`, id, userID, expiresAt)
+	return err
+}
+
+func (r *Postgres) ConsumeTwoFactorChallenge(ctx context.Context, id, userID uuid.UUID) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE two_factor_challenges
+		SET used_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > NOW()
+	`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *Postgres) RecordTwoFactorFailure(ctx context.Context, userID uuid.UUID, limit int, lockout time.Duration) (bool, error) {
+	var locked bool
+	err := r.pool.QueryRow(ctx, `
+		UPDATE users
+		SET two_factor_failures = CASE WHEN two_factor_failures + 1 >= $2 THEN 0 ELSE two_factor_failures + 1 END,
+		    two_factor_locked_until = CASE
+		        WHEN two_factor_failures + 1 >= $2 THEN NOW() + $3::float8 * INTERVAL '1 second'
+		        ELSE two_factor_locked_until
+		    END
+		WHERE id = $1
+		RETURNING COALESCE(two_factor_locked_until > NOW(), false)
+	`, userID, limit, lockout.Seconds()).Scan(&locked)
+	return locked, err
+}
+
+func (r *Postgres) ResetTwoFactorFailures(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE users SET two_factor_failures = 0 WHERE id = $1 AND two_factor_failures > 0
+	`, userID)
+	return err
+}
+
+func (r *Postgres) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID uuid.UUID, hashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO user_recovery_codes (user_id, code_hash)
+		SELECT $1, h FROM unnest($2::text[]) AS h
+	`, userID, hashes)
+	return err
+}
+
+func (r *Postgres) ConsumeRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE user_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
 }
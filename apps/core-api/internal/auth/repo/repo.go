@@ -8,15 +8,20 @@ import (
 )
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	FirstName    string    `json:"first_name,omitempty"`
-	LastName     string    `json:"last_name,omitempty"`
-	Role         string    `json:"role"`
-	IsActive     bool      `json:"is_active"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID               uuid.UUID `json:"id"`
+	Email            string    `json:"email"`
+	PasswordHash     string    `json:"-"`
+	FirstName        string    `json:"first_name,omitempty"`
+	LastName         string    `json:"last_name,omitempty"`
+	Role             string    `json:"role"`
+	IsActive         bool      `json:"is_active"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	TOTPSecret       string    `json:"-"`
+	TOTPLastStep     int64     `json:"-"`
+	TokenVersion     int       `json:"-"`
+	TwoFactorLocked  bool      `json:"-"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
 }
 
 type CreateUserInput struct {
@@ -30,4 +35,30 @@ type CreateUserInput struct {
 type Repository interface {
 	CreateUser(ctx context.Context, input CreateUserInput) (*User, error)
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (*User, error)
+	// TokenVersion returns the version tokens must carry to be accepted,
+	// pgx.ErrNoRows once the user is erased or deactivated.
+	TokenVersion(ctx context.Context, userID uuid.UUID) (int, error)
+
+	// SetPendingTOTPSecret stores a secret that is not active until EnableTOTP.
+	SetPendingTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error
+	EnableTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID uuid.UUID) error
+	// AdvanceTOTPStep records step as used; it returns false if step was already used.
+	AdvanceTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error
+	// ConsumeRecoveryCode marks a code as used; it returns false if no unused code matches.
+	ConsumeRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) (bool, error)
+
+	// CreateTwoFactorChallenge records a login challenge for
+	// ConsumeTwoFactorChallenge.
+	CreateTwoFactorChallenge(ctx context.Context, id, userID uuid.UUID, expiresAt time.Time) error
+	// ConsumeTwoFactorChallenge uses up the challenge; it returns false if it
+	// was already used, has expired or is not the user's.
+	ConsumeTwoFactorChallenge(ctx context.Context, id, userID uuid.UUID) (bool, error)
+	// RecordTwoFactorFailure counts a failed code and locks the user's second
+	// factor for lockout once limit failures in a row are reached. It
+	// reports whether the second factor is now locked.
+	RecordTwoFactorFailure(ctx context.Context, userID uuid.UUID, limit int, lockout time.Duration) (bool, error)
+	ResetTwoFactorFailures(ctx context.Context, userID uuid.UUID) error
 }
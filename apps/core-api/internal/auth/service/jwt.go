@@ -8,15 +8,24 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// PurposeTwoFactorChallenge marks a token that only proves the password step of
+// a two-factor login. It is never accepted as an access token.
+const PurposeTwoFactorChallenge = "2fa_challenge"
+
 type JWT struct {
 	secret []byte
 	expiry time.Duration
 }
 
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID  string `json:"user_id"`
+	Email   string `json:"email"`
+	Role    string `json:"role"`
+	MFA     bool   `json:"mfa,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+	// TokenVersion is the user's token_version when the token was issued;
+	// see Service.TokenVersion.
+	TokenVersion int `json:"tv,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -27,18 +36,34 @@ func NewJWT(secret string, expiry time.Duration) *JWT {
 	}
 }
 
-func (j *JWT) GenerateToken(userID, email, role string) (string, error) {
-	now := time.Now().UTC()
+// GenerateToken issues an access token for the user's current token
+// version. mfa records whether the session completed a second factor.
+func (j *JWT) GenerateToken(userID, email, role string, version int, mfa bool) (string, error) {
+	return j.sign(&Claims{
+		UserID:       userID,
+		Email:        email,
+		Role:         role,
+		MFA:          mfa,
+		TokenVersion: version,
+	}, j.expiry)
+}
+
+// GenerateChallengeToken issues the token for the second step of a login.
+// id is its jti, the challenge row that makes it single-use.
+func (j *JWT) GenerateChallengeToken(userID, id string, ttl time.Duration) (string, error) {
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   userID,
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(j.expiry)),
-		},
+		UserID:  userID,
+		Purpose: PurposeTwoFactorChallenge,
 	}
+	claims.ID = id
+	return j.sign(claims, ttl)
+}
+
+func (j *JWT) sign(claims *Claims, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims.Subject = claims.UserID
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	signed, err := token.SignedString(j.secret)
@@ -49,6 +74,28 @@ func (j *JWT) GenerateToken(userID, email, role string) (string, error) {
 }
 
 func (j *JWT) ParseToken(tokenString string) (*Claims, error) {
+	claims, err := j.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("invalid token purpose")
+	}
+	return claims, nil
+}
+
+func (j *JWT) ParseChallengeToken(tokenString string) (*Claims, error) {
+	claims, err := j.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeTwoFactorChallenge {
+		return nil, errors.New("invalid token purpose")
+	}
+	return claims, nil
+}
+
+func (j *JWT) parse(tokenString string) (*Claims, error) {
 	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	var claims Claims
 	_, err := parser.ParseWithClaims(tokenString, &claims, func(_ *jwt.Token) (any, error) {
@@ -4,19 +4,83 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/repo"
 )
 
+var (
+	ErrInvalidCredentials       = errors.New("invalid credentials")
+	ErrInvalidTwoFactorCode     = errors.New("invalid two-factor code")
+	ErrTwoFactorAlreadyEnabled  = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotEnabled      = errors.New("two-factor authentication not enabled")
+	ErrTwoFactorNotPending      = errors.New("two-factor setup not started")
+	ErrTwoFactorRequiredForRole = errors.New("two-factor authentication is required for this role")
+	ErrTwoFactorLocked          = errors.New("too many failed two-factor codes, try again later")
+)
+
+type Options struct {
+	// TOTPIssuer is shown by authenticator apps next to the account name.
+	TOTPIssuer string
+	// TwoFactorRequiredRoles lists roles whose sessions must complete 2FA.
+	TwoFactorRequiredRoles []string
+	ChallengeTTL           time.Duration
+	// MaxTwoFactorFailures failed codes in a row lock the user's second
+	// factor for TwoFactorLockout.
+	MaxTwoFactorFailures int
+	TwoFactorLockout     time.Duration
+	// Now overrides the clock used for TOTP validation (tests).
+	Now func() time.Time
+}
+
 type Service struct {
 	repo repo.Repository
 	jwt  *JWT
+
+	totpIssuer    string
+	requiredRoles map[string]struct{}
+	challengeTTL  time.Duration
+	maxFailures   int
+	lockout       time.Duration
+	now           func() time.Time
 }
 
-func New(r repo.Repository, jwt *JWT) *Service {
-	return &Service{repo: r, jwt: jwt}
+func New(r repo.Repository, jwt *JWT, opts Options) *Service {
+	s := &Service{
+		repo:          r,
+		jwt:           jwt,
+		totpIssuer:    opts.TOTPIssuer,
+		requiredRoles: make(map[string]struct{}, len(opts.TwoFactorRequiredRoles)),
+		challengeTTL:  opts.ChallengeTTL,
+		maxFailures:   opts.MaxTwoFactorFailures,
+		lockout:       opts.TwoFactorLockout,
+		now:           opts.Now,
+	}
+	if s.totpIssuer == "" {
+		s.totpIssuer = "iPhone Storage"
+	}
+	if s.challengeTTL <= 0 {
+		s.challengeTTL = 5 * time.Minute
+	}
+	if s.maxFailures <= 0 {
+		s.maxFailures = 5
+	}
+	if s.lockout <= 0 {
+		s.lockout = 15 * time.Minute
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+	for _, role := range opts.TwoFactorRequiredRoles {
+		role = strings.TrimSpace(strings.ToLower(role))
+		if role != "" {
+			s.requiredRoles[role] = struct{}{}
+		}
+	}
+	return s
 }
 
 type RegisterInput struct {
@@ -31,6 +95,23 @@ type LoginInput struct {
 	Password string
 }
 
+// LoginResult is either a session (Token) or, for accounts with 2FA enabled,
+// a ChallengeToken to be exchanged through VerifyTwoFactorLogin.
+type LoginResult struct {
+	Token                  string
+	User                   *repo.User
+	ChallengeToken         string
+	ChallengeExpiresIn     time.Duration
+	TwoFactorSetupRequired bool
+}
+
+func (r *LoginResult) TwoFactorRequired() bool { return r.ChallengeToken != "" }
+
+type TwoFactorSetup struct {
+	Secret          string
+	ProvisioningURI string
+}
+
 func (s *Service) Register(ctx context.Context, in RegisterInput) (string, *repo.User, error) {
 	email := strings.TrimSpace(strings.ToLower(in.Email))
 	if email == "" || len(in.Password) < 8 {
@@ -53,33 +134,259 @@ func (s *Service) Register(ctx context.Context, in RegisterInput) (string, *repo
 		return "", nil, err
 	}
 
-	token, err := s.jwt.GenerateToken(u.ID.String(), u.Email, u.Role)
+	token, err := s.jwt.GenerateToken(u.ID.String(), u.Email, u.Role, u.TokenVersion, false)
 	if err != nil {
 		return "", nil, err
 	}
 	return token, u, nil
 }
 
-func (s *Service) Login(ctx context.Context, in LoginInput) (string, *repo.User, error) {
+func (s *Service) Login(ctx context.Context, in LoginInput) (*LoginResult, error) {
 	email := strings.TrimSpace(strings.ToLower(in.Email))
 	if email == "" || in.Password == "" {
-		return "", nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
 
 	u, err := s.repo.GetUserByEmail(ctx, email)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(in.Password)); err != nil {
-		return "", nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
+	}
+
+	if u.TwoFactorEnabled {
+		if u.TwoFactorLocked {
+			return nil, ErrTwoFactorLocked
+		}
+		id := uuid.New()
+		if err := s.repo.CreateTwoFactorChallenge(ctx, id, u.ID, time.Now().Add(s.challengeTTL)); err != nil {
+			return nil, err
+		}
+		challenge, err := s.jwt.GenerateChallengeToken(u.ID.String(), id.String(), s.challengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{ChallengeToken: challenge, ChallengeExpiresIn: s.challengeTTL}, nil
 	}
 
-	token, err := s.jwt.GenerateToken(u.ID.String(), u.Email, u.Role)
+	token, err := s.jwt.GenerateToken(u.ID.String(), u.Email, u.Role, u.TokenVersion, false)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
-	return token, u, nil
+	return &LoginResult{
+		Token:                  token,
+		User:                   u,
+		TwoFactorSetupRequired: s.TwoFactorRequired(u.Role),
+	}, nil
+}
+
+// VerifyTwoFactorLogin completes a login started by Login. code may be a TOTP
+// code or an unused recovery code. A challenge is good for one attempt, so
+// a wrong code means logging in again.
+func (s *Service) VerifyTwoFactorLogin(ctx context.Context, challengeToken, code string) (*LoginResult, error) {
+	claims, err := s.jwt.ParseChallengeToken(challengeToken)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	challengeID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	consumed, err := s.repo.ConsumeTwoFactorChallenge(ctx, challengeID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrInvalidCredentials
+	}
+
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !u.TwoFactorEnabled {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := s.checkSecondFactor(ctx, u, code, true); err != nil {
+		return nil, err
+	}
+
+	token, err := s.jwt.GenerateToken(u.ID.String(), u.Email, u.Role, u.TokenVersion, true)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Token: token, User: u}, nil
+}
+
+// TokenVersion returns the token version the user's access tokens must
+// carry. Changing the password or erasing the account bumps it; an erased
+// or deactivated user has none and the error is pgx.ErrNoRows.
+func (s *Service) TokenVersion(ctx context.Context, userID uuid.UUID) (int, error) {
+	return s.repo.TokenVersion(ctx, userID)
+}
+
+// TwoFactorRequired reports whether sessions for role must complete 2FA.
+func (s *Service) TwoFactorRequired(role string) bool {
+	_, ok := s.requiredRoles[strings.ToLower(role)]
+	return ok
+}
+
+// BeginTwoFactorSetup generates a new secret for the user. It is stored as
+// pending and only takes effect once confirmed with EnableTwoFactor.
+func (s *Service) BeginTwoFactorSetup(ctx context.Context, userID uuid.UUID) (*TwoFactorSetup, error) {
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetPendingTOTPSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+	return &TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: TOTPProvisioningURI(s.totpIssuer, u.Email, secret),
+	}, nil
+}
+
+// EnableTwoFactor confirms the pending secret with a current code and returns
+// the one-time recovery codes together with a fresh 2FA-verified session token.
+func (s *Service) EnableTwoFactor(ctx context.Context, userID uuid.UUID, code string) ([]string, string, error) {
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.TwoFactorEnabled {
+		return nil, "", ErrTwoFactorAlreadyEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, "", ErrTwoFactorNotPending
+	}
+
+	step, ok := ValidateTOTP(u.TOTPSecret, code, s.now(), 0)
+	if !ok {
+		return nil, "", ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.repo.EnableTOTP(ctx, userID, step, hashes); err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.jwt.GenerateToken(u.ID.String(), u.Email, u.Role, u.TokenVersion, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return codes, token, nil
+}
+
+func (s *Service) DisableTwoFactor(ctx context.Context, userID uuid.UUID, code string) error {
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !u.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if s.TwoFactorRequired(u.Role) {
+		return ErrTwoFactorRequiredForRole
+	}
+	if err := s.checkSecondFactor(ctx, u, code, true); err != nil {
+		return err
+	}
+	return s.repo.DisableTOTP(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes. It requires a TOTP code,
+// not a recovery code.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	u, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !u.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := s.checkSecondFactor(ctx, u, code, false); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkSecondFactor verifies code, counting failures towards the lockout.
+func (s *Service) checkSecondFactor(ctx context.Context, u *repo.User, code string, allowRecovery bool) error {
+	if u.TwoFactorLocked {
+		return ErrTwoFactorLocked
+	}
+	err := s.verifySecondFactor(ctx, u, code, allowRecovery)
+	switch {
+	case errors.Is(err, ErrInvalidTwoFactorCode):
+		locked, rerr := s.repo.RecordTwoFactorFailure(ctx, u.ID, s.maxFailures, s.lockout)
+		if rerr != nil {
+			return rerr
+		}
+		if locked {
+			return ErrTwoFactorLocked
+		}
+		return err
+	case err != nil:
+		return err
+	}
+	return s.repo.ResetTwoFactorFailures(ctx, u.ID)
+}
+
+func (s *Service) verifySecondFactor(ctx context.Context, u *repo.User, code string, allowRecovery bool) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrInvalidTwoFactorCode
+	}
+
+	if step, ok := ValidateTOTP(u.TOTPSecret, code, s.now(), u.TOTPLastStep); ok {
+		advanced, err := s.repo.AdvanceTOTPStep(ctx, u.ID, step)
+		if err != nil {
+			return err
+		}
+		if !advanced {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	if !allowRecovery {
+		return ErrInvalidTwoFactorCode
+	}
+	consumed, err := s.repo.ConsumeRecoveryCode(ctx, u.ID, HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
 }
 
 func (s *Service) JWT() *JWT { return s.jwt }
@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/repo"
+)
+
+func TestTOTPCodeAt_RFC6238Vectors(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("12345678901234567890")
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tc := range cases {
+		if got := totpCodeAt(key, tc.unix/totpPeriod, 8); got != tc.want {
+			t.Fatalf("code at %d = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestValidateTOTP_SkewAndReplay(t *testing.T) {
+	t.Parallel()
+
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	code, err := TOTPCode(secret, now.Add(-totpPeriod*time.Second))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+
+	step, ok := ValidateTOTP(secret, code, now, 0)
+	if !ok {
+		t.Fatal("previous-step code rejected, want accepted within skew")
+	}
+	if _, ok := ValidateTOTP(secret, code, now, step); ok {
+		t.Fatal("code accepted twice, want replay rejected")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(2*totpPeriod*time.Second), 0); ok {
+		t.Fatal("stale code accepted, want rejected outside skew")
+	}
+}
+
+func TestLogin_TwoStepWithFixedClock(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 9, 9, 10, 0, 0, 0, time.UTC)
+	store := newMemoryRepo(t, "admin@example.com", "admin12345", "admin")
+	svc := New(store, NewJWT("test-secret", time.Hour), Options{
+		TwoFactorRequiredRoles: []string{"admin"},
+		Now:                    func() time.Time { return now },
+	})
+
+	res, err := svc.Login(ctx, LoginInput{Email: "admin@example.com", Password: "admin12345"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if res.TwoFactorRequired() || !res.TwoFactorSetupRequired {
+		t.Fatalf("login before enrollment = %+v, want session with setup required", res)
+	}
+
+	setup, err := svc.BeginTwoFactorSetup(ctx, store.user.ID)
+	if err != nil {
+		t.Fatalf("BeginTwoFactorSetup: %v", err)
+	}
+	code, _ := TOTPCode(setup.Secret, now)
+	recovery, _, err := svc.EnableTwoFactor(ctx, store.user.ID, code)
+	if err != nil {
+		t.Fatalf("EnableTwoFactor: %v", err)
+	}
+
+	res, err = svc.Login(ctx, LoginInput{Email: "admin@example.com", Password: "admin12345"})
+	if err != nil {
+		t.Fatalf("Login after enrollment: %v", err)
+	}
+	if !res.TwoFactorRequired() || res.Token != "" {
+		t.Fatalf("login after enrollment = %+v, want challenge only", res)
+	}
+	if _, err := svc.JWT().ParseToken(res.ChallengeToken); err == nil {
+		t.Fatal("challenge token accepted as access token")
+	}
+
+	if _, err := svc.VerifyTwoFactorLogin(ctx, res.ChallengeToken, code); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("replayed enrollment code: err = %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	now = now.Add(totpPeriod * time.Second)
+	next, _ := TOTPCode(setup.Secret, now)
+	verified, err := svc.VerifyTwoFactorLogin(ctx, challenge(t, svc), next)
+	if err != nil {
+		t.Fatalf("VerifyTwoFactorLogin: %v", err)
+	}
+	claims, err := svc.JWT().ParseToken(verified.Token)
+	if err != nil || !claims.MFA {
+		t.Fatalf("verified token claims = %+v, err = %v, want mfa session", claims, err)
+	}
+
+	if _, err := svc.VerifyTwoFactorLogin(ctx, challenge(t, svc), recovery[0]); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if _, err := svc.VerifyTwoFactorLogin(ctx, challenge(t, svc), recovery[0]); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("reused recovery code: err = %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	if err := svc.DisableTwoFactor(ctx, store.user.ID, recovery[1]); !errors.Is(err, ErrTwoFactorRequiredForRole) {
+		t.Fatalf("DisableTwoFactor for admin: err = %v, want ErrTwoFactorRequiredForRole", err)
+	}
+}
+
+func TestVerifyTwoFactorLogin_ChallengeIsSingleUse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 9, 9, 10, 0, 0, 0, time.UTC)
+	store, secret := enrolledRepo(t, now)
+	svc := New(store, NewJWT("test-secret", time.Hour), Options{Now: func() time.Time { return now }})
+
+	token := challenge(t, svc)
+	if _, err := svc.VerifyTwoFactorLogin(ctx, token, "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("wrong code: err = %v, want ErrInvalidTwoFactorCode", err)
+	}
+	now = now.Add(totpPeriod * time.Second)
+	code, _ := TOTPCode(secret, now)
+	if _, err := svc.VerifyTwoFactorLogin(ctx, token, code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("second attempt on a challenge: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	token = challenge(t, svc)
+	if _, err := svc.VerifyTwoFactorLogin(ctx, token, code); err != nil {
+		t.Fatalf("VerifyTwoFactorLogin: %v", err)
+	}
+	now = now.Add(totpPeriod * time.Second)
+	code, _ = TOTPCode(secret, now)
+	if _, err := svc.VerifyTwoFactorLogin(ctx, token, code); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("replayed challenge: err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestVerifyTwoFactorLogin_LocksOutAfterFailedCodes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 9, 9, 10, 0, 0, 0, time.UTC)
+	store, secret := enrolledRepo(t, now)
+	svc := New(store, NewJWT("test-secret", time.Hour), Options{
+		MaxTwoFactorFailures: 3,
+		Now:                  func() time.Time { return now },
+	})
+
+	// A success resets the count.
+	for i := 0; i < 2; i++ {
+		if _, err := svc.VerifyTwoFactorLogin(ctx, challenge(t, svc), "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("failure %d: err = %v, want ErrInvalidTwoFactorCode", i+1, err)
+		}
+	}
+	now = now.Add(totpPeriod * time.Second)
+	code, _ := TOTPCode(secret, now)
+	if _, err := svc.VerifyTwoFactorLogin(ctx, challenge(t, svc), code); err != nil {
+		t.Fatalf("VerifyTwoFactorLogin: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := svc.VerifyTwoFactorLogin(ctx, challenge(t, svc), "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("failure %d after reset: err = %v, want ErrInvalidTwoFactorCode", i+1, err)
+		}
+	}
+	if _, err := svc.VerifyTwoFactorLogin(ctx, challenge(t, svc), "000000"); !errors.Is(err, ErrTwoFactorLocked) {
+		t.Fatalf("third failure: err = %v, want ErrTwoFactorLocked", err)
+	}
+	if store.lockout != 15*time.Minute {
+		t.Fatalf("lockout = %v, want the 15m default", store.lockout)
+	}
+
+	if _, err := svc.Login(ctx, LoginInput{Email: "user@example.com", Password: "user12345"}); !errors.Is(err, ErrTwoFactorLocked) {
+		t.Fatalf("login while locked: err = %v, want ErrTwoFactorLocked", err)
+	}
+	now = now.Add(totpPeriod * time.Second)
+	code, _ = TOTPCode(secret, now)
+	if err := svc.DisableTwoFactor(ctx, store.user.ID, code); !errors.Is(err, ErrTwoFactorLocked) {
+		t.Fatalf("correct code while locked: err = %v, want ErrTwoFactorLocked", err)
+	}
+
+	store.user.TwoFactorLocked = false
+	if _, err := svc.VerifyTwoFactorLogin(ctx, challenge(t, svc), code); err != nil {
+		t.Fatalf("VerifyTwoFactorLogin after the lockout: %v", err)
+	}
+}
+
+// challenge logs in as the memoryRepo user and returns the challenge token.
+func challenge(t *testing.T, svc *Service) string {
+	t.Helper()
+	m := svc.repo.(*memoryRepo)
+	res, err := svc.Login(context.Background(), LoginInput{Email: m.user.Email, Password: m.password})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !res.TwoFactorRequired() {
+		t.Fatalf("login = %+v, want a challenge", res)
+	}
+	return res.ChallengeToken
+}
+
+// enrolledRepo returns a user with two-factor enabled at now and its secret.
+func enrolledRepo(t *testing.T, now time.Time) (*memoryRepo, string) {
+	t.Helper()
+	store := newMemoryRepo(t, "user@example.com", "user12345", "customer")
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret: %v", err)
+	}
+	store.user.TOTPSecret = secret
+	store.user.TwoFactorEnabled = true
+	store.user.TOTPLastStep = now.Unix() / totpPeriod
+	return store, secret
+}
+
+type memoryRepo struct {
+	user       repo.User
+	password   string
+	codes      map[string]bool
+	challenges map[uuid.UUID]bool
+	failures   int
+	lockout    time.Duration
+}
+
+func newMemoryRepo(t *testing.T, email, password, role string) *memoryRepo {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	return &memoryRepo{
+		user:       repo.User{ID: uuid.New(), Email: email, PasswordHash: string(hash), Role: role, IsActive: true},
+		password:   password,
+		codes:      map[string]bool{},
+		challenges: map[uuid.UUID]bool{},
+	}
+}
+
+func (m *memoryRepo) CreateUser(context.Context, repo.CreateUserInput) (*repo.User, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *memoryRepo) GetUserByEmail(_ context.Context, email string) (*repo.User, error) {
+	if email != m.user.Email {
+		return nil, pgx.ErrNoRows
+	}
+	u := m.user
+	return &u, nil
+}
+
+func (m *memoryRepo) GetUserByID(_ context.Context, id uuid.UUID) (*repo.User, error) {
+	if id != m.user.ID {
+		return nil, pgx.ErrNoRows
+	}
+	u := m.user
+	return &u, nil
+}
+
+func (m *memoryRepo) TokenVersion(_ context.Context, id uuid.UUID) (int, error) {
+	if id != m.user.ID {
+		return 0, pgx.ErrNoRows
+	}
+	return m.user.TokenVersion, nil
+}
+
+func (m *memoryRepo) SetPendingTOTPSecret(_ context.Context, _ uuid.UUID, secret string) error {
+	m.user.TOTPSecret = secret
+	return nil
+}
+
+func (m *memoryRepo) EnableTOTP(_ context.Context, _ uuid.UUID, step int64, hashes []string) error {
+	m.user.TwoFactorEnabled = true
+	m.user.TOTPLastStep = step
+	return m.ReplaceRecoveryCodes(context.Background(), m.user.ID, hashes)
+}
+
+func (m *memoryRepo) DisableTOTP(context.Context, uuid.UUID) error {
+	m.user.TwoFactorEnabled = false
+	m.user.TOTPSecret = ""
+	m.codes = map[string]bool{}
+	return nil
+}
+
+func (m *memoryRepo) AdvanceTOTPStep(_ context.Context, _ uuid.UUID, step int64) (bool, error) {
+	if step <= m.user.TOTPLastStep {
+		return false, nil
+	}
+	m.user.TOTPLastStep = step
+	return true, nil
+}
+
+func (m *memoryRepo) ReplaceRecoveryCodes(_ context.Context, _ uuid.UUID, hashes []string) error {
+	m.codes = make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		m.codes[h] = false
+	}
+	return nil
+}
+
+func (m *memoryRepo) ConsumeRecoveryCode(_ context.Context, _ uuid.UUID, hash string) (bool, error) {
+	used, ok := m.codes[hash]
+	if !ok || used {
+		return false, nil
+	}
+	m.codes[hash] = true
+	return true, nil
+}
+
+func (m *memoryRepo) CreateTwoFactorChallenge(_ context.Context, id, _ uuid.UUID, _ time.Time) error {
+	m.challenges[id] = false
+	return nil
+}
+
+func (m *memoryRepo) ConsumeTwoFactorChallenge(_ context.Context, id, userID uuid.UUID) (bool, error) {
+	used, ok := m.challenges[id]
+	if !ok || used || userID != m.user.ID {
+		return false, nil
+	}
+	m.challenges[id] = true
+	return true, nil
+}
+
+func (m *memoryRepo) RecordTwoFactorFailure(_ context.Context, _ uuid.UUID, limit int, lockout time.Duration) (bool, error) {
+	m.failures++
+	if m.failures >= limit {
+		m.failures = 0
+		m.user.TwoFactorLocked = true
+		m.lockout = lockout
+	}
+	return m.user.TwoFactorLocked, nil
+}
+
+func (m *memoryRepo) ResetTwoFactorFailures(context.Context, uuid.UUID) error {
+	m.failures = 0
+	return nil
+}
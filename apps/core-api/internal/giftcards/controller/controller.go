@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/giftcards/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/giftcards/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/http/middleware"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+type Controller struct {
+	svc *service.Service
+}
+
+func New(svc *service.Service) *Controller {
+	return &Controller{svc: svc}
+}
+
+type GiftCardListResponse struct {
+	Items []repo.GiftCard `json:"items"`
+	Page  pagination.Page `json:"page"`
+}
+
+type MyGiftCardsResponse struct {
+	Items []repo.GiftCard `json:"items"`
+}
+
+type BalanceRequest struct {
+	Code string `json:"code"`
+}
+
+type PurchaseGiftCardRequest struct {
+	Amount float64 `json:"amount"`
+}
+
+type IssueGiftCardRequest struct {
+	Amount    float64    `json:"amount"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Note      string     `json:"note,omitempty"`
+}
+
+type RefundRequest struct {
+	// Amount to credit; omit it to refund what is left of the order.
+	Amount float64 `json:"amount,omitempty"`
+	Reason string  `json:"reason"`
+}
+
+// CheckBalance godoc
+// @Summary Gift card balance inquiry
+// @Description The code is sent in the body so it stays out of URLs and logs.
+// @Tags gift-cards
+// @Accept json
+// @Produce json
+// @Param body body BalanceRequest true "Gift card code"
+// @Success 200 {object} service.Balance
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Router /api/gift-cards/balance [post]
+func (c *Controller) CheckBalance(w http.ResponseWriter, r *http.Request) {
+	var req BalanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	balance, err := c.svc.Balance(r.Context(), req.Code)
+	if err != nil {
+		writeGiftCardError(w, err, "failed to check balance")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpjson.WriteJSON(w, http.StatusOK, balance)
+}
+
+// PurchaseGiftCard godoc
+// @Summary Buy a gift card
+// @Description The card is pending until payment-service charges the buyer, then active. The code is only returned here.
+// @Tags gift-cards
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body PurchaseGiftCardRequest true "Amount"
+// @Success 202 {object} service.IssuedGiftCard
+// @Failure 400 {object} map[string]any
+// @Router /api/gift-cards [post]
+func (c *Controller) PurchaseGiftCard(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req PurchaseGiftCardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	card, err := c.svc.Purchase(r.Context(), userID, req.Amount)
+	if err != nil {
+		writeGiftCardError(w, err, "failed to buy gift card")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpjson.WriteJSON(w, http.StatusAccepted, card)
+}
+
+// ListMyGiftCards godoc
+// @Summary List the gift cards I bought
+// @Tags gift-cards
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} MyGiftCardsResponse
+// @Router /api/me/gift-cards [get]
+func (c *Controller) ListMyGiftCards(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	cards, err := c.svc.Purchased(r.Context(), userID)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list gift cards")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, MyGiftCardsResponse{Items: cards})
+}
+
+// GetMyStoreCredit godoc
+// @Summary Get my store credit
+// @Description Balance and latest ledger entries. Store credit is issued on refunds and can pay for orders with use_store_credit.
+// @Tags gift-cards
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} repo.StoreCredit
+// @Router /api/me/store-credit [get]
+func (c *Controller) GetMyStoreCredit(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	credit, err := c.svc.StoreCredit(r.Context(), userID)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to get store credit")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpjson.WriteJSON(w, http.StatusOK, credit)
+}
+
+// AdminListGiftCards godoc
+// @Summary List gift cards
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param limit query int false "Page size (1-100)" default(20)
+// @Param cursor query string false "next_cursor or prev_cursor from a previous page"
+// @Param include_total query bool false "Include the total card count"
+// @Success 200 {object} GiftCardListResponse
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/gift-cards [get]
+func (c *Controller) AdminListGiftCards(w http.ResponseWriter, r *http.Request) {
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cards, p, err := c.svc.List(r.Context(), page)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list gift cards")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, GiftCardListResponse{Items: cards, Page: p})
+}
+
+// IssueGiftCard godoc
+// @Summary Issue a gift card
+// @Description The card is funded from promotions and active at once. The code is only returned here.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body IssueGiftCardRequest true "Gift card"
+// @Success 201 {object} service.IssuedGiftCard
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/gift-cards [post]
+func (c *Controller) IssueGiftCard(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req IssueGiftCardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	card, err := c.svc.Issue(r.Context(), adminID, service.IssueInput{
+		Amount:    req.Amount,
+		ExpiresAt: req.ExpiresAt,
+		Note:      req.Note,
+	})
+	if err != nil {
+		writeGiftCardError(w, err, "failed to issue gift card")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpjson.WriteJSON(w, http.StatusCreated, card)
+}
+
+// AdminGetGiftCard godoc
+// @Summary Get a gift card
+// @Description The card with its latest ledger entries.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Gift card ID (uuid)"
+// @Success 200 {object} service.Statement
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/gift-cards/{id} [get]
+func (c *Controller) AdminGetGiftCard(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	statement, err := c.svc.Get(r.Context(), id)
+	if err != nil {
+		writeGiftCardError(w, err, "failed to get gift card")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, statement)
+}
+
+// DisableGiftCard godoc
+// @Summary Disable a gift card
+// @Description The card can no longer be spent; amounts already held for orders still settle.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Gift card ID (uuid)"
+// @Success 200 {object} repo.GiftCard
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any "not active"
+// @Router /api/admin/gift-cards/{id}/disable [post]
+func (c *Controller) DisableGiftCard(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	card, err := c.svc.Disable(r.Context(), id)
+	if err != nil {
+		writeGiftCardError(w, err, "failed to disable gift card")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, card)
+}
+
+// RefundOrder godoc
+// @Summary Refund an order to store credit
+// @Description Credits the order's customer; the order is marked refunded once its whole total has been refunded.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Order ID (uuid)"
+// @Param body body RefundRequest true "Refund"
+// @Success 201 {object} repo.Refund
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any "code order_not_refundable: unpaid, already refunded or a guest order; code refund_too_large: more than is left to refund"
+// @Router /api/admin/orders/{id}/refunds [post]
+func (c *Controller) RefundOrder(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	orderID, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	refund, err := c.svc.Refund(r.Context(), adminID, orderID, service.RefundInput{Amount: req.Amount, Reason: req.Reason})
+	if err != nil {
+		writeGiftCardError(w, err, "failed to refund order")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, refund)
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return uuid.UUID{}, false
+	}
+	return id, true
+}
+
+func currentUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	raw, ok := middleware.UserIDFromContext(r.Context())
+	if ok {
+		if id, err := uuid.Parse(raw); err == nil {
+			return id, true
+		}
+	}
+	httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+	return uuid.UUID{}, false
+}
+
+func writeGiftCardError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidGiftCard), errors.Is(err, service.ErrInvalidRefund):
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrGiftCardNotFound):
+		httpjson.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrNotActive):
+		httpjson.WriteError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrNotRefundable):
+		httpjson.WriteErrorCode(w, http.StatusConflict, "order_not_refundable", err.Error())
+	case errors.Is(err, service.ErrRefundTooLarge):
+		httpjson.WriteErrorCode(w, http.StatusConflict, "refund_too_large", err.Error())
+	case util.IsNotFound(err):
+		httpjson.WriteError(w, http.StatusNotFound, "not found")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, fallback)
+	}
+}
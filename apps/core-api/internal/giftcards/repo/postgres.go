@@ -0,0 +1,307 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+	"github.com/kalen1o/iphone-storage/shared/ledger"
+)
+
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+// giftCardColumns are read from gift_cards g joined to its ledger account a.
+const giftCardColumns = `g.id, g.last4, g.currency, a.balance_cents, g.initial_amount_cents, g.source, g.status,
+	g.purchased_by, g.issued_by, COALESCE(g.note, ''), g.expires_at, g.activated_at, g.created_at, g.updated_at`
+
+const giftCardFrom = ` FROM gift_cards g JOIN ledger_accounts a ON a.id = g.account_id `
+
+func scanGiftCard(row pgx.Row) (*GiftCard, error) {
+	var g GiftCard
+	var balance, initial int64
+	if err := row.Scan(&g.ID, &g.Last4, &g.Currency, &balance, &initial, &g.Source, &g.Status,
+		&g.PurchasedBy, &g.IssuedBy, &g.Note, &g.ExpiresAt, &g.ActivatedAt, &g.CreatedAt, &g.UpdatedAt); err != nil {
+		return nil, err
+	}
+	g.Balance = ledger.FromCents(balance)
+	g.InitialAmount = ledger.FromCents(initial)
+	return &g, nil
+}
+
+func (p *Postgres) CreateGiftCard(ctx context.Context, in CreateGiftCardInput) (*GiftCard, error) {
+	tx, err := p.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var accountID, id uuid.UUID
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO ledger_accounts (kind, currency) VALUES ('gift_card', $1) RETURNING id
+	`, in.Currency).Scan(&accountID); err != nil {
+		return nil, err
+	}
+	status := StatusPending
+	if in.Source == SourceAdmin {
+		status = StatusActive
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO gift_cards (account_id, code_hash, last4, currency, initial_amount_cents, source, status,
+		                        purchased_by, issued_by, note, expires_at, activated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11, CASE WHEN $7 = 'active' THEN NOW() END)
+		RETURNING id
+	`, accountID, in.CodeHash, in.Last4, in.Currency, in.AmountCents, in.Source, status,
+		in.PurchasedBy, in.IssuedBy, in.Note, in.ExpiresAt).Scan(&id); err != nil {
+		return nil, err
+	}
+	if in.Source == SourceAdmin {
+		promotions, err := ledger.SystemAccount(ctx, tx, "promotions", in.Currency)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := ledger.Post(ctx, tx, ledger.Transaction{Kind: "issue", CreatedBy: in.IssuedBy},
+			ledger.Posting{AccountID: promotions, Cents: -in.AmountCents},
+			ledger.Posting{AccountID: accountID, Cents: in.AmountCents}); err != nil {
+			return nil, err
+		}
+	}
+
+	g, err := scanGiftCard(tx.QueryRow(ctx, `SELECT `+giftCardColumns+giftCardFrom+`WHERE g.id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (p *Postgres) GetGiftCard(ctx context.Context, id uuid.UUID) (*GiftCard, error) {
+	return scanGiftCard(p.pool.QueryRow(ctx, `SELECT `+giftCardColumns+giftCardFrom+`WHERE g.id = $1`, id))
+}
+
+func (p *Postgres) GetGiftCardByCodeHash(ctx context.Context, codeHash string) (*GiftCard, error) {
+	return scanGiftCard(p.pool.QueryRow(ctx, `SELECT `+giftCardColumns+giftCardFrom+`WHERE g.code_hash = $1`, codeHash))
+}
+
+func (p *Postgres) ListGiftCards(ctx context.Context, page pagination.Params) ([]GiftCard, pagination.Page, error) {
+	keyset, orderBy := page.Where("g.created_at", "g.id", 1)
+	args := append(page.Args(), page.Fetch())
+
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+giftCardColumns+giftCardFrom+`
+		WHERE `+keyset+`
+		ORDER BY `+orderBy+`
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	cards, err := collectGiftCards(rows)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+
+	cards, pg := pagination.Finish(page, cards, func(g GiftCard) (time.Time, uuid.UUID) {
+		return g.CreatedAt, g.ID
+	})
+	if page.IncludeTotal {
+		var total int
+		if err := p.pool.QueryRow(ctx, `SELECT count(*) FROM gift_cards`).Scan(&total); err != nil {
+			return nil, pagination.Page{}, err
+		}
+		pg.Total = &total
+	}
+	return cards, pg, nil
+}
+
+func (p *Postgres) ListPurchasedGiftCards(ctx context.Context, userID uuid.UUID) ([]GiftCard, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+giftCardColumns+giftCardFrom+`
+		WHERE g.purchased_by = $1
+		ORDER BY g.created_at DESC, g.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return collectGiftCards(rows)
+}
+
+func collectGiftCards(rows pgx.Rows) ([]GiftCard, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (GiftCard, error) {
+		g, err := scanGiftCard(row)
+		if err != nil {
+			return GiftCard{}, err
+		}
+		return *g, nil
+	})
+}
+
+func (p *Postgres) DisableGiftCard(ctx context.Context, id uuid.UUID) (*GiftCard, error) {
+	return scanGiftCard(p.pool.QueryRow(ctx, `
+		WITH g AS (
+			UPDATE gift_cards SET status = 'disabled', updated_at = NOW()
+			WHERE id = $1 AND status = 'active'
+			RETURNING *
+		)
+		SELECT `+giftCardColumns+` FROM g JOIN ledger_accounts a ON a.id = g.account_id
+	`, id))
+}
+
+func (p *Postgres) GiftCardEntries(ctx context.Context, id uuid.UUID, limit int) ([]Entry, error) {
+	var accountID uuid.UUID
+	if err := p.pool.QueryRow(ctx, `SELECT account_id FROM gift_cards WHERE id = $1`, id).Scan(&accountID); err != nil {
+		return nil, err
+	}
+	return p.entries(ctx, accountID, limit)
+}
+
+func (p *Postgres) StoreCredit(ctx context.Context, userID uuid.UUID, currency string, limit int) (*StoreCredit, error) {
+	sc := &StoreCredit{Currency: currency, Entries: []Entry{}}
+	var accountID uuid.UUID
+	var balance int64
+	err := p.pool.QueryRow(ctx, `
+		SELECT id, balance_cents FROM ledger_accounts
+		WHERE kind = 'store_credit' AND user_id = $1 AND currency = $2
+	`, userID, currency).Scan(&accountID, &balance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sc.Balance = ledger.FromCents(balance)
+	if sc.Entries, err = p.entries(ctx, accountID, limit); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// entries returns the account's latest entries, newest first.
+func (p *Postgres) entries(ctx context.Context, accountID uuid.UUID, limit int) ([]Entry, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT e.id, t.id, t.kind, t.order_id, e.amount_cents, COALESCE(t.reference, ''), e.created_at
+		FROM ledger_entries e
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		WHERE e.account_id = $1
+		ORDER BY e.created_at DESC, e.id DESC
+		LIMIT $2
+	`, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Entry, error) {
+		var e Entry
+		var cents int64
+		err := row.Scan(&e.ID, &e.TransactionID, &e.Kind, &e.OrderID, &cents, &e.Reference, &e.CreatedAt)
+		e.Amount = ledger.FromCents(cents)
+		return e, err
+	})
+}
+
+func (p *Postgres) RefundToStoreCredit(ctx context.Context, in RefundInput) (*Refund, error) {
+	tx, err := p.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var userID *uuid.UUID
+	var status, currency string
+	var total float64
+	if err := tx.QueryRow(ctx, `
+		SELECT user_id, status, total::float8, COALESCE(currency, 'USD')
+		FROM orders
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, in.OrderID).Scan(&userID, &status, &total, &currency); err != nil {
+		return nil, err
+	}
+	switch status {
+	case "paid", "processing", "shipped", "delivered":
+	default:
+		return nil, ErrNotRefundable
+	}
+	if userID == nil {
+		return nil, ErrNotRefundable
+	}
+
+	var refunded int64
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(e.amount_cents), 0)::bigint
+		FROM ledger_transactions t
+		JOIN ledger_entries e ON e.transaction_id = t.id
+		JOIN ledger_accounts a ON a.id = e.account_id
+		WHERE t.order_id = $1 AND t.kind = 'refund' AND a.kind = 'store_credit'
+	`, in.OrderID).Scan(&refunded); err != nil {
+		return nil, err
+	}
+	left := ledger.ToCents(total) - refunded
+	amount := in.AmountCents
+	if amount == 0 {
+		amount = left
+	}
+	switch {
+	case left <= 0:
+		return nil, ErrNotRefundable
+	case amount > left:
+		return nil, ErrRefundTooLarge
+	}
+
+	var creditID uuid.UUID
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO ledger_accounts (kind, user_id, currency) VALUES ('store_credit', $1, $2)
+		ON CONFLICT (user_id, currency) WHERE kind = 'store_credit' DO NOTHING
+	`, *userID, currency); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(ctx, `
+		SELECT id FROM ledger_accounts WHERE kind = 'store_credit' AND user_id = $1 AND currency = $2
+	`, *userID, currency).Scan(&creditID); err != nil {
+		return nil, err
+	}
+	refunds, err := ledger.SystemAccount(ctx, tx, "refunds", currency)
+	if err != nil {
+		return nil, err
+	}
+	txID, err := ledger.Post(ctx, tx, ledger.Transaction{Kind: "refund", OrderID: &in.OrderID, Reference: in.Reason, CreatedBy: &in.CreatedBy},
+		ledger.Posting{AccountID: refunds, Cents: -amount}, ledger.Posting{AccountID: creditID, Cents: amount})
+	if err != nil {
+		return nil, err
+	}
+
+	if amount == left {
+		if _, err := tx.Exec(ctx, `
+			UPDATE orders SET status = 'refunded', updated_at = NOW() WHERE id = $1
+		`, in.OrderID); err != nil {
+			return nil, err
+		}
+		status = "refunded"
+	}
+	var balance int64
+	if err := tx.QueryRow(ctx, `SELECT balance_cents FROM ledger_accounts WHERE id = $1`, creditID).Scan(&balance); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &Refund{
+		OrderID:       in.OrderID,
+		TransactionID: txID,
+		Amount:        ledger.FromCents(amount),
+		Refunded:      ledger.FromCents(refunded + amount),
+		OrderStatus:   status,
+		StoreCredit:   ledger.FromCents(balance),
+	}, nil
+}
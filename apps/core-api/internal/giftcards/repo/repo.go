@@ -0,0 +1,125 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+)
+
+// Gift card statuses and sources; see migration 019.
+const (
+	StatusPending  = "pending"
+	StatusActive   = "active"
+	StatusFailed   = "failed"
+	StatusDisabled = "disabled"
+
+	SourceAdmin    = "admin"
+	SourcePurchase = "purchase"
+)
+
+// ErrNotRefundable is returned for an order that is unpaid, already fully
+// refunded, or has no customer to credit.
+var ErrNotRefundable = errors.New("order cannot be refunded")
+
+// ErrRefundTooLarge is returned when a refund would take the order's
+// refunds past its total.
+var ErrRefundTooLarge = errors.New("refund exceeds what is left to refund")
+
+// GiftCard is a card as shown to admins and its buyer; the code is only
+// returned when the card is issued.
+type GiftCard struct {
+	ID            uuid.UUID  `json:"id"`
+	Last4         string     `json:"last4"`
+	Currency      string     `json:"currency"`
+	Balance       float64    `json:"balance"`
+	InitialAmount float64    `json:"initial_amount"`
+	Source        string     `json:"source"`
+	Status        string     `json:"status"`
+	PurchasedBy   *uuid.UUID `json:"purchased_by,omitempty"`
+	IssuedBy      *uuid.UUID `json:"issued_by,omitempty"`
+	Note          string     `json:"note,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	ActivatedAt   *time.Time `json:"activated_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Entry is one line of a gift card or store credit statement: a ledger
+// entry on the account with the transaction that made it.
+type Entry struct {
+	ID            uuid.UUID `json:"id"`
+	TransactionID uuid.UUID `json:"transaction_id"`
+	// Kind is the transaction's kind: issue, purchase, hold, release or
+	// refund. A capture does not touch the card or credit, so it has none.
+	Kind      string     `json:"kind"`
+	OrderID   *uuid.UUID `json:"order_id,omitempty"`
+	Amount    float64    `json:"amount"`
+	Reference string     `json:"reference,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// StoreCredit is a customer's credit balance and its latest entries.
+type StoreCredit struct {
+	Currency string  `json:"currency"`
+	Balance  float64 `json:"balance"`
+	Entries  []Entry `json:"entries"`
+}
+
+// Refund is the outcome of refunding an order to store credit.
+type Refund struct {
+	OrderID       uuid.UUID `json:"order_id"`
+	TransactionID uuid.UUID `json:"transaction_id"`
+	Amount        float64   `json:"amount"`
+	// Refunded is the order's refunds so far, this one included; once it
+	// reaches the total the order is refunded.
+	Refunded    float64 `json:"refunded"`
+	OrderStatus string  `json:"order_status"`
+	// StoreCredit is the customer's balance after the refund.
+	StoreCredit float64 `json:"store_credit"`
+}
+
+type CreateGiftCardInput struct {
+	CodeHash    string
+	Last4       string
+	Currency    string
+	AmountCents int64
+	Source      string
+	PurchasedBy *uuid.UUID
+	IssuedBy    *uuid.UUID
+	Note        string
+	ExpiresAt   *time.Time
+}
+
+type RefundInput struct {
+	OrderID uuid.UUID
+	// AmountCents of zero refunds what is left of the order's total.
+	AmountCents int64
+	Reason      string
+	CreatedBy   uuid.UUID
+}
+
+type Repository interface {
+	// CreateGiftCard opens the card's account. Cards issued by an admin are
+	// funded from promotions and active at once; purchased cards wait in
+	// pending until payment-service charges the buyer.
+	CreateGiftCard(ctx context.Context, in CreateGiftCardInput) (*GiftCard, error)
+	GetGiftCard(ctx context.Context, id uuid.UUID) (*GiftCard, error)
+	GetGiftCardByCodeHash(ctx context.Context, codeHash string) (*GiftCard, error)
+	ListGiftCards(ctx context.Context, page pagination.Params) ([]GiftCard, pagination.Page, error)
+	ListPurchasedGiftCards(ctx context.Context, userID uuid.UUID) ([]GiftCard, error)
+	// DisableGiftCard returns pgx.ErrNoRows if the card is not active.
+	DisableGiftCard(ctx context.Context, id uuid.UUID) (*GiftCard, error)
+	// GiftCardEntries returns the card's statement, newest first.
+	GiftCardEntries(ctx context.Context, id uuid.UUID, limit int) ([]Entry, error)
+
+	// StoreCredit returns the user's balance in currency, zero if they
+	// have never had credit.
+	StoreCredit(ctx context.Context, userID uuid.UUID, currency string, limit int) (*StoreCredit, error)
+	// RefundToStoreCredit credits the order's customer with part or all of
+	// its total, and marks the order refunded once all of it is.
+	RefundToStoreCredit(ctx context.Context, in RefundInput) (*Refund, error)
+}
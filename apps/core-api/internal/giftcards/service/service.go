@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/giftcards/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/giftcode"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+	"github.com/kalen1o/iphone-storage/shared/events"
+	"github.com/kalen1o/iphone-storage/shared/kafka"
+)
+
+// Currency is the only currency orders are placed in, and so the only one
+// gift cards and store credit are held in.
+const Currency = "USD"
+
+// Amount bounds. Admins may issue larger cards than customers can buy.
+const (
+	MinPurchaseAmount = 5.0
+	MaxPurchaseAmount = 500.0
+	MaxIssueAmount    = 5000.0
+)
+
+// statementLimit caps the entries returned with a statement.
+const statementLimit = 50
+
+// maxOrderTotal is the largest total orders.total can hold.
+const maxOrderTotal = 99999999.99
+
+var (
+	// ErrInvalidGiftCard is wrapped with a message naming the offending
+	// field.
+	ErrInvalidGiftCard  = errors.New("invalid gift card")
+	ErrGiftCardNotFound = errors.New("gift card not found")
+	ErrNotActive        = errors.New("gift card is not active")
+	// ErrInvalidRefund is wrapped with a message naming the offending
+	// field.
+	ErrInvalidRefund  = errors.New("invalid refund")
+	ErrNotRefundable  = repo.ErrNotRefundable
+	ErrRefundTooLarge = repo.ErrRefundTooLarge
+)
+
+type Service struct {
+	repo     repo.Repository
+	producer *kafka.Producer
+	now      func() time.Time
+}
+
+func New(r repo.Repository, producer *kafka.Producer) *Service {
+	return &Service{repo: r, producer: producer, now: time.Now}
+}
+
+// IssuedGiftCard is a new card with its code, the only time the code is
+// returned.
+type IssuedGiftCard struct {
+	repo.GiftCard
+	Code string `json:"code"`
+}
+
+type IssueInput struct {
+	Amount    float64
+	ExpiresAt *time.Time
+	Note      string
+}
+
+// Issue issues an active card funded from promotions.
+func (s *Service) Issue(ctx context.Context, adminID uuid.UUID, in IssueInput) (*IssuedGiftCard, error) {
+	cents, problem := wholeCents(in.Amount, 0, MaxIssueAmount)
+	if problem != "" {
+		return nil, invalidGiftCard(problem)
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(s.now()) {
+		return nil, invalidGiftCard("expires_at must be in the future")
+	}
+	return s.create(ctx, repo.CreateGiftCardInput{
+		Currency:    Currency,
+		AmountCents: cents,
+		Source:      repo.SourceAdmin,
+		IssuedBy:    &adminID,
+		Note:        strings.TrimSpace(in.Note),
+		ExpiresAt:   in.ExpiresAt,
+	})
+}
+
+// Purchase creates a pending card for the user and asks payment-service to
+// charge them for it; the card is funded and becomes active once the
+// charge succeeds.
+func (s *Service) Purchase(ctx context.Context, userID uuid.UUID, amount float64) (*IssuedGiftCard, error) {
+	cents, problem := wholeCents(amount, MinPurchaseAmount, MaxPurchaseAmount)
+	if problem != "" {
+		return nil, invalidGiftCard(problem)
+	}
+	card, err := s.create(ctx, repo.CreateGiftCardInput{
+		Currency:    Currency,
+		AmountCents: cents,
+		Source:      repo.SourcePurchase,
+		PurchasedBy: &userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.producer != nil {
+		b, err := events.Marshal(events.Envelope[events.GiftCardsPurchasedData]{
+			EventID:     uuid.NewString(),
+			Type:        events.TypeGiftCardsPurchased,
+			OccurredAt:  time.Now().UTC(),
+			AggregateID: card.ID.String(),
+			Data: events.GiftCardsPurchasedData{
+				GiftCardID:  card.ID.String(),
+				UserID:      userID.String(),
+				AmountCents: cents,
+				Currency:    card.Currency,
+			},
+		})
+		if err == nil {
+			_ = s.producer.Publish(ctx, events.TopicGiftCardsPurchased, []byte(card.ID.String()), b)
+		}
+	}
+	return card, nil
+}
+
+func (s *Service) create(ctx context.Context, in repo.CreateGiftCardInput) (*IssuedGiftCard, error) {
+	// A code collision is astronomically unlikely; retry rather than fail.
+	for attempt := 0; ; attempt++ {
+		code, err := giftcode.Generate()
+		if err != nil {
+			return nil, err
+		}
+		in.CodeHash = giftcode.Hash(code)
+		in.Last4 = giftcode.Last4(code)
+		card, err := s.repo.CreateGiftCard(ctx, in)
+		if util.IsUniqueViolation(err, "gift_cards_code_hash_key") && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &IssuedGiftCard{GiftCard: *card, Code: code}, nil
+	}
+}
+
+// wholeCents converts a positive amount of at least min and at most max to
+// cents, or says what is wrong with it.
+func wholeCents(amount, min, max float64) (int64, string) {
+	cents := math.Round(amount * 100)
+	switch {
+	case math.IsNaN(amount) || amount <= 0 || amount < min:
+		return 0, fmt.Sprintf("amount must be at least %.2f", math.Max(min, 0.01))
+	case amount > max:
+		return 0, fmt.Sprintf("amount must be at most %.2f", max)
+	case math.Abs(amount*100-cents) > 1e-6:
+		return 0, "amount must be in whole cents"
+	}
+	return int64(cents), ""
+}
+
+// Balance is what a balance inquiry shows: enough to spend the card, nothing
+// about who holds it.
+type Balance struct {
+	Last4     string     `json:"last4"`
+	Currency  string     `json:"currency"`
+	Balance   float64    `json:"balance"`
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Balance looks a card up by its code.
+func (s *Service) Balance(ctx context.Context, code string) (*Balance, error) {
+	if giftcode.Normalize(code) == "" {
+		return nil, invalidGiftCard("code is required")
+	}
+	card, err := s.repo.GetGiftCardByCodeHash(ctx, giftcode.Hash(code))
+	if util.IsNotFound(err) {
+		return nil, ErrGiftCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Balance{
+		Last4:     card.Last4,
+		Currency:  card.Currency,
+		Balance:   card.Balance,
+		Status:    card.Status,
+		ExpiresAt: card.ExpiresAt,
+	}, nil
+}
+
+func (s *Service) List(ctx context.Context, page pagination.Params) ([]repo.GiftCard, pagination.Page, error) {
+	return s.repo.ListGiftCards(ctx, page)
+}
+
+// Purchased returns the cards the user has bought, newest first.
+func (s *Service) Purchased(ctx context.Context, userID uuid.UUID) ([]repo.GiftCard, error) {
+	return s.repo.ListPurchasedGiftCards(ctx, userID)
+}
+
+// Statement is a gift card with its latest ledger entries.
+type Statement struct {
+	repo.GiftCard
+	Entries []repo.Entry `json:"entries"`
+}
+
+func (s *Service) Get(ctx context.Context, id uuid.UUID) (*Statement, error) {
+	card, err := s.repo.GetGiftCard(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.GiftCardEntries(ctx, id, statementLimit)
+	if err != nil {
+		return nil, err
+	}
+	return &Statement{GiftCard: *card, Entries: entries}, nil
+}
+
+// Disable stops an active card from being spent. Its balance stays on the
+// ledger, and amounts already held for orders are still taken or given
+// back as those orders settle.
+func (s *Service) Disable(ctx context.Context, id uuid.UUID) (*repo.GiftCard, error) {
+	card, err := s.repo.DisableGiftCard(ctx, id)
+	if util.IsNotFound(err) {
+		if _, getErr := s.repo.GetGiftCard(ctx, id); getErr != nil {
+			return nil, getErr
+		}
+		return nil, ErrNotActive
+	}
+	return card, err
+}
+
+func (s *Service) StoreCredit(ctx context.Context, userID uuid.UUID) (*repo.StoreCredit, error) {
+	return s.repo.StoreCredit(ctx, userID, Currency, statementLimit)
+}
+
+type RefundInput struct {
+	// Amount of zero refunds what is left of the order's total.
+	Amount float64
+	Reason string
+}
+
+// Refund refunds a paid order to its customer's store credit.
+func (s *Service) Refund(ctx context.Context, adminID, orderID uuid.UUID, in RefundInput) (*repo.Refund, error) {
+	var cents int64
+	if in.Amount != 0 {
+		var problem string
+		if cents, problem = wholeCents(in.Amount, 0, maxOrderTotal); problem != "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRefund, problem)
+		}
+	}
+	reason := strings.TrimSpace(in.Reason)
+	if reason == "" {
+		return nil, fmt.Errorf("%w: reason is required", ErrInvalidRefund)
+	}
+	return s.repo.RefundToStoreCredit(ctx, repo.RefundInput{
+		OrderID:     orderID,
+		AmountCents: cents,
+		Reason:      reason,
+		CreatedBy:   adminID,
+	})
+}
+
+func invalidGiftCard(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidGiftCard, msg)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestWholeCents(t *testing.T) {
+	for _, tc := range []struct {
+		amount   float64
+		min, max float64
+		want     int64
+		ok       bool
+	}{
+		{amount: 25, min: 5, max: 500, want: 2500, ok: true},
+		{amount: 19.99, min: 5, max: 500, want: 1999, ok: true},
+		{amount: 0.01, max: 10, want: 1, ok: true},
+		{amount: 500, min: 5, max: 500, want: 50000, ok: true},
+		{amount: 4.99, min: 5, max: 500},
+		{amount: 500.01, min: 5, max: 500},
+		{amount: 0, max: 10},
+		{amount: -5, max: 10},
+		{amount: 10.005, max: 100},
+	} {
+		got, problem := wholeCents(tc.amount, tc.min, tc.max)
+		if ok := problem == ""; ok != tc.ok || got != tc.want {
+			t.Errorf("wholeCents(%v, %v, %v) = %d, %q; want %d, ok %v", tc.amount, tc.min, tc.max, got, problem, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestIssueAndRefundValidate(t *testing.T) {
+	now := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	s := &Service{now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	past := now.Add(-time.Hour)
+	for name, in := range map[string]IssueInput{
+		"no amount":       {},
+		"over the limit":  {Amount: MaxIssueAmount + 1},
+		"already expired": {Amount: 50, ExpiresAt: &past},
+	} {
+		if _, err := s.Issue(ctx, uuid.New(), in); !errors.Is(err, ErrInvalidGiftCard) {
+			t.Errorf("issue %s: err = %v, want ErrInvalidGiftCard", name, err)
+		}
+	}
+	if _, err := s.Purchase(ctx, uuid.New(), MinPurchaseAmount-1); !errors.Is(err, ErrInvalidGiftCard) {
+		t.Errorf("purchase under the minimum: err = %v, want ErrInvalidGiftCard", err)
+	}
+
+	for name, in := range map[string]RefundInput{
+		"no reason":          {Amount: 10},
+		"negative amount":    {Amount: -10, Reason: "damaged"},
+		"fraction of a cent": {Amount: 0.001, Reason: "damaged"},
+	} {
+		if _, err := s.Refund(ctx, uuid.New(), uuid.New(), in); !errors.Is(err, ErrInvalidRefund) {
+			t.Errorf("refund %s: err = %v, want ErrInvalidRefund", name, err)
+		}
+	}
+	if _, err := s.Balance(ctx, " - "); !errors.Is(err, ErrInvalidGiftCard) {
+		t.Errorf("balance of an empty code: err = %v, want ErrInvalidGiftCard", err)
+	}
+}
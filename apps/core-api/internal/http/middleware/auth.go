@@ -5,7 +5,11 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/google/uuid"
+
+	apikeyservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/apikeys/service"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
 )
 
 type contextKey string
@@ -14,14 +18,31 @@ const (
 	contextKeyUserID contextKey = "user_id"
 	contextKeyEmail  contextKey = "email"
 	contextKeyRole   contextKey = "role"
+	contextKeyMFA    contextKey = "mfa"
+	contextKeyAPIKey contextKey = "api_key_id"
+	contextKeyScopes contextKey = "scopes"
 )
 
+// APIKeyHeader carries service API keys. Keys are also accepted as Bearer tokens.
+const APIKeyHeader = "X-API-Key"
+
+// TokenVersions returns the token version a user's access tokens must carry,
+// pgx.ErrNoRows for a user who can no longer sign in.
+type TokenVersions interface {
+	TokenVersion(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
 type AuthMiddleware struct {
-	jwt *service.JWT
+	jwt      *service.JWT
+	apiKeys  *apikeyservice.Service
+	versions TokenVersions
 }
 
-func NewAuthMiddleware(jwt *service.JWT) *AuthMiddleware {
-	return &AuthMiddleware{jwt: jwt}
+// NewAuthMiddleware accepts user JWTs and, when apiKeys is non-nil, service API keys.
+// User tokens are only accepted while they carry the version from versions, so
+// changing the password or erasing the account revokes them.
+func NewAuthMiddleware(jwt *service.JWT, apiKeys *apikeyservice.Service, versions TokenVersions) *AuthMiddleware {
+	return &AuthMiddleware{jwt: jwt, apiKeys: apiKeys, versions: versions}
 }
 
 func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
@@ -29,24 +50,75 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 		raw := r.Header.Get("Authorization")
 		token := strings.TrimSpace(strings.TrimPrefix(raw, "Bearer"))
 		token = strings.TrimSpace(token)
+		if key := strings.TrimSpace(r.Header.Get(APIKeyHeader)); key != "" {
+			token = key
+		}
 		if token == "" {
 			http.Error(w, "missing bearer token", http.StatusUnauthorized)
 			return
 		}
 
+		if apikeyservice.IsAPIKey(token) {
+			m.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
 		claims, err := m.jwt.ParseToken(token)
 		if err != nil {
 			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
 		}
+		if !m.current(w, r, claims) {
+			return
+		}
 
 		ctx := context.WithValue(r.Context(), contextKeyUserID, claims.UserID)
 		ctx = context.WithValue(ctx, contextKeyEmail, claims.Email)
 		ctx = context.WithValue(ctx, contextKeyRole, claims.Role)
+		ctx = context.WithValue(ctx, contextKeyMFA, claims.MFA)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// current checks the token against the user's token version and writes the
+// error response if it has been revoked.
+func (m *AuthMiddleware) current(w http.ResponseWriter, r *http.Request, claims *service.Claims) bool {
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	version, err := m.versions.TokenVersion(r.Context(), userID)
+	switch {
+	case util.IsNotFound(err):
+		http.Error(w, "session revoked", http.StatusUnauthorized)
+		return false
+	case err != nil:
+		http.Error(w, "failed to check session", http.StatusServiceUnavailable)
+		return false
+	case claims.TokenVersion != version:
+		http.Error(w, "session revoked", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (m *AuthMiddleware) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	if m.apiKeys == nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	k, err := m.apiKeys.Authenticate(r.Context(), key)
+	if err != nil {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), contextKeyAPIKey, k.ID.String())
+	ctx = context.WithValue(ctx, contextKeyScopes, k.Scopes)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
 func UserIDFromContext(ctx context.Context) (string, bool) {
 	v, ok := ctx.Value(contextKeyUserID).(string)
 	return v, ok && v != ""
@@ -61,3 +133,67 @@ func RoleFromContext(ctx context.Context) (string, bool) {
 	v, ok := ctx.Value(contextKeyRole).(string)
 	return v, ok && v != ""
 }
+
+// MFAFromContext reports whether the session completed a second factor.
+func MFAFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(contextKeyMFA).(bool)
+	return v
+}
+
+// RequireTwoFactor rejects sessions that have not completed 2FA when the
+// caller's role requires it. It must run after Authenticate.
+func RequireTwoFactor(required func(role string) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := RoleFromContext(r.Context())
+			if required(role) && !MFAFromContext(r.Context()) {
+				http.Error(w, "two-factor authentication required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIKeyIDFromContext returns the key ID when the caller authenticated with an API key.
+func APIKeyIDFromContext(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(contextKeyAPIKey).(string)
+	return v, ok && v != ""
+}
+
+func ScopesFromContext(ctx context.Context) []string {
+	v, _ := ctx.Value(contextKeyScopes).([]string)
+	return v
+}
+
+// RequireAccess admits users with role, or API keys holding scope. It must run
+// after Authenticate.
+func RequireAccess(role, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := APIKeyIDFromContext(r.Context()); ok {
+				if scope != "" && hasScope(ScopesFromContext(r.Context()), scope) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, "insufficient scope", http.StatusForbidden)
+				return
+			}
+
+			if userRole, ok := RoleFromContext(r.Context()); ok && role != "" && userRole == role {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, "forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+func hasScope(scopes []string, want string) bool {
+	for _, s := range scopes {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
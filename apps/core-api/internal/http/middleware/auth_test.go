@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	accountrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/account/repo"
+	accountservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/account/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/service"
+)
+
+// accounts is an account repository that keeps token versions the way the
+// Postgres one does: a password change or erasure bumps them, and an erased
+// user has none.
+type accounts struct {
+	accountrepo.Repository
+	profile accountrepo.Profile
+	version int
+	erased  bool
+	err     error
+}
+
+func (a *accounts) GetProfile(_ context.Context, id uuid.UUID) (*accountrepo.Profile, error) {
+	if a.erased || id != a.profile.ID {
+		return nil, pgx.ErrNoRows
+	}
+	p := a.profile
+	return &p, nil
+}
+
+func (a *accounts) UpdatePasswordHash(_ context.Context, _ uuid.UUID, hash string) error {
+	a.profile.PasswordHash = hash
+	a.version++
+	return nil
+}
+
+func (a *accounts) Erase(context.Context, uuid.UUID) error {
+	a.erased = true
+	a.version++
+	return nil
+}
+
+func (a *accounts) TokenVersion(_ context.Context, id uuid.UUID) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	if a.erased || id != a.profile.ID {
+		return 0, pgx.ErrNoRows
+	}
+	return a.version, nil
+}
+
+func TestAuthenticate_RevokesTokensOnPasswordChangeAndErasure(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	store := &accounts{profile: accountrepo.Profile{ID: uuid.New(), Email: "a@example.com", PasswordHash: string(hash), Role: "customer"}}
+	account := accountservice.New(store)
+	jwt := service.NewJWT("test-secret", time.Hour)
+	handler := NewAuthMiddleware(jwt, nil, store).Authenticate(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	issue := func() string {
+		t.Helper()
+		token, err := jwt.GenerateToken(store.profile.ID.String(), store.profile.Email, store.profile.Role, store.version, false)
+		if err != nil {
+			t.Fatalf("generate token: %v", err)
+		}
+		return token
+	}
+	call := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	ctx := context.Background()
+
+	stolen := issue()
+	if code := call(stolen); code != http.StatusNoContent {
+		t.Fatalf("current token: status %d, want 204", code)
+	}
+
+	if err := account.ChangePassword(ctx, store.profile.ID, "old-password", "new-password"); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if code := call(stolen); code != http.StatusUnauthorized {
+		t.Fatalf("token from before the password change: status %d, want 401", code)
+	}
+	fresh := issue()
+	if code := call(fresh); code != http.StatusNoContent {
+		t.Fatalf("token from after the password change: status %d, want 204", code)
+	}
+
+	store.err = errors.New("connection refused")
+	if code := call(fresh); code != http.StatusServiceUnavailable {
+		t.Fatalf("version lookup failing: status %d, want 503", code)
+	}
+	store.err = nil
+
+	if err := account.Erase(ctx, store.profile.ID, "new-password"); err != nil {
+		t.Fatalf("erase: %v", err)
+	}
+	if code := call(fresh); code != http.StatusUnauthorized {
+		t.Fatalf("token of an erased account: status %d, want 401", code)
+	}
+}
+
+func TestAuthenticate_RejectsChallengeTokens(t *testing.T) {
+	t.Parallel()
+
+	store := &accounts{profile: accountrepo.Profile{ID: uuid.New()}}
+	jwt := service.NewJWT("test-secret", time.Hour)
+	handler := NewAuthMiddleware(jwt, nil, store).Authenticate(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	challenge, err := jwt.GenerateChallengeToken(store.profile.ID.String(), uuid.NewString(), time.Minute)
+	if err != nil {
+		t.Fatalf("generate challenge: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("challenge token: status %d, want 401", rec.Code)
+	}
+}
@@ -8,7 +8,8 @@ import (
 
 func CORS() func(http.Handler) http.Handler {
 	allowedOrigins := parseAllowedOrigins(os.Getenv("ALLOWED_ORIGINS"))
-	allowedHeaders := "Authorization,Content-Type"
+	allowedHeaders := "Authorization,Content-Type,X-API-Key,X-Queue-Ticket,If-None-Match,If-Modified-Since"
+	exposedHeaders := "ETag,Last-Modified,Retry-After"
 	allowedMethods := "GET,POST,PUT,PATCH,DELETE,OPTIONS"
 
 	return func(next http.Handler) http.Handler {
@@ -21,6 +22,7 @@ func CORS() func(http.Handler) http.Handler {
 			}
 			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
 			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
 
 			if r.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusNoContent)
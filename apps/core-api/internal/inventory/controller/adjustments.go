@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+type AdjustmentRequest struct {
+	// Quantity is the number of units received or written off, or the
+	// counted on-hand quantity for a correction.
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason,omitempty"`
+	// ReferenceID links the adjustment to e.g. a purchase order or RMA.
+	ReferenceID *uuid.UUID `json:"reference_id,omitempty"`
+	// WarehouseID defaults to the active warehouse that ships first.
+	WarehouseID *uuid.UUID `json:"warehouse_id,omitempty"`
+}
+
+// ReceiveStock godoc
+// @Summary Receive stock
+// @Description Adds delivered units to on-hand and available stock.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param product_id path string true "Product ID (uuid)"
+// @Param body body AdjustmentRequest true "Units received"
+// @Success 201 {object} repo.Adjustment
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/inventory/{product_id}/receipts [post]
+func (c *Controller) ReceiveStock(w http.ResponseWriter, r *http.Request) {
+	c.adjust(w, r, c.svc.ReceiveStock)
+}
+
+// CorrectCount godoc
+// @Summary Correct a stock count
+// @Description Sets on-hand stock to a physical count; the difference is applied to available stock. Fails with 409 if the count is below the units reserved for orders.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param product_id path string true "Product ID (uuid)"
+// @Param body body AdjustmentRequest true "Counted quantity and reason"
+// @Success 201 {object} repo.Adjustment
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/inventory/{product_id}/corrections [post]
+func (c *Controller) CorrectCount(w http.ResponseWriter, r *http.Request) {
+	c.adjust(w, r, c.svc.CorrectCount)
+}
+
+// WriteOffStock godoc
+// @Summary Write off stock
+// @Description Removes damaged or lost units. Fails with 409 if fewer units are unreserved.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param product_id path string true "Product ID (uuid)"
+// @Param body body AdjustmentRequest true "Units written off and reason"
+// @Success 201 {object} repo.Adjustment
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/inventory/{product_id}/write-offs [post]
+func (c *Controller) WriteOffStock(w http.ResponseWriter, r *http.Request) {
+	c.adjust(w, r, c.svc.WriteOff)
+}
+
+type adjustFunc func(ctx context.Context, productID uuid.UUID, in service.AdjustInput) (*repo.Adjustment, error)
+
+func (c *Controller) adjust(w http.ResponseWriter, r *http.Request, fn adjustFunc) {
+	productID, err := uuid.Parse(mux.Vars(r)["product_id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	var req AdjustmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	in := service.AdjustInput{Quantity: req.Quantity, Reason: req.Reason, ReferenceID: req.ReferenceID, WarehouseID: req.WarehouseID}
+	in.CreatedBy = userID(r)
+
+	a, err := fn(r.Context(), productID, in)
+	if err != nil {
+		writeStockError(w, err, "failed to adjust inventory")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, a)
+}
+
+func writeStockError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidAdjustment):
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, repo.ErrInsufficientStock), errors.Is(err, repo.ErrNoWarehouse):
+		httpjson.WriteError(w, http.StatusConflict, err.Error())
+	case util.IsNotFound(err):
+		httpjson.WriteError(w, http.StatusNotFound, "inventory or warehouse not found")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+type LedgerResponse struct {
+	Items   []repo.Adjustment `json:"items"`
+	Balance repo.Balance      `json:"balance"`
+	pagination.Page
+}
+
+// GetLedger godoc
+// @Summary Get a product's inventory ledger
+// @Description Every change to the product's available, reserved and on-hand stock, newest first and keyset paginated. The balance compares the inventory row with the sum of the ledger.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param product_id path string true "Product ID (uuid)"
+// @Param limit query int false "Page size (1-100)" default(20)
+// @Param cursor query string false "next_cursor or prev_cursor from a previous page"
+// @Param include_total query bool false "Include the total entry count"
+// @Success 200 {object} LedgerResponse
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/inventory/{product_id}/ledger [get]
+func (c *Controller) GetLedger(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(mux.Vars(r)["product_id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, p, balance, err := c.svc.Ledger(r.Context(), productID, page)
+	if err != nil {
+		if util.IsNotFound(err) {
+			httpjson.WriteError(w, http.StatusNotFound, "inventory not found")
+			return
+		}
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to fetch inventory ledger")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, LedgerResponse{Items: entries, Balance: *balance, Page: p})
+}
+
+// GetReplenishmentReport godoc
+// @Summary Low-stock and replenishment report
+// @Description Products below their low-stock threshold (or all products) with sell-through, days of cover and a suggested reorder quantity from recent sales.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param days query int false "Sales window in days (1-365)" default(30)
+// @Param target_days query int false "Days of sales to reorder for (1-365)" default(30)
+// @Param all query bool false "Include products above their threshold"
+// @Success 200 {object} service.ReplenishmentReport
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/inventory/low-stock [get]
+func (c *Controller) GetReplenishmentReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	days, ok := parseDays(w, q.Get("days"), "days", service.DefaultReportWindowDays)
+	if !ok {
+		return
+	}
+	target, ok := parseDays(w, q.Get("target_days"), "target_days", service.DefaultReportTargetDays)
+	if !ok {
+		return
+	}
+	all := false
+	if raw := q.Get("all"); raw != "" {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "all must be a boolean")
+			return
+		}
+		all = b
+	}
+
+	report, err := c.svc.ReplenishmentReport(r.Context(), days, target, all)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to build report")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, report)
+}
+
+func parseDays(w http.ResponseWriter, raw, name string, def int) (int, bool) {
+	if raw == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > 365 {
+		httpjson.WriteError(w, http.StatusBadRequest, name+" must be an integer between 1 and 365")
+		return 0, false
+	}
+	return n, true
+}
@@ -2,7 +2,9 @@ package controller
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -23,6 +25,19 @@ func New(svc *service.Service) *Controller {
 type Availability struct {
 	ProductID string `json:"product_id"`
 	InStock   bool   `json:"in_stock"`
+	// EstimatedAvailableAt is set on preorder and backorder products that
+	// cannot be allocated now, when open purchase orders are expected to
+	// bring in enough stock for a unit ordered now.
+	EstimatedAvailableAt *time.Time `json:"estimated_available_at,omitempty"`
+	// Regions is set with by_region=true.
+	Regions []RegionAvailability `json:"regions,omitempty"`
+}
+
+// RegionAvailability is whether a region's warehouses have the product.
+type RegionAvailability struct {
+	Country string `json:"country"`
+	Region  string `json:"region,omitempty"`
+	InStock bool   `json:"in_stock"`
 }
 
 type InventoryListResponse struct {
@@ -34,7 +49,10 @@ type InventoryListResponse struct {
 // @Tags inventory
 // @Produce json
 // @Param product_ids query string false "Comma-separated product IDs (uuid)"
+// @Param by_region query bool false "Include availability per warehouse region"
+// @Param If-None-Match header string false "ETag from a previous response"
 // @Success 200 {object} InventoryListResponse
+// @Success 304 "Not modified: the If-None-Match or If-Modified-Since validator is current"
 // @Router /api/inventory [get]
 func (c *Controller) GetInventory(w http.ResponseWriter, r *http.Request) {
 	ids, err := parseProductIDsQuery(r)
@@ -49,14 +67,27 @@ func (c *Controller) GetInventory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	estimates, err := c.svc.GetRestockEstimates(r.Context(), ids)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to fetch inventory")
+		return
+	}
+
+	regionsByID, ok := c.regionsByID(w, r, ids)
+	if !ok {
+		return
+	}
+
 	items := make([]Availability, 0, len(ids))
 	for _, id := range ids {
 		items = append(items, Availability{
-			ProductID: id.String(),
-			InStock:   inStockByID[id],
+			ProductID:            id.String(),
+			InStock:              inStockByID[id],
+			EstimatedAvailableAt: estimateFor(estimates, id),
+			Regions:              regionsByID[id],
 		})
 	}
-	httpjson.WriteJSON(w, http.StatusOK, InventoryListResponse{Items: items})
+	httpjson.WriteConditionalJSON(w, r, InventoryListResponse{Items: items}, httpjson.Validators{CacheControl: httpjson.CacheAvailability})
 }
 
 // GetInventoryByProductID godoc
@@ -64,7 +95,10 @@ func (c *Controller) GetInventory(w http.ResponseWriter, r *http.Request) {
 // @Tags inventory
 // @Produce json
 // @Param id path string true "Product ID (uuid)"
+// @Param by_region query bool false "Include availability per warehouse region"
+// @Param If-None-Match header string false "ETag from a previous response"
 // @Success 200 {object} Availability
+// @Success 304 "Not modified: the If-None-Match or If-Modified-Since validator is current"
 // @Failure 404 {object} map[string]any
 // @Router /api/inventory/{id} [get]
 func (c *Controller) GetInventoryByProductID(w http.ResponseWriter, r *http.Request) {
@@ -85,10 +119,61 @@ func (c *Controller) GetInventoryByProductID(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	httpjson.WriteJSON(w, http.StatusOK, Availability{
-		ProductID: id.String(),
-		InStock:   inStock,
-	})
+	estimates, err := c.svc.GetRestockEstimates(r.Context(), []uuid.UUID{id})
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to fetch inventory")
+		return
+	}
+
+	regionsByID, ok := c.regionsByID(w, r, []uuid.UUID{id})
+	if !ok {
+		return
+	}
+
+	httpjson.WriteConditionalJSON(w, r, Availability{
+		ProductID:            id.String(),
+		InStock:              inStock,
+		EstimatedAvailableAt: estimateFor(estimates, id),
+		Regions:              regionsByID[id],
+	}, httpjson.Validators{CacheControl: httpjson.CacheAvailability})
+}
+
+// regionsByID returns regional availability if by_region=true was asked
+// for, or nil. It writes the error response and returns false on failure.
+func (c *Controller) regionsByID(w http.ResponseWriter, r *http.Request, ids []uuid.UUID) (map[uuid.UUID][]RegionAvailability, bool) {
+	raw := r.URL.Query().Get("by_region")
+	if raw == "" {
+		return nil, true
+	}
+	byRegion, err := strconv.ParseBool(raw)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "by_region must be a boolean")
+		return nil, false
+	}
+	if !byRegion {
+		return nil, true
+	}
+
+	stock, err := c.svc.GetRegionStockByProductIDs(r.Context(), ids)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to fetch inventory")
+		return nil, false
+	}
+	out := make(map[uuid.UUID][]RegionAvailability, len(stock))
+	for id, regions := range stock {
+		for _, rs := range regions {
+			out[id] = append(out[id], RegionAvailability{Country: rs.Country, Region: rs.Region, InStock: rs.Available > 0})
+		}
+	}
+	return out, true
+}
+
+func estimateFor(estimates map[uuid.UUID]time.Time, id uuid.UUID) *time.Time {
+	t, ok := estimates[id]
+	if !ok {
+		return nil
+	}
+	return &t
 }
 
 func parseProductIDsQuery(r *http.Request) ([]uuid.UUID, error) {
@@ -0,0 +1,417 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/http/middleware"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+type SupplierListResponse struct {
+	Items []repo.Supplier `json:"items"`
+}
+
+type CreateSupplierRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+	// LeadTimeDays is the default time from ordering to delivery.
+	LeadTimeDays *int `json:"lead_time_days,omitempty"`
+}
+
+type UpdateSupplierRequest struct {
+	Name         *string `json:"name,omitempty"`
+	Email        *string `json:"email,omitempty"`
+	Phone        *string `json:"phone,omitempty"`
+	LeadTimeDays *int    `json:"lead_time_days,omitempty"`
+	IsActive     *bool   `json:"is_active,omitempty"`
+}
+
+type PurchaseOrderLineRequest struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	UnitCost  *float64  `json:"unit_cost,omitempty"`
+}
+
+type CreatePurchaseOrderRequest struct {
+	SupplierID uuid.UUID `json:"supplier_id"`
+	// WarehouseID is where the goods will be received.
+	WarehouseID uuid.UUID `json:"warehouse_id"`
+	// ExpectedAt defaults to the supplier's lead time once the order is placed.
+	ExpectedAt *time.Time                 `json:"expected_at,omitempty"`
+	Notes      string                     `json:"notes,omitempty"`
+	Lines      []PurchaseOrderLineRequest `json:"lines"`
+}
+
+type UpdatePurchaseOrderRequest struct {
+	ExpectedAt *time.Time `json:"expected_at,omitempty"`
+	Notes      *string    `json:"notes,omitempty"`
+	// Lines replaces the lines of a draft.
+	Lines []PurchaseOrderLineRequest `json:"lines,omitempty"`
+}
+
+type ReceiptLineRequest struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+}
+
+type ReceivePurchaseOrderRequest struct {
+	Lines []ReceiptLineRequest `json:"lines"`
+}
+
+type PurchaseOrderListResponse struct {
+	Items []repo.PurchaseOrder `json:"items"`
+	pagination.Page
+}
+
+// ListSuppliers godoc
+// @Summary List suppliers
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} SupplierListResponse
+// @Router /api/admin/suppliers [get]
+func (c *Controller) ListSuppliers(w http.ResponseWriter, r *http.Request) {
+	items, err := c.svc.ListSuppliers(r.Context())
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list suppliers")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, SupplierListResponse{Items: items})
+}
+
+// CreateSupplier godoc
+// @Summary Create a supplier
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body CreateSupplierRequest true "Supplier"
+// @Success 201 {object} repo.Supplier
+// @Failure 400 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/suppliers [post]
+func (c *Controller) CreateSupplier(w http.ResponseWriter, r *http.Request) {
+	var req CreateSupplierRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	s, err := c.svc.CreateSupplier(r.Context(), repo.CreateSupplierInput{
+		Name:         req.Name,
+		Email:        req.Email,
+		Phone:        req.Phone,
+		LeadTimeDays: req.LeadTimeDays,
+	})
+	if err != nil {
+		writePurchasingError(w, err, "failed to create supplier")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, s)
+}
+
+// UpdateSupplier godoc
+// @Summary Update a supplier
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Supplier ID (uuid)"
+// @Param body body UpdateSupplierRequest true "Fields to change"
+// @Success 200 {object} repo.Supplier
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/suppliers/{id} [patch]
+func (c *Controller) UpdateSupplier(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req UpdateSupplierRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	s, err := c.svc.UpdateSupplier(r.Context(), id, repo.UpdateSupplierInput{
+		Name:         req.Name,
+		Email:        req.Email,
+		Phone:        req.Phone,
+		LeadTimeDays: req.LeadTimeDays,
+		IsActive:     req.IsActive,
+	})
+	if err != nil {
+		writePurchasingError(w, err, "failed to update supplier")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, s)
+}
+
+// ListPurchaseOrders godoc
+// @Summary List purchase orders
+// @Description Newest first, keyset paginated. Lines are not included; fetch an order for its lines.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "draft, ordered, partially_received, received or cancelled"
+// @Param supplier_id query string false "Supplier ID (uuid)"
+// @Param limit query int false "Page size (1-100)" default(20)
+// @Param cursor query string false "next_cursor or prev_cursor from a previous page"
+// @Param include_total query bool false "Include the total order count"
+// @Success 200 {object} PurchaseOrderListResponse
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/purchase-orders [get]
+func (c *Controller) ListPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	f := repo.PurchaseOrderFilter{Status: q.Get("status")}
+	if raw := q.Get("supplier_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid supplier_id")
+			return
+		}
+		f.SupplierID = &id
+	}
+
+	orders, p, err := c.svc.ListPurchaseOrders(r.Context(), f, page)
+	if err != nil {
+		writePurchasingError(w, err, "failed to list purchase orders")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, PurchaseOrderListResponse{Items: orders, Page: p})
+}
+
+// GetPurchaseOrder godoc
+// @Summary Get a purchase order
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Purchase order ID (uuid)"
+// @Success 200 {object} repo.PurchaseOrder
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/purchase-orders/{id} [get]
+func (c *Controller) GetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	o, err := c.svc.GetPurchaseOrder(r.Context(), id)
+	if err != nil {
+		writePurchasingError(w, err, "failed to get purchase order")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, o)
+}
+
+// CreatePurchaseOrder godoc
+// @Summary Create a draft purchase order
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body CreatePurchaseOrderRequest true "Purchase order"
+// @Success 201 {object} repo.PurchaseOrder
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/purchase-orders [post]
+func (c *Controller) CreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var req CreatePurchaseOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	in := repo.CreatePurchaseOrderInput{
+		SupplierID:  req.SupplierID,
+		WarehouseID: req.WarehouseID,
+		ExpectedAt:  req.ExpectedAt,
+		Notes:       req.Notes,
+		Lines:       lineInputs(req.Lines),
+		CreatedBy:   userID(r),
+	}
+	o, err := c.svc.CreatePurchaseOrder(r.Context(), in)
+	if err != nil {
+		writePurchasingError(w, err, "failed to create purchase order")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, o)
+}
+
+// UpdatePurchaseOrder godoc
+// @Summary Update a purchase order
+// @Description Changes the expected date or notes of a draft or open order, or replaces the lines of a draft.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Purchase order ID (uuid)"
+// @Param body body UpdatePurchaseOrderRequest true "Fields to change"
+// @Success 200 {object} repo.PurchaseOrder
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/purchase-orders/{id} [patch]
+func (c *Controller) UpdatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req UpdatePurchaseOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	in := repo.UpdatePurchaseOrderInput{ExpectedAt: req.ExpectedAt, Notes: req.Notes}
+	if req.Lines != nil {
+		in.Lines = lineInputs(req.Lines)
+	}
+	o, err := c.svc.UpdatePurchaseOrder(r.Context(), id, in)
+	if err != nil {
+		writePurchasingError(w, err, "failed to update purchase order")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, o)
+}
+
+// PlacePurchaseOrder godoc
+// @Summary Place a purchase order
+// @Description Moves a draft to ordered; its lines count as incoming stock for preorder and backorder estimates from then on.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Purchase order ID (uuid)"
+// @Success 200 {object} repo.PurchaseOrder
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/purchase-orders/{id}/order [post]
+func (c *Controller) PlacePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	o, err := c.svc.PlacePurchaseOrder(r.Context(), id)
+	if err != nil {
+		writePurchasingError(w, err, "failed to place purchase order")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, o)
+}
+
+// CancelPurchaseOrder godoc
+// @Summary Cancel a purchase order
+// @Description Cancels an order that is not fully received. Units already received stay in stock.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Purchase order ID (uuid)"
+// @Success 200 {object} repo.PurchaseOrder
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/purchase-orders/{id}/cancel [post]
+func (c *Controller) CancelPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	o, err := c.svc.CancelPurchaseOrder(r.Context(), id)
+	if err != nil {
+		writePurchasingError(w, err, "failed to cancel purchase order")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, o)
+}
+
+// ReceivePurchaseOrder godoc
+// @Summary Receive goods against a purchase order
+// @Description Adds the delivered units to the order's warehouse as purchase adjustments referencing the order. The order becomes partially_received or, once every line is complete, received.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Purchase order ID (uuid)"
+// @Param body body ReceivePurchaseOrderRequest true "Units delivered"
+// @Success 200 {object} repo.PurchaseOrder
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/purchase-orders/{id}/receipts [post]
+func (c *Controller) ReceivePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req ReceivePurchaseOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	receipt := make([]repo.ReceiptLine, 0, len(req.Lines))
+	for _, l := range req.Lines {
+		receipt = append(receipt, repo.ReceiptLine{ProductID: l.ProductID, Quantity: l.Quantity})
+	}
+	o, err := c.svc.ReceivePurchaseOrder(r.Context(), id, receipt, userID(r))
+	if err != nil {
+		writePurchasingError(w, err, "failed to receive purchase order")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, o)
+}
+
+func lineInputs(lines []PurchaseOrderLineRequest) []repo.PurchaseOrderLineInput {
+	out := make([]repo.PurchaseOrderLineInput, 0, len(lines))
+	for _, l := range lines {
+		out = append(out, repo.PurchaseOrderLineInput{ProductID: l.ProductID, Quantity: l.Quantity, UnitCost: l.UnitCost})
+	}
+	return out
+}
+
+// userID returns the authenticated user, or nil for API keys.
+func userID(r *http.Request) *uuid.UUID {
+	raw, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+func writePurchasingError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidPurchaseOrder), errors.Is(err, repo.ErrNotOnOrder):
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrSupplierNameTaken), errors.Is(err, repo.ErrPurchaseOrderStatus),
+		errors.Is(err, repo.ErrOverReceipt):
+		httpjson.WriteError(w, http.StatusConflict, err.Error())
+	case util.IsNotFound(err):
+		httpjson.WriteError(w, http.StatusNotFound, "not found")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, fallback)
+	}
+}
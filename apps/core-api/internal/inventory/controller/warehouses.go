@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+type WarehouseListResponse struct {
+	Items []repo.Warehouse `json:"items"`
+}
+
+type CreateWarehouseRequest struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Country (ISO 3166-1 alpha-2) and Region (state or province) place the
+	// warehouse for nearest-warehouse allocation and regional availability.
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	// Priority orders warehouses for allocation; lower ships first.
+	Priority *int `json:"priority,omitempty"`
+}
+
+type UpdateWarehouseRequest struct {
+	Name     *string `json:"name,omitempty"`
+	Country  *string `json:"country,omitempty"`
+	Region   *string `json:"region,omitempty"`
+	Priority *int    `json:"priority,omitempty"`
+	IsActive *bool   `json:"is_active,omitempty"`
+}
+
+type WarehouseStockResponse struct {
+	Items []repo.WarehouseStock `json:"items"`
+}
+
+type TransferRequest struct {
+	FromWarehouseID uuid.UUID `json:"from_warehouse_id"`
+	ToWarehouseID   uuid.UUID `json:"to_warehouse_id"`
+	Quantity        int       `json:"quantity"`
+	Reason          string    `json:"reason,omitempty"`
+}
+
+// ListWarehouses godoc
+// @Summary List warehouses
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} WarehouseListResponse
+// @Router /api/admin/warehouses [get]
+func (c *Controller) ListWarehouses(w http.ResponseWriter, r *http.Request) {
+	items, err := c.svc.ListWarehouses(r.Context())
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list warehouses")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, WarehouseListResponse{Items: items})
+}
+
+// CreateWarehouse godoc
+// @Summary Create a warehouse
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body CreateWarehouseRequest true "Warehouse"
+// @Success 201 {object} repo.Warehouse
+// @Failure 400 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/warehouses [post]
+func (c *Controller) CreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	var req CreateWarehouseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	in := repo.CreateWarehouseInput{Code: req.Code, Name: req.Name, Country: req.Country, Region: req.Region, Priority: 100}
+	if req.Priority != nil {
+		in.Priority = *req.Priority
+	}
+
+	wh, err := c.svc.CreateWarehouse(r.Context(), in)
+	if err != nil {
+		writeWarehouseError(w, err, "failed to create warehouse")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, wh)
+}
+
+// UpdateWarehouse godoc
+// @Summary Update a warehouse
+// @Description Inactive warehouses keep their stock but are not allocated from.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Warehouse ID (uuid)"
+// @Param body body UpdateWarehouseRequest true "Fields to change"
+// @Success 200 {object} repo.Warehouse
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/warehouses/{id} [patch]
+func (c *Controller) UpdateWarehouse(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req UpdateWarehouseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	wh, err := c.svc.UpdateWarehouse(r.Context(), id, repo.UpdateWarehouseInput{
+		Name:     req.Name,
+		Country:  req.Country,
+		Region:   req.Region,
+		Priority: req.Priority,
+		IsActive: req.IsActive,
+	})
+	if err != nil {
+		writeWarehouseError(w, err, "failed to update warehouse")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, wh)
+}
+
+// GetWarehouseStock godoc
+// @Summary Get a product's stock per warehouse
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param product_id path string true "Product ID (uuid)"
+// @Success 200 {object} WarehouseStockResponse
+// @Router /api/admin/inventory/{product_id}/warehouses [get]
+func (c *Controller) GetWarehouseStock(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(mux.Vars(r)["product_id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	items, err := c.svc.ListWarehouseStock(r.Context(), productID)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to fetch warehouse stock")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, WarehouseStockResponse{Items: items})
+}
+
+// TransferStock godoc
+// @Summary Transfer stock between warehouses
+// @Description Moves unreserved units; the product's total stock is unchanged.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param product_id path string true "Product ID (uuid)"
+// @Param body body TransferRequest true "Transfer"
+// @Success 201 {object} repo.Transfer
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/inventory/{product_id}/transfers [post]
+func (c *Controller) TransferStock(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(mux.Vars(r)["product_id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	in := repo.TransferInput{
+		ProductID:       productID,
+		FromWarehouseID: req.FromWarehouseID,
+		ToWarehouseID:   req.ToWarehouseID,
+		Quantity:        req.Quantity,
+		Reason:          req.Reason,
+	}
+	in.CreatedBy = userID(r)
+
+	t, err := c.svc.Transfer(r.Context(), in)
+	if err != nil {
+		writeStockError(w, err, "failed to transfer stock")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, t)
+}
+
+func writeWarehouseError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidWarehouse):
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrWarehouseCodeTaken):
+		httpjson.WriteError(w, http.StatusConflict, err.Error())
+	case util.IsNotFound(err):
+		httpjson.WriteError(w, http.StatusNotFound, "not found")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,185 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Adjustment types an admin can record; they are inventory_adjustments
+// adjustment_type values.
+const (
+	// TypePurchase receives stock into on_hand and available.
+	TypePurchase = "purchase"
+	// TypeCorrection sets on_hand to a physical count.
+	TypeCorrection = "correction"
+	// TypeWriteOff removes damaged or lost units.
+	TypeWriteOff = "adjustment"
+)
+
+var (
+	// ErrInsufficientStock is returned when an adjustment would take
+	// available below zero, i.e. touch units reserved for orders.
+	ErrInsufficientStock = errors.New("adjustment exceeds unreserved stock")
+	ErrUnknownAdjustment = errors.New("unknown adjustment type")
+)
+
+// Adjustment is an inventory ledger entry. Reserved and on-hand levels are
+// nil on entries written before they were recorded (migrations 011, 012).
+type Adjustment struct {
+	ID              uuid.UUID  `json:"id"`
+	ProductID       uuid.UUID  `json:"product_id"`
+	Type            string     `json:"adjustment_type"`
+	Quantity        int        `json:"quantity"`
+	AvailableBefore int        `json:"available_before"`
+	AvailableAfter  int        `json:"available_after"`
+	ReservedBefore  *int       `json:"reserved_before"`
+	ReservedAfter   *int       `json:"reserved_after"`
+	OnHandBefore    *int       `json:"on_hand_before"`
+	OnHandAfter     *int       `json:"on_hand_after"`
+	Reason          string     `json:"reason,omitempty"`
+	ReferenceID     *uuid.UUID `json:"reference_id,omitempty"`
+	// WarehouseID is set on admin adjustments, which apply at one warehouse.
+	WarehouseID *uuid.UUID `json:"warehouse_id,omitempty"`
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type AdjustInput struct {
+	ProductID uuid.UUID
+	Type      string
+	// Quantity is the number of units received or written off, or the
+	// counted on_hand for a correction.
+	Quantity    int
+	Reason      string
+	ReferenceID *uuid.UUID
+	// WarehouseID defaults to the active warehouse that ships first.
+	WarehouseID *uuid.UUID
+	CreatedBy   *uuid.UUID
+}
+
+// stock is the part of an inventory row an adjustment changes; reserved
+// units are never touched.
+type stock struct {
+	available, onHand int
+}
+
+// applyAdjustment returns the stock after the adjustment and the signed
+// change to on_hand, which is recorded as the adjustment quantity.
+// Reserved units are never touched: a write-off or a count below what is
+// reserved fails with ErrInsufficientStock.
+func applyAdjustment(before stock, typ string, qty int) (stock, int, error) {
+	var delta int
+	switch typ {
+	case TypePurchase:
+		delta = qty
+	case TypeWriteOff:
+		delta = -qty
+	case TypeCorrection:
+		delta = qty - before.onHand
+	default:
+		return before, 0, ErrUnknownAdjustment
+	}
+	after := stock{available: before.available + delta, onHand: before.onHand + delta}
+	if after.available < 0 || after.onHand < 0 {
+		return before, 0, ErrInsufficientStock
+	}
+	return after, delta, nil
+}
+
+// Adjust applies an admin adjustment to the stock at one warehouse, and to
+// the product's total, under the inventory row lock. It records it with the
+// total before and after. Counts and write-offs are checked against the
+// warehouse's unreserved stock.
+func (r *Postgres) Adjust(ctx context.Context, in AdjustInput) (*Adjustment, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	a, err := adjust(ctx, tx, in)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// adjust applies and records an adjustment in tx; see Adjust.
+func adjust(ctx context.Context, tx pgx.Tx, in AdjustInput) (*Adjustment, error) {
+	total, err := lockInventory(ctx, tx, in.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	warehouseID := in.WarehouseID
+	if warehouseID == nil {
+		id, err := DefaultWarehouse(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		warehouseID = &id
+	}
+	if err := ensureWarehouseStock(ctx, tx, in.ProductID, *warehouseID); err != nil {
+		return nil, err
+	}
+
+	var at stock
+	if err := tx.QueryRow(ctx, `
+		SELECT available, on_hand FROM warehouse_stock WHERE product_id = $1 AND warehouse_id = $2 FOR UPDATE
+	`, in.ProductID, *warehouseID).Scan(&at.available, &at.onHand); err != nil {
+		return nil, err
+	}
+	atAfter, delta, err := applyAdjustment(at, in.Type, in.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	before := stock{available: total.Available, onHand: total.OnHand}
+	after := stock{available: before.available + delta, onHand: before.onHand + delta}
+	reserved := total.Reserved
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE warehouse_stock SET available = $3, on_hand = $4, updated_at = NOW()
+		WHERE product_id = $1 AND warehouse_id = $2
+	`, in.ProductID, *warehouseID, atAfter.available, atAfter.onHand); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE inventory SET available = $2, on_hand = $3, updated_at = NOW() WHERE product_id = $1
+	`, in.ProductID, after.available, after.onHand); err != nil {
+		return nil, err
+	}
+
+	a := Adjustment{
+		ProductID:       in.ProductID,
+		Type:            in.Type,
+		Quantity:        delta,
+		AvailableBefore: before.available,
+		AvailableAfter:  after.available,
+		ReservedBefore:  &reserved,
+		ReservedAfter:   &reserved,
+		OnHandBefore:    &before.onHand,
+		OnHandAfter:     &after.onHand,
+		Reason:          in.Reason,
+		ReferenceID:     in.ReferenceID,
+		WarehouseID:     warehouseID,
+		CreatedBy:       in.CreatedBy,
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO inventory_adjustments (product_id, adjustment_type, quantity, available_before, available_after,
+		                                   reserved_before, reserved_after, on_hand_before, on_hand_after,
+		                                   reason, reference_id, warehouse_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11, $12, $13)
+		RETURNING id, created_at
+	`, a.ProductID, a.Type, a.Quantity, a.AvailableBefore, a.AvailableAfter,
+		a.ReservedBefore, a.ReservedAfter, a.OnHandBefore, a.OnHandAfter,
+		a.Reason, a.ReferenceID, a.WarehouseID, a.CreatedBy).Scan(&a.ID, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
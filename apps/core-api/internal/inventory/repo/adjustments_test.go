@@ -0,0 +1,33 @@
+package repo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyAdjustment(t *testing.T) {
+	// 10 on hand, 3 of them reserved for orders.
+	before := stock{available: 7, onHand: 10}
+
+	for name, tc := range map[string]struct {
+		typ       string
+		qty       int
+		want      stock
+		wantDelta int
+		wantErr   error
+	}{
+		"receive":              {typ: TypePurchase, qty: 5, want: stock{available: 12, onHand: 15}, wantDelta: 5},
+		"write off":            {typ: TypeWriteOff, qty: 7, want: stock{available: 0, onHand: 3}, wantDelta: -7},
+		"write off reserved":   {typ: TypeWriteOff, qty: 8, want: before, wantErr: ErrInsufficientStock},
+		"count up":             {typ: TypeCorrection, qty: 12, want: stock{available: 9, onHand: 12}, wantDelta: 2},
+		"count down":           {typ: TypeCorrection, qty: 4, want: stock{available: 1, onHand: 4}, wantDelta: -6},
+		"count below reserved": {typ: TypeCorrection, qty: 2, want: before, wantErr: ErrInsufficientStock},
+		"count matches":        {typ: TypeCorrection, qty: 10, want: before},
+		"unknown type":         {typ: "sale", qty: 1, want: before, wantErr: ErrUnknownAdjustment},
+	} {
+		got, delta, err := applyAdjustment(before, tc.typ, tc.qty)
+		if !errors.Is(err, tc.wantErr) || got != tc.want || delta != tc.wantDelta {
+			t.Errorf("%s: applyAdjustment = (%+v, %d, %v), want (%+v, %d, %v)", name, got, delta, err, tc.want, tc.wantDelta, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+)
+
+// Levels are the stock counts of an inventory row.
+type Levels struct {
+	Available int `json:"available"`
+	Reserved  int `json:"reserved"`
+	OnHand    int `json:"on_hand"`
+}
+
+// Balance compares a product's inventory row with its replayed ledger.
+type Balance struct {
+	Current Levels `json:"current"`
+	// Ledger is the sum of the changes recorded in the ledger; it equals
+	// Current unless the row was changed without a ledger entry.
+	Ledger Levels `json:"ledger"`
+	InSync bool   `json:"in_sync"`
+}
+
+// GetBalance returns pgx.ErrNoRows if the product has no inventory row.
+func (r *Postgres) GetBalance(ctx context.Context, productID uuid.UUID) (*Balance, error) {
+	var b Balance
+	if err := r.pool.QueryRow(ctx, `
+		SELECT i.available, i.reserved, i.on_hand, l.available, l.reserved, l.on_hand
+		FROM inventory i
+		CROSS JOIN LATERAL inventory_ledger_balance(i.product_id) l
+		WHERE i.product_id = $1
+	`, productID).Scan(&b.Current.Available, &b.Current.Reserved, &b.Current.OnHand,
+		&b.Ledger.Available, &b.Ledger.Reserved, &b.Ledger.OnHand); err != nil {
+		return nil, err
+	}
+	b.InSync = b.Current == b.Ledger
+	return &b, nil
+}
+
+// ListLedger returns a product's ledger entries, newest first.
+func (r *Postgres) ListLedger(ctx context.Context, productID uuid.UUID, page pagination.Params) ([]Adjustment, pagination.Page, error) {
+	keyset, orderBy := page.Where("created_at", "id", 2)
+	args := append([]any{productID}, page.Args()...)
+	args = append(args, page.Fetch())
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, product_id, adjustment_type, quantity, available_before, available_after,
+		       reserved_before, reserved_after, on_hand_before, on_hand_after,
+		       COALESCE(reason, ''), reference_id, warehouse_id, created_by, created_at
+		FROM inventory_adjustments
+		WHERE product_id = $1 AND `+keyset+`
+		ORDER BY `+orderBy+`
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	entries := make([]Adjustment, 0, page.Fetch())
+	for rows.Next() {
+		var a Adjustment
+		if err := rows.Scan(&a.ID, &a.ProductID, &a.Type, &a.Quantity, &a.AvailableBefore, &a.AvailableAfter,
+			&a.ReservedBefore, &a.ReservedAfter, &a.OnHandBefore, &a.OnHandAfter,
+			&a.Reason, &a.ReferenceID, &a.WarehouseID, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, pagination.Page{}, err
+		}
+		entries = append(entries, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+	rows.Close()
+
+	entries, p := pagination.Finish(page, entries, func(a Adjustment) (time.Time, uuid.UUID) {
+		return a.CreatedAt, a.ID
+	})
+	if page.IncludeTotal {
+		var total int
+		if err := r.pool.QueryRow(ctx, `
+			SELECT count(*) FROM inventory_adjustments WHERE product_id = $1
+		`, productID).Scan(&total); err != nil {
+			return nil, pagination.Page{}, err
+		}
+		p.Total = &total
+	}
+	return entries, p, nil
+}
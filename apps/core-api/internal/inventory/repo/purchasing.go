@@ -0,0 +1,594 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+)
+
+// Sell modes of a product; see migration 010.
+const (
+	SellPreorder  = "preorder"
+	SellBackorder = "backorder"
+)
+
+// Purchase order statuses; see migration 015.
+const (
+	POStatusDraft             = "draft"
+	POStatusOrdered           = "ordered"
+	POStatusPartiallyReceived = "partially_received"
+	POStatusReceived          = "received"
+	POStatusCancelled         = "cancelled"
+)
+
+var (
+	// ErrPurchaseOrderStatus is wrapped with the order's current status.
+	ErrPurchaseOrderStatus = errors.New("not allowed in the purchase order's status")
+	// ErrNotOnOrder is returned when a receipt lists a product the purchase
+	// order does not.
+	ErrNotOnOrder = errors.New("product is not on the purchase order")
+	// ErrOverReceipt is returned when a receipt exceeds what is outstanding.
+	ErrOverReceipt = errors.New("receipt exceeds the quantity outstanding")
+)
+
+type Supplier struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email,omitempty"`
+	Phone        string    `json:"phone,omitempty"`
+	LeadTimeDays *int      `json:"lead_time_days,omitempty"`
+	IsActive     bool      `json:"is_active"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type CreateSupplierInput struct {
+	Name         string
+	Email        string
+	Phone        string
+	LeadTimeDays *int
+}
+
+// UpdateSupplierInput changes the non-nil fields.
+type UpdateSupplierInput struct {
+	Name         *string
+	Email        *string
+	Phone        *string
+	LeadTimeDays *int
+	IsActive     *bool
+}
+
+type PurchaseOrder struct {
+	ID          uuid.UUID  `json:"id"`
+	SupplierID  uuid.UUID  `json:"supplier_id"`
+	WarehouseID uuid.UUID  `json:"warehouse_id"`
+	Status      string     `json:"status"`
+	ExpectedAt  *time.Time `json:"expected_at,omitempty"`
+	Notes       string     `json:"notes,omitempty"`
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty"`
+	OrderedAt   *time.Time `json:"ordered_at,omitempty"`
+	ReceivedAt  *time.Time `json:"received_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	// Lines is not loaded by ListPurchaseOrders.
+	Lines []PurchaseOrderLine `json:"lines,omitempty"`
+}
+
+type PurchaseOrderLine struct {
+	ID               uuid.UUID `json:"id"`
+	ProductID        uuid.UUID `json:"product_id"`
+	QuantityOrdered  int       `json:"quantity_ordered"`
+	QuantityReceived int       `json:"quantity_received"`
+	UnitCost         *float64  `json:"unit_cost,omitempty"`
+}
+
+type PurchaseOrderLineInput struct {
+	ProductID uuid.UUID
+	Quantity  int
+	UnitCost  *float64
+}
+
+type CreatePurchaseOrderInput struct {
+	SupplierID  uuid.UUID
+	WarehouseID uuid.UUID
+	ExpectedAt  *time.Time
+	Notes       string
+	Lines       []PurchaseOrderLineInput
+	CreatedBy   *uuid.UUID
+}
+
+// UpdatePurchaseOrderInput changes the non-nil fields. Lines replaces the
+// order's lines and is only allowed on drafts.
+type UpdatePurchaseOrderInput struct {
+	ExpectedAt *time.Time
+	Notes      *string
+	Lines      []PurchaseOrderLineInput
+}
+
+type PurchaseOrderFilter struct {
+	Status     string
+	SupplierID *uuid.UUID
+}
+
+// ReceiptLine is quantity units of a product delivered against an order.
+type ReceiptLine struct {
+	ProductID uuid.UUID
+	Quantity  int
+}
+
+const supplierColumns = `id, name, COALESCE(email, ''), COALESCE(phone, ''), lead_time_days, is_active, created_at, updated_at`
+
+func scanSupplier(row pgx.Row) (*Supplier, error) {
+	var s Supplier
+	if err := row.Scan(&s.ID, &s.Name, &s.Email, &s.Phone, &s.LeadTimeDays, &s.IsActive, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *Postgres) ListSuppliers(ctx context.Context) ([]Supplier, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+supplierColumns+` FROM suppliers ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Supplier, 0)
+	for rows.Next() {
+		s, err := scanSupplier(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *s)
+	}
+	return out, rows.Err()
+}
+
+func (r *Postgres) CreateSupplier(ctx context.Context, in CreateSupplierInput) (*Supplier, error) {
+	return scanSupplier(r.pool.QueryRow(ctx, `
+		INSERT INTO suppliers (name, email, phone, lead_time_days)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4)
+		RETURNING `+supplierColumns,
+		in.Name, in.Email, in.Phone, in.LeadTimeDays))
+}
+
+func (r *Postgres) UpdateSupplier(ctx context.Context, id uuid.UUID, in UpdateSupplierInput) (*Supplier, error) {
+	return scanSupplier(r.pool.QueryRow(ctx, `
+		UPDATE suppliers
+		SET name = COALESCE($2, name),
+		    email = CASE WHEN $3::text IS NULL THEN email ELSE NULLIF($3, '') END,
+		    phone = CASE WHEN $4::text IS NULL THEN phone ELSE NULLIF($4, '') END,
+		    lead_time_days = COALESCE($5, lead_time_days),
+		    is_active = COALESCE($6, is_active),
+		    updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+supplierColumns,
+		id, in.Name, in.Email, in.Phone, in.LeadTimeDays, in.IsActive))
+}
+
+const purchaseOrderColumns = `id, supplier_id, warehouse_id, status, expected_at, COALESCE(notes, ''), created_by,
+	ordered_at, received_at, created_at, updated_at`
+
+func scanPurchaseOrder(row pgx.Row) (*PurchaseOrder, error) {
+	var o PurchaseOrder
+	if err := row.Scan(&o.ID, &o.SupplierID, &o.WarehouseID, &o.Status, &o.ExpectedAt, &o.Notes, &o.CreatedBy,
+		&o.OrderedAt, &o.ReceivedAt, &o.CreatedAt, &o.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// ListPurchaseOrders returns purchase orders, newest first, without their
+// lines.
+func (r *Postgres) ListPurchaseOrders(ctx context.Context, f PurchaseOrderFilter, page pagination.Params) ([]PurchaseOrder, pagination.Page, error) {
+	const filter = `($1 = '' OR status = $1) AND ($2::uuid IS NULL OR supplier_id = $2)`
+	keyset, orderBy := page.Where("created_at", "id", 3)
+	args := append([]any{f.Status, f.SupplierID}, page.Args()...)
+	args = append(args, page.Fetch())
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+purchaseOrderColumns+`
+		FROM purchase_orders
+		WHERE `+filter+` AND `+keyset+`
+		ORDER BY `+orderBy+`
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	orders := make([]PurchaseOrder, 0, page.Fetch())
+	for rows.Next() {
+		o, err := scanPurchaseOrder(rows)
+		if err != nil {
+			return nil, pagination.Page{}, err
+		}
+		orders = append(orders, *o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+	rows.Close()
+
+	orders, p := pagination.Finish(page, orders, func(o PurchaseOrder) (time.Time, uuid.UUID) {
+		return o.CreatedAt, o.ID
+	})
+	if page.IncludeTotal {
+		var total int
+		if err := r.pool.QueryRow(ctx, `SELECT count(*) FROM purchase_orders WHERE `+filter, f.Status, f.SupplierID).Scan(&total); err != nil {
+			return nil, pagination.Page{}, err
+		}
+		p.Total = &total
+	}
+	return orders, p, nil
+}
+
+// querier is satisfied by the pool and by transactions.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// GetPurchaseOrder returns the order with its lines.
+func (r *Postgres) GetPurchaseOrder(ctx context.Context, id uuid.UUID) (*PurchaseOrder, error) {
+	return getPurchaseOrder(ctx, r.pool, id, false)
+}
+
+// getPurchaseOrder loads the order and its lines, locking them with lock.
+func getPurchaseOrder(ctx context.Context, q querier, id uuid.UUID, lock bool) (*PurchaseOrder, error) {
+	forUpdate := ""
+	if lock {
+		forUpdate = " FOR UPDATE"
+	}
+	o, err := scanPurchaseOrder(q.QueryRow(ctx, `SELECT `+purchaseOrderColumns+` FROM purchase_orders WHERE id = $1`+forUpdate, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT id, product_id, quantity_ordered, quantity_received, unit_cost::float8
+		FROM purchase_order_lines
+		WHERE purchase_order_id = $1
+		ORDER BY product_id`+forUpdate, id)
+	if err != nil {
+		return nil, err
+	}
+	o.Lines, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (PurchaseOrderLine, error) {
+		var l PurchaseOrderLine
+		err := row.Scan(&l.ID, &l.ProductID, &l.QuantityOrdered, &l.QuantityReceived, &l.UnitCost)
+		return l, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// CreatePurchaseOrder creates a draft order.
+func (r *Postgres) CreatePurchaseOrder(ctx context.Context, in CreatePurchaseOrderInput) (*PurchaseOrder, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var id uuid.UUID
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO purchase_orders (supplier_id, warehouse_id, expected_at, notes, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		RETURNING id
+	`, in.SupplierID, in.WarehouseID, in.ExpectedAt, in.Notes, in.CreatedBy).Scan(&id); err != nil {
+		return nil, err
+	}
+	if err := insertPurchaseOrderLines(ctx, tx, id, in.Lines); err != nil {
+		return nil, err
+	}
+	o, err := getPurchaseOrder(ctx, tx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func insertPurchaseOrderLines(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, lines []PurchaseOrderLineInput) error {
+	for _, l := range lines {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO purchase_order_lines (purchase_order_id, product_id, quantity_ordered, unit_cost)
+			VALUES ($1, $2, $3, $4)
+		`, orderID, l.ProductID, l.Quantity, l.UnitCost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdatePurchaseOrder changes an order that is still open. Lines can only be
+// replaced on drafts.
+func (r *Postgres) UpdatePurchaseOrder(ctx context.Context, id uuid.UUID, in UpdatePurchaseOrderInput) (*PurchaseOrder, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	o, err := getPurchaseOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case in.Lines != nil && o.Status != POStatusDraft:
+		return nil, fmt.Errorf("%w: lines can only be changed on drafts, order is %s", ErrPurchaseOrderStatus, o.Status)
+	case !isOpen(o.Status) && o.Status != POStatusDraft:
+		return nil, fmt.Errorf("%w: order is %s", ErrPurchaseOrderStatus, o.Status)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE purchase_orders
+		SET expected_at = COALESCE($2, expected_at),
+		    notes = CASE WHEN $3::text IS NULL THEN notes ELSE NULLIF($3, '') END,
+		    updated_at = NOW()
+		WHERE id = $1
+	`, id, in.ExpectedAt, in.Notes); err != nil {
+		return nil, err
+	}
+	if in.Lines != nil {
+		if _, err := tx.Exec(ctx, `DELETE FROM purchase_order_lines WHERE purchase_order_id = $1`, id); err != nil {
+			return nil, err
+		}
+		if err := insertPurchaseOrderLines(ctx, tx, id, in.Lines); err != nil {
+			return nil, err
+		}
+	}
+
+	o, err = getPurchaseOrder(ctx, tx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// isOpen reports whether an order is placed with the supplier and still
+// expects deliveries.
+func isOpen(status string) bool {
+	return status == POStatusOrdered || status == POStatusPartiallyReceived
+}
+
+// PlacePurchaseOrder moves a draft to ordered. Without an expected date the
+// order is expected after the supplier's lead time.
+func (r *Postgres) PlacePurchaseOrder(ctx context.Context, id uuid.UUID) (*PurchaseOrder, error) {
+	return r.transition(ctx, id, []string{POStatusDraft}, `
+		UPDATE purchase_orders o
+		SET status = 'ordered', ordered_at = NOW(), updated_at = NOW(),
+		    expected_at = COALESCE(o.expected_at, NOW() + make_interval(days => s.lead_time_days))
+		FROM suppliers s
+		WHERE o.id = $1 AND s.id = o.supplier_id
+	`)
+}
+
+// CancelPurchaseOrder cancels an order that is not fully received; units
+// already received stay in stock.
+func (r *Postgres) CancelPurchaseOrder(ctx context.Context, id uuid.UUID) (*PurchaseOrder, error) {
+	return r.transition(ctx, id, []string{POStatusDraft, POStatusOrdered, POStatusPartiallyReceived}, `
+		UPDATE purchase_orders SET status = 'cancelled', updated_at = NOW() WHERE id = $1
+	`)
+}
+
+func (r *Postgres) transition(ctx context.Context, id uuid.UUID, from []string, update string) (*PurchaseOrder, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var status string
+	if err := tx.QueryRow(ctx, `SELECT status FROM purchase_orders WHERE id = $1 FOR UPDATE`, id).Scan(&status); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(from, status) {
+		return nil, fmt.Errorf("%w: order is %s", ErrPurchaseOrderStatus, status)
+	}
+	if _, err := tx.Exec(ctx, update, id); err != nil {
+		return nil, err
+	}
+
+	o, err := getPurchaseOrder(ctx, tx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// planReceipt adds the receipt to the order's lines and returns the order's
+// status afterwards. Receipt lines for the same product are summed.
+func planReceipt(lines []PurchaseOrderLine, receipt []ReceiptLine) ([]PurchaseOrderLine, string, error) {
+	out := slices.Clone(lines)
+	for _, rl := range receipt {
+		i := slices.IndexFunc(out, func(l PurchaseOrderLine) bool { return l.ProductID == rl.ProductID })
+		if i < 0 {
+			return nil, "", fmt.Errorf("%w: %s", ErrNotOnOrder, rl.ProductID)
+		}
+		out[i].QuantityReceived += rl.Quantity
+		if out[i].QuantityReceived > out[i].QuantityOrdered {
+			return nil, "", fmt.Errorf("%w: %d of %s outstanding", ErrOverReceipt,
+				lines[i].QuantityOrdered-lines[i].QuantityReceived, rl.ProductID)
+		}
+	}
+
+	status := POStatusReceived
+	for _, l := range out {
+		if l.QuantityReceived < l.QuantityOrdered {
+			status = POStatusPartiallyReceived
+		}
+	}
+	return out, status, nil
+}
+
+// ReceivePurchaseOrder books a delivery against an open order: each line is
+// added to the order's warehouse with a purchase adjustment referencing the
+// order, in one transaction. It returns the updated order and the
+// adjustments.
+func (r *Postgres) ReceivePurchaseOrder(ctx context.Context, id uuid.UUID, receipt []ReceiptLine, createdBy *uuid.UUID) (*PurchaseOrder, []Adjustment, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	o, err := getPurchaseOrder(ctx, tx, id, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isOpen(o.Status) {
+		return nil, nil, fmt.Errorf("%w: order is %s", ErrPurchaseOrderStatus, o.Status)
+	}
+	lines, status, err := planReceipt(o.Lines, receipt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Inventory rows are locked in product order, like the lines, so
+	// concurrent receipts cannot deadlock.
+	var adjustments []Adjustment
+	for i, l := range lines {
+		qty := l.QuantityReceived - o.Lines[i].QuantityReceived
+		if qty == 0 {
+			continue
+		}
+		a, err := adjust(ctx, tx, AdjustInput{
+			ProductID:   l.ProductID,
+			Type:        TypePurchase,
+			Quantity:    qty,
+			ReferenceID: &o.ID,
+			WarehouseID: &o.WarehouseID,
+			CreatedBy:   createdBy,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		adjustments = append(adjustments, *a)
+		if _, err := tx.Exec(ctx, `
+			UPDATE purchase_order_lines SET quantity_received = $2 WHERE id = $1
+		`, l.ID, l.QuantityReceived); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE purchase_orders
+		SET status = $2, updated_at = NOW(),
+		    received_at = CASE WHEN $2 = 'received' THEN NOW() ELSE received_at END
+		WHERE id = $1
+	`, id, status); err != nil {
+		return nil, nil, err
+	}
+
+	o, err = getPurchaseOrder(ctx, tx, id, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, err
+	}
+	return o, adjustments, nil
+}
+
+// Incoming is stock on open purchase orders due at ExpectedAt.
+type Incoming struct {
+	ExpectedAt time.Time
+	Quantity   int
+}
+
+// RestockState is what decides when a product sold as a preorder or
+// backorder can next be allocated.
+type RestockState struct {
+	Available   int
+	SellMode    string
+	ReleaseDate *time.Time
+	// Queued is the quantity waiting for stock.
+	Queued int
+	// Incoming is sorted by ExpectedAt. Open orders without an expected
+	// date are left out.
+	Incoming []Incoming
+}
+
+// GetRestockStates returns the restock state of those of the products sold
+// as preorders or backorders.
+func (r *Postgres) GetRestockStates(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]RestockState, error) {
+	out := map[uuid.UUID]RestockState{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	rows, err := r.pool.Query(ctx, `
+		SELECT p.id, i.available, p.sell_mode, p.release_date, COALESCE(q.queued, 0)::int
+		FROM products p
+		JOIN inventory i ON i.product_id = p.id
+		LEFT JOIN LATERAL (
+			SELECT SUM(a.quantity) AS queued
+			FROM inventory_allocations a
+			WHERE a.product_id = p.id AND a.status = 'pending'
+		) q ON true
+		WHERE p.id = ANY($1::uuid[]) AND p.sell_mode IN ('preorder', 'backorder')
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var s RestockState
+		if err := rows.Scan(&id, &s.Available, &s.SellMode, &s.ReleaseDate, &s.Queued); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out[id] = s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	rows, err = r.pool.Query(ctx, `
+		SELECT l.product_id, o.expected_at, SUM(l.quantity_ordered - l.quantity_received)::int
+		FROM purchase_order_lines l
+		JOIN purchase_orders o ON o.id = l.purchase_order_id
+		WHERE l.product_id = ANY($1::uuid[]) AND o.status IN ('ordered', 'partially_received')
+		  AND o.expected_at IS NOT NULL AND l.quantity_received < l.quantity_ordered
+		GROUP BY l.product_id, o.expected_at
+		ORDER BY l.product_id, o.expected_at
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var in Incoming
+		if err := rows.Scan(&id, &in.ExpectedAt, &in.Quantity); err != nil {
+			return nil, err
+		}
+		if s, ok := out[id]; ok {
+			s.Incoming = append(s.Incoming, in)
+			out[id] = s
+		}
+	}
+	return out, rows.Err()
+}
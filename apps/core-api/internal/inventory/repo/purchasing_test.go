@@ -0,0 +1,37 @@
+package repo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPlanReceipt(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	lines := []PurchaseOrderLine{
+		{ProductID: a, QuantityOrdered: 10, QuantityReceived: 4},
+		{ProductID: b, QuantityOrdered: 5},
+	}
+
+	out, status, err := planReceipt(lines, []ReceiptLine{{ProductID: a, Quantity: 2}, {ProductID: a, Quantity: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[0].QuantityReceived != 10 || status != POStatusPartiallyReceived {
+		t.Errorf("got received %d, status %s; want 10, partially_received", out[0].QuantityReceived, status)
+	}
+	if lines[0].QuantityReceived != 4 {
+		t.Error("planReceipt changed its input")
+	}
+
+	if _, status, _ := planReceipt(out, []ReceiptLine{{ProductID: b, Quantity: 5}}); status != POStatusReceived {
+		t.Errorf("status = %s, want received", status)
+	}
+	if _, _, err := planReceipt(lines, []ReceiptLine{{ProductID: a, Quantity: 7}}); !errors.Is(err, ErrOverReceipt) {
+		t.Errorf("over-receipt err = %v", err)
+	}
+	if _, _, err := planReceipt(lines, []ReceiptLine{{ProductID: uuid.New(), Quantity: 1}}); !errors.Is(err, ErrNotOnOrder) {
+		t.Errorf("unknown product err = %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// StockReportRow is a product's stock with its recent demand.
+type StockReportRow struct {
+	ProductID         uuid.UUID
+	Name              string
+	SKU               string
+	Levels            Levels
+	LowStockThreshold int
+	LowStockSince     *time.Time
+	// UnitsSold is the units reserved for orders since the window start,
+	// net of reservations released again.
+	UnitsSold int
+	// Waiting is the units queued as preorders or backorders.
+	Waiting int
+}
+
+// StockReport returns products below their low-stock threshold, or every
+// product with all, most short of their threshold first.
+func (r *Postgres) StockReport(ctx context.Context, since time.Time, all bool) ([]StockReportRow, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT p.id, p.name, p.sku, i.available, i.reserved, i.on_hand,
+		       COALESCE(i.low_stock_threshold, 0), i.low_stock_since,
+		       GREATEST(COALESCE(s.sold, 0), 0)::int, COALESCE(w.waiting, 0)::int
+		FROM inventory i
+		JOIN products p ON p.id = i.product_id
+		LEFT JOIN LATERAL (
+			SELECT SUM(CASE WHEN a.adjustment_type = 'sale' THEN a.quantity ELSE -a.quantity END) AS sold
+			FROM inventory_adjustments a
+			WHERE a.product_id = i.product_id AND a.adjustment_type IN ('sale', 'release') AND a.created_at >= $1
+		) s ON true
+		LEFT JOIN LATERAL (
+			SELECT SUM(q.quantity) AS waiting
+			FROM inventory_allocations q
+			WHERE q.product_id = i.product_id AND q.status = 'pending'
+		) w ON true
+		WHERE p.deleted_at IS NULL AND ($2 OR i.available < i.low_stock_threshold)
+		ORDER BY i.available - COALESCE(i.low_stock_threshold, 0), p.name, p.id
+	`, since, all)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (StockReportRow, error) {
+		var s StockReportRow
+		err := row.Scan(&s.ProductID, &s.Name, &s.SKU, &s.Levels.Available, &s.Levels.Reserved, &s.Levels.OnHand,
+			&s.LowStockThreshold, &s.LowStockSince, &s.UnitsSold, &s.Waiting)
+		return s, err
+	})
+}
@@ -0,0 +1,282 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNoWarehouse is returned when stock is added without a warehouse and no
+// active warehouse exists to default to.
+var ErrNoWarehouse = errors.New("no active warehouse")
+
+type Warehouse struct {
+	ID        uuid.UUID `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	Country   string    `json:"country,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	Priority  int       `json:"priority"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CreateWarehouseInput struct {
+	Code     string
+	Name     string
+	Country  string
+	Region   string
+	Priority int
+}
+
+// UpdateWarehouseInput changes the non-nil fields.
+type UpdateWarehouseInput struct {
+	Name     *string
+	Country  *string
+	Region   *string
+	Priority *int
+	IsActive *bool
+}
+
+// WarehouseStock is a product's stock at one warehouse.
+type WarehouseStock struct {
+	WarehouseID   uuid.UUID `json:"warehouse_id"`
+	WarehouseCode string    `json:"warehouse_code"`
+	Levels
+}
+
+// RegionStock is a product's sellable stock in a region, summed over its
+// active warehouses.
+type RegionStock struct {
+	ProductID uuid.UUID
+	Country   string
+	Region    string
+	Available int
+}
+
+type Transfer struct {
+	ID              uuid.UUID  `json:"id"`
+	ProductID       uuid.UUID  `json:"product_id"`
+	FromWarehouseID uuid.UUID  `json:"from_warehouse_id"`
+	ToWarehouseID   uuid.UUID  `json:"to_warehouse_id"`
+	Quantity        int        `json:"quantity"`
+	Reason          string     `json:"reason,omitempty"`
+	CreatedBy       *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type TransferInput struct {
+	ProductID       uuid.UUID
+	FromWarehouseID uuid.UUID
+	ToWarehouseID   uuid.UUID
+	Quantity        int
+	Reason          string
+	CreatedBy       *uuid.UUID
+}
+
+const warehouseColumns = `id, code, name, COALESCE(country, ''), COALESCE(region, ''), priority, is_active, created_at, updated_at`
+
+func scanWarehouse(row pgx.Row) (*Warehouse, error) {
+	var w Warehouse
+	if err := row.Scan(&w.ID, &w.Code, &w.Name, &w.Country, &w.Region, &w.Priority, &w.IsActive, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (r *Postgres) ListWarehouses(ctx context.Context) ([]Warehouse, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+warehouseColumns+` FROM warehouses ORDER BY priority, code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Warehouse, 0)
+	for rows.Next() {
+		w, err := scanWarehouse(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *w)
+	}
+	return out, rows.Err()
+}
+
+func (r *Postgres) CreateWarehouse(ctx context.Context, in CreateWarehouseInput) (*Warehouse, error) {
+	return scanWarehouse(r.pool.QueryRow(ctx, `
+		INSERT INTO warehouses (code, name, country, region, priority)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)
+		RETURNING `+warehouseColumns,
+		in.Code, in.Name, in.Country, in.Region, in.Priority))
+}
+
+func (r *Postgres) UpdateWarehouse(ctx context.Context, id uuid.UUID, in UpdateWarehouseInput) (*Warehouse, error) {
+	return scanWarehouse(r.pool.QueryRow(ctx, `
+		UPDATE warehouses
+		SET name = COALESCE($2, name),
+		    country = CASE WHEN $3::text IS NULL THEN country ELSE NULLIF($3, '') END,
+		    region = CASE WHEN $4::text IS NULL THEN region ELSE NULLIF($4, '') END,
+		    priority = COALESCE($5, priority),
+		    is_active = COALESCE($6, is_active),
+		    updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+warehouseColumns,
+		id, in.Name, in.Country, in.Region, in.Priority, in.IsActive))
+}
+
+// ListWarehouseStock returns a product's stock per warehouse.
+func (r *Postgres) ListWarehouseStock(ctx context.Context, productID uuid.UUID) ([]WarehouseStock, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT w.id, w.code, s.available, s.reserved, s.on_hand
+		FROM warehouse_stock s
+		JOIN warehouses w ON w.id = s.warehouse_id
+		WHERE s.product_id = $1
+		ORDER BY w.priority, w.code
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]WarehouseStock, 0)
+	for rows.Next() {
+		var s WarehouseStock
+		if err := rows.Scan(&s.WarehouseID, &s.WarehouseCode, &s.Available, &s.Reserved, &s.OnHand); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// GetAvailableByRegion returns the products' available stock per region of
+// their active warehouses. Warehouses without a country are left out.
+func (r *Postgres) GetAvailableByRegion(ctx context.Context, ids []uuid.UUID) ([]RegionStock, error) {
+	out := make([]RegionStock, 0)
+	if len(ids) == 0 {
+		return out, nil
+	}
+	rows, err := r.pool.Query(ctx, `
+		SELECT s.product_id, w.country, COALESCE(w.region, ''), SUM(s.available)::int
+		FROM warehouse_stock s
+		JOIN warehouses w ON w.id = s.warehouse_id
+		WHERE s.product_id = ANY($1::uuid[]) AND w.is_active AND w.country IS NOT NULL
+		GROUP BY s.product_id, w.country, COALESCE(w.region, '')
+		ORDER BY s.product_id, w.country, COALESCE(w.region, '')
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s RegionStock
+		if err := rows.Scan(&s.ProductID, &s.Country, &s.Region, &s.Available); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Transfer moves unreserved units between warehouses. The product's total
+// stock does not change, so no ledger entry is written; the transfer is
+// recorded in stock_transfers.
+func (r *Postgres) Transfer(ctx context.Context, in TransferInput) (*Transfer, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// warehouse_stock rows are only changed under the inventory row lock.
+	if _, err := lockInventory(ctx, tx, in.ProductID); err != nil {
+		return nil, err
+	}
+	if err := ensureWarehouseStock(ctx, tx, in.ProductID, in.ToWarehouseID); err != nil {
+		return nil, err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE warehouse_stock
+		SET available = available - $3, on_hand = on_hand - $3, updated_at = NOW()
+		WHERE product_id = $1 AND warehouse_id = $2 AND available >= $3
+	`, in.ProductID, in.FromWarehouseID, in.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() != 1 {
+		return nil, ErrInsufficientStock
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE warehouse_stock
+		SET available = available + $3, on_hand = on_hand + $3, updated_at = NOW()
+		WHERE product_id = $1 AND warehouse_id = $2
+	`, in.ProductID, in.ToWarehouseID, in.Quantity); err != nil {
+		return nil, err
+	}
+
+	t := Transfer{
+		ProductID:       in.ProductID,
+		FromWarehouseID: in.FromWarehouseID,
+		ToWarehouseID:   in.ToWarehouseID,
+		Quantity:        in.Quantity,
+		Reason:          in.Reason,
+		CreatedBy:       in.CreatedBy,
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO stock_transfers (product_id, from_warehouse_id, to_warehouse_id, quantity, reason, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		RETURNING id, created_at
+	`, t.ProductID, t.FromWarehouseID, t.ToWarehouseID, t.Quantity, t.Reason, t.CreatedBy).Scan(&t.ID, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// lockInventory locks a product's inventory row and returns its levels.
+func lockInventory(ctx context.Context, tx pgx.Tx, productID uuid.UUID) (Levels, error) {
+	var l Levels
+	err := tx.QueryRow(ctx, `
+		SELECT available, reserved, on_hand FROM inventory WHERE product_id = $1 FOR UPDATE
+	`, productID).Scan(&l.Available, &l.Reserved, &l.OnHand)
+	return l, err
+}
+
+// ensureWarehouseStock creates an empty stock row for the product at the
+// warehouse. It returns pgx.ErrNoRows if the warehouse does not exist.
+func ensureWarehouseStock(ctx context.Context, tx pgx.Tx, productID, warehouseID uuid.UUID) error {
+	var ok bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM warehouses WHERE id = $1)`, warehouseID).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO warehouse_stock (product_id, warehouse_id) VALUES ($1, $2)
+		ON CONFLICT (product_id, warehouse_id) DO NOTHING
+	`, productID, warehouseID)
+	return err
+}
+
+// DefaultWarehouse returns the active warehouse that ships first, or
+// ErrNoWarehouse if none is active.
+func DefaultWarehouse(ctx context.Context, tx pgx.Tx) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := tx.QueryRow(ctx, `
+		SELECT id FROM warehouses WHERE is_active ORDER BY priority, code LIMIT 1
+	`).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, ErrNoWarehouse
+	}
+	return id, err
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/cache"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+	"github.com/kalen1o/iphone-storage/shared/events"
+)
+
+// ErrInvalidAdjustment is wrapped with a message naming the offending field.
+var ErrInvalidAdjustment = errors.New("invalid adjustment")
+
+const (
+	maxAdjustmentQuantity  = 1_000_000
+	maxAdjustmentReasonLen = 500
+)
+
+type AdjustInput struct {
+	// Quantity is the number of units for receipts and write-offs, and the
+	// counted on_hand for corrections.
+	Quantity    int
+	Reason      string
+	ReferenceID *uuid.UUID
+	// WarehouseID defaults to the warehouse that ships first.
+	WarehouseID *uuid.UUID
+	CreatedBy   *uuid.UUID
+}
+
+// ReceiveStock adds delivered units to on_hand and available.
+func (s *Service) ReceiveStock(ctx context.Context, productID uuid.UUID, in AdjustInput) (*repo.Adjustment, error) {
+	if in.Quantity <= 0 {
+		return nil, invalidAdjustment("quantity must be > 0")
+	}
+	return s.adjust(ctx, productID, repo.TypePurchase, in)
+}
+
+// CorrectCount sets on_hand to a physical count; the difference is applied
+// to available.
+func (s *Service) CorrectCount(ctx context.Context, productID uuid.UUID, in AdjustInput) (*repo.Adjustment, error) {
+	if in.Quantity < 0 {
+		return nil, invalidAdjustment("counted quantity must be >= 0")
+	}
+	if strings.TrimSpace(in.Reason) == "" {
+		return nil, invalidAdjustment("reason is required for corrections")
+	}
+	return s.adjust(ctx, productID, repo.TypeCorrection, in)
+}
+
+// WriteOff removes damaged or lost units from on_hand and available.
+func (s *Service) WriteOff(ctx context.Context, productID uuid.UUID, in AdjustInput) (*repo.Adjustment, error) {
+	if in.Quantity <= 0 {
+		return nil, invalidAdjustment("quantity must be > 0")
+	}
+	if strings.TrimSpace(in.Reason) == "" {
+		return nil, invalidAdjustment("reason is required for write-offs")
+	}
+	return s.adjust(ctx, productID, repo.TypeWriteOff, in)
+}
+
+func (s *Service) adjust(ctx context.Context, productID uuid.UUID, typ string, in AdjustInput) (*repo.Adjustment, error) {
+	if in.Quantity > maxAdjustmentQuantity {
+		return nil, invalidAdjustment(fmt.Sprintf("quantity must be at most %d", maxAdjustmentQuantity))
+	}
+	reason := strings.TrimSpace(in.Reason)
+	if len(reason) > maxAdjustmentReasonLen {
+		return nil, invalidAdjustment(fmt.Sprintf("reason must be at most %d characters", maxAdjustmentReasonLen))
+	}
+
+	a, err := s.repo.Adjust(ctx, repo.AdjustInput{
+		ProductID:   productID,
+		Type:        typ,
+		Quantity:    in.Quantity,
+		Reason:      reason,
+		ReferenceID: in.ReferenceID,
+		WarehouseID: in.WarehouseID,
+		CreatedBy:   in.CreatedBy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_ = s.cache.Invalidate(ctx, cache.NamespaceStock)
+	s.publishAdjusted(ctx, a)
+	return a, nil
+}
+
+func (s *Service) publishAdjusted(ctx context.Context, a *repo.Adjustment) {
+	if s.producer == nil {
+		return
+	}
+	data := events.InventoryAdjustedData{
+		AdjustmentID:    a.ID.String(),
+		ProductID:       a.ProductID.String(),
+		AdjustmentType:  a.Type,
+		Quantity:        a.Quantity,
+		AvailableBefore: a.AvailableBefore,
+		AvailableAfter:  a.AvailableAfter,
+		OnHandBefore:    *a.OnHandBefore,
+		OnHandAfter:     *a.OnHandAfter,
+		Reason:          a.Reason,
+	}
+	if a.ReferenceID != nil {
+		data.ReferenceID = a.ReferenceID.String()
+	}
+	b, err := events.Marshal(events.Envelope[events.InventoryAdjustedData]{
+		EventID:     uuid.NewString(),
+		Type:        events.TypeInventoryAdjusted,
+		OccurredAt:  time.Now().UTC(),
+		AggregateID: a.ProductID.String(),
+		Data:        data,
+	})
+	if err == nil {
+		_ = s.producer.Publish(ctx, events.TopicInventoryAdjusted, []byte(a.ProductID.String()), b)
+	}
+}
+
+func invalidAdjustment(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAdjustment, msg)
+}
+
+// Ledger returns a page of the product's inventory ledger and how its
+// replayed balance compares with the inventory row.
+func (s *Service) Ledger(ctx context.Context, productID uuid.UUID, page pagination.Params) ([]repo.Adjustment, pagination.Page, *repo.Balance, error) {
+	balance, err := s.repo.GetBalance(ctx, productID)
+	if err != nil {
+		return nil, pagination.Page{}, nil, err
+	}
+	entries, p, err := s.repo.ListLedger(ctx, productID, page)
+	if err != nil {
+		return nil, pagination.Page{}, nil, err
+	}
+	return entries, p, balance, nil
+}
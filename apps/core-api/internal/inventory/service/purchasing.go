@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/cache"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+var (
+	// ErrInvalidPurchaseOrder is wrapped with a message naming the offending
+	// field.
+	ErrInvalidPurchaseOrder = errors.New("invalid purchase order")
+	ErrSupplierNameTaken    = errors.New("supplier name already exists")
+)
+
+const (
+	maxPurchaseOrderLines = 200
+	maxLeadTimeDays       = 365
+)
+
+func (s *Service) ListSuppliers(ctx context.Context) ([]repo.Supplier, error) {
+	return s.repo.ListSuppliers(ctx)
+}
+
+func (s *Service) CreateSupplier(ctx context.Context, in repo.CreateSupplierInput) (*repo.Supplier, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Email = strings.TrimSpace(in.Email)
+	in.Phone = strings.TrimSpace(in.Phone)
+	if in.Name == "" || len(in.Name) > 255 {
+		return nil, invalidPurchaseOrder("name is required and must be at most 255 characters")
+	}
+	if err := validateSupplierFields(&in.Email, &in.Phone, in.LeadTimeDays); err != nil {
+		return nil, err
+	}
+
+	sup, err := s.repo.CreateSupplier(ctx, in)
+	if util.IsUniqueViolation(err, "suppliers_name_key") {
+		return nil, ErrSupplierNameTaken
+	}
+	return sup, err
+}
+
+func (s *Service) UpdateSupplier(ctx context.Context, id uuid.UUID, in repo.UpdateSupplierInput) (*repo.Supplier, error) {
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		if name == "" || len(name) > 255 {
+			return nil, invalidPurchaseOrder("name must not be empty and must be at most 255 characters")
+		}
+		in.Name = &name
+	}
+	if in.Email != nil {
+		email := strings.TrimSpace(*in.Email)
+		in.Email = &email
+	}
+	if in.Phone != nil {
+		phone := strings.TrimSpace(*in.Phone)
+		in.Phone = &phone
+	}
+	if err := validateSupplierFields(in.Email, in.Phone, in.LeadTimeDays); err != nil {
+		return nil, err
+	}
+
+	sup, err := s.repo.UpdateSupplier(ctx, id, in)
+	if util.IsUniqueViolation(err, "suppliers_name_key") {
+		return nil, ErrSupplierNameTaken
+	}
+	return sup, err
+}
+
+func validateSupplierFields(email, phone *string, leadTimeDays *int) error {
+	if email != nil && *email != "" && (len(*email) > 255 || !strings.Contains(*email, "@")) {
+		return invalidPurchaseOrder("email must be a valid email address")
+	}
+	if phone != nil && len(*phone) > 50 {
+		return invalidPurchaseOrder("phone must be at most 50 characters")
+	}
+	if leadTimeDays != nil && (*leadTimeDays < 0 || *leadTimeDays > maxLeadTimeDays) {
+		return invalidPurchaseOrder(fmt.Sprintf("lead_time_days must be between 0 and %d", maxLeadTimeDays))
+	}
+	return nil
+}
+
+func (s *Service) ListPurchaseOrders(ctx context.Context, f repo.PurchaseOrderFilter, page pagination.Params) ([]repo.PurchaseOrder, pagination.Page, error) {
+	switch f.Status {
+	case "", repo.POStatusDraft, repo.POStatusOrdered, repo.POStatusPartiallyReceived, repo.POStatusReceived, repo.POStatusCancelled:
+	default:
+		return nil, pagination.Page{}, invalidPurchaseOrder("status must be draft, ordered, partially_received, received or cancelled")
+	}
+	return s.repo.ListPurchaseOrders(ctx, f, page)
+}
+
+func (s *Service) GetPurchaseOrder(ctx context.Context, id uuid.UUID) (*repo.PurchaseOrder, error) {
+	return s.repo.GetPurchaseOrder(ctx, id)
+}
+
+// CreatePurchaseOrder creates a draft. Drafts do not count as incoming
+// stock until they are placed.
+func (s *Service) CreatePurchaseOrder(ctx context.Context, in repo.CreatePurchaseOrderInput) (*repo.PurchaseOrder, error) {
+	if in.SupplierID == uuid.Nil || in.WarehouseID == uuid.Nil {
+		return nil, invalidPurchaseOrder("supplier_id and warehouse_id are required")
+	}
+	if len(in.Lines) == 0 {
+		return nil, invalidPurchaseOrder("lines must not be empty")
+	}
+	if err := validatePurchaseOrderLines(in.Lines); err != nil {
+		return nil, err
+	}
+	in.Notes = strings.TrimSpace(in.Notes)
+	if len(in.Notes) > maxAdjustmentReasonLen {
+		return nil, invalidPurchaseOrder(fmt.Sprintf("notes must be at most %d characters", maxAdjustmentReasonLen))
+	}
+
+	o, err := s.repo.CreatePurchaseOrder(ctx, in)
+	if util.IsForeignKeyViolation(err) {
+		return nil, invalidPurchaseOrder("supplier, warehouse or product not found")
+	}
+	return o, err
+}
+
+// UpdatePurchaseOrder changes the expected date or notes of an open order,
+// or the lines of a draft.
+func (s *Service) UpdatePurchaseOrder(ctx context.Context, id uuid.UUID, in repo.UpdatePurchaseOrderInput) (*repo.PurchaseOrder, error) {
+	if in.Lines != nil {
+		if len(in.Lines) == 0 {
+			return nil, invalidPurchaseOrder("lines must not be empty")
+		}
+		if err := validatePurchaseOrderLines(in.Lines); err != nil {
+			return nil, err
+		}
+	}
+	if in.Notes != nil {
+		notes := strings.TrimSpace(*in.Notes)
+		if len(notes) > maxAdjustmentReasonLen {
+			return nil, invalidPurchaseOrder(fmt.Sprintf("notes must be at most %d characters", maxAdjustmentReasonLen))
+		}
+		in.Notes = &notes
+	}
+
+	o, err := s.repo.UpdatePurchaseOrder(ctx, id, in)
+	if util.IsForeignKeyViolation(err) {
+		return nil, invalidPurchaseOrder("product not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The expected date of an open order feeds restock estimates.
+	_ = s.cache.Invalidate(ctx, cache.NamespaceStock)
+	return o, nil
+}
+
+func validatePurchaseOrderLines(lines []repo.PurchaseOrderLineInput) error {
+	if len(lines) > maxPurchaseOrderLines {
+		return invalidPurchaseOrder(fmt.Sprintf("at most %d lines", maxPurchaseOrderLines))
+	}
+	seen := make(map[uuid.UUID]bool, len(lines))
+	for _, l := range lines {
+		if l.ProductID == uuid.Nil {
+			return invalidPurchaseOrder("product_id is required on every line")
+		}
+		if seen[l.ProductID] {
+			return invalidPurchaseOrder("each product may appear on one line only")
+		}
+		seen[l.ProductID] = true
+		if l.Quantity <= 0 || l.Quantity > maxAdjustmentQuantity {
+			return invalidPurchaseOrder(fmt.Sprintf("quantity must be between 1 and %d", maxAdjustmentQuantity))
+		}
+		if l.UnitCost != nil && *l.UnitCost < 0 {
+			return invalidPurchaseOrder("unit_cost must be >= 0")
+		}
+	}
+	return nil
+}
+
+// PlacePurchaseOrder marks a draft as ordered from the supplier; its lines
+// count as incoming stock from then on.
+func (s *Service) PlacePurchaseOrder(ctx context.Context, id uuid.UUID) (*repo.PurchaseOrder, error) {
+	o, err := s.repo.PlacePurchaseOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.cache.Invalidate(ctx, cache.NamespaceStock)
+	return o, nil
+}
+
+func (s *Service) CancelPurchaseOrder(ctx context.Context, id uuid.UUID) (*repo.PurchaseOrder, error) {
+	o, err := s.repo.CancelPurchaseOrder(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.cache.Invalidate(ctx, cache.NamespaceStock)
+	return o, nil
+}
+
+// ReceivePurchaseOrder books delivered units against an open order. Each
+// product received is published as inventory.adjusted, which lets
+// inventory-service fill waiting preorders and backorders.
+func (s *Service) ReceivePurchaseOrder(ctx context.Context, id uuid.UUID, receipt []repo.ReceiptLine, createdBy *uuid.UUID) (*repo.PurchaseOrder, error) {
+	if len(receipt) == 0 {
+		return nil, invalidPurchaseOrder("lines must not be empty")
+	}
+	for _, l := range receipt {
+		if l.ProductID == uuid.Nil {
+			return nil, invalidPurchaseOrder("product_id is required on every line")
+		}
+		if l.Quantity <= 0 || l.Quantity > maxAdjustmentQuantity {
+			return nil, invalidPurchaseOrder(fmt.Sprintf("quantity must be between 1 and %d", maxAdjustmentQuantity))
+		}
+	}
+
+	o, adjustments, err := s.repo.ReceivePurchaseOrder(ctx, id, receipt, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.cache.Invalidate(ctx, cache.NamespaceStock)
+	for i := range adjustments {
+		s.publishAdjusted(ctx, &adjustments[i])
+	}
+	return o, nil
+}
+
+// GetRestockEstimates returns, for those of the products sold as preorders
+// or backorders that cannot be allocated now, when a unit ordered now is
+// expected to be: once open purchase orders have brought in enough stock
+// for the queue ahead of it, and not before the release date. Products
+// without an estimate are left out.
+func (s *Service) GetRestockEstimates(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]time.Time, error) {
+	states, err := cache.Get(ctx, s.cache, cache.Spec{
+		Endpoint:   "inventory.restock",
+		Key:        cache.HashKey(ids),
+		Namespaces: []string{cache.NamespaceStock},
+		TTL:        s.cacheTTL,
+	}, func(ctx context.Context) (map[uuid.UUID]repo.RestockState, error) {
+		return s.repo.GetRestockStates(ctx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make(map[uuid.UUID]time.Time, len(states))
+	for id, st := range states {
+		if t, ok := estimateRestock(st, now); ok {
+			out[id] = t
+		}
+	}
+	return out, nil
+}
+
+// estimateRestock walks the incoming stock in order of arrival until it
+// covers the queued units and one more beyond what is available. It reports
+// false when the unit could be allocated now, or when open orders do not
+// cover it.
+func estimateRestock(st repo.RestockState, now time.Time) (time.Time, bool) {
+	prerelease := st.SellMode == repo.SellPreorder && st.ReleaseDate != nil && st.ReleaseDate.After(now)
+	need := st.Queued + 1 - st.Available
+
+	var at time.Time
+	if need > 0 {
+		covered := false
+		for _, in := range st.Incoming {
+			need -= in.Quantity
+			if need <= 0 {
+				at, covered = in.ExpectedAt, true
+				break
+			}
+		}
+		if !covered {
+			return time.Time{}, false
+		}
+	} else if !prerelease {
+		return time.Time{}, false
+	}
+	if prerelease && st.ReleaseDate.After(at) {
+		at = *st.ReleaseDate
+	}
+	// An overdue delivery is expected any time now, not in the past.
+	if at.Before(now) {
+		at = now
+	}
+	return at, true
+}
+
+func invalidPurchaseOrder(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPurchaseOrder, msg)
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+)
+
+func TestEstimateRestock(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return now.AddDate(0, 0, n) }
+	incoming := []repo.Incoming{{ExpectedAt: day(5), Quantity: 3}, {ExpectedAt: day(20), Quantity: 10}}
+
+	tests := []struct {
+		name string
+		st   repo.RestockState
+		want time.Time
+		ok   bool
+	}{
+		{"in stock", repo.RestockState{SellMode: repo.SellBackorder, Available: 2}, time.Time{}, false},
+		{"first delivery covers", repo.RestockState{SellMode: repo.SellBackorder, Queued: 2, Incoming: incoming}, day(5), true},
+		{"queue spills into second delivery", repo.RestockState{SellMode: repo.SellBackorder, Available: 1, Queued: 4, Incoming: incoming}, day(20), true},
+		{"not covered", repo.RestockState{SellMode: repo.SellBackorder, Queued: 13, Incoming: incoming}, time.Time{}, false},
+		{"release date after delivery", repo.RestockState{SellMode: repo.SellPreorder, ReleaseDate: ptr(day(10)), Incoming: incoming}, day(10), true},
+		{"in stock before release", repo.RestockState{SellMode: repo.SellPreorder, ReleaseDate: ptr(day(10)), Available: 50}, day(10), true},
+		{"overdue delivery", repo.RestockState{SellMode: repo.SellBackorder, Incoming: []repo.Incoming{{ExpectedAt: day(-3), Quantity: 1}}}, now, true},
+	}
+	for _, tt := range tests {
+		got, ok := estimateRestock(tt.st, now)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+)
+
+const (
+	DefaultReportWindowDays = 30
+	DefaultReportTargetDays = 30
+)
+
+// ReplenishmentItem is a row of the replenishment report.
+type ReplenishmentItem struct {
+	ProductID         uuid.UUID  `json:"product_id"`
+	Name              string     `json:"name"`
+	SKU               string     `json:"sku"`
+	Available         int        `json:"available"`
+	Reserved          int        `json:"reserved"`
+	OnHand            int        `json:"on_hand"`
+	LowStockThreshold int        `json:"low_stock_threshold"`
+	LowStockSince     *time.Time `json:"low_stock_since,omitempty"`
+	Waiting           int        `json:"waiting"`
+	UnitsSold         int        `json:"units_sold"`
+	// DailySales is UnitsSold averaged over the window.
+	DailySales float64 `json:"daily_sales"`
+	// SellThrough is the share of the stock there was to sell that sold in
+	// the window: sold / (sold + on hand).
+	SellThrough float64 `json:"sell_through"`
+	// DaysOfCover is how long available stock lasts at DailySales; nil
+	// when nothing sold.
+	DaysOfCover *float64 `json:"days_of_cover"`
+	// SuggestedReorder covers TargetDays of sales plus the threshold as
+	// safety stock and the waiting orders, less what is available.
+	SuggestedReorder int `json:"suggested_reorder"`
+}
+
+type ReplenishmentReport struct {
+	WindowDays int                 `json:"window_days"`
+	TargetDays int                 `json:"target_days"`
+	Items      []ReplenishmentItem `json:"items"`
+}
+
+// ReplenishmentReport reports products below their low-stock threshold (or
+// all products) with their sales over the last windowDays and how much to
+// reorder to cover targetDays.
+func (s *Service) ReplenishmentReport(ctx context.Context, windowDays, targetDays int, all bool) (*ReplenishmentReport, error) {
+	rows, err := s.repo.StockReport(ctx, time.Now().AddDate(0, 0, -windowDays), all)
+	if err != nil {
+		return nil, err
+	}
+	out := &ReplenishmentReport{WindowDays: windowDays, TargetDays: targetDays, Items: make([]ReplenishmentItem, 0, len(rows))}
+	for _, row := range rows {
+		out.Items = append(out.Items, replenishment(row, windowDays, targetDays))
+	}
+	return out, nil
+}
+
+func replenishment(row repo.StockReportRow, windowDays, targetDays int) ReplenishmentItem {
+	it := ReplenishmentItem{
+		ProductID:         row.ProductID,
+		Name:              row.Name,
+		SKU:               row.SKU,
+		Available:         row.Levels.Available,
+		Reserved:          row.Levels.Reserved,
+		OnHand:            row.Levels.OnHand,
+		LowStockThreshold: row.LowStockThreshold,
+		LowStockSince:     row.LowStockSince,
+		Waiting:           row.Waiting,
+		UnitsSold:         row.UnitsSold,
+		DailySales:        float64(row.UnitsSold) / float64(windowDays),
+	}
+	if stock := row.UnitsSold + row.Levels.OnHand; stock > 0 {
+		it.SellThrough = float64(row.UnitsSold) / float64(stock)
+	}
+	if it.DailySales > 0 {
+		cover := float64(row.Levels.Available) / it.DailySales
+		it.DaysOfCover = &cover
+	}
+	need := int(math.Ceil(it.DailySales*float64(targetDays))) + row.LowStockThreshold + row.Waiting
+	it.SuggestedReorder = max(need-row.Levels.Available, 0)
+	return it
+}
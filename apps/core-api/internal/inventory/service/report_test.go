@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+)
+
+func TestReplenishment(t *testing.T) {
+	row := repo.StockReportRow{
+		Levels:            repo.Levels{Available: 6, Reserved: 4, OnHand: 10},
+		LowStockThreshold: 10,
+		UnitsSold:         30,
+		Waiting:           2,
+	}
+	it := replenishment(row, 30, 14)
+
+	if it.DailySales != 1 {
+		t.Errorf("DailySales = %v, want 1", it.DailySales)
+	}
+	if it.SellThrough != 0.75 {
+		t.Errorf("SellThrough = %v, want 0.75", it.SellThrough)
+	}
+	if it.DaysOfCover == nil || *it.DaysOfCover != 6 {
+		t.Errorf("DaysOfCover = %v, want 6", it.DaysOfCover)
+	}
+	// 14 days of sales + 10 safety + 2 waiting - 6 available.
+	if it.SuggestedReorder != 20 {
+		t.Errorf("SuggestedReorder = %d, want 20", it.SuggestedReorder)
+	}
+
+	idle := replenishment(repo.StockReportRow{Levels: repo.Levels{Available: 50, OnHand: 50}, LowStockThreshold: 10}, 30, 14)
+	if idle.DaysOfCover != nil || idle.SellThrough != 0 || idle.SuggestedReorder != 0 {
+		t.Errorf("idle product = %+v", idle)
+	}
+}
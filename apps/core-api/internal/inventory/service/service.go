@@ -2,22 +2,36 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/cache"
+	"github.com/kalen1o/iphone-storage/shared/kafka"
 )
 
 type Service struct {
-	repo *repo.Postgres
+	repo     *repo.Postgres
+	producer *kafka.Producer
+	cache    *cache.Cache
+	cacheTTL time.Duration
 }
 
-func New(r *repo.Postgres) *Service {
-	return &Service{repo: r}
+// New wires the service. c may be nil to disable caching.
+func New(r *repo.Postgres, producer *kafka.Producer, c *cache.Cache, cacheTTL time.Duration) *Service {
+	return &Service{repo: r, producer: producer, cache: c, cacheTTL: cacheTTL}
 }
 
 func (s *Service) GetInStockByProductIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
-	availableByID, err := s.repo.GetAvailableByProductIDs(ctx, ids)
+	availableByID, err := cache.Get(ctx, s.cache, cache.Spec{
+		Endpoint:   "inventory.list",
+		Key:        cache.HashKey(ids),
+		Namespaces: []string{cache.NamespaceStock},
+		TTL:        s.cacheTTL,
+	}, func(ctx context.Context) (map[uuid.UUID]int, error) {
+		return s.repo.GetAvailableByProductIDs(ctx, ids)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -31,9 +45,38 @@ func (s *Service) GetInStockByProductIDs(ctx context.Context, ids []uuid.UUID) (
 }
 
 func (s *Service) GetInStockByProductID(ctx context.Context, id uuid.UUID) (bool, error) {
-	available, err := s.repo.GetAvailableByProductID(ctx, id)
+	available, err := cache.Get(ctx, s.cache, cache.Spec{
+		Endpoint:   "inventory.get",
+		Key:        id.String(),
+		Namespaces: []string{cache.NamespaceStock},
+		TTL:        s.cacheTTL,
+	}, func(ctx context.Context) (int, error) {
+		return s.repo.GetAvailableByProductID(ctx, id)
+	})
 	if err != nil {
 		return false, err
 	}
 	return available > 0, nil
 }
+
+// GetRegionStockByProductIDs returns the products' available stock per
+// warehouse region.
+func (s *Service) GetRegionStockByProductIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]repo.RegionStock, error) {
+	rows, err := cache.Get(ctx, s.cache, cache.Spec{
+		Endpoint:   "inventory.regions",
+		Key:        cache.HashKey(ids),
+		Namespaces: []string{cache.NamespaceStock},
+		TTL:        s.cacheTTL,
+	}, func(ctx context.Context) ([]repo.RegionStock, error) {
+		return s.repo.GetAvailableByRegion(ctx, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[uuid.UUID][]repo.RegionStock, len(ids))
+	for _, r := range rows {
+		out[r.ProductID] = append(out[r.ProductID], r)
+	}
+	return out, nil
+}
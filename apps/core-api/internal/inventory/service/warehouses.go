@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/cache"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+var (
+	// ErrInvalidWarehouse is wrapped with a message naming the offending field.
+	ErrInvalidWarehouse   = errors.New("invalid warehouse")
+	ErrWarehouseCodeTaken = errors.New("warehouse code already exists")
+)
+
+func (s *Service) ListWarehouses(ctx context.Context) ([]repo.Warehouse, error) {
+	return s.repo.ListWarehouses(ctx)
+}
+
+func (s *Service) CreateWarehouse(ctx context.Context, in repo.CreateWarehouseInput) (*repo.Warehouse, error) {
+	in.Code = strings.TrimSpace(in.Code)
+	in.Name = strings.TrimSpace(in.Name)
+	in.Country = strings.ToUpper(strings.TrimSpace(in.Country))
+	in.Region = strings.TrimSpace(in.Region)
+	if in.Code == "" || len(in.Code) > 50 {
+		return nil, invalidWarehouse("code is required and must be at most 50 characters")
+	}
+	if in.Name == "" {
+		return nil, invalidWarehouse("name is required")
+	}
+	if err := validateLocation(&in.Country, &in.Region); err != nil {
+		return nil, err
+	}
+
+	w, err := s.repo.CreateWarehouse(ctx, in)
+	if util.IsUniqueViolation(err, "warehouses_code_key") {
+		return nil, ErrWarehouseCodeTaken
+	}
+	return w, err
+}
+
+func (s *Service) UpdateWarehouse(ctx context.Context, id uuid.UUID, in repo.UpdateWarehouseInput) (*repo.Warehouse, error) {
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		if name == "" {
+			return nil, invalidWarehouse("name must not be empty")
+		}
+		in.Name = &name
+	}
+	if in.Country != nil {
+		country := strings.ToUpper(strings.TrimSpace(*in.Country))
+		in.Country = &country
+		if err := validateLocation(in.Country, nil); err != nil {
+			return nil, err
+		}
+	}
+	if in.Region != nil {
+		region := strings.TrimSpace(*in.Region)
+		in.Region = &region
+		if err := validateLocation(nil, in.Region); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := s.repo.UpdateWarehouse(ctx, id, in)
+	if err != nil {
+		return nil, err
+	}
+	// Deactivating a warehouse or moving it changes regional availability.
+	_ = s.cache.Invalidate(ctx, cache.NamespaceStock)
+	return w, nil
+}
+
+func validateLocation(country, region *string) error {
+	if country != nil && *country != "" && len(*country) != 2 {
+		return invalidWarehouse("country must be a two-letter ISO country code")
+	}
+	if region != nil && len(*region) > 100 {
+		return invalidWarehouse("region must be at most 100 characters")
+	}
+	return nil
+}
+
+func (s *Service) ListWarehouseStock(ctx context.Context, productID uuid.UUID) ([]repo.WarehouseStock, error) {
+	return s.repo.ListWarehouseStock(ctx, productID)
+}
+
+// Transfer moves unreserved units of a product between warehouses.
+func (s *Service) Transfer(ctx context.Context, in repo.TransferInput) (*repo.Transfer, error) {
+	if in.Quantity <= 0 || in.Quantity > maxAdjustmentQuantity {
+		return nil, invalidAdjustment(fmt.Sprintf("quantity must be between 1 and %d", maxAdjustmentQuantity))
+	}
+	if in.FromWarehouseID == uuid.Nil || in.ToWarehouseID == uuid.Nil {
+		return nil, invalidAdjustment("from_warehouse_id and to_warehouse_id are required")
+	}
+	if in.FromWarehouseID == in.ToWarehouseID {
+		return nil, invalidAdjustment("from_warehouse_id and to_warehouse_id must differ")
+	}
+	in.Reason = strings.TrimSpace(in.Reason)
+	if len(in.Reason) > maxAdjustmentReasonLen {
+		return nil, invalidAdjustment(fmt.Sprintf("reason must be at most %d characters", maxAdjustmentReasonLen))
+	}
+
+	t, err := s.repo.Transfer(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.cache.Invalidate(ctx, cache.NamespaceStock)
+	return t, nil
+}
+
+func invalidWarehouse(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidWarehouse, msg)
+}
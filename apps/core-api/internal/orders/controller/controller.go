@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/repo"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/service"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
 )
 
@@ -31,7 +33,8 @@ func New(svc *service.Service) *Controller {
 // @Security BearerAuth
 // @Param body body repo.CreateOrderInput true "Order"
 // @Success 201 {object} repo.Order
-// @Failure 400 {object} map[string]any
+// @Failure 400 {object} map[string]any "code invalid_gift_card: a gift card is unknown, not active, expired or empty"
+// @Failure 409 {object} map[string]any "code purchase_limit_exceeded: the order would go over a per-customer limit; code raffle_winners_only: a product is sold by raffle and the user holds no open offer for it"
 // @Router /api/orders [post]
 func (c *Controller) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	userIDRaw, ok := middleware.UserIDFromContext(r.Context())
@@ -55,9 +58,29 @@ func (c *Controller) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		httpjson.WriteError(w, http.StatusBadRequest, "shipping_address_text is required")
 		return
 	}
+	if country := strings.TrimSpace(input.ShippingCountry); country != "" && len(country) != 2 {
+		httpjson.WriteError(w, http.StatusBadRequest, "shipping_country must be a two-letter ISO country code")
+		return
+	}
 
 	order, err := c.svc.Create(r.Context(), userID, input)
 	if err != nil {
+		if errors.Is(err, repo.ErrVariantRequired) {
+			httpjson.WriteError(w, http.StatusBadRequest, "product has variants; order a specific variant")
+			return
+		}
+		if errors.Is(err, repo.ErrInvalidGiftCard) {
+			httpjson.WriteErrorCode(w, http.StatusBadRequest, "invalid_gift_card", err.Error())
+			return
+		}
+		if errors.Is(err, repo.ErrPurchaseLimitExceeded) {
+			httpjson.WriteErrorCode(w, http.StatusConflict, "purchase_limit_exceeded", err.Error())
+			return
+		}
+		if errors.Is(err, repo.ErrRaffleWinnersOnly) {
+			httpjson.WriteErrorCode(w, http.StatusConflict, "raffle_winners_only", err.Error())
+			return
+		}
 		httpjson.WriteError(w, http.StatusBadRequest, "failed to create order")
 		return
 	}
@@ -65,6 +88,49 @@ func (c *Controller) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	httpjson.WriteJSON(w, http.StatusCreated, order)
 }
 
+type OrdersListResponse struct {
+	Items []repo.Order `json:"items"`
+	pagination.Page
+}
+
+// ListOrders godoc
+// @Summary List current user's orders
+// @Description Newest first, keyset paginated. Items are not included; fetch an order for its lines.
+// @Tags orders
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Page size (1-100)" default(20)
+// @Param cursor query string false "next_cursor or prev_cursor from a previous page"
+// @Param include_total query bool false "Include the total order count"
+// @Success 200 {object} OrdersListResponse
+// @Failure 400 {object} map[string]any
+// @Router /api/orders [get]
+func (c *Controller) ListOrders(w http.ResponseWriter, r *http.Request) {
+	userIDRaw, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userID, err := uuid.Parse(userIDRaw)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	orders, p, err := c.svc.ListForUser(r.Context(), userID, page)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list orders")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, OrdersListResponse{Items: orders, Page: p})
+}
+
 // GetOrder godoc
 // @Summary Get order by ID
 // @Tags orders
@@ -105,3 +171,48 @@ func (c *Controller) GetOrder(w http.ResponseWriter, r *http.Request) {
 
 	httpjson.WriteJSON(w, http.StatusOK, order)
 }
+
+// CancelOrder godoc
+// @Summary Cancel order
+// @Description Cancels an order that is awaiting payment or waiting for preorder or backorder stock.
+// @Tags orders
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Order ID (uuid)"
+// @Success 200 {object} repo.Order
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/orders/{id}/cancel [post]
+func (c *Controller) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	userIDRaw, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	userID, err := uuid.Parse(userIDRaw)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	orderID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	order, err := c.svc.Cancel(r.Context(), orderID, userID)
+	if err != nil {
+		switch {
+		case util.IsNotFound(err):
+			httpjson.WriteError(w, http.StatusNotFound, "not found")
+		case errors.Is(err, repo.ErrNotCancellable):
+			httpjson.WriteError(w, http.StatusConflict, err.Error())
+		default:
+			httpjson.WriteError(w, http.StatusInternalServerError, "failed to cancel order")
+		}
+		return
+	}
+
+	httpjson.WriteJSON(w, http.StatusOK, order)
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+type PurchaseLimitListResponse struct {
+	Items []repo.PurchaseLimit `json:"items"`
+}
+
+type CreatePurchaseLimitRequest struct {
+	// Exactly one of ProductID and Category. A product's limit covers its
+	// variants.
+	ProductID   *uuid.UUID `json:"product_id,omitempty"`
+	Category    string     `json:"category,omitempty"`
+	MaxQuantity int        `json:"max_quantity"`
+	// WindowSeconds counts a customer's orders over a rolling window, e.g.
+	// 2592000 for 30 days. Without it the limit holds for its whole run.
+	WindowSeconds *int `json:"window_seconds,omitempty"`
+	// StartsAt and EndsAt bound when the limit applies, e.g. a launch.
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+// UpdatePurchaseLimitRequest changes the fields present. window_seconds,
+// starts_at and ends_at set to null are cleared.
+type UpdatePurchaseLimitRequest struct {
+	MaxQuantity   *int                `json:"max_quantity,omitempty"`
+	WindowSeconds nullable[int]       `json:"window_seconds,omitempty" swaggertype:"integer"`
+	StartsAt      nullable[time.Time] `json:"starts_at,omitempty" swaggertype:"string" format:"date-time"`
+	EndsAt        nullable[time.Time] `json:"ends_at,omitempty" swaggertype:"string" format:"date-time"`
+	IsActive      *bool               `json:"is_active,omitempty"`
+}
+
+// nullable is a field of a partial update that tells a null, which clears
+// the field, from a missing key, which leaves it as it is.
+type nullable[T any] struct {
+	set   bool
+	value *T
+}
+
+func (n *nullable[T]) UnmarshalJSON(b []byte) error {
+	n.set = true
+	return json.Unmarshal(b, &n.value)
+}
+
+// cleared reports whether the field was set to null.
+func (n nullable[T]) cleared() bool { return n.set && n.value == nil }
+
+// ListPurchaseLimits godoc
+// @Summary List purchase limits
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} PurchaseLimitListResponse
+// @Router /api/admin/purchase-limits [get]
+func (c *Controller) ListPurchaseLimits(w http.ResponseWriter, r *http.Request) {
+	items, err := c.svc.ListPurchaseLimits(r.Context())
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list purchase limits")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, PurchaseLimitListResponse{Items: items})
+}
+
+// CreatePurchaseLimit godoc
+// @Summary Create a purchase limit
+// @Description Caps the units of a product or category one customer may order. Cancelled orders do not count.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body CreatePurchaseLimitRequest true "Limit"
+// @Success 201 {object} repo.PurchaseLimit
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/purchase-limits [post]
+func (c *Controller) CreatePurchaseLimit(w http.ResponseWriter, r *http.Request) {
+	var req CreatePurchaseLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	l, err := c.svc.CreatePurchaseLimit(r.Context(), repo.CreatePurchaseLimitInput{
+		ProductID:     req.ProductID,
+		Category:      req.Category,
+		MaxQuantity:   req.MaxQuantity,
+		WindowSeconds: req.WindowSeconds,
+		StartsAt:      req.StartsAt,
+		EndsAt:        req.EndsAt,
+	})
+	if err != nil {
+		writePurchaseLimitError(w, err, "failed to create purchase limit")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, l)
+}
+
+// UpdatePurchaseLimit godoc
+// @Summary Update a purchase limit
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Purchase limit ID (uuid)"
+// @Param body body UpdatePurchaseLimitRequest true "Fields to change"
+// @Success 200 {object} repo.PurchaseLimit
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/purchase-limits/{id} [patch]
+func (c *Controller) UpdatePurchaseLimit(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req UpdatePurchaseLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	l, err := c.svc.UpdatePurchaseLimit(r.Context(), id, repo.UpdatePurchaseLimitInput{
+		MaxQuantity:        req.MaxQuantity,
+		WindowSeconds:      req.WindowSeconds.value,
+		StartsAt:           req.StartsAt.value,
+		EndsAt:             req.EndsAt.value,
+		IsActive:           req.IsActive,
+		ClearWindowSeconds: req.WindowSeconds.cleared(),
+		ClearStartsAt:      req.StartsAt.cleared(),
+		ClearEndsAt:        req.EndsAt.cleared(),
+	})
+	if err != nil {
+		writePurchaseLimitError(w, err, "failed to update purchase limit")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, l)
+}
+
+// DeletePurchaseLimit godoc
+// @Summary Delete a purchase limit
+// @Tags admin
+// @Security BearerAuth
+// @Param id path string true "Purchase limit ID (uuid)"
+// @Success 204
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/purchase-limits/{id} [delete]
+func (c *Controller) DeletePurchaseLimit(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if err := c.svc.DeletePurchaseLimit(r.Context(), id); err != nil {
+		writePurchaseLimitError(w, err, "failed to delete purchase limit")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writePurchaseLimitError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidPurchaseLimit):
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+	case util.IsNotFound(err):
+		httpjson.WriteError(w, http.StatusNotFound, "not found")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, fallback)
+	}
+}
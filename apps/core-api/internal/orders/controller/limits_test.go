@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUpdatePurchaseLimitRequest_TellsNullFromMissing(t *testing.T) {
+	var req UpdatePurchaseLimitRequest
+	if err := json.Unmarshal([]byte(`{"window_seconds": null, "starts_at": "2026-09-01T00:00:00Z"}`), &req); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !req.WindowSeconds.cleared() {
+		t.Error("window_seconds: null not cleared")
+	}
+	if req.StartsAt.cleared() || req.StartsAt.value == nil || req.StartsAt.value.Month() != 9 {
+		t.Errorf("starts_at = %+v, want set to the given time", req.StartsAt)
+	}
+	if req.EndsAt.set {
+		t.Error("missing ends_at is set")
+	}
+}
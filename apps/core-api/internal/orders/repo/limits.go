@@ -0,0 +1,230 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrPurchaseLimitExceeded is wrapped with a message naming the limit an
+// order would go over.
+var ErrPurchaseLimitExceeded = errors.New("purchase limit exceeded")
+
+// PurchaseLimit caps how many units of a product, with its variants, or of
+// a category one customer may order; see migration 017.
+type PurchaseLimit struct {
+	ID          uuid.UUID  `json:"id"`
+	ProductID   *uuid.UUID `json:"product_id,omitempty"`
+	Category    string     `json:"category,omitempty"`
+	MaxQuantity int        `json:"max_quantity"`
+	// WindowSeconds counts orders over a rolling window; without it every
+	// order since StartsAt counts.
+	WindowSeconds *int       `json:"window_seconds,omitempty"`
+	StartsAt      *time.Time `json:"starts_at,omitempty"`
+	EndsAt        *time.Time `json:"ends_at,omitempty"`
+	IsActive      bool       `json:"is_active"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+type CreatePurchaseLimitInput struct {
+	ProductID     *uuid.UUID
+	Category      string
+	MaxQuantity   int
+	WindowSeconds *int
+	StartsAt      *time.Time
+	EndsAt        *time.Time
+}
+
+// UpdatePurchaseLimitInput changes the non-nil fields. The Clear fields
+// set the window and bounds back to none.
+type UpdatePurchaseLimitInput struct {
+	MaxQuantity        *int
+	WindowSeconds      *int
+	StartsAt           *time.Time
+	EndsAt             *time.Time
+	IsActive           *bool
+	ClearWindowSeconds bool
+	ClearStartsAt      bool
+	ClearEndsAt        bool
+}
+
+const purchaseLimitColumns = `id, product_id, COALESCE(category, ''), max_quantity, window_seconds, starts_at, ends_at, is_active, created_at, updated_at`
+
+func scanPurchaseLimit(row pgx.Row) (*PurchaseLimit, error) {
+	var l PurchaseLimit
+	if err := row.Scan(&l.ID, &l.ProductID, &l.Category, &l.MaxQuantity, &l.WindowSeconds, &l.StartsAt, &l.EndsAt,
+		&l.IsActive, &l.CreatedAt, &l.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (r *Postgres) ListPurchaseLimits(ctx context.Context) ([]PurchaseLimit, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+purchaseLimitColumns+` FROM purchase_limits ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]PurchaseLimit, 0)
+	for rows.Next() {
+		l, err := scanPurchaseLimit(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *l)
+	}
+	return out, rows.Err()
+}
+
+func (r *Postgres) GetPurchaseLimit(ctx context.Context, id uuid.UUID) (*PurchaseLimit, error) {
+	return scanPurchaseLimit(r.pool.QueryRow(ctx, `SELECT `+purchaseLimitColumns+` FROM purchase_limits WHERE id = $1`, id))
+}
+
+func (r *Postgres) CreatePurchaseLimit(ctx context.Context, in CreatePurchaseLimitInput) (*PurchaseLimit, error) {
+	return scanPurchaseLimit(r.pool.QueryRow(ctx, `
+		INSERT INTO purchase_limits (product_id, category, max_quantity, window_seconds, starts_at, ends_at)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+		RETURNING `+purchaseLimitColumns,
+		in.ProductID, in.Category, in.MaxQuantity, in.WindowSeconds, in.StartsAt, in.EndsAt))
+}
+
+func (r *Postgres) UpdatePurchaseLimit(ctx context.Context, id uuid.UUID, in UpdatePurchaseLimitInput) (*PurchaseLimit, error) {
+	return scanPurchaseLimit(r.pool.QueryRow(ctx, `
+		UPDATE purchase_limits
+		SET max_quantity = COALESCE($2, max_quantity),
+		    window_seconds = CASE WHEN $7 THEN NULL ELSE COALESCE($3, window_seconds) END,
+		    starts_at = CASE WHEN $8 THEN NULL ELSE COALESCE($4, starts_at) END,
+		    ends_at = CASE WHEN $9 THEN NULL ELSE COALESCE($5, ends_at) END,
+		    is_active = COALESCE($6, is_active),
+		    updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+purchaseLimitColumns,
+		id, in.MaxQuantity, in.WindowSeconds, in.StartsAt, in.EndsAt, in.IsActive,
+		in.ClearWindowSeconds, in.ClearStartsAt, in.ClearEndsAt))
+}
+
+func (r *Postgres) DeletePurchaseLimit(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM purchase_limits WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// limitLine is an order line as purchase limits see it.
+type limitLine struct {
+	productID uuid.UUID
+	parentID  *uuid.UUID
+	name      string
+	category  string
+	quantity  int
+}
+
+// covers reports whether the limit applies to the line.
+func (l PurchaseLimit) covers(line limitLine) bool {
+	if l.ProductID != nil {
+		return *l.ProductID == line.productID || (line.parentID != nil && *l.ProductID == *line.parentID)
+	}
+	return l.Category != "" && l.Category == line.category
+}
+
+// requested returns the units the lines ask for under the limit, and the
+// name of the first line it covers.
+func (l PurchaseLimit) requested(lines []limitLine) (qty int, name string) {
+	for _, line := range lines {
+		if l.covers(line) {
+			if name == "" {
+				name = line.name
+			}
+			qty += line.quantity
+		}
+	}
+	return qty, name
+}
+
+// checkPurchaseLimits fails with ErrPurchaseLimitExceeded if the lines
+// would take the user over an active limit. It must run in the transaction
+// that creates the order: concurrent orders of the same user wait for each
+// other here, so each counts the ones committed before it.
+func checkPurchaseLimits(ctx context.Context, tx pgx.Tx, userID uuid.UUID, lines []limitLine) error {
+	productIDs := make([]uuid.UUID, 0, len(lines))
+	categories := make([]string, 0, len(lines))
+	for _, line := range lines {
+		productIDs = append(productIDs, line.productID)
+		if line.parentID != nil {
+			productIDs = append(productIDs, *line.parentID)
+		}
+		if line.category != "" {
+			categories = append(categories, line.category)
+		}
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT `+purchaseLimitColumns+`
+		FROM purchase_limits
+		WHERE is_active
+		  AND (starts_at IS NULL OR starts_at <= NOW()) AND (ends_at IS NULL OR ends_at > NOW())
+		  AND (product_id = ANY($1::uuid[]) OR category = ANY($2::text[]))
+		ORDER BY id
+	`, productIDs, categories)
+	if err != nil {
+		return err
+	}
+	limits, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (PurchaseLimit, error) {
+		l, err := scanPurchaseLimit(row)
+		if err != nil {
+			return PurchaseLimit{}, err
+		}
+		return *l, nil
+	})
+	if err != nil || len(limits) == 0 {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended('purchase_limits:' || $1::text, 0))`, userID.String()); err != nil {
+		return err
+	}
+
+	for _, l := range limits {
+		want, name := l.requested(lines)
+		if want == 0 {
+			continue
+		}
+		// Variants count towards their parent's limit, and a variant
+		// without a category of its own is in its parent's.
+		var used int
+		if err := tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(oi.quantity), 0)::int
+			FROM order_items oi
+			JOIN orders o ON o.id = oi.order_id
+			JOIN products p ON p.id = oi.product_id
+			LEFT JOIN products parent ON parent.id = p.parent_id
+			WHERE o.user_id = $1 AND o.status <> 'cancelled' AND o.deleted_at IS NULL
+			  AND o.created_at >= GREATEST(
+				COALESCE($4::timestamptz, '-infinity'),
+				CASE WHEN $5::int IS NULL THEN '-infinity' ELSE NOW() - make_interval(secs => $5) END
+			  )
+			  AND (oi.product_id = $2 OR p.parent_id = $2 OR ($3 <> '' AND COALESCE(p.category, parent.category) = $3))
+		`, userID, l.ProductID, l.Category, l.StartsAt, l.WindowSeconds).Scan(&used); err != nil {
+			return err
+		}
+		if used+want > l.MaxQuantity {
+			subject := name
+			if l.ProductID == nil {
+				subject = "category " + l.Category
+			}
+			return fmt.Errorf("%w: at most %d per customer for %s, %d left", ErrPurchaseLimitExceeded,
+				l.MaxQuantity, subject, max(l.MaxQuantity-used, 0))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/testdb"
+)
+
+func TestPurchaseLimitRequested(t *testing.T) {
+	iphone, accessory := uuid.New(), uuid.New()
+	black, white := uuid.New(), uuid.New()
+	lines := []limitLine{
+		{productID: black, parentID: &iphone, name: "iPhone Black", category: "smartphones", quantity: 1},
+		{productID: white, parentID: &iphone, name: "iPhone White", category: "smartphones", quantity: 2},
+		{productID: accessory, name: "Case", category: "accessories", quantity: 5},
+	}
+
+	for name, tc := range map[string]struct {
+		limit    PurchaseLimit
+		wantQty  int
+		wantName string
+	}{
+		"parent covers its variants": {limit: PurchaseLimit{ProductID: &iphone}, wantQty: 3, wantName: "iPhone Black"},
+		"single variant":             {limit: PurchaseLimit{ProductID: &white}, wantQty: 2, wantName: "iPhone White"},
+		"category":                   {limit: PurchaseLimit{Category: "accessories"}, wantQty: 5, wantName: "Case"},
+		"other product":              {limit: PurchaseLimit{ProductID: new(uuid.UUID)}},
+		"other category":             {limit: PurchaseLimit{Category: "laptops"}},
+	} {
+		qty, got := tc.limit.requested(lines)
+		if qty != tc.wantQty || got != tc.wantName {
+			t.Errorf("%s: requested = %d, %q; want %d, %q", name, qty, got, tc.wantQty, tc.wantName)
+		}
+	}
+}
+
+// TestCheckPurchaseLimits_CountsConcurrentAndCancelledOrders places
+// concurrent orders for one customer under a limit of two and checks that
+// only two get through, and that cancelling one gives its unit back.
+func TestCheckPurchaseLimits_CountsConcurrentAndCancelledOrders(t *testing.T) {
+	ctx, pool := testdb.New(t, "users", "products", "orders", "order_items", "purchase_limits", "raffles", "raffle_entries")
+	r := NewPostgres(pool)
+
+	userID, productID := uuid.New(), uuid.New()
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO users (id, email, password_hash) VALUES ($1, $2, 'x')
+	`, userID, "limits-"+userID.String()+"@example.test"); err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `
+		INSERT INTO products (id, name, sku, price, category, is_active)
+		VALUES ($1, 'iPhone Limit Test', $2, 1199.00, 'smartphones', true)
+	`, productID, "TEST-LIMIT-"+productID.String()); err != nil {
+		t.Fatalf("insert product: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, _ = pool.Exec(ctx, `DELETE FROM order_items WHERE product_id = $1`, productID)
+		_, _ = pool.Exec(ctx, `DELETE FROM orders WHERE user_id = $1`, userID)
+		_, _ = pool.Exec(ctx, `DELETE FROM purchase_limits WHERE product_id = $1`, productID)
+		_, _ = pool.Exec(ctx, `DELETE FROM products WHERE id = $1`, productID)
+		_, _ = pool.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	})
+	if _, err := r.CreatePurchaseLimit(ctx, CreatePurchaseLimitInput{ProductID: &productID, MaxQuantity: 2}); err != nil {
+		t.Fatalf("create limit: %v", err)
+	}
+	order := CreateOrderInput{
+		ShippingAddressText: "1 Infinite Loop",
+		Items:               []CreateOrderItemInput{{ProductID: productID, Quantity: 1}},
+	}
+
+	const attempts = 8
+	var mu sync.Mutex
+	var placed []*Order
+	var limited, failed int
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			o, err := r.Create(ctx, userID, order)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				placed = append(placed, o)
+			case errors.Is(err, ErrPurchaseLimitExceeded):
+				limited++
+			default:
+				failed++
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if failed != 0 || len(placed) != 2 || limited != attempts-2 {
+		t.Fatalf("placed/limited/failed = %d/%d/%d, want 2/%d/0", len(placed), limited, failed, attempts-2)
+	}
+
+	if _, err := r.CancelForUser(ctx, placed[0].ID, userID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if _, err := r.Create(ctx, userID, order); err != nil {
+		t.Fatalf("order after cancelling one: %v", err)
+	}
+	if _, err := r.Create(ctx, userID, order); !errors.Is(err, ErrPurchaseLimitExceeded) {
+		t.Fatalf("third active order: err = %v, want ErrPurchaseLimitExceeded", err)
+	}
+}
@@ -2,12 +2,17 @@ package repo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
 )
 
 type Postgres struct {
@@ -33,10 +38,14 @@ func (r *Postgres) Create(ctx context.Context, userID uuid.UUID, input CreateOrd
 	defer func() { _ = tx.Rollback(ctx) }()
 
 	type productSnapshot struct {
-		id    uuid.UUID
-		name  string
-		sku   string
-		price float64
+		id           uuid.UUID
+		name         string
+		sku          string
+		price        float64
+		parentID     *uuid.UUID
+		optionValues map[string]string
+		hasVariants  bool
+		category     string
 	}
 
 	uniqueProductIDs := make([]uuid.UUID, 0, len(input.Items))
@@ -53,20 +62,29 @@ func (r *Postgres) Create(ctx context.Context, userID uuid.UUID, input CreateOrd
 	}
 
 	productSnapshots := make(map[uuid.UUID]productSnapshot, len(uniqueProductIDs))
+	// A variant is only orderable while its parent is active too. The price
+	// comes from the price history rather than products.price, which the
+	// price scheduler may not have caught up with yet.
 	rows, err := tx.Query(ctx, `
-		SELECT id, name, sku, price::float8
-		FROM products
-		WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL AND is_active = true
+		SELECT p.id, p.name, p.sku, COALESCE(product_effective_price(p.id, NOW()), p.price)::float8, p.parent_id, p.option_values,
+		       EXISTS (SELECT 1 FROM products v WHERE v.parent_id = p.id AND v.deleted_at IS NULL),
+		       COALESCE(p.category, parent.category, '')
+		FROM products p
+		LEFT JOIN products parent ON parent.id = p.parent_id
+		WHERE p.id = ANY($1::uuid[]) AND p.deleted_at IS NULL AND p.is_active = true
+		  AND (p.parent_id IS NULL OR (parent.deleted_at IS NULL AND parent.is_active = true))
 	`, uniqueProductIDs)
 	if err != nil {
 		return nil, err
 	}
 	for rows.Next() {
 		var s productSnapshot
-		if err := rows.Scan(&s.id, &s.name, &s.sku, &s.price); err != nil {
+		var rawOptionValues json.RawMessage
+		if err := rows.Scan(&s.id, &s.name, &s.sku, &s.price, &s.parentID, &rawOptionValues, &s.hasVariants, &s.category); err != nil {
 			rows.Close()
 			return nil, err
 		}
+		_ = json.Unmarshal(rawOptionValues, &s.optionValues)
 		productSnapshots[s.id] = s
 	}
 	if err := rows.Err(); err != nil {
@@ -78,15 +96,28 @@ func (r *Postgres) Create(ctx context.Context, userID uuid.UUID, input CreateOrd
 	if len(productSnapshots) != len(uniqueProductIDs) {
 		return nil, pgx.ErrNoRows
 	}
+	for _, s := range productSnapshots {
+		if s.hasVariants {
+			return nil, ErrVariantRequired
+		}
+	}
 
 	subtotal := 0.0
-
+	lines := make([]limitLine, 0, len(input.Items))
 	for _, item := range input.Items {
 		s, ok := productSnapshots[item.ProductID]
 		if !ok {
 			return nil, pgx.ErrNoRows
 		}
 		subtotal += s.price * float64(item.Quantity)
+		lines = append(lines, limitLine{productID: s.id, parentID: s.parentID, name: s.name, category: s.category, quantity: item.Quantity})
+	}
+	if err := checkPurchaseLimits(ctx, tx, userID, lines); err != nil {
+		return nil, err
+	}
+	raffleEntryIDs, err := checkRaffles(ctx, tx, userID, lines)
+	if err != nil {
+		return nil, err
 	}
 
 	tax := 0.0
@@ -95,10 +126,12 @@ func (r *Postgres) Create(ctx context.Context, userID uuid.UUID, input CreateOrd
 
 	var order Order
 	row := tx.QueryRow(ctx, `
-		INSERT INTO orders (user_id, status, subtotal, tax, total, currency, customer_notes, shipping_address_text)
-		VALUES ($1, 'payment_required', $2, $3, $4, $5, $6, $7)
-		RETURNING id, user_id, status, subtotal::float8, tax::float8, total::float8, currency, COALESCE(customer_notes, ''), shipping_address_text, created_at, updated_at
-	`, userID, subtotal, tax, total, currency, input.CustomerNotes, input.ShippingAddressText)
+		INSERT INTO orders (user_id, status, subtotal, tax, total, currency, customer_notes, shipping_address_text,
+		                    shipping_country, shipping_region)
+		VALUES ($1, 'payment_required', $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''))
+		RETURNING id, user_id, status, subtotal::float8, tax::float8, total::float8, currency, COALESCE(customer_notes, ''), shipping_address_text, COALESCE(shipping_country, ''), COALESCE(shipping_region, ''), created_at, updated_at
+	`, userID, subtotal, tax, total, currency, input.CustomerNotes, input.ShippingAddressText,
+		strings.ToUpper(strings.TrimSpace(input.ShippingCountry)), strings.TrimSpace(input.ShippingRegion))
 
 	if err := row.Scan(
 		&order.ID,
@@ -110,11 +143,16 @@ func (r *Postgres) Create(ctx context.Context, userID uuid.UUID, input CreateOrd
 		&order.Currency,
 		&order.CustomerNotes,
 		&order.ShippingAddressText,
+		&order.ShippingCountry,
+		&order.ShippingRegion,
 		&order.CreatedAt,
 		&order.UpdatedAt,
 	); err != nil {
 		return nil, err
 	}
+	if err := claimRaffleEntries(ctx, tx, order.ID, raffleEntryIDs); err != nil {
+		return nil, err
+	}
 
 	order.Items = make([]OrderItem, 0, len(input.Items))
 	for _, item := range input.Items {
@@ -122,12 +160,20 @@ func (r *Postgres) Create(ctx context.Context, userID uuid.UUID, input CreateOrd
 		unitPrice := s.price
 		totalPrice := unitPrice * float64(item.Quantity)
 
+		// The variant is snapshotted so the line stays readable if the
+		// product's options change later.
+		meta := itemMetadata{ParentProductID: s.parentID, OptionValues: s.optionValues}
+		rawMeta, err := json.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+
 		var oi OrderItem
 		row := tx.QueryRow(ctx, `
-			INSERT INTO order_items (order_id, product_id, product_name, product_sku, quantity, unit_price, total_price)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			INSERT INTO order_items (order_id, product_id, product_name, product_sku, quantity, unit_price, total_price, metadata)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			RETURNING id, order_id, product_id, product_name, product_sku, quantity, unit_price::float8, total_price::float8, created_at
-		`, order.ID, s.id, s.name, s.sku, item.Quantity, unitPrice, totalPrice)
+		`, order.ID, s.id, s.name, s.sku, item.Quantity, unitPrice, totalPrice, rawMeta)
 
 		if err := row.Scan(
 			&oi.ID,
@@ -142,9 +188,15 @@ func (r *Postgres) Create(ctx context.Context, userID uuid.UUID, input CreateOrd
 		); err != nil {
 			return nil, err
 		}
+		oi.ParentProductID = meta.ParentProductID
+		oi.OptionValues = meta.OptionValues
 		order.Items = append(order.Items, oi)
 	}
 
+	if order.Tender, err = holdTender(ctx, tx, userID, order.ID, currency, total, input.GiftCardCodes, input.UseStoreCredit); err != nil {
+		return nil, err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return nil, err
 	}
@@ -154,7 +206,7 @@ func (r *Postgres) Create(ctx context.Context, userID uuid.UUID, input CreateOrd
 
 func (r *Postgres) GetByIDForUser(ctx context.Context, orderID, userID uuid.UUID) (*Order, error) {
 	row := r.pool.QueryRow(ctx, `
-		SELECT id, user_id, status, subtotal::float8, tax::float8, total::float8, currency, COALESCE(customer_notes, ''), shipping_address_text, created_at, updated_at
+		SELECT id, user_id, status, subtotal::float8, tax::float8, total::float8, currency, COALESCE(customer_notes, ''), shipping_address_text, COALESCE(shipping_country, ''), COALESCE(shipping_region, ''), created_at, updated_at
 		FROM orders
 		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
 	`, orderID, userID)
@@ -170,6 +222,8 @@ func (r *Postgres) GetByIDForUser(ctx context.Context, orderID, userID uuid.UUID
 		&order.Currency,
 		&order.CustomerNotes,
 		&order.ShippingAddressText,
+		&order.ShippingCountry,
+		&order.ShippingRegion,
 		&order.CreatedAt,
 		&order.UpdatedAt,
 	); err != nil {
@@ -177,7 +231,7 @@ func (r *Postgres) GetByIDForUser(ctx context.Context, orderID, userID uuid.UUID
 	}
 
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, order_id, product_id, product_name, product_sku, quantity, unit_price::float8, total_price::float8, created_at
+		SELECT id, order_id, product_id, product_name, product_sku, quantity, unit_price::float8, total_price::float8, metadata, created_at
 		FROM order_items
 		WHERE order_id = $1
 		ORDER BY created_at ASC
@@ -190,6 +244,7 @@ func (r *Postgres) GetByIDForUser(ctx context.Context, orderID, userID uuid.UUID
 	items := make([]OrderItem, 0)
 	for rows.Next() {
 		var oi OrderItem
+		var rawMeta json.RawMessage
 		if err := rows.Scan(
 			&oi.ID,
 			&oi.OrderID,
@@ -199,10 +254,15 @@ func (r *Postgres) GetByIDForUser(ctx context.Context, orderID, userID uuid.UUID
 			&oi.Quantity,
 			&oi.UnitPrice,
 			&oi.TotalPrice,
+			&rawMeta,
 			&oi.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
+		var meta itemMetadata
+		_ = json.Unmarshal(rawMeta, &meta)
+		oi.ParentProductID = meta.ParentProductID
+		oi.OptionValues = meta.OptionValues
 		items = append(items, oi)
 	}
 	if err := rows.Err(); err != nil {
@@ -210,5 +270,93 @@ func (r *Postgres) GetByIDForUser(ctx context.Context, orderID, userID uuid.UUID
 	}
 	order.Items = items
 
+	if order.Tender, err = r.loadTender(ctx, &order); err != nil {
+		return nil, err
+	}
+
 	return &order, nil
 }
+
+func (r *Postgres) ListForUser(ctx context.Context, userID uuid.UUID, page pagination.Params) ([]Order, pagination.Page, error) {
+	keyset, orderBy := page.Where("created_at", "id", 2)
+	args := append([]any{userID}, page.Args()...)
+	args = append(args, page.Fetch())
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, user_id, status, subtotal::float8, tax::float8, total::float8, currency, COALESCE(customer_notes, ''), shipping_address_text, COALESCE(shipping_country, ''), COALESCE(shipping_region, ''), created_at, updated_at
+		FROM orders
+		WHERE user_id = $1 AND deleted_at IS NULL AND `+keyset+`
+		ORDER BY `+orderBy+`
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, pagination.Page{}, err
+	}
+	defer rows.Close()
+
+	orders := make([]Order, 0, page.Fetch())
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(
+			&o.ID,
+			&o.UserID,
+			&o.Status,
+			&o.Subtotal,
+			&o.Tax,
+			&o.Total,
+			&o.Currency,
+			&o.CustomerNotes,
+			&o.ShippingAddressText,
+			&o.ShippingCountry,
+			&o.ShippingRegion,
+			&o.CreatedAt,
+			&o.UpdatedAt,
+		); err != nil {
+			return nil, pagination.Page{}, err
+		}
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pagination.Page{}, err
+	}
+	rows.Close()
+
+	orders, p := pagination.Finish(page, orders, func(o Order) (time.Time, uuid.UUID) {
+		return o.CreatedAt, o.ID
+	})
+	if page.IncludeTotal {
+		var total int
+		if err := r.pool.QueryRow(ctx, `
+			SELECT count(*) FROM orders WHERE user_id = $1 AND deleted_at IS NULL
+		`, userID).Scan(&total); err != nil {
+			return nil, pagination.Page{}, err
+		}
+		p.Total = &total
+	}
+	return orders, p, nil
+}
+
+// itemMetadata is the order_items.metadata snapshot.
+type itemMetadata struct {
+	ParentProductID *uuid.UUID        `json:"parent_product_id,omitempty"`
+	OptionValues    map[string]string `json:"option_values,omitempty"`
+}
+
+func (r *Postgres) CancelForUser(ctx context.Context, orderID, userID uuid.UUID) (*Order, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE orders
+		SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		  AND status IN ('payment_required', 'preordered', 'backordered')
+	`, orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	order, err := r.GetByIDForUser(ctx, orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotCancellable
+	}
+	return order, nil
+}
@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrRaffleWinnersOnly is wrapped with a message naming the raffled
+// product an order may not include.
+var ErrRaffleWinnersOnly = errors.New("product is sold by raffle")
+
+// checkRaffles fails with ErrRaffleWinnersOnly if the lines include a
+// product with a live raffle that the user has no open offer for, or more
+// than the one unit an offer is for. It returns the entries holding the
+// offers, for claimRaffleEntries once the order exists. It must run in the
+// transaction that creates the order; concurrent orders of the same user
+// wait for each other here, so an offer is ordered once.
+func checkRaffles(ctx context.Context, tx pgx.Tx, userID uuid.UUID, lines []limitLine) ([]uuid.UUID, error) {
+	want := make(map[uuid.UUID]int, len(lines))
+	names := make(map[uuid.UUID]string, len(lines))
+	productIDs := make([]uuid.UUID, 0, len(lines))
+	for _, line := range lines {
+		if _, ok := want[line.productID]; !ok {
+			productIDs = append(productIDs, line.productID)
+			names[line.productID] = line.name
+		}
+		want[line.productID] += line.quantity
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT r.product_id, r.id FROM raffles r
+		WHERE r.product_id = ANY($1::uuid[]) AND r.status IN ('scheduled', 'drawn')
+	`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	type live struct{ productID, raffleID uuid.UUID }
+	raffles, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (live, error) {
+		var l live
+		err := row.Scan(&l.productID, &l.raffleID)
+		return l, err
+	})
+	if err != nil || len(raffles) == 0 {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended('raffles:' || $1::text, 0))`, userID.String()); err != nil {
+		return nil, err
+	}
+
+	entryIDs := make([]uuid.UUID, 0, len(raffles))
+	for _, l := range raffles {
+		name := names[l.productID]
+		var entryID uuid.UUID
+		var status string
+		var claimExpiresAt *time.Time
+		err := tx.QueryRow(ctx, `
+			SELECT id, status, claim_expires_at
+			FROM raffle_entries
+			WHERE raffle_id = $1 AND user_id = $2
+			FOR UPDATE
+		`, l.raffleID, userID).Scan(&entryID, &status, &claimExpiresAt)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		switch {
+		case status == "claimed" || status == "purchased":
+			return nil, fmt.Errorf("%w: you have already ordered your %s", ErrRaffleWinnersOnly, name)
+		case status != "won" || claimExpiresAt == nil || !claimExpiresAt.After(time.Now()):
+			return nil, fmt.Errorf("%w: %s can only be ordered by raffle winners during their claim window", ErrRaffleWinnersOnly, name)
+		case want[l.productID] != 1:
+			return nil, fmt.Errorf("%w: raffle winners may order one %s", ErrRaffleWinnersOnly, name)
+		}
+		entryIDs = append(entryIDs, entryID)
+	}
+	return entryIDs, nil
+}
+
+// claimRaffleEntries turns the offers checkRaffles found into claims by
+// the order, in the transaction that creates it, so the raffle job can no
+// longer forfeit them. inventory-service hands the held units to the order
+// when it reserves it.
+func claimRaffleEntries(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, entryIDs []uuid.UUID) error {
+	if len(entryIDs) == 0 {
+		return nil
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE raffle_entries
+		SET status = 'claimed', order_id = $1, updated_at = NOW()
+		WHERE id = ANY($2::uuid[]) AND status = 'won'
+	`, orderID, entryIDs)
+	if err != nil {
+		return err
+	}
+	if int(tag.RowsAffected()) != len(entryIDs) {
+		return fmt.Errorf("%w: the raffle offer is no longer open", ErrRaffleWinnersOnly)
+	}
+	return nil
+}
@@ -2,9 +2,12 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
 )
 
 type Order struct {
@@ -17,9 +20,13 @@ type Order struct {
 	Currency            string      `json:"currency"`
 	CustomerNotes       string      `json:"customer_notes,omitempty"`
 	ShippingAddressText string      `json:"shipping_address_text,omitempty"`
+	ShippingCountry     string      `json:"shipping_country,omitempty"`
+	ShippingRegion      string      `json:"shipping_region,omitempty"`
 	Items               []OrderItem `json:"items,omitempty"`
-	CreatedAt           time.Time   `json:"created_at"`
-	UpdatedAt           time.Time   `json:"updated_at"`
+	// Tender is the gift card and store credit paying part of the order.
+	Tender    *Tender   `json:"tender,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type OrderItem struct {
@@ -31,7 +38,10 @@ type OrderItem struct {
 	Quantity    int       `json:"quantity"`
 	UnitPrice   float64   `json:"unit_price"`
 	TotalPrice  float64   `json:"total_price"`
-	CreatedAt   time.Time `json:"created_at"`
+	// ParentProductID and OptionValues identify the variant that was ordered.
+	ParentProductID *uuid.UUID        `json:"parent_product_id,omitempty"`
+	OptionValues    map[string]string `json:"option_values,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
 }
 
 type CreateOrderItemInput struct {
@@ -40,12 +50,51 @@ type CreateOrderItemInput struct {
 }
 
 type CreateOrderInput struct {
-	CustomerNotes       string                 `json:"customer_notes,omitempty"`
-	ShippingAddressText string                 `json:"shipping_address_text"`
-	Items               []CreateOrderItemInput `json:"items"`
+	CustomerNotes       string `json:"customer_notes,omitempty"`
+	ShippingAddressText string `json:"shipping_address_text"`
+	// ShippingCountry (ISO 3166-1 alpha-2) and ShippingRegion (state or
+	// province) are optional; inventory uses them to ship from the nearest
+	// warehouse.
+	ShippingCountry string                 `json:"shipping_country,omitempty"`
+	ShippingRegion  string                 `json:"shipping_region,omitempty"`
+	Items           []CreateOrderItemInput `json:"items"`
+	// GiftCardCodes and UseStoreCredit pay part or all of the order: gift
+	// cards are applied in the order given, then store credit, and the
+	// provider is charged the rest.
+	GiftCardCodes  []string `json:"gift_card_codes,omitempty"`
+	UseStoreCredit bool     `json:"use_store_credit,omitempty"`
 }
 
+// Order statuses. Orders with preorder or backorder lines wait in
+// preordered or backordered until inventory-service has allocated stock to
+// every line, and then continue to payment_required.
+const (
+	StatusPaymentRequired = "payment_required"
+	StatusPreordered      = "preordered"
+	StatusBackordered     = "backordered"
+	StatusCancelled       = "cancelled"
+)
+
+// ErrNotCancellable is returned when an order is past the point where the
+// customer may cancel it.
+var ErrNotCancellable = errors.New("order can no longer be cancelled")
+
+// ErrVariantRequired is returned when an order names a parent product that
+// has variants instead of one of the variants.
+var ErrVariantRequired = errors.New("product has variants; order a variant instead")
+
 type Repository interface {
 	Create(ctx context.Context, userID uuid.UUID, input CreateOrderInput) (*Order, error)
 	GetByIDForUser(ctx context.Context, orderID, userID uuid.UUID) (*Order, error)
+	// ListForUser returns a page of the user's orders, newest first, without
+	// their items.
+	ListForUser(ctx context.Context, userID uuid.UUID, page pagination.Params) ([]Order, pagination.Page, error)
+	// CancelForUser cancels an order that has not been paid yet.
+	CancelForUser(ctx context.Context, orderID, userID uuid.UUID) (*Order, error)
+
+	ListPurchaseLimits(ctx context.Context) ([]PurchaseLimit, error)
+	GetPurchaseLimit(ctx context.Context, id uuid.UUID) (*PurchaseLimit, error)
+	CreatePurchaseLimit(ctx context.Context, in CreatePurchaseLimitInput) (*PurchaseLimit, error)
+	UpdatePurchaseLimit(ctx context.Context, id uuid.UUID, in UpdatePurchaseLimitInput) (*PurchaseLimit, error)
+	DeletePurchaseLimit(ctx context.Context, id uuid.UUID) error
 }
@@ -0,0 +1,216 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/giftcode"
+	"github.com/kalen1o/iphone-storage/shared/ledger"
+)
+
+// Tender methods besides the provider charge.
+const (
+	TenderGiftCard    = "gift_card"
+	TenderStoreCredit = "store_credit"
+)
+
+// ErrInvalidGiftCard is wrapped with a message saying why a gift card
+// cannot pay for the order.
+var ErrInvalidGiftCard = errors.New("gift card cannot be used")
+
+// Tender is how an order is paid besides the provider: gift card and store
+// credit set aside when the order was placed. payment-service charges the
+// provider for AmountDue and takes the lines when the order is paid; they
+// are given back if it is cancelled.
+type Tender struct {
+	Lines     []TenderLine `json:"lines"`
+	AmountDue float64      `json:"amount_due"`
+}
+
+type TenderLine struct {
+	Method string `json:"method"`
+	// GiftCardLast4 tells the order's gift cards apart.
+	GiftCardLast4 string  `json:"gift_card_last4,omitempty"`
+	Amount        float64 `json:"amount"`
+}
+
+type tenderSource struct {
+	accountID uuid.UUID
+	line      TenderLine
+	balance   int64
+	amount    int64
+}
+
+// holdTender sets gift cards, in the order given, and then the user's store
+// credit aside for the order, up to its total, in a 'hold' ledger
+// transaction. It must run in the transaction that creates the order; the
+// source accounts stay locked until it commits.
+func holdTender(ctx context.Context, tx pgx.Tx, userID, orderID uuid.UUID, currency string, total float64, codes []string, useStoreCredit bool) (*Tender, error) {
+	if len(codes) == 0 && !useStoreCredit {
+		return nil, nil
+	}
+
+	sources, err := lockGiftCards(ctx, tx, currency, codes)
+	if err != nil {
+		return nil, err
+	}
+	if useStoreCredit {
+		var s tenderSource
+		err := tx.QueryRow(ctx, `
+			SELECT id, balance_cents FROM ledger_accounts
+			WHERE kind = 'store_credit' AND user_id = $1 AND currency = $2
+			FOR UPDATE
+		`, userID, currency).Scan(&s.accountID, &s.balance)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+		case err != nil:
+			return nil, err
+		default:
+			s.line.Method = TenderStoreCredit
+			sources = append(sources, s)
+		}
+	}
+
+	remaining := ledger.ToCents(total)
+	held := make([]tenderSource, 0, len(sources))
+	for _, s := range sources {
+		amount := min(s.balance, remaining)
+		if amount <= 0 {
+			continue
+		}
+		s.amount = amount
+		s.line.Amount = ledger.FromCents(amount)
+		held = append(held, s)
+		remaining -= amount
+	}
+	if len(held) == 0 {
+		return nil, nil
+	}
+
+	heldAccountID, err := ledger.SystemAccount(ctx, tx, "held", currency)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tender{Lines: make([]TenderLine, 0, len(held)), AmountDue: ledger.FromCents(remaining)}
+	postings := make([]ledger.Posting, 0, len(held)+1)
+	var sum int64
+	for _, s := range held {
+		postings = append(postings, ledger.Posting{AccountID: s.accountID, Cents: -s.amount})
+		sum += s.amount
+		t.Lines = append(t.Lines, s.line)
+	}
+	postings = append(postings, ledger.Posting{AccountID: heldAccountID, Cents: sum})
+	if _, err := ledger.Post(ctx, tx, ledger.Transaction{Kind: "hold", OrderID: &orderID}, postings...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// lockGiftCards locks the accounts of the gift cards with the given codes,
+// in account order so concurrent orders cannot deadlock, and returns them
+// in the order the codes were given. Every card must be usable.
+func lockGiftCards(ctx context.Context, tx pgx.Tx, currency string, codes []string) ([]tenderSource, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	hashes := make([]string, 0, len(codes))
+	seen := make(map[string]struct{}, len(codes))
+	for _, code := range codes {
+		h := giftcode.Hash(code)
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		seen[h] = struct{}{}
+		hashes = append(hashes, h)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT g.code_hash, g.last4, g.status, g.currency, g.expires_at, a.id, a.balance_cents
+		FROM gift_cards g
+		JOIN ledger_accounts a ON a.id = g.account_id
+		WHERE g.code_hash = ANY($1::text[])
+		ORDER BY a.id
+		FOR UPDATE OF a
+	`, hashes)
+	if err != nil {
+		return nil, err
+	}
+	type card struct {
+		last4, status, currency string
+		expiresAt               *time.Time
+		source                  tenderSource
+	}
+	byHash := make(map[string]card, len(hashes))
+	for rows.Next() {
+		var hash string
+		var c card
+		if err := rows.Scan(&hash, &c.last4, &c.status, &c.currency, &c.expiresAt, &c.source.accountID, &c.source.balance); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byHash[hash] = c
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sources := make([]tenderSource, 0, len(hashes))
+	for _, h := range hashes {
+		c, ok := byHash[h]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%w: gift card not found", ErrInvalidGiftCard)
+		case c.status != "active":
+			return nil, fmt.Errorf("%w: gift card ending %s is %s", ErrInvalidGiftCard, c.last4, c.status)
+		case c.expiresAt != nil && !c.expiresAt.After(time.Now()):
+			return nil, fmt.Errorf("%w: gift card ending %s has expired", ErrInvalidGiftCard, c.last4)
+		case c.currency != currency:
+			return nil, fmt.Errorf("%w: gift card ending %s is in %s", ErrInvalidGiftCard, c.last4, c.currency)
+		case c.source.balance <= 0:
+			return nil, fmt.Errorf("%w: gift card ending %s has no balance", ErrInvalidGiftCard, c.last4)
+		}
+		c.source.line = TenderLine{Method: TenderGiftCard, GiftCardLast4: c.last4}
+		sources = append(sources, c.source)
+	}
+	return sources, nil
+}
+
+// loadTender returns the gift card and store credit still set aside for,
+// or taken by, the order, or nil if it has none.
+func (r *Postgres) loadTender(ctx context.Context, order *Order) (*Tender, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT a.kind, COALESCE(g.last4, ''), -SUM(e.amount_cents)
+		FROM ledger_transactions t
+		JOIN ledger_entries e ON e.transaction_id = t.id
+		JOIN ledger_accounts a ON a.id = e.account_id
+		LEFT JOIN gift_cards g ON g.account_id = a.id
+		WHERE t.order_id = $1 AND t.kind IN ('hold', 'release') AND a.kind IN ('gift_card', 'store_credit')
+		GROUP BY a.id, a.kind, g.last4
+		HAVING SUM(e.amount_cents) <> 0
+		ORDER BY MIN(e.created_at), a.kind, g.last4
+	`, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TenderLine, error) {
+		var l TenderLine
+		var cents int64
+		err := row.Scan(&l.Method, &l.GiftCardLast4, &cents)
+		l.Amount = ledger.FromCents(cents)
+		return l, err
+	})
+	if err != nil || len(lines) == 0 {
+		return nil, err
+	}
+	due := ledger.ToCents(order.Total)
+	for _, l := range lines {
+		due -= ledger.ToCents(l.Amount)
+	}
+	return &Tender{Lines: lines, AmountDue: ledger.FromCents(due)}, nil
+}
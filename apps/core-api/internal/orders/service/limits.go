@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+// ErrInvalidPurchaseLimit is wrapped with a message naming the offending
+// field.
+var ErrInvalidPurchaseLimit = errors.New("invalid purchase limit")
+
+func (s *Service) ListPurchaseLimits(ctx context.Context) ([]repo.PurchaseLimit, error) {
+	return s.repo.ListPurchaseLimits(ctx)
+}
+
+func (s *Service) CreatePurchaseLimit(ctx context.Context, in repo.CreatePurchaseLimitInput) (*repo.PurchaseLimit, error) {
+	in.Category = strings.TrimSpace(in.Category)
+	if (in.ProductID == nil) == (in.Category == "") {
+		return nil, invalidPurchaseLimit("exactly one of product_id and category is required")
+	}
+	if len(in.Category) > 100 {
+		return nil, invalidPurchaseLimit("category must be at most 100 characters")
+	}
+	if in.MaxQuantity <= 0 {
+		return nil, invalidPurchaseLimit("max_quantity must be > 0")
+	}
+	if in.WindowSeconds != nil && *in.WindowSeconds <= 0 {
+		return nil, invalidPurchaseLimit("window_seconds must be > 0")
+	}
+	if in.StartsAt != nil && in.EndsAt != nil && !in.EndsAt.After(*in.StartsAt) {
+		return nil, invalidPurchaseLimit("ends_at must be after starts_at")
+	}
+
+	l, err := s.repo.CreatePurchaseLimit(ctx, in)
+	if util.IsForeignKeyViolation(err) {
+		return nil, invalidPurchaseLimit("product not found")
+	}
+	return l, err
+}
+
+func (s *Service) UpdatePurchaseLimit(ctx context.Context, id uuid.UUID, in repo.UpdatePurchaseLimitInput) (*repo.PurchaseLimit, error) {
+	if in.MaxQuantity != nil && *in.MaxQuantity <= 0 {
+		return nil, invalidPurchaseLimit("max_quantity must be > 0")
+	}
+	if in.WindowSeconds != nil && *in.WindowSeconds <= 0 {
+		return nil, invalidPurchaseLimit("window_seconds must be > 0")
+	}
+
+	// The bounds left as they are come from the stored limit.
+	cur, err := s.repo.GetPurchaseLimit(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	startsAt, endsAt := patchTime(cur.StartsAt, in.StartsAt, in.ClearStartsAt), patchTime(cur.EndsAt, in.EndsAt, in.ClearEndsAt)
+	if startsAt != nil && endsAt != nil && !endsAt.After(*startsAt) {
+		return nil, invalidPurchaseLimit("ends_at must be after starts_at")
+	}
+
+	l, err := s.repo.UpdatePurchaseLimit(ctx, id, in)
+	if util.IsCheckViolation(err) {
+		// Another update moved the other bound since it was read.
+		return nil, invalidPurchaseLimit("ends_at must be after starts_at")
+	}
+	return l, err
+}
+
+// patchTime returns the value a time field has after an update.
+func patchTime(cur, set *time.Time, clear bool) *time.Time {
+	switch {
+	case clear:
+		return nil
+	case set != nil:
+		return set
+	default:
+		return cur
+	}
+}
+
+func (s *Service) DeletePurchaseLimit(ctx context.Context, id uuid.UUID) error {
+	return s.repo.DeletePurchaseLimit(ctx, id)
+}
+
+func invalidPurchaseLimit(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPurchaseLimit, msg)
+}
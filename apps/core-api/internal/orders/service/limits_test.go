@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/repo"
+)
+
+// limitRepo holds one stored limit and records updates; anything else
+// panics.
+type limitRepo struct {
+	repo.Repository
+	stored  repo.PurchaseLimit
+	updates []repo.UpdatePurchaseLimitInput
+}
+
+func (f *limitRepo) GetPurchaseLimit(context.Context, uuid.UUID) (*repo.PurchaseLimit, error) {
+	l := f.stored
+	return &l, nil
+}
+
+func (f *limitRepo) UpdatePurchaseLimit(_ context.Context, _ uuid.UUID, in repo.UpdatePurchaseLimitInput) (*repo.PurchaseLimit, error) {
+	f.updates = append(f.updates, in)
+	l := f.stored
+	return &l, nil
+}
+
+func TestUpdatePurchaseLimit_ChecksBoundsAgainstStoredLimit(t *testing.T) {
+	launch := time.Date(2026, 9, 19, 8, 0, 0, 0, time.UTC)
+	at := func(h int) *time.Time { v := launch.Add(time.Duration(h) * time.Hour); return &v }
+	zero, one := 0, 1
+
+	for name, tc := range map[string]struct {
+		stored  repo.PurchaseLimit
+		in      repo.UpdatePurchaseLimitInput
+		wantErr bool
+	}{
+		"ends before the stored start":   {stored: repo.PurchaseLimit{StartsAt: at(0)}, in: repo.UpdatePurchaseLimitInput{EndsAt: at(-1)}, wantErr: true},
+		"starts after the stored end":    {stored: repo.PurchaseLimit{EndsAt: at(24)}, in: repo.UpdatePurchaseLimitInput{StartsAt: at(48)}, wantErr: true},
+		"both bounds replaced":           {stored: repo.PurchaseLimit{StartsAt: at(0), EndsAt: at(24)}, in: repo.UpdatePurchaseLimitInput{StartsAt: at(48), EndsAt: at(72)}},
+		"start cleared, earlier end":     {stored: repo.PurchaseLimit{StartsAt: at(0)}, in: repo.UpdatePurchaseLimitInput{ClearStartsAt: true, EndsAt: at(-1)}},
+		"end cleared":                    {stored: repo.PurchaseLimit{StartsAt: at(0), EndsAt: at(24)}, in: repo.UpdatePurchaseLimitInput{ClearEndsAt: true}},
+		"window cleared":                 {stored: repo.PurchaseLimit{WindowSeconds: &one}, in: repo.UpdatePurchaseLimitInput{ClearWindowSeconds: true}},
+		"zero window":                    {in: repo.UpdatePurchaseLimitInput{WindowSeconds: &zero}, wantErr: true},
+		"zero max quantity":              {in: repo.UpdatePurchaseLimitInput{MaxQuantity: &zero}, wantErr: true},
+		"unrelated change, stored range": {stored: repo.PurchaseLimit{StartsAt: at(0), EndsAt: at(24)}, in: repo.UpdatePurchaseLimitInput{MaxQuantity: &one}},
+	} {
+		fake := &limitRepo{stored: tc.stored}
+		_, err := New(fake, nil).UpdatePurchaseLimit(context.Background(), uuid.New(), tc.in)
+		if tc.wantErr {
+			if !errors.Is(err, ErrInvalidPurchaseLimit) {
+				t.Errorf("%s: err = %v, want ErrInvalidPurchaseLimit", name, err)
+			}
+			if len(fake.updates) != 0 {
+				t.Errorf("%s: limit was updated", name)
+			}
+			continue
+		}
+		if err != nil || len(fake.updates) != 1 {
+			t.Errorf("%s: err = %v, updates = %d; want one update", name, err, len(fake.updates))
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/orders/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
 	"github.com/kalen1o/iphone-storage/shared/events"
 	"github.com/kalen1o/iphone-storage/shared/kafka"
 )
@@ -62,3 +63,29 @@ func (s *Service) Create(ctx context.Context, userID uuid.UUID, input repo.Creat
 func (s *Service) GetByIDForUser(ctx context.Context, orderID, userID uuid.UUID) (*repo.Order, error) {
 	return s.repo.GetByIDForUser(ctx, orderID, userID)
 }
+
+// Cancel cancels an unpaid order, including one waiting for preorder or
+// backorder stock; inventory-service releases what it holds.
+func (s *Service) Cancel(ctx context.Context, orderID, userID uuid.UUID) (*repo.Order, error) {
+	order, err := s.repo.CancelForUser(ctx, orderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if s.producer != nil {
+		b, err := events.Marshal(events.Envelope[events.OrdersCancelledData]{
+			EventID:     uuid.NewString(),
+			Type:        events.TypeOrdersCancelled,
+			OccurredAt:  time.Now().UTC(),
+			AggregateID: order.ID.String(),
+			Data:        events.OrdersCancelledData{OrderID: order.ID.String(), Reason: "cancelled_by_customer"},
+		})
+		if err == nil {
+			_ = s.producer.Publish(ctx, events.TopicOrdersCancelled, []byte(order.ID.String()), b)
+		}
+	}
+	return order, nil
+}
+
+func (s *Service) ListForUser(ctx context.Context, userID uuid.UUID, page pagination.Params) ([]repo.Order, pagination.Page, error) {
+	return s.repo.ListForUser(ctx, userID, page)
+}
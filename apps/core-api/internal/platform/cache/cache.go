@@ -0,0 +1,208 @@
+// Package cache is a Redis read-through cache for hot read endpoints.
+//
+// Entries are keyed by the generation of each namespace they depend on, so
+// invalidating a namespace is a single INCR: new reads miss and old entries
+// age out on their TTL. Misses are collapsed per key with singleflight, and
+// entries past their refresh point are served while one caller reloads them
+// in the background, so an expiring hot key never stampedes the database.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// Namespaces group entries that are invalidated together.
+const (
+	// NamespaceCatalog covers product data; bumped by products.updated.
+	NamespaceCatalog = "catalog"
+	// NamespaceStock covers anything derived from inventory counts; bumped
+	// by inventory events.
+	NamespaceStock = "stock"
+)
+
+const (
+	keyPrefix   = "cache:"
+	genPrefix   = "cache:gen:"
+	loadTimeout = 10 * time.Second
+)
+
+// Stats holds per-endpoint counters, published as the "cache" expvar:
+// <endpoint>.hit, .miss, .refresh and .error.
+var Stats = expvar.NewMap("cache")
+
+type Cache struct {
+	rdb *redis.Client
+	// refreshAhead is the fraction of the TTL, at the end of an entry's life,
+	// during which a read triggers a background refresh.
+	refreshAhead float64
+	group        singleflight.Group
+	refreshing   sync.Map
+	now          func() time.Time
+}
+
+func New(rdb *redis.Client, refreshAhead float64) *Cache {
+	if refreshAhead < 0 || refreshAhead >= 1 {
+		refreshAhead = 0.2
+	}
+	return &Cache{rdb: rdb, refreshAhead: refreshAhead, now: time.Now}
+}
+
+// Spec identifies a cached value.
+type Spec struct {
+	// Endpoint names the metrics bucket and key space, e.g. "products.search".
+	Endpoint   string
+	Key        string
+	Namespaces []string
+	TTL        time.Duration
+}
+
+type entry struct {
+	Value     json.RawMessage `json:"v"`
+	RefreshAt int64           `json:"r"`
+}
+
+// Get returns the cached value for spec or loads and stores it. Redis
+// failures are counted and fall through to load, so the cache never turns an
+// outage into errors. Load errors are returned and not cached. A nil Cache
+// always loads.
+func Get[T any](ctx context.Context, c *Cache, spec Spec, load func(context.Context) (T, error)) (T, error) {
+	if c == nil {
+		return load(ctx)
+	}
+
+	key, err := c.key(ctx, spec)
+	if err != nil {
+		Stats.Add(spec.Endpoint+".error", 1)
+		return load(ctx)
+	}
+
+	raw, err := c.rdb.Get(ctx, key).Bytes()
+	switch {
+	case err == nil:
+		var e entry
+		var v T
+		if json.Unmarshal(raw, &e) == nil && json.Unmarshal(e.Value, &v) == nil {
+			Stats.Add(spec.Endpoint+".hit", 1)
+			if c.now().UnixMilli() >= e.RefreshAt {
+				refresh(ctx, c, key, spec, load)
+			}
+			return v, nil
+		}
+		Stats.Add(spec.Endpoint+".error", 1)
+	case errors.Is(err, redis.Nil):
+	default:
+		Stats.Add(spec.Endpoint+".error", 1)
+		return load(ctx)
+	}
+
+	Stats.Add(spec.Endpoint+".miss", 1)
+	res, err, _ := c.group.Do(key, func() (any, error) {
+		// The load is shared by every waiter, so it must not die with the
+		// caller that happened to start it.
+		lctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		v, err := load(lctx)
+		if err != nil {
+			return v, err
+		}
+		c.store(lctx, key, spec, v)
+		return v, nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return res.(T), nil
+}
+
+// refresh reloads key in the background unless this instance is already
+// refreshing it. The stale value keeps being served meanwhile.
+func refresh[T any](ctx context.Context, c *Cache, key string, spec Spec, load func(context.Context) (T, error)) {
+	if _, running := c.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	Stats.Add(spec.Endpoint+".refresh", 1)
+	go func() {
+		defer c.refreshing.Delete(key)
+		lctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		v, err := load(lctx)
+		if err != nil {
+			Stats.Add(spec.Endpoint+".error", 1)
+			return
+		}
+		c.store(lctx, key, spec, v)
+	}()
+}
+
+// Invalidate bumps the generation of each namespace.
+func (c *Cache) Invalidate(ctx context.Context, namespaces ...string) error {
+	if c == nil || len(namespaces) == 0 {
+		return nil
+	}
+	pipe := c.rdb.Pipeline()
+	for _, ns := range namespaces {
+		pipe.Incr(ctx, genPrefix+ns)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *Cache) store(ctx context.Context, key string, spec Spec, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		Stats.Add(spec.Endpoint+".error", 1)
+		return
+	}
+	refreshAt := c.now().Add(time.Duration(float64(spec.TTL) * (1 - c.refreshAhead)))
+	e, _ := json.Marshal(entry{Value: b, RefreshAt: refreshAt.UnixMilli()})
+	if err := c.rdb.Set(ctx, key, e, spec.TTL).Err(); err != nil {
+		Stats.Add(spec.Endpoint+".error", 1)
+	}
+}
+
+// key resolves the namespace generations into the entry's Redis key.
+func (c *Cache) key(ctx context.Context, spec Spec) (string, error) {
+	var b strings.Builder
+	b.WriteString(keyPrefix)
+	b.WriteString(spec.Endpoint)
+	if len(spec.Namespaces) > 0 {
+		genKeys := make([]string, len(spec.Namespaces))
+		for i, ns := range spec.Namespaces {
+			genKeys[i] = genPrefix + ns
+		}
+		gens, err := c.rdb.MGet(ctx, genKeys...).Result()
+		if err != nil {
+			return "", err
+		}
+		for _, g := range gens {
+			b.WriteString(":g")
+			if s, ok := g.(string); ok {
+				b.WriteString(s)
+			} else {
+				b.WriteString("0")
+			}
+		}
+	}
+	b.WriteString(":")
+	b.WriteString(spec.Key)
+	return b.String(), nil
+}
+
+// HashKey derives a compact key from any JSON-encodable request description.
+func HashKey(v any) string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// newTestCache returns a Cache on the test Redis database, skipping the
+// test if Redis is unreachable, and a spec of its own so tests do not share
+// entries or generations.
+func newTestCache(t *testing.T) (context.Context, *Cache, Spec) {
+	t.Helper()
+
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	db, _ := strconv.Atoi(os.Getenv("TEST_REDIS_DB"))
+	if db == 0 {
+		db = 15
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	rdb := redis.NewClient(&redis.Options{Addr: addr, DB: db})
+	t.Cleanup(func() { _ = rdb.Close() })
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		t.Skipf("skipping integration test: cannot reach redis (%v)", err)
+	}
+
+	id := uuid.NewString()
+	spec := Spec{Endpoint: "test." + id, Key: "k", Namespaces: []string{"test-" + id}, TTL: time.Minute}
+	t.Cleanup(func() {
+		keys, _ := rdb.Keys(context.Background(), keyPrefix+"*"+id+"*").Result()
+		if len(keys) > 0 {
+			_ = rdb.Del(context.Background(), keys...).Err()
+		}
+	})
+	return ctx, New(rdb, 0.2), spec
+}
+
+type product struct {
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+}
+
+// loader counts its loads and returns the product with the load number as
+// its price.
+type loader struct{ calls atomic.Int32 }
+
+func (l *loader) load(context.Context) (product, error) {
+	return product{Name: "iPhone", Price: int(l.calls.Add(1))}, nil
+}
+
+func stat(spec Spec, name string) int64 {
+	if v, ok := Stats.Get(spec.Endpoint + "." + name).(interface{ Value() int64 }); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestGet_LoadsOnceAndServesHits(t *testing.T) {
+	ctx, c, spec := newTestCache(t)
+	var l loader
+
+	for i := 0; i < 3; i++ {
+		got, err := Get(ctx, c, spec, l.load)
+		if err != nil || got != (product{Name: "iPhone", Price: 1}) {
+			t.Fatalf("Get %d = %+v, %v; want the first load", i+1, got, err)
+		}
+	}
+	if n := l.calls.Load(); n != 1 {
+		t.Fatalf("loads = %d, want 1", n)
+	}
+	if hit, miss := stat(spec, "hit"), stat(spec, "miss"); hit != 2 || miss != 1 {
+		t.Fatalf("hit/miss = %d/%d, want 2/1", hit, miss)
+	}
+
+	other := spec
+	other.Key = "other"
+	if got, _ := Get(ctx, c, other, l.load); got.Price != 2 {
+		t.Fatalf("another key = %+v, want a load of its own", got)
+	}
+}
+
+func TestGet_DoesNotCacheLoadErrors(t *testing.T) {
+	ctx, c, spec := newTestCache(t)
+	failure := errors.New("database down")
+
+	if _, err := Get(ctx, c, spec, func(context.Context) (product, error) { return product{}, failure }); !errors.Is(err, failure) {
+		t.Fatalf("Get = %v, want the load error", err)
+	}
+	var l loader
+	if got, err := Get(ctx, c, spec, l.load); err != nil || got.Price != 1 {
+		t.Fatalf("Get after a failed load = %+v, %v; want a fresh load", got, err)
+	}
+}
+
+func TestGet_CollapsesConcurrentMisses(t *testing.T) {
+	ctx, c, spec := newTestCache(t)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (product, error) {
+		calls.Add(1)
+		<-release
+		return product{Name: "iPhone", Price: 1}, nil
+	}
+
+	var wg sync.WaitGroup
+	var errs atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := Get(ctx, c, spec, load); err != nil || got.Price != 1 {
+				errs.Add(1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := errs.Load(); n != 0 {
+		t.Fatalf("%d callers got no value", n)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("loads = %d, want 1 for 20 concurrent misses", n)
+	}
+}
+
+func TestGet_RefreshesAheadOfExpiry(t *testing.T) {
+	ctx, c, spec := newTestCache(t)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	refreshed := make(chan struct{})
+	load := func(context.Context) (product, error) {
+		n := calls.Add(1)
+		if n > 1 {
+			<-release
+			defer close(refreshed)
+		}
+		return product{Name: "iPhone", Price: int(n)}, nil
+	}
+
+	if _, err := Get(ctx, c, spec, load); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// Within the last fifth of the minute's TTL, reads get the stale value
+	// while a single background load replaces it.
+	start := time.Now()
+	c.now = func() time.Time { return start.Add(50 * time.Second) }
+	for i := 0; i < 3; i++ {
+		if got, err := Get(ctx, c, spec, load); err != nil || got.Price != 1 {
+			t.Fatalf("Get during refresh = %+v, %v; want the stale value", got, err)
+		}
+	}
+	close(release)
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no background refresh")
+	}
+	if n := stat(spec, "refresh"); n != 1 {
+		t.Fatalf("refreshes = %d, want 1", n)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := Get(ctx, c, spec, load)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got.Price == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get after refresh = %+v, want the refreshed value", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("loads = %d, want 2", n)
+	}
+}
+
+func TestInvalidate_BumpsOnlyItsNamespaces(t *testing.T) {
+	ctx, c, spec := newTestCache(t)
+	other := spec
+	other.Endpoint += ".other"
+	other.Namespaces = []string{spec.Namespaces[0] + "-other"}
+
+	var l, lo loader
+	_, _ = Get(ctx, c, spec, l.load)
+	_, _ = Get(ctx, c, other, lo.load)
+
+	if err := c.Invalidate(ctx, spec.Namespaces...); err != nil {
+		t.Fatalf("Invalidate: %v", err)
+	}
+	if got, _ := Get(ctx, c, spec, l.load); got.Price != 2 {
+		t.Fatalf("Get after invalidation = %+v, want a new load", got)
+	}
+	if got, _ := Get(ctx, c, other, lo.load); got.Price != 1 {
+		t.Fatalf("entry of another namespace = %+v, want it still cached", got)
+	}
+}
+
+func TestGet_FallsThroughWithoutRedis(t *testing.T) {
+	var l loader
+	if got, err := Get(context.Background(), nil, Spec{Endpoint: "test.nil"}, l.load); err != nil || got.Price != 1 {
+		t.Fatalf("Get on a nil cache = %+v, %v; want a load", got, err)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 50 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { _ = rdb.Close() })
+	c := New(rdb, 0.2)
+	spec := Spec{Endpoint: "test.down." + uuid.NewString(), Key: "k", Namespaces: []string{NamespaceCatalog}, TTL: time.Minute}
+	if got, err := Get(context.Background(), c, spec, l.load); err != nil || got.Price != 2 {
+		t.Fatalf("Get with redis down = %+v, %v; want a load", got, err)
+	}
+	if n := stat(spec, "error"); n != 1 {
+		t.Fatalf("errors = %d, want 1", n)
+	}
+	if err := c.Invalidate(context.Background(), NamespaceCatalog); err == nil {
+		t.Fatal("Invalidate with redis down succeeded, want an error")
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	a := HashKey(map[string]any{"q": "iphone", "page": 1})
+	if a != HashKey(map[string]any{"page": 1, "q": "iphone"}) {
+		t.Error("HashKey depends on map order")
+	}
+	if a == HashKey(map[string]any{"q": "iphone", "page": 2}) {
+		t.Error("HashKey is the same for different requests")
+	}
+	if len(a) != 32 {
+		t.Errorf("HashKey length = %d, want 32", len(a))
+	}
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/kalen1o/iphone-storage/shared/events"
+	sharedkafka "github.com/kalen1o/iphone-storage/shared/kafka"
+	"github.com/kalen1o/iphone-storage/shared/logging"
+)
+
+// invalidations maps each topic to the namespaces its events make stale.
+// Inventory events only name orders, not products, so they invalidate every
+// stock-derived entry.
+var invalidations = map[string][]string{
+	events.TopicProductsUpdated:     {NamespaceCatalog},
+	events.TopicInventoryReserved:   {NamespaceStock},
+	events.TopicInventoryReleased:   {NamespaceStock},
+	events.TopicInventoryOutOfStock: {NamespaceStock},
+	events.TopicInventoryAdjusted:   {NamespaceStock},
+	// Lines reserved alongside the waiting ones change availability.
+	events.TopicInventoryAwaitingStock: {NamespaceStock},
+}
+
+// Invalidator consumes catalog and inventory events and bumps the matching
+// namespace generations. Generations live in Redis, so one consumer group is
+// enough for all core-api instances.
+type Invalidator struct {
+	cache   *Cache
+	brokers []string
+	groupID string
+	log     *logging.Logger
+}
+
+func NewInvalidator(c *Cache, brokers []string, groupID string, log *logging.Logger) *Invalidator {
+	return &Invalidator{cache: c, brokers: brokers, groupID: groupID, log: log}
+}
+
+// Run blocks until ctx is done or a consumer fails.
+func (i *Invalidator) Run(ctx context.Context) error {
+	errCh := make(chan error, len(invalidations))
+	for topic, namespaces := range invalidations {
+		go func() { errCh <- i.consume(ctx, topic, namespaces) }()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (i *Invalidator) consume(ctx context.Context, topic string, namespaces []string) error {
+	c := sharedkafka.NewConsumer(sharedkafka.ConsumerConfig{
+		Brokers: i.brokers,
+		GroupID: i.groupID,
+		Topic:   topic,
+	})
+	defer func() { _ = c.Close() }()
+	return i.apply(ctx, c, topic, namespaces)
+}
+
+// source is the part of a Kafka consumer apply needs.
+type source interface {
+	Fetch(ctx context.Context) (kafka.Message, error)
+	Commit(ctx context.Context, msg kafka.Message) error
+}
+
+// apply bumps namespaces for every message of topic from src until ctx is
+// done or a fetch fails.
+func (i *Invalidator) apply(ctx context.Context, src source, topic string, namespaces []string) error {
+	for {
+		msg, err := src.Fetch(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		// A failed bump is logged and skipped: entries still expire on
+		// their TTL, and blocking the partition would only delay later
+		// invalidations.
+		if err := i.cache.Invalidate(ctx, namespaces...); err != nil {
+			i.log.Error("cache invalidation failed", map[string]any{
+				"err":   err.Error(),
+				"topic": topic,
+			})
+		}
+		_ = src.Commit(ctx, msg)
+	}
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/kalen1o/iphone-storage/shared/events"
+	"github.com/kalen1o/iphone-storage/shared/logging"
+)
+
+// fakeSource hands out n messages, then fails with err.
+type fakeSource struct {
+	n         int
+	err       error
+	committed int
+}
+
+func (f *fakeSource) Fetch(context.Context) (kafka.Message, error) {
+	if f.n == 0 {
+		return kafka.Message{}, f.err
+	}
+	f.n--
+	return kafka.Message{Offset: int64(f.n)}, nil
+}
+
+func (f *fakeSource) Commit(context.Context, kafka.Message) error {
+	f.committed++
+	return nil
+}
+
+func TestInvalidations_CoverCatalogAndStockTopics(t *testing.T) {
+	for topic, want := range map[string]string{
+		events.TopicProductsUpdated:        NamespaceCatalog,
+		events.TopicInventoryReserved:      NamespaceStock,
+		events.TopicInventoryReleased:      NamespaceStock,
+		events.TopicInventoryOutOfStock:    NamespaceStock,
+		events.TopicInventoryAdjusted:      NamespaceStock,
+		events.TopicInventoryAwaitingStock: NamespaceStock,
+	} {
+		if got := invalidations[topic]; len(got) != 1 || got[0] != want {
+			t.Errorf("invalidations[%s] = %v, want [%s]", topic, got, want)
+		}
+	}
+}
+
+func TestInvalidator_BumpsGenerationPerMessage(t *testing.T) {
+	ctx, c, spec := newTestCache(t)
+	i := NewInvalidator(c, nil, "test", logging.New("core-api", "test"))
+
+	var l loader
+	_, _ = Get(ctx, c, spec, l.load)
+
+	src := &fakeSource{n: 3, err: context.Canceled}
+	if err := i.apply(ctx, src, "test", spec.Namespaces); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if src.committed != 3 {
+		t.Fatalf("committed = %d, want 3", src.committed)
+	}
+	gen, err := c.rdb.Get(ctx, genPrefix+spec.Namespaces[0]).Int()
+	if err != nil || gen != 3 {
+		t.Fatalf("generation = %d, %v; want 3", gen, err)
+	}
+	if got, _ := Get(ctx, c, spec, l.load); got.Price != 2 {
+		t.Fatalf("Get after invalidation = %+v, want a new load", got)
+	}
+}
+
+func TestInvalidator_SkipsFailedBumpsAndStopsOnFetchErrors(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 50 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { _ = rdb.Close() })
+	i := NewInvalidator(New(rdb, 0.2), nil, "test", logging.New("core-api", "test"))
+
+	// With Redis down the messages are still committed rather than
+	// blocking the partition.
+	broken := errors.New("broker gone")
+	src := &fakeSource{n: 2, err: broken}
+	if err := i.apply(context.Background(), src, "test", []string{NamespaceStock}); !errors.Is(err, broken) {
+		t.Fatalf("apply = %v, want the fetch error", err)
+	}
+	if src.committed != 2 {
+		t.Fatalf("committed = %d, want 2", src.committed)
+	}
+}
@@ -0,0 +1,68 @@
+// Package giftcode generates gift card codes and derives the hash they are
+// looked up by, shared by gift card issuance and checkout.
+package giftcode
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// alphabet is Crockford's base32: no I, L, O or U, so codes survive being
+// read out or typed by hand.
+const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Length is the number of symbols in a code, 80 random bits.
+const Length = 16
+
+// Generate returns a new code as four dash-separated groups of four.
+func Generate() (string, error) {
+	b := make([]byte, Length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i, c := range b {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(alphabet[c&31])
+	}
+	return sb.String(), nil
+}
+
+// Normalize strips separators and folds case and look-alike symbols, so a
+// code is found however it was typed.
+func Normalize(code string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		switch r {
+		case '-', ' ':
+			continue
+		case 'O':
+			r = '0'
+		case 'I', 'L':
+			r = '1'
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Hash returns the hex SHA-256 of the normalized code, as stored in
+// gift_cards.code_hash.
+func Hash(code string) string {
+	sum := sha256.Sum256([]byte(Normalize(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Last4 returns the last four symbols of the normalized code, shown to
+// tell cards apart.
+func Last4(code string) string {
+	n := Normalize(code)
+	if len(n) < 4 {
+		return n
+	}
+	return n[len(n)-4:]
+}
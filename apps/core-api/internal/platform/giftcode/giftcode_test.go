@@ -0,0 +1,41 @@
+package giftcode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	code, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != Length+3 || strings.Count(code, "-") != 3 {
+		t.Fatalf("code %q: want four groups of four", code)
+	}
+	if Normalize(code) != strings.ReplaceAll(code, "-", "") {
+		t.Fatalf("generated code %q is not in normal form", code)
+	}
+	other, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == code {
+		t.Fatalf("two codes are both %q", code)
+	}
+}
+
+func TestHash_IgnoresHowTheCodeWasTyped(t *testing.T) {
+	want := Hash("AB0C-1DEF-GH1J-KMNP")
+	for _, typed := range []string{"ab0c1defgh1jkmnp", "AB0C 1DEF GH1J KMNP", "ABOC-IDEF-GHLJ-KMNP"} {
+		if got := Hash(typed); got != want {
+			t.Errorf("Hash(%q) = %s, want %s", typed, got, want)
+		}
+	}
+	if Hash("AB0C-1DEF-GH1J-KMNQ") == want {
+		t.Error("different codes hash the same")
+	}
+	if got := Last4("ab0c-1def-gh1j-kmnp"); got != "KMNP" {
+		t.Errorf("Last4 = %q, want KMNP", got)
+	}
+}
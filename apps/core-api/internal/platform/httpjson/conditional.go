@@ -0,0 +1,112 @@
+package httpjson
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cache-Control policies shared by the public read handlers.
+const (
+	// CacheCatalog suits product data: it changes rarely and a short stale
+	// window is fine because clients revalidate with the ETag.
+	CacheCatalog = "public, max-age=60, stale-while-revalidate=300"
+	// CacheAvailability suits stock levels, which move with every checkout.
+	CacheAvailability = "public, max-age=5"
+	// CacheRevalidate stores the response but makes every use revalidate.
+	CacheRevalidate = "private, no-cache"
+)
+
+// Validators describes how a response may be cached and revalidated.
+type Validators struct {
+	// LastModified is sent as Last-Modified and checked against
+	// If-Modified-Since. Leave it zero when the body depends on data without
+	// a reliable timestamp, so clients fall back to the ETag.
+	LastModified time.Time
+	CacheControl string
+}
+
+// WriteConditionalJSON writes v as a 200 response with a strong ETag and the
+// given validators, or a bodyless 304 when the request's If-None-Match or
+// If-Modified-Since shows the client already has it. The ETag hashes the
+// encoded body together with LastModified, so it changes whenever either does.
+func WriteConditionalJSON(w http.ResponseWriter, r *http.Request, v any, val Validators) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to encode response")
+		return
+	}
+
+	etag := ETag(val.LastModified, body.Bytes())
+	h := w.Header()
+	h.Set("ETag", etag)
+	if val.CacheControl != "" {
+		h.Set("Cache-Control", val.CacheControl)
+	}
+	lastModified := val.LastModified.UTC().Truncate(time.Second)
+	if !val.LastModified.IsZero() {
+		h.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+
+	if NotModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body.Bytes())
+	}
+}
+
+// ETag returns a strong entity tag for body as of updatedAt.
+func ETag(updatedAt time.Time, body []byte) string {
+	h := sha256.New()
+	if !updatedAt.IsZero() {
+		h.Write([]byte(strconv.FormatInt(updatedAt.UnixNano(), 10)))
+	}
+	h.Write(body)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// NotModified evaluates the GET/HEAD preconditions of RFC 9110 section 13.2.2:
+// If-None-Match wins when present, otherwise If-Modified-Since is compared
+// with lastModified (ignored when zero).
+func NotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag)
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ims)
+}
+
+// etagListMatches applies the weak comparison If-None-Match calls for, so a
+// W/ prefix added by an intermediary does not defeat revalidation.
+func etagListMatches(list, etag string) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package httpjson
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, method string, headers map[string]string, val Validators) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, "/", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	WriteConditionalJSON(w, r, map[string]string{"name": "iPhone"}, val)
+	return w
+}
+
+func TestWriteConditionalJSONETag(t *testing.T) {
+	val := Validators{CacheControl: CacheCatalog}
+	first := serve(t, http.MethodGet, nil, val)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Body.Len() == 0 {
+		t.Fatalf("first response = %d etag=%q body=%q", first.Code, etag, first.Body.String())
+	}
+	if got := first.Header().Get("Cache-Control"); got != CacheCatalog {
+		t.Fatalf("Cache-Control = %q", got)
+	}
+
+	for _, inm := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		w := serve(t, http.MethodGet, map[string]string{"If-None-Match": inm}, val)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %q: %d body=%q", inm, w.Code, w.Body.String())
+		}
+	}
+	if w := serve(t, http.MethodGet, map[string]string{"If-None-Match": `"stale"`}, val); w.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match: %d, want 200", w.Code)
+	}
+}
+
+func TestWriteConditionalJSONLastModified(t *testing.T) {
+	modified := time.Date(2025, 3, 4, 5, 6, 7, 890, time.UTC)
+	val := Validators{LastModified: modified}
+
+	w := serve(t, http.MethodGet, nil, val)
+	if got := w.Header().Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified = %q", got)
+	}
+
+	at := map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}
+	if w := serve(t, http.MethodGet, at, val); w.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since at Last-Modified: %d, want 304", w.Code)
+	}
+	before := map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}
+	if w := serve(t, http.MethodGet, before, val); w.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since before Last-Modified: %d, want 200", w.Code)
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since.
+	both := map[string]string{"If-None-Match": `"stale"`, "If-Modified-Since": at["If-Modified-Since"]}
+	if w := serve(t, http.MethodGet, both, val); w.Code != http.StatusOK {
+		t.Errorf("stale If-None-Match with current If-Modified-Since: %d, want 200", w.Code)
+	}
+
+	// Without a Last-Modified the date is ignored.
+	if w := serve(t, http.MethodGet, at, Validators{}); w.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since without Last-Modified: %d, want 200", w.Code)
+	}
+}
+
+func TestETagTracksUpdatedAt(t *testing.T) {
+	body := []byte(`{"a":1}`)
+	t1 := time.Unix(100, 0)
+	if ETag(t1, body) == ETag(t1.Add(time.Millisecond), body) {
+		t.Fatal("ETag ignores updatedAt")
+	}
+	if ETag(t1, body) != ETag(t1, body) {
+		t.Fatal("ETag not deterministic")
+	}
+}
@@ -14,3 +14,9 @@ func WriteJSON(w http.ResponseWriter, status int, v any) {
 func WriteError(w http.ResponseWriter, status int, message string) {
 	WriteJSON(w, status, map[string]any{"error": message})
 }
+
+// WriteErrorCode writes an error with a machine-readable code next to the
+// message, for errors clients are expected to handle.
+func WriteErrorCode(w http.ResponseWriter, status int, code, message string) {
+	WriteJSON(w, status, map[string]any{"error": message, "code": code})
+}
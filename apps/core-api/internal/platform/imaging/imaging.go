@@ -0,0 +1,125 @@
+// Package imaging decodes uploaded images and produces downscaled variants
+// using only the standard library.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// MaxPixels bounds width*height of an accepted image, so a small file that
+// decompresses to a huge bitmap is refused before it is decoded.
+const MaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image type (jpeg or png only)")
+	ErrInvalidImage      = errors.New("invalid image")
+)
+
+// Format is a supported image encoding.
+type Format struct {
+	ContentType string
+	Ext         string
+}
+
+var (
+	JPEG = Format{ContentType: "image/jpeg", Ext: "jpg"}
+	PNG  = Format{ContentType: "image/png", Ext: "png"}
+)
+
+// Sniff detects the format from the content itself; the client's declared
+// content type and file name are not trusted.
+func Sniff(data []byte) (Format, error) {
+	switch http.DetectContentType(data) {
+	case JPEG.ContentType:
+		return JPEG, nil
+	case PNG.ContentType:
+		return PNG, nil
+	default:
+		return Format{}, ErrUnsupportedFormat
+	}
+}
+
+// Decode checks the dimensions from the header and then decodes data.
+func Decode(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrInvalidImage, cfg.Width, cfg.Height, MaxPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	return img, nil
+}
+
+// Encode writes img in format f.
+func Encode(img image.Image, f Format) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch f {
+	case JPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	case PNG:
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	default:
+		err = ErrUnsupportedFormat
+	}
+	return buf.Bytes(), err
+}
+
+// Fit scales img down so that neither side exceeds maxDim, keeping the
+// aspect ratio. Images already small enough are returned as is. Each output
+// pixel is the average of the source pixels it covers, which is sharp
+// enough for thumbnails and avoids the aliasing of nearest-neighbour.
+func Fit(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= maxDim && sh <= maxDim {
+		return img
+	}
+	dw, dh := maxDim, sh*maxDim/sw
+	if sh > sw {
+		dw, dh = sw*maxDim/sh, maxDim
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	// Averaging premultiplied RGBA keeps transparent pixels from bleeding
+	// their colour into the edges.
+	src := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					bl += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(bl / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
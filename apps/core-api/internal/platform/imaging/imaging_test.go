@@ -0,0 +1,75 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solid(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestFitKeepsAspectRatio(t *testing.T) {
+	for _, tc := range []struct {
+		w, h, max, wantW, wantH int
+	}{
+		{1600, 1200, 200, 200, 150},
+		{1200, 1600, 200, 150, 200},
+		{100, 50, 200, 100, 50},
+		{5000, 3, 200, 200, 1},
+	} {
+		got := Fit(solid(tc.w, tc.h, color.RGBA{A: 255}), tc.max).Bounds()
+		if got.Dx() != tc.wantW || got.Dy() != tc.wantH {
+			t.Errorf("Fit(%dx%d, %d) = %dx%d, want %dx%d", tc.w, tc.h, tc.max, got.Dx(), got.Dy(), tc.wantW, tc.wantH)
+		}
+	}
+}
+
+func TestFitAverages(t *testing.T) {
+	// Left half red, right half blue; a 1px-high 2px-wide result keeps them apart.
+	img := solid(4, 2, color.RGBA{R: 255, A: 255})
+	for y := 0; y < 2; y++ {
+		img.SetRGBA(2, y, color.RGBA{B: 255, A: 255})
+		img.SetRGBA(3, y, color.RGBA{B: 255, A: 255})
+	}
+	out := Fit(img, 2).(*image.RGBA)
+	if got := out.RGBAAt(0, 0); got != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("left pixel = %v", got)
+	}
+	if got := out.RGBAAt(1, 0); got != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("right pixel = %v", got)
+	}
+}
+
+func TestSniffAndRoundTrip(t *testing.T) {
+	src := solid(8, 8, color.RGBA{G: 200, A: 255})
+	for _, f := range []Format{JPEG, PNG} {
+		data, err := Encode(src, f)
+		if err != nil {
+			t.Fatalf("Encode %s: %v", f.Ext, err)
+		}
+		got, err := Sniff(data)
+		if err != nil || got != f {
+			t.Fatalf("Sniff %s = %v, %v", f.Ext, got, err)
+		}
+		img, err := Decode(data)
+		if err != nil || img.Bounds().Dx() != 8 {
+			t.Fatalf("Decode %s: %v", f.Ext, err)
+		}
+	}
+
+	if _, err := Sniff([]byte("GIF89a......")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Sniff gif: %v", err)
+	}
+	if _, err := Decode([]byte("\x89PNG\r\n\x1a\ngarbage")); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("Decode garbage: %v", err)
+	}
+}
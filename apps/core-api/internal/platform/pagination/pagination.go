@@ -0,0 +1,174 @@
+// Package pagination implements keyset pagination over (created_at, id) with
+// opaque cursor tokens, shared by the list endpoints.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var (
+	ErrInvalidLimit  = fmt.Errorf("limit must be an integer between 1 and %d", MaxLimit)
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type Direction string
+
+const (
+	// Next pages towards older rows, Prev back towards newer ones.
+	Next Direction = "n"
+	Prev Direction = "p"
+)
+
+// Cursor points at the row a page starts after (Next) or before (Prev).
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	Direction Direction `json:"d"`
+}
+
+// Encode returns the opaque token handed to clients.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func Decode(token string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.ID == uuid.Nil || c.CreatedAt.IsZero() || (c.Direction != Next && c.Direction != Prev) {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Params is a parsed page request. Cursor is nil for the first page.
+type Params struct {
+	Limit        int
+	Cursor       *Cursor
+	IncludeTotal bool
+}
+
+// FromRequest reads limit, cursor and include_total from the query string.
+// Unlike the old offset listing, out-of-range limits are rejected rather
+// than clamped.
+func FromRequest(r *http.Request) (Params, error) {
+	q := r.URL.Query()
+	p := Params{Limit: DefaultLimit}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxLimit {
+			return Params{}, ErrInvalidLimit
+		}
+		p.Limit = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := Decode(v)
+		if err != nil {
+			return Params{}, err
+		}
+		p.Cursor = c
+	}
+	if v := q.Get("include_total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Params{}, errors.New("include_total must be a boolean")
+		}
+		p.IncludeTotal = b
+	}
+	return p, nil
+}
+
+// Backward reports whether the page is fetched towards newer rows.
+func (p Params) Backward() bool {
+	return p.Cursor != nil && p.Cursor.Direction == Prev
+}
+
+// Fetch returns how many rows to query: one extra to learn whether more
+// rows exist in the direction of travel.
+func (p Params) Fetch() int {
+	return p.Limit + 1
+}
+
+// Where returns the keyset predicate and ORDER BY for columns named by
+// createdAt and id, with the cursor values bound to placeholders $argN and
+// $argN+1. Without a cursor the predicate is "true".
+func (p Params) Where(createdAt, id string, argN int) (where, orderBy string) {
+	switch {
+	case p.Cursor == nil:
+		return "true", createdAt + " DESC, " + id + " DESC"
+	case p.Backward():
+		return fmt.Sprintf("(%s, %s) > ($%d, $%d)", createdAt, id, argN, argN+1), createdAt + " ASC, " + id + " ASC"
+	default:
+		return fmt.Sprintf("(%s, %s) < ($%d, $%d)", createdAt, id, argN, argN+1), createdAt + " DESC, " + id + " DESC"
+	}
+}
+
+// Args returns the cursor values for the placeholders used by Where, or nil.
+func (p Params) Args() []any {
+	if p.Cursor == nil {
+		return nil
+	}
+	return []any{p.Cursor.CreatedAt, p.Cursor.ID}
+}
+
+// Page is the pagination block of a list response.
+type Page struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	Total      *int   `json:"total,omitempty"`
+}
+
+// Finish trims rows fetched with Fetch() to the page, restores newest-first
+// order for backward pages and computes the cursors. key extracts the
+// (created_at, id) of a row.
+func Finish[T any](p Params, rows []T, key func(T) (time.Time, uuid.UUID)) ([]T, Page) {
+	more := len(rows) > p.Limit
+	if more {
+		rows = rows[:p.Limit]
+	}
+	if p.Backward() {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	var page Page
+	if len(rows) == 0 {
+		return rows, page
+	}
+	hasNext := more
+	hasPrev := p.Cursor != nil
+	if p.Backward() {
+		// We came from an older page, so there is always a next one.
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		t, id := key(rows[len(rows)-1])
+		page.NextCursor = Cursor{CreatedAt: t, ID: id, Direction: Next}.Encode()
+	}
+	if hasPrev {
+		t, id := key(rows[0])
+		page.PrevCursor = Cursor{CreatedAt: t, ID: id, Direction: Prev}.Encode()
+	}
+	return rows, page
+}
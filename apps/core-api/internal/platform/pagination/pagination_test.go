@@ -0,0 +1,111 @@
+package pagination
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type row struct {
+	t  time.Time
+	id uuid.UUID
+}
+
+func key(r row) (time.Time, uuid.UUID) { return r.t, r.id }
+
+// rows returns n rows newest first, one minute apart.
+func rows(n int) []row {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	out := make([]row, n)
+	for i := range out {
+		out[i] = row{t: base.Add(time.Duration(n-i) * time.Minute), id: uuid.New()}
+	}
+	return out
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{CreatedAt: time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC), ID: uuid.New(), Direction: Prev}
+	got, err := Decode(c.Encode())
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !got.CreatedAt.Equal(c.CreatedAt) || got.ID != c.ID || got.Direction != c.Direction {
+		t.Fatalf("round trip = %+v, want %+v", got, c)
+	}
+
+	for _, bad := range []string{"not-base64!", "e30", Cursor{ID: uuid.New(), Direction: Next}.Encode()} {
+		if _, err := Decode(bad); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Decode(%q) error = %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+}
+
+func TestFromRequestLimit(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		limit int
+		err   bool
+	}{
+		{"", DefaultLimit, false},
+		{"limit=1", 1, false},
+		{"limit=100", 100, false},
+		{"limit=0", 0, true},
+		{"limit=101", 0, true},
+		{"limit=abc", 0, true},
+		{"cursor=garbage", 0, true},
+	} {
+		p, err := FromRequest(httptest.NewRequest("GET", "/?"+tc.query, nil))
+		if (err != nil) != tc.err {
+			t.Errorf("%q: error = %v, want error %v", tc.query, err, tc.err)
+			continue
+		}
+		if !tc.err && p.Limit != tc.limit {
+			t.Errorf("%q: limit = %d, want %d", tc.query, p.Limit, tc.limit)
+		}
+	}
+}
+
+func TestFinishWalksForwardAndBack(t *testing.T) {
+	all := rows(5)
+
+	// First page: rows 0-1, with one extra fetched.
+	first, page := Finish(Params{Limit: 2}, append([]row{}, all[:3]...), key)
+	if len(first) != 2 || first[0] != all[0] || page.PrevCursor != "" || page.NextCursor == "" {
+		t.Fatalf("first page = %v %+v", first, page)
+	}
+
+	// Second page going forward: rows 2-3, more remain.
+	next, _ := Decode(page.NextCursor)
+	second, page := Finish(Params{Limit: 2, Cursor: next}, append([]row{}, all[2:5]...), key)
+	if len(second) != 2 || second[0] != all[2] || page.NextCursor == "" || page.PrevCursor == "" {
+		t.Fatalf("second page = %v %+v", second, page)
+	}
+
+	// Back from the second page: the query returns rows ascending (1, 0).
+	prev, _ := Decode(page.PrevCursor)
+	if prev.Direction != Prev || prev.ID != all[2].id {
+		t.Fatalf("prev cursor = %+v", prev)
+	}
+	back, page := Finish(Params{Limit: 2, Cursor: prev}, []row{all[1], all[0]}, key)
+	if len(back) != 2 || back[0] != all[0] || back[1] != all[1] {
+		t.Fatalf("back page = %v, want newest first", back)
+	}
+	if page.PrevCursor != "" || page.NextCursor == "" {
+		t.Fatalf("back page cursors = %+v, want only next", page)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	w, o := Params{Limit: 10}.Where("created_at", "id", 3)
+	if w != "true" || o != "created_at DESC, id DESC" {
+		t.Fatalf("no cursor: %q %q", w, o)
+	}
+	c := &Cursor{CreatedAt: time.Now(), ID: uuid.New(), Direction: Prev}
+	w, o = Params{Limit: 10, Cursor: c}.Where("created_at", "id", 3)
+	if w != "(created_at, id) > ($3, $4)" || o != "created_at ASC, id ASC" {
+		t.Fatalf("prev cursor: %q %q", w, o)
+	}
+}
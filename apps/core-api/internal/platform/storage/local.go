@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Local stores objects as files under a root directory. Its Handler serves
+// them, so core-api can host the public URLs itself in development.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocal(dir, publicBaseURL string) *Local {
+	return &Local{dir: dir, baseURL: publicBaseURL}
+}
+
+func (l *Local) Put(_ context.Context, key, _ string, body []byte) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	path := filepath.Join(l.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write then rename, so a reader never sees a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(body); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Delete(_ context.Context, key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(filepath.Join(l.dir, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) URL(key string) string {
+	return joinURL(l.baseURL, key)
+}
+
+// Handler serves stored objects by key, relative to wherever it is mounted.
+// Directory listings are refused.
+func (l *Local) Handler() http.Handler {
+	files := http.FileServer(http.Dir(l.dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		if len(key) > 0 && key[0] == '/' {
+			key = key[1:]
+		}
+		if !ValidKey(key) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", ImmutableCacheControl)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+// Package storage keeps uploaded media behind a small object-store interface
+// with a local filesystem backend for development and an S3-compatible one
+// for MinIO or S3 proper.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kalen1o/iphone-storage/shared/config"
+)
+
+// ImmutableCacheControl is sent with stored objects. Keys embed a content
+// hash, so an object under a given key never changes.
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+var ErrInvalidKey = errors.New("invalid object key")
+
+// Store is an object store addressed by slash-separated keys.
+type Store interface {
+	Put(ctx context.Context, key, contentType string, body []byte) error
+	Delete(ctx context.Context, key string) error
+	// URL returns the stable public URL of key.
+	URL(key string) string
+}
+
+// New builds the backend selected by cfg.Backend.
+func New(cfg config.StorageConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg.LocalDir, cfg.PublicBaseURL), nil
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:      cfg.S3Endpoint,
+			Region:        cfg.S3Region,
+			Bucket:        cfg.S3Bucket,
+			AccessKey:     cfg.S3AccessKey,
+			SecretKey:     cfg.S3SecretKey,
+			PathStyle:     cfg.S3PathStyle,
+			PublicBaseURL: cfg.PublicBaseURL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// ValidKey reports whether key is safe on every backend: relative, no empty,
+// "." or ".." segments, and only URL-safe characters.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+		for _, r := range seg {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func joinURL(base, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
@@ -1,13 +1,22 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/http/middleware"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/service"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
@@ -23,34 +32,129 @@ func New(svc *service.Service) *Controller {
 
 type ProductsListResponse struct {
 	Items []repo.Product `json:"items"`
+	pagination.Page
+	// Facets is only returned on the first page.
+	Facets *repo.Facets `json:"facets,omitempty"`
 }
 
+type SuggestResponse struct {
+	Items []repo.Suggestion `json:"items"`
+}
+
+// attrQueryPrefix marks attribute filters, e.g. attr.storage=256GB.
+const attrQueryPrefix = "attr."
+
 // GetProducts godoc
-// @Summary List products
+// @Summary List and search products
+// @Description Attribute filters are passed as attr.<name>=<value>, e.g. attr.storage=256GB&attr.color=Black; they match variant options or top-level metadata.
 // @Tags products
 // @Produce json
-// @Param limit query int false "Limit" default(20)
-// @Param offset query int false "Offset" default(0)
+// @Param q query string false "Full-text query over name, SKU, category and description"
+// @Param category query string false "Category"
+// @Param min_price query number false "Minimum price"
+// @Param max_price query number false "Maximum price"
+// @Param in_stock query bool false "Only products with stock available"
+// @Param sort query string false "Sort order" Enums(relevance, newest, price_asc, price_desc, name_asc)
+// @Param limit query int false "Page size (1-100)" default(20)
+// @Param cursor query string false "next_cursor or prev_cursor from a previous page (sort=newest only)"
+// @Param offset query int false "Offset for the other sorts" default(0)
+// @Param include_total query bool false "Include the total match count"
+// @Param If-None-Match header string false "ETag from a previous response"
 // @Success 200 {object} ProductsListResponse
+// @Success 304 "Not modified: the If-None-Match or If-Modified-Since validator is current"
+// @Failure 400 {object} map[string]any
 // @Router /api/products [get]
 func (c *Controller) GetProducts(w http.ResponseWriter, r *http.Request) {
-	limit := parseIntQuery(r, "limit", 20)
-	offset := parseIntQuery(r, "offset", 0)
+	page, err := pagination.FromRequest(r)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	params := repo.SearchParams{
+		Query:    q.Get("q"),
+		Category: strings.TrimSpace(q.Get("category")),
+		Sort:     q.Get("sort"),
+		Page:     page,
+	}
+	if v := q.Get("offset"); v != "" {
+		if params.Offset, err = strconv.Atoi(v); err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+	}
+	if params.MinPrice, err = parseFloatQuery(r, "min_price"); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid min_price")
+		return
+	}
+	if params.MaxPrice, err = parseFloatQuery(r, "max_price"); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid max_price")
+		return
+	}
+	if v := q.Get("in_stock"); v != "" {
+		if params.InStock, err = strconv.ParseBool(v); err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid in_stock")
+			return
+		}
+	}
+	for key, values := range q {
+		name, ok := strings.CutPrefix(key, attrQueryPrefix)
+		if !ok || name == "" || len(values) == 0 {
+			continue
+		}
+		if params.Attributes == nil {
+			params.Attributes = make(map[string]string)
+		}
+		params.Attributes[name] = values[0]
+	}
 
-	products, err := c.svc.List(r.Context(), limit, offset)
+	result, err := c.svc.Search(r.Context(), params)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidSearch) {
+			httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list products")
 		return
 	}
-	httpjson.WriteJSON(w, http.StatusOK, map[string]any{"items": products})
+	// Listings embed availability and facets, which have no timestamp of
+	// their own, so they revalidate by ETag only.
+	httpjson.WriteConditionalJSON(w, r, ProductsListResponse{
+		Items:  result.Items,
+		Page:   result.Page,
+		Facets: result.Facets,
+	}, httpjson.Validators{CacheControl: httpjson.CacheCatalog})
+}
+
+// SuggestProducts godoc
+// @Summary Type-ahead product suggestions
+// @Tags products
+// @Produce json
+// @Param q query string true "Partial query; the last word is matched as a prefix"
+// @Param limit query int false "Limit" default(10)
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {object} SuggestResponse
+// @Success 304 "Not modified: the If-None-Match or If-Modified-Since validator is current"
+// @Router /api/products/suggest [get]
+func (c *Controller) SuggestProducts(w http.ResponseWriter, r *http.Request) {
+	items, err := c.svc.Suggest(r.Context(), r.URL.Query().Get("q"), parseIntQuery(r, "limit", 10))
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to suggest products")
+		return
+	}
+	httpjson.WriteConditionalJSON(w, r, SuggestResponse{Items: items}, httpjson.Validators{CacheControl: httpjson.CacheCatalog})
 }
 
 // GetProductByID godoc
 // @Summary Get product by ID
+// @Description For a parent product the response includes the option matrix and its variants with availability.
 // @Tags products
 // @Produce json
 // @Param id path string true "Product ID (uuid)"
+// @Param If-None-Match header string false "ETag from a previous response"
 // @Success 200 {object} repo.Product
+// @Success 304 "Not modified: the If-None-Match or If-Modified-Since validator is current"
 // @Failure 404 {object} map[string]any
 // @Router /api/products/{id} [get]
 func (c *Controller) GetProductByID(w http.ResponseWriter, r *http.Request) {
@@ -71,9 +175,674 @@ func (c *Controller) GetProductByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	val := httpjson.Validators{CacheControl: httpjson.CacheCatalog}
+	// Variant availability changes without touching the parent's
+	// updated_at, so Last-Modified is only trustworthy for plain products.
+	if len(product.Variants) == 0 {
+		val.LastModified = product.UpdatedAt
+	}
+	httpjson.WriteConditionalJSON(w, r, product, val)
+}
+
+type CreateProductRequest struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	SKU         string  `json:"sku"`
+	Price       float64 `json:"price"`
+	Category    string  `json:"category,omitempty"`
+	// Images is a JSON array of http(s) URLs or absolute paths.
+	Images json.RawMessage `json:"images,omitempty" swaggertype:"array,string"`
+	// Metadata is an arbitrary JSON object.
+	Metadata     json.RawMessage `json:"metadata,omitempty" swaggertype:"object"`
+	IsActive     *bool           `json:"is_active,omitempty"`
+	IsDigital    bool            `json:"is_digital"`
+	InitialStock int             `json:"initial_stock"`
+	// SellMode is in_stock_only (default), preorder or backorder.
+	// ReleaseDate and PreorderLimit (0 for no limit) apply to preorders.
+	SellMode      string     `json:"sell_mode,omitempty"`
+	ReleaseDate   *time.Time `json:"release_date,omitempty"`
+	PreorderLimit *int       `json:"preorder_limit,omitempty"`
+}
+
+type UpdateProductRequest struct {
+	Name        *string         `json:"name,omitempty"`
+	Description *string         `json:"description,omitempty"`
+	SKU         *string         `json:"sku,omitempty"`
+	Price       *float64        `json:"price,omitempty"`
+	Category    *string         `json:"category,omitempty"`
+	Images      json.RawMessage `json:"images,omitempty" swaggertype:"array,string"`
+	Metadata    json.RawMessage `json:"metadata,omitempty" swaggertype:"object"`
+	IsDigital   *bool           `json:"is_digital,omitempty"`
+	// Switching sell_mode away from preorder clears release_date and
+	// preorder_limit.
+	SellMode      *string    `json:"sell_mode,omitempty"`
+	ReleaseDate   *time.Time `json:"release_date,omitempty"`
+	PreorderLimit *int       `json:"preorder_limit,omitempty"`
+}
+
+type SetOptionsRequest struct {
+	Options []repo.Option `json:"options"`
+}
+
+type CreateVariantRequest struct {
+	// Name defaults to the parent name followed by the option values.
+	Name string `json:"name,omitempty"`
+	SKU  string `json:"sku"`
+	// Price defaults to the parent price.
+	Price        *float64          `json:"price,omitempty"`
+	OptionValues map[string]string `json:"option_values"`
+	// Images defaults to the parent images.
+	Images       json.RawMessage `json:"images,omitempty" swaggertype:"array,string"`
+	IsActive     *bool           `json:"is_active,omitempty"`
+	InitialStock int             `json:"initial_stock"`
+}
+
+// AdminGetProduct godoc
+// @Summary Get product (admin)
+// @Description Unlike GET /api/products/{id}, inactive products are returned too.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {object} repo.Product
+// @Success 304 "Not modified: the If-None-Match or If-Modified-Since validator is current"
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/products/{id} [get]
+func (c *Controller) AdminGetProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	product, err := c.svc.GetForAdmin(r.Context(), id)
+	if err != nil {
+		writeProductError(w, err, "failed to get product")
+		return
+	}
+	httpjson.WriteConditionalJSON(w, r, product, httpjson.Validators{CacheControl: httpjson.CacheRevalidate})
+}
+
+// CreateProduct godoc
+// @Summary Create product
+// @Description Creates the product with an inventory row seeded from initial_stock.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param body body CreateProductRequest true "Product"
+// @Success 201 {object} repo.Product
+// @Failure 400 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/products [post]
+func (c *Controller) CreateProduct(w http.ResponseWriter, r *http.Request) {
+	var req CreateProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	product, err := c.svc.Create(r.Context(), service.CreateInput{
+		Name:         req.Name,
+		Description:  req.Description,
+		SKU:          req.SKU,
+		Price:        req.Price,
+		Category:     req.Category,
+		Images:       req.Images,
+		Metadata:     req.Metadata,
+		IsActive:     req.IsActive,
+		IsDigital:    req.IsDigital,
+		InitialStock: req.InitialStock,
+		Selling: repo.Selling{
+			SellMode:      req.SellMode,
+			ReleaseDate:   req.ReleaseDate,
+			PreorderLimit: req.PreorderLimit,
+		},
+	})
+	if err != nil {
+		writeProductError(w, err, "failed to create product")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, product)
+}
+
+// UpdateProduct godoc
+// @Summary Update product
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Param body body UpdateProductRequest true "Fields to change"
+// @Success 200 {object} repo.Product
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/products/{id} [patch]
+func (c *Controller) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req UpdateProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	product, err := c.svc.Update(r.Context(), id, service.UpdateInput{
+		Name:          req.Name,
+		Description:   req.Description,
+		SKU:           req.SKU,
+		Price:         req.Price,
+		Category:      req.Category,
+		Images:        req.Images,
+		Metadata:      req.Metadata,
+		IsDigital:     req.IsDigital,
+		SellMode:      req.SellMode,
+		ReleaseDate:   req.ReleaseDate,
+		PreorderLimit: req.PreorderLimit,
+	})
+	if err != nil {
+		writeProductError(w, err, "failed to update product")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, product)
+}
+
+// SetProductOptions godoc
+// @Summary Set variant options
+// @Description Replaces the option axes (e.g. storage, color) of a parent product.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Param body body SetOptionsRequest true "Options"
+// @Success 200 {object} repo.Product
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/products/{id}/options [put]
+func (c *Controller) SetProductOptions(w http.ResponseWriter, r *http.Request) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req SetOptionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	product, err := c.svc.SetOptions(r.Context(), id, req.Options)
+	if err != nil {
+		writeProductError(w, err, "failed to set product options")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, product)
+}
+
+// CreateVariant godoc
+// @Summary Create product variant
+// @Description Adds a variant with its own SKU, price and inventory under a parent product.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Parent product ID (uuid)"
+// @Param body body CreateVariantRequest true "Variant"
+// @Success 201 {object} repo.Product
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/products/{id}/variants [post]
+func (c *Controller) CreateVariant(w http.ResponseWriter, r *http.Request) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req CreateVariantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	product, err := c.svc.CreateVariant(r.Context(), id, service.VariantInput{
+		Name:         req.Name,
+		SKU:          req.SKU,
+		Price:        req.Price,
+		OptionValues: req.OptionValues,
+		Images:       req.Images,
+		IsActive:     req.IsActive,
+		InitialStock: req.InitialStock,
+	})
+	if err != nil {
+		writeProductError(w, err, "failed to create variant")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, product)
+}
+
+type UploadImageResponse struct {
+	Product *repo.Product          `json:"product"`
+	Image   *service.UploadedImage `json:"image"`
+}
+
+// UploadProductImage godoc
+// @Summary Upload product image
+// @Description Stores a JPEG or PNG (detected from content) with 200px and 800px variants and appends its URL to the product's images.
+// @Tags admin
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Param file formData file true "Image file"
+// @Success 201 {object} UploadImageResponse
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Failure 413 {object} map[string]any
+// @Failure 415 {object} map[string]any
+// @Router /api/admin/products/{id}/images [post]
+func (c *Controller) UploadProductImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := readUploadedFile(w, r, "file", c.svc.MaxImageBytes())
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, service.ErrImageTooLarge) {
+			httpjson.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("image must be at most %d bytes", c.svc.MaxImageBytes()))
+			return
+		}
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	product, image, err := c.svc.UploadImage(r.Context(), id, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrImageTooLarge):
+			httpjson.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("image must be at most %d bytes", c.svc.MaxImageBytes()))
+		case errors.Is(err, service.ErrUnsupportedImage):
+			httpjson.WriteError(w, http.StatusUnsupportedMediaType, err.Error())
+		default:
+			writeProductError(w, err, "failed to upload image")
+		}
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, UploadImageResponse{Product: product, Image: image})
+}
+
+// readUploadedFile returns the content of the named multipart field. The
+// body is capped a little above limit to leave room for the multipart
+// framing; the file itself must not exceed limit.
+func readUploadedFile(w http.ResponseWriter, r *http.Request, field string, limit int64) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("expected multipart/form-data")
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing %q file", field)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != field {
+			_ = part.Close()
+			continue
+		}
+		defer func() { _ = part.Close() }()
+		data, err := io.ReadAll(io.LimitReader(part, limit+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > limit {
+			return nil, service.ErrImageTooLarge
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("%q is empty", field)
+		}
+		return data, nil
+	}
+}
+
+const maxImportBytes = 50 << 20
+
+// bulkTimeout replaces the server's write deadline for imports and exports,
+// which can take far longer than a normal request.
+const bulkTimeout = 10 * time.Minute
+
+// ImportProducts godoc
+// @Summary Bulk import products
+// @Description Upserts products by SKU from CSV (header row required) or NDJSON. Rows are validated individually and reported; valid rows are written in transactional batches. Empty cells keep the current value of existing products; stock only applies to new products.
+// @Tags admin
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Security BearerAuth
+// @Param format query string false "csv or ndjson; defaults from Content-Type"
+// @Param dry_run query bool false "Validate and report without committing"
+// @Param batch_size query int false "Rows per transaction" default(200)
+// @Success 200 {object} service.ImportReport
+// @Failure 400 {object} map[string]any
+// @Failure 413 {object} map[string]any
+// @Router /api/admin/products/import [post]
+func (c *Controller) ImportProducts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = formatFromContentType(r.Header.Get("Content-Type"))
+	}
+	var opts service.ImportOptions
+	if v := q.Get("dry_run"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			httpjson.WriteError(w, http.StatusBadRequest, "invalid dry_run")
+			return
+		}
+		opts.DryRun = b
+	}
+	opts.BatchSize = parseIntQuery(r, "batch_size", service.DefaultImportBatchSize)
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(bulkTimeout))
+	_ = rc.SetWriteDeadline(time.Now().Add(bulkTimeout))
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	rr, err := service.NewRecordReader(format, r.Body)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	report, err := c.svc.Import(r.Context(), rr, opts)
+	if err != nil {
+		// Batches before the failure may have been committed; the report
+		// says which.
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			httpjson.WriteJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+				"error":  fmt.Sprintf("import must be at most %d bytes", maxImportBytes),
+				"report": report,
+			})
+		case errors.Is(err, service.ErrInvalidImport):
+			httpjson.WriteJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "report": report})
+		default:
+			httpjson.WriteError(w, http.StatusInternalServerError, "failed to import products")
+		}
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, report)
+}
+
+// ExportProducts godoc
+// @Summary Export the catalog
+// @Description Streams every live product, variants after their parent, in a format ImportProducts accepts.
+// @Tags admin
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Security BearerAuth
+// @Param format query string false "csv or ndjson" default(csv)
+// @Success 200 {string} string
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/products/export [get]
+func (c *Controller) ExportProducts(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = service.FormatCSV
+	}
+	contentType := map[string]string{
+		service.FormatCSV:    "text/csv; charset=utf-8",
+		service.FormatNDJSON: "application/x-ndjson",
+	}[format]
+	if contentType == "" {
+		httpjson.WriteError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(bulkTimeout))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="catalog-%s.%s"`, time.Now().UTC().Format("20060102"), format))
+	w.Header().Set("Cache-Control", "no-store")
+	// Once rows are streaming the status is sent; a failure can only cut
+	// the body short.
+	_ = c.svc.Export(r.Context(), format, w)
+}
+
+func formatFromContentType(ct string) string {
+	switch {
+	case strings.HasPrefix(ct, "text/csv"):
+		return service.FormatCSV
+	case strings.HasPrefix(ct, "application/x-ndjson"), strings.HasPrefix(ct, "application/ndjson"):
+		return service.FormatNDJSON
+	default:
+		return ""
+	}
+}
+
+type PriceHistoryResponse struct {
+	Items []repo.Price `json:"items"`
+}
+
+// GetProductPrices godoc
+// @Summary Price history
+// @Description Price windows that have started, latest first. status is active, superseded or expired.
+// @Tags products
+// @Produce json
+// @Param id path string true "Product ID (uuid)"
+// @Success 200 {object} PriceHistoryResponse
+// @Failure 404 {object} map[string]any
+// @Router /api/products/{id}/prices [get]
+func (c *Controller) GetProductPrices(w http.ResponseWriter, r *http.Request) {
+	c.priceHistory(w, r, false)
+}
+
+// AdminGetProductPrices godoc
+// @Summary Price history and schedule (admin)
+// @Description Includes scheduled windows and inactive products.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Success 200 {object} PriceHistoryResponse
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/products/{id}/prices [get]
+func (c *Controller) AdminGetProductPrices(w http.ResponseWriter, r *http.Request) {
+	c.priceHistory(w, r, true)
+}
+
+func (c *Controller) priceHistory(w http.ResponseWriter, r *http.Request, admin bool) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	prices, err := c.svc.PriceHistory(r.Context(), id, admin)
+	if err != nil {
+		writeProductError(w, err, "failed to get price history")
+		return
+	}
+	cacheControl := httpjson.CacheCatalog
+	if admin {
+		cacheControl = httpjson.CacheRevalidate
+	}
+	httpjson.WriteConditionalJSON(w, r, PriceHistoryResponse{Items: prices}, httpjson.Validators{CacheControl: cacheControl})
+}
+
+type SchedulePriceRequest struct {
+	Price float64 `json:"price"`
+	// EffectiveFrom defaults to now.
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"`
+	// EffectiveTo is omitted for an open-ended price.
+	EffectiveTo *time.Time `json:"effective_to,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+}
+
+// ScheduleProductPrice godoc
+// @Summary Schedule a price change
+// @Description Adds a price window. It overrides windows that started before it, so a sale with an effective_to falls back to the regular price when it ends. A window starting now applies immediately.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Param body body SchedulePriceRequest true "Price window"
+// @Success 201 {object} repo.Price
+// @Failure 400 {object} map[string]any
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/products/{id}/prices [post]
+func (c *Controller) ScheduleProductPrice(w http.ResponseWriter, r *http.Request) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req SchedulePriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	in := service.SchedulePriceInput{
+		Price:         req.Price,
+		EffectiveFrom: req.EffectiveFrom,
+		EffectiveTo:   req.EffectiveTo,
+		Reason:        req.Reason,
+	}
+	if raw, ok := middleware.UserIDFromContext(r.Context()); ok {
+		if userID, err := uuid.Parse(raw); err == nil {
+			in.CreatedBy = &userID
+		}
+	}
+
+	price, err := c.svc.SchedulePrice(r.Context(), id, in)
+	if err != nil {
+		writeProductError(w, err, "failed to schedule price")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, price)
+}
+
+// CancelProductPrice godoc
+// @Summary Cancel a scheduled price
+// @Description Only windows that have not started can be cancelled.
+// @Tags admin
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Param priceId path string true "Price window ID (uuid)"
+// @Success 204
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/products/{id}/prices/{priceId} [delete]
+func (c *Controller) CancelProductPrice(w http.ResponseWriter, r *http.Request) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	priceID, err := uuid.Parse(mux.Vars(r)["priceId"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid price id")
+		return
+	}
+	if err := c.svc.CancelScheduledPrice(r.Context(), id, priceID); err != nil {
+		if util.IsNotFound(err) {
+			httpjson.WriteError(w, http.StatusNotFound, "scheduled price not found")
+			return
+		}
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to cancel price")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ActivateProduct godoc
+// @Summary Activate product
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Success 200 {object} repo.Product
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/products/{id}/activate [post]
+func (c *Controller) ActivateProduct(w http.ResponseWriter, r *http.Request) {
+	c.setActive(w, r, true)
+}
+
+// DeactivateProduct godoc
+// @Summary Deactivate product
+// @Description Hides the product from the public catalog and from new orders.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Success 200 {object} repo.Product
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/products/{id}/deactivate [post]
+func (c *Controller) DeactivateProduct(w http.ResponseWriter, r *http.Request) {
+	c.setActive(w, r, false)
+}
+
+func (c *Controller) setActive(w http.ResponseWriter, r *http.Request, active bool) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	product, err := c.svc.SetActive(r.Context(), id, active)
+	if err != nil {
+		writeProductError(w, err, "failed to update product")
+		return
+	}
 	httpjson.WriteJSON(w, http.StatusOK, product)
 }
 
+// DeleteProduct godoc
+// @Summary Delete product
+// @Description Soft-deletes the product. Order history keeps referencing it and the SKU stays reserved.
+// @Tags admin
+// @Security BearerAuth
+// @Param id path string true "Product ID (uuid)"
+// @Success 204
+// @Failure 404 {object} map[string]any
+// @Router /api/admin/products/{id} [delete]
+func (c *Controller) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := productIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := c.svc.Delete(r.Context(), id); err != nil {
+		writeProductError(w, err, "failed to delete product")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func productIDFromPath(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeProductError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidProduct):
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrSKUTaken):
+		httpjson.WriteError(w, http.StatusConflict, "sku already exists")
+	case errors.Is(err, service.ErrVariantExists):
+		httpjson.WriteError(w, http.StatusConflict, "variant with these option values already exists")
+	case errors.Is(err, service.ErrStorageUnavailable):
+		httpjson.WriteError(w, http.StatusServiceUnavailable, err.Error())
+	case util.IsNotFound(err):
+		httpjson.WriteError(w, http.StatusNotFound, "not found")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
 func parseIntQuery(r *http.Request, key string, def int) int {
 	v := r.URL.Query().Get(key)
 	if v == "" {
@@ -85,3 +854,15 @@ func parseIntQuery(r *http.Request, key string, def int) int {
 	}
 	return i
 }
+
+func parseFloatQuery(r *http.Request, key string) (*float64, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("invalid number")
+	}
+	return &f, nil
+}
@@ -0,0 +1,125 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ImportItem is one row of a bulk import: Create for a SKU that does not
+// exist yet, or ID and Update for an existing product.
+type ImportItem struct {
+	Create *CreateProductInput
+	ID     uuid.UUID
+	Update *UpdateProductInput
+}
+
+// ExistingSKU describes the product currently holding a SKU.
+type ExistingSKU struct {
+	ID      uuid.UUID
+	Deleted bool
+	Variant bool
+}
+
+// ExportRow is a product as written by the catalog export.
+type ExportRow struct {
+	Product
+	// ParentSKU is set on variants.
+	ParentSKU string
+	// OnHand is the physical stock of the product.
+	OnHand int
+}
+
+func (r *Postgres) LookupSKUs(ctx context.Context, skus []string) (map[string]ExistingSKU, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT sku, id, deleted_at IS NOT NULL, parent_id IS NOT NULL
+		FROM products
+		WHERE sku = ANY($1)
+	`, skus)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]ExistingSKU, len(skus))
+	for rows.Next() {
+		var sku string
+		var e ExistingSKU
+		if err := rows.Scan(&sku, &e.ID, &e.Deleted, &e.Variant); err != nil {
+			return nil, err
+		}
+		out[sku] = e
+	}
+	return out, rows.Err()
+}
+
+func (r *Postgres) ImportBatch(ctx context.Context, items []ImportItem, dryRun bool) ([]Product, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	out := make([]Product, 0, len(items))
+	for _, item := range items {
+		var p *Product
+		if item.Create != nil {
+			p, err = insertProduct(ctx, tx, *item.Create, "bulk import")
+		} else {
+			p, err = updateProduct(ctx, tx, item.ID, *item.Update)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *p)
+	}
+
+	if dryRun {
+		return out, nil
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *Postgres) Export(ctx context.Context, fn func(*ExportRow) error) error {
+	// Parents come first, each followed by its variants.
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+prefixedProductColumns+`, COALESCE(parent.sku, ''), COALESCE(i.on_hand, 0)
+		FROM products p
+		LEFT JOIN products parent ON parent.id = p.parent_id
+		LEFT JOIN inventory i ON i.product_id = p.id
+		WHERE p.deleted_at IS NULL
+		ORDER BY COALESCE(parent.created_at, p.created_at), COALESCE(p.parent_id, p.id),
+		         p.parent_id IS NOT NULL, p.created_at, p.id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row ExportRow
+		p, err := scanProduct(exportScanner{rows: rows, extra: []any{&row.ParentSKU, &row.OnHand}})
+		if err != nil {
+			return err
+		}
+		row.Product = *p
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// exportScanner appends the export's extra columns to scanProduct's targets.
+type exportScanner struct {
+	rows  pgx.Rows
+	extra []any
+}
+
+func (s exportScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(dest, s.extra...)...)
+}
@@ -3,9 +3,14 @@ package repo
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	inventoryrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
 )
 
 type Postgres struct {
@@ -16,67 +21,16 @@ func NewPostgres(pool *pgxpool.Pool) *Postgres {
 	return &Postgres{pool: pool}
 }
 
-func (r *Postgres) List(ctx context.Context, limit, offset int) ([]Product, error) {
-	if limit <= 0 || limit > 100 {
-		limit = 20
-	}
-	if offset < 0 {
-		offset = 0
-	}
+const productColumns = `id, name, COALESCE(description, ''), sku, price::float8, COALESCE(category, ''), images, metadata,
+		       is_active, is_digital, sell_mode, release_date, preorder_limit, parent_id, option_values, created_at, updated_at`
 
-	rows, err := r.pool.Query(ctx, `
-		SELECT id, name, COALESCE(description, ''), sku, price::float8, COALESCE(category, ''), images, metadata,
-		       is_active, is_digital, created_at, updated_at
-		FROM products
-		WHERE deleted_at IS NULL AND is_active = true
-		ORDER BY created_at DESC
-		LIMIT $1 OFFSET $2
-	`, limit, offset)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	out := make([]Product, 0, limit)
-	for rows.Next() {
-		var p Product
-		var rawImages, rawMetadata json.RawMessage
-		if err := rows.Scan(
-			&p.ID,
-			&p.Name,
-			&p.Description,
-			&p.SKU,
-			&p.Price,
-			&p.Category,
-			&rawImages,
-			&rawMetadata,
-			&p.IsActive,
-			&p.IsDigital,
-			&p.CreatedAt,
-			&p.UpdatedAt,
-		); err != nil {
-			return nil, err
-		}
-		_ = json.Unmarshal(rawImages, &p.Images)
-		_ = json.Unmarshal(rawMetadata, &p.Metadata)
-		out = append(out, p)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
-func (r *Postgres) GetByID(ctx context.Context, id uuid.UUID) (*Product, error) {
-	row := r.pool.QueryRow(ctx, `
-		SELECT id, name, COALESCE(description, ''), sku, price::float8, COALESCE(category, ''), images, metadata,
-		       is_active, is_digital, created_at, updated_at
-		FROM products
-		WHERE id = $1 AND deleted_at IS NULL AND is_active = true
-	`, id)
+// prefixedProductColumns is productColumns for queries that alias products as p.
+const prefixedProductColumns = `p.id, p.name, COALESCE(p.description, ''), p.sku, p.price::float8, COALESCE(p.category, ''), p.images, p.metadata,
+		       p.is_active, p.is_digital, p.sell_mode, p.release_date, p.preorder_limit, p.parent_id, p.option_values, p.created_at, p.updated_at`
 
+func scanProduct(row pgx.Row) (*Product, error) {
 	var p Product
-	var rawImages, rawMetadata json.RawMessage
+	var rawImages, rawMetadata, rawOptionValues json.RawMessage
 	if err := row.Scan(
 		&p.ID,
 		&p.Name,
@@ -88,6 +42,11 @@ func (r *Postgres) GetByID(ctx context.Context, id uuid.UUID) (*Product, error)
 		&rawMetadata,
 		&p.IsActive,
 		&p.IsDigital,
+		&p.SellMode,
+		&p.ReleaseDate,
+		&p.PreorderLimit,
+		&p.ParentID,
+		&rawOptionValues,
 		&p.CreatedAt,
 		&p.UpdatedAt,
 	); err != nil {
@@ -95,5 +54,331 @@ func (r *Postgres) GetByID(ctx context.Context, id uuid.UUID) (*Product, error)
 	}
 	_ = json.Unmarshal(rawImages, &p.Images)
 	_ = json.Unmarshal(rawMetadata, &p.Metadata)
+	_ = json.Unmarshal(rawOptionValues, &p.OptionValues)
 	return &p, nil
 }
+
+func (r *Postgres) GetByID(ctx context.Context, id uuid.UUID) (*Product, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+productColumns+`
+		FROM products
+		WHERE id = $1 AND deleted_at IS NULL AND is_active = true
+	`, id)
+	return scanProduct(row)
+}
+
+func (r *Postgres) GetAny(ctx context.Context, id uuid.UUID) (*Product, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+productColumns+`
+		FROM products
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id)
+	return scanProduct(row)
+}
+
+func (r *Postgres) Create(ctx context.Context, input CreateProductInput) (*Product, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	p, err := insertProduct(ctx, tx, input, "product created")
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// insertProduct inserts the product with its inventory row and 'initial'
+// adjustment, recorded with reason. Initial stock goes to the warehouse
+// that ships first; it returns inventoryrepo.ErrNoWarehouse if there is
+// stock and no active warehouse to hold it.
+func insertProduct(ctx context.Context, tx pgx.Tx, input CreateProductInput, reason string) (*Product, error) {
+	images, err := marshalImages(input.Images)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := marshalMetadata(input.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	optionValues := input.OptionValues
+	if optionValues == nil {
+		optionValues = map[string]string{}
+	}
+	rawOptionValues, err := json.Marshal(optionValues)
+	if err != nil {
+		return nil, err
+	}
+
+	row := tx.QueryRow(ctx, `
+		INSERT INTO products (name, description, sku, price, category, images, metadata, is_active, is_digital, parent_id, option_values,
+		                      sell_mode, release_date, preorder_limit)
+		VALUES ($1, NULLIF($2, ''), $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, COALESCE(NULLIF($12, ''), 'in_stock_only'), $13, $14)
+		RETURNING `+productColumns+`
+	`, input.Name, input.Description, input.SKU, input.Price, input.Category, images, metadata, input.IsActive, input.IsDigital, input.ParentID, rawOptionValues,
+		input.Selling.SellMode, input.Selling.ReleaseDate, input.Selling.PreorderLimit)
+	p, err := scanProduct(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO inventory (product_id, available, reserved, on_hand)
+		VALUES ($1, $2, 0, $2)
+	`, p.ID, input.InitialStock); err != nil {
+		return nil, err
+	}
+	warehouseID, err := inventoryrepo.DefaultWarehouse(ctx, tx)
+	switch {
+	case errors.Is(err, inventoryrepo.ErrNoWarehouse) && input.InitialStock == 0:
+		// Nothing to place; the first stock adjustment picks a warehouse.
+	case err != nil:
+		return nil, err
+	default:
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO warehouse_stock (product_id, warehouse_id, available, reserved, on_hand)
+			VALUES ($1, $2, $3, 0, $3)
+		`, p.ID, warehouseID, input.InitialStock); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO inventory_adjustments (product_id, adjustment_type, quantity, available_before, available_after,
+		                                   reserved_before, reserved_after, on_hand_before, on_hand_after, reason)
+		VALUES ($1, 'initial', $2, 0, $2, 0, 0, 0, $2, $3)
+	`, p.ID, input.InitialStock, reason); err != nil {
+		return nil, err
+	}
+	if err := recordPrice(ctx, tx, p.ID, input.Price, reason); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *Postgres) Update(ctx context.Context, id uuid.UUID, input UpdateProductInput) (*Product, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	p, err := updateProduct(ctx, tx, id, input)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// updateProduct applies input and, when the price changes, records it in
+// the price history effective immediately.
+func updateProduct(ctx context.Context, tx pgx.Tx, id uuid.UUID, input UpdateProductInput) (*Product, error) {
+	var images, metadata []byte
+	var err error
+	if input.Images != nil {
+		if images, err = marshalImages(*input.Images); err != nil {
+			return nil, err
+		}
+	}
+	if input.Metadata != nil {
+		if metadata, err = marshalMetadata(*input.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	var sellMode *string
+	var releaseDate *time.Time
+	var preorderLimit *int
+	if input.Selling != nil {
+		sellMode, releaseDate, preorderLimit = &input.Selling.SellMode, input.Selling.ReleaseDate, input.Selling.PreorderLimit
+	}
+
+	// An empty description or category clears the column.
+	row := tx.QueryRow(ctx, `
+		UPDATE products
+		SET name = COALESCE($2, name),
+		    description = CASE WHEN $3::text IS NULL THEN description ELSE NULLIF($3, '') END,
+		    sku = COALESCE($4, sku),
+		    price = COALESCE($5, price),
+		    category = CASE WHEN $6::text IS NULL THEN category ELSE NULLIF($6, '') END,
+		    images = COALESCE($7::jsonb, images),
+		    metadata = COALESCE($8::jsonb, metadata),
+		    is_digital = COALESCE($9, is_digital),
+		    is_active = COALESCE($10, is_active),
+		    sell_mode = COALESCE($11, sell_mode),
+		    release_date = CASE WHEN $11::text IS NULL THEN release_date ELSE $12 END,
+		    preorder_limit = CASE WHEN $11::text IS NULL THEN preorder_limit ELSE $13 END
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+productColumns+`
+	`, id, input.Name, input.Description, input.SKU, input.Price, input.Category, images, metadata, input.IsDigital, input.IsActive,
+		sellMode, releaseDate, preorderLimit)
+	p, err := scanProduct(row)
+	if err != nil {
+		return nil, err
+	}
+	if input.Price != nil {
+		if err := recordPrice(ctx, tx, id, *input.Price, "price updated"); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (r *Postgres) SetActive(ctx context.Context, id uuid.UUID, active bool) (*Product, error) {
+	row := r.pool.QueryRow(ctx, `
+		UPDATE products
+		SET is_active = $2
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+productColumns+`
+	`, id, active)
+	return scanProduct(row)
+}
+
+func (r *Postgres) AppendImage(ctx context.Context, id uuid.UUID, url string, maxImages int) (*Product, error) {
+	row := r.pool.QueryRow(ctx, `
+		UPDATE products
+		SET images = COALESCE(images, '[]'::jsonb) || jsonb_build_array($2::text)
+		WHERE id = $1 AND deleted_at IS NULL
+		  AND jsonb_array_length(COALESCE(images, '[]'::jsonb)) < $3
+		RETURNING `+productColumns+`
+	`, id, url, maxImages)
+	return scanProduct(row)
+}
+
+func (r *Postgres) SoftDelete(ctx context.Context, id uuid.UUID) (*Product, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// The rows are kept so order_items and inventory history stay valid; the
+	// SKUs therefore remain taken.
+	row := tx.QueryRow(ctx, `
+		UPDATE products
+		SET is_active = false,
+		    deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+productColumns+`
+	`, id)
+	p, err := scanProduct(row)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE products
+		SET is_active = false,
+		    deleted_at = NOW()
+		WHERE parent_id = $1 AND deleted_at IS NULL
+	`, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *Postgres) GetOptions(ctx context.Context, productID uuid.UUID) ([]Option, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT name, option_values
+		FROM product_options
+		WHERE product_id = $1
+		ORDER BY position ASC, name ASC
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Option, 0)
+	for rows.Next() {
+		var o Option
+		if err := rows.Scan(&o.Name, &o.Values); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *Postgres) SetOptions(ctx context.Context, productID uuid.UUID, options []Option) error {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `DELETE FROM product_options WHERE product_id = $1`, productID); err != nil {
+		return err
+	}
+	for i, o := range options {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO product_options (product_id, name, position, option_values)
+			VALUES ($1, $2, $3, $4)
+		`, productID, o.Name, i, o.Values); err != nil {
+			return err
+		}
+	}
+	// Touch the parent so its updated_at (and Last-Modified) reflects the
+	// new matrix.
+	if _, err := tx.Exec(ctx, `UPDATE products SET updated_at = NOW() WHERE id = $1`, productID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *Postgres) ListVariants(ctx context.Context, parentID uuid.UUID, includeInactive bool) ([]Variant, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT p.id, p.name, p.sku, p.price::float8, p.option_values, p.is_active, COALESCE(i.available, 0), p.sell_mode, p.release_date
+		FROM products p
+		LEFT JOIN inventory i ON i.product_id = p.id
+		WHERE p.parent_id = $1 AND p.deleted_at IS NULL AND (p.is_active OR $2)
+		ORDER BY p.created_at ASC
+	`, parentID, includeInactive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Variant, 0)
+	for rows.Next() {
+		var v Variant
+		var rawOptionValues json.RawMessage
+		if err := rows.Scan(&v.ID, &v.Name, &v.SKU, &v.Price, &rawOptionValues, &v.IsActive, &v.Available, &v.SellMode, &v.ReleaseDate); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(rawOptionValues, &v.OptionValues)
+		v.InStock = v.Available > 0
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func marshalImages(images []string) ([]byte, error) {
+	if images == nil {
+		images = []string{}
+	}
+	return json.Marshal(images)
+}
+
+func marshalMetadata(metadata map[string]any) ([]byte, error) {
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	return json.Marshal(metadata)
+}
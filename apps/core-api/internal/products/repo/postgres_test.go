@@ -0,0 +1,216 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	inventoryrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/testdb"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+// newTestRepo returns a repository on the test database and a SKU prefix
+// of its own; every product with that prefix is removed when the test
+// ends.
+func newTestRepo(t *testing.T) (context.Context, *Postgres, string) {
+	t.Helper()
+	ctx, pool := testdb.New(t, "products", "product_options", "product_prices", "inventory", "inventory_adjustments", "warehouses", "warehouse_stock")
+
+	prefix := "TEST-" + uuid.NewString()[:8] + "-"
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		ids := `SELECT id FROM products WHERE sku LIKE $1 || '%'`
+		for _, table := range []string{"product_prices", "inventory_adjustments", "warehouse_stock", "inventory", "product_options"} {
+			_, _ = pool.Exec(ctx, `DELETE FROM `+table+` WHERE product_id IN (`+ids+`)`, prefix)
+		}
+		_, _ = pool.Exec(ctx, `DELETE FROM products WHERE sku LIKE $1 || '%' AND parent_id IS NOT NULL`, prefix)
+		_, _ = pool.Exec(ctx, `DELETE FROM products WHERE sku LIKE $1 || '%'`, prefix)
+	})
+	return ctx, NewPostgres(pool), prefix
+}
+
+func TestCreate_SeedsInventoryAndLedger(t *testing.T) {
+	ctx, r, prefix := newTestRepo(t)
+
+	p, err := r.Create(ctx, CreateProductInput{
+		Name:         "iPhone 17",
+		SKU:          prefix + "IP17",
+		Price:        999,
+		Images:       []string{"/img/front.jpg"},
+		Metadata:     map[string]any{"storage": "256GB"},
+		IsActive:     true,
+		InitialStock: 7,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if p.SellMode != SellInStockOnly || p.Description != "" || p.Category != "" || p.Metadata["storage"] != "256GB" || len(p.Images) != 1 {
+		t.Fatalf("created = %+v, want defaults and the given images and metadata", p)
+	}
+
+	var available, onHand int
+	var kind string
+	var quantity int
+	if err := r.pool.QueryRow(ctx, `
+		SELECT i.available, i.on_hand, a.adjustment_type, a.quantity
+		FROM inventory i
+		JOIN inventory_adjustments a ON a.product_id = i.product_id
+		WHERE i.product_id = $1
+	`, p.ID).Scan(&available, &onHand, &kind, &quantity); err != nil {
+		t.Fatalf("read inventory: %v", err)
+	}
+	if available != 7 || onHand != 7 || kind != "initial" || quantity != 7 {
+		t.Fatalf("inventory = %d/%d with a %s adjustment of %d, want 7/7 with an initial one of 7", available, onHand, kind, quantity)
+	}
+
+	_, err = r.Create(ctx, CreateProductInput{Name: "Copy", SKU: prefix + "IP17", Price: 1})
+	if !util.IsUniqueViolation(err, "products_sku_key") {
+		t.Fatalf("Create with a taken SKU: err = %v, want a products_sku_key violation", err)
+	}
+}
+
+func TestCreate_RefusesStockWithoutAnActiveWarehouse(t *testing.T) {
+	ctx, r, prefix := newTestRepo(t)
+	// The warehouses are switched off only inside tx, which is rolled back.
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, `UPDATE warehouses SET is_active = false WHERE is_active`); err != nil {
+		t.Fatalf("deactivate warehouses: %v", err)
+	}
+
+	_, err = insertProduct(ctx, tx, CreateProductInput{Name: "iPhone 17", SKU: prefix + "IP17", Price: 999, InitialStock: 3}, "test")
+	if !errors.Is(err, inventoryrepo.ErrNoWarehouse) {
+		t.Fatalf("insert with stock: err = %v, want inventoryrepo.ErrNoWarehouse", err)
+	}
+
+	// Without stock there is nothing to place.
+	p, err := insertProduct(ctx, tx, CreateProductInput{Name: "iPhone 17e", SKU: prefix + "IP17E", Price: 599}, "test")
+	if err != nil {
+		t.Fatalf("insert without stock: %v", err)
+	}
+	var rows int
+	if err := tx.QueryRow(ctx, `SELECT count(*) FROM warehouse_stock WHERE product_id = $1`, p.ID).Scan(&rows); err != nil || rows != 0 {
+		t.Fatalf("warehouse stock rows = %d, %v; want none", rows, err)
+	}
+}
+
+func TestUpdate_ChangesOnlyGivenFields(t *testing.T) {
+	ctx, r, prefix := newTestRepo(t)
+	p, err := r.Create(ctx, CreateProductInput{
+		Name: "iPhone 17", Description: "The new one", SKU: prefix + "IP17", Price: 999, Category: "smartphones", IsActive: true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	name, empty, price := "iPhone 17 Pro", "", 1099.0
+	got, err := r.Update(ctx, p.ID, UpdateProductInput{Name: &name, Description: &empty, Price: &price})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got.Name != name || got.Description != "" || got.Price != price || got.Category != "smartphones" || got.SKU != p.SKU {
+		t.Fatalf("updated = %+v, want new name and price, no description, the rest kept", got)
+	}
+	prices, err := r.ListPrices(ctx, p.ID, nil)
+	if err != nil || len(prices) != 2 || prices[0].Price != price {
+		t.Fatalf("price history = %+v, %v; want the new price on top of the initial one", prices, err)
+	}
+
+	if _, err := r.Update(ctx, uuid.New(), UpdateProductInput{Name: &name}); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("Update of a missing product: err = %v, want pgx.ErrNoRows", err)
+	}
+}
+
+func TestSetActiveAndSoftDelete(t *testing.T) {
+	ctx, r, prefix := newTestRepo(t)
+	parent, err := r.Create(ctx, CreateProductInput{Name: "iPhone 17", SKU: prefix + "IP17", Price: 999, IsActive: true})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	variant, err := r.Create(ctx, CreateProductInput{
+		Name: "iPhone 17 Black", SKU: prefix + "IP17-BLK", Price: 999, IsActive: true,
+		ParentID: &parent.ID, OptionValues: map[string]string{"Color": "Black"},
+	})
+	if err != nil {
+		t.Fatalf("Create variant: %v", err)
+	}
+
+	if p, err := r.SetActive(ctx, parent.ID, false); err != nil || p.IsActive {
+		t.Fatalf("SetActive false = %+v, %v", p, err)
+	}
+	if _, err := r.GetByID(ctx, parent.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("GetByID of an inactive product: err = %v, want pgx.ErrNoRows", err)
+	}
+	if _, err := r.GetAny(ctx, parent.ID); err != nil {
+		t.Fatalf("GetAny of an inactive product: %v", err)
+	}
+
+	if _, err := r.SoftDelete(ctx, parent.ID); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+	for _, id := range []uuid.UUID{parent.ID, variant.ID} {
+		if _, err := r.GetAny(ctx, id); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("GetAny after deleting the parent: err = %v, want pgx.ErrNoRows", err)
+		}
+	}
+	if _, err := r.SoftDelete(ctx, parent.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("second SoftDelete: err = %v, want pgx.ErrNoRows", err)
+	}
+	// The SKU stays taken by the deleted row.
+	if _, err := r.Create(ctx, CreateProductInput{Name: "Again", SKU: parent.SKU, Price: 1}); !util.IsUniqueViolation(err, "products_sku_key") {
+		t.Fatalf("Create with a deleted product's SKU: err = %v, want a products_sku_key violation", err)
+	}
+}
+
+func TestVariants_OptionsAndAvailability(t *testing.T) {
+	ctx, r, prefix := newTestRepo(t)
+	parent, err := r.Create(ctx, CreateProductInput{Name: "iPhone 17", SKU: prefix + "IP17", Price: 999, IsActive: true})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	options := []Option{{Name: "Storage", Values: []string{"256GB", "128GB"}}, {Name: "Color", Values: []string{"Black"}}}
+	if err := r.SetOptions(ctx, parent.ID, options); err != nil {
+		t.Fatalf("SetOptions: %v", err)
+	}
+	if got, err := r.GetOptions(ctx, parent.ID); err != nil || !reflect.DeepEqual(got, options) {
+		t.Fatalf("GetOptions = %+v, %v; want %+v in the order set", got, err, options)
+	}
+
+	variant := func(sku, storage string, active bool, stock int) (*Product, error) {
+		return r.Create(ctx, CreateProductInput{
+			Name: "iPhone 17 " + storage, SKU: prefix + sku, Price: 999, IsActive: active, InitialStock: stock,
+			ParentID: &parent.ID, OptionValues: map[string]string{"Storage": storage, "Color": "Black"},
+		})
+	}
+	if _, err := variant("IP17-128", "128GB", true, 4); err != nil {
+		t.Fatalf("Create variant: %v", err)
+	}
+	if _, err := variant("IP17-256", "256GB", false, 0); err != nil {
+		t.Fatalf("Create inactive variant: %v", err)
+	}
+	if _, err := variant("IP17-128-B", "128GB", true, 0); !util.IsUniqueViolation(err, "idx_products_variant_options") {
+		t.Fatalf("Create a second 128GB Black: err = %v, want an idx_products_variant_options violation", err)
+	}
+
+	live, err := r.ListVariants(ctx, parent.ID, false)
+	if err != nil || len(live) != 1 {
+		t.Fatalf("ListVariants = %+v, %v; want the active variant only", live, err)
+	}
+	if v := live[0]; v.Available != 4 || !v.InStock || v.OptionValues["Storage"] != "128GB" {
+		t.Fatalf("variant = %+v, want 4 in stock", v)
+	}
+	all, err := r.ListVariants(ctx, parent.ID, true)
+	if err != nil || len(all) != 2 || all[1].IsActive || all[1].InStock {
+		t.Fatalf("ListVariants with inactive = %+v, %v; want the inactive one last and out of stock", all, err)
+	}
+}
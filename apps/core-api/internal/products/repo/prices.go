@@ -0,0 +1,164 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Price is one window of a product's price history.
+type Price struct {
+	ID            uuid.UUID  `json:"id"`
+	ProductID     uuid.UUID  `json:"product_id"`
+	Price         float64    `json:"price"`
+	EffectiveFrom time.Time  `json:"effective_from"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+	Reason        string     `json:"reason,omitempty"`
+	CreatedBy     *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	// Status is derived at read time; see the service.
+	Status string `json:"status"`
+}
+
+type CreatePriceInput struct {
+	ProductID     uuid.UUID
+	Price         float64
+	EffectiveFrom time.Time
+	EffectiveTo   *time.Time
+	Reason        string
+	CreatedBy     *uuid.UUID
+}
+
+// priceSchedulerLock is the advisory lock key that keeps core-api instances
+// from running the price sync concurrently.
+const priceSchedulerLock = 7_037_001
+
+const priceColumns = `id, product_id, price::float8, effective_from, effective_to, COALESCE(reason, ''), created_by, created_at`
+
+func scanPrice(row pgx.Row) (*Price, error) {
+	var p Price
+	if err := row.Scan(&p.ID, &p.ProductID, &p.Price, &p.EffectiveFrom, &p.EffectiveTo, &p.Reason, &p.CreatedBy, &p.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// recordPrice adds an open-ended window starting now, unless price already
+// is the effective one.
+func recordPrice(ctx context.Context, tx pgx.Tx, productID uuid.UUID, price float64, reason string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO product_prices (product_id, price, effective_from, reason)
+		SELECT $1, $2, NOW(), NULLIF($3, '')
+		WHERE product_effective_price($1, NOW()) IS DISTINCT FROM $2::numeric
+	`, productID, price, reason)
+	return err
+}
+
+// ListPrices returns the history of a product, latest start first. With
+// startedBy set, windows starting after it (scheduled ones) are left out.
+func (r *Postgres) ListPrices(ctx context.Context, productID uuid.UUID, startedBy *time.Time) ([]Price, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+priceColumns+`
+		FROM product_prices
+		WHERE product_id = $1 AND ($2::timestamptz IS NULL OR effective_from <= $2)
+		ORDER BY effective_from DESC, created_at DESC
+	`, productID, startedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Price, 0)
+	for rows.Next() {
+		p, err := scanPrice(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *p)
+	}
+	return out, rows.Err()
+}
+
+func (r *Postgres) CreatePrice(ctx context.Context, input CreatePriceInput) (*Price, error) {
+	row := r.pool.QueryRow(ctx, `
+		INSERT INTO product_prices (product_id, price, effective_from, effective_to, reason, created_by)
+		SELECT id, $2, $3, $4, NULLIF($5, ''), $6
+		FROM products
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+priceColumns+`
+	`, input.ProductID, input.Price, input.EffectiveFrom, input.EffectiveTo, input.Reason, input.CreatedBy)
+	return scanPrice(row)
+}
+
+// DeleteScheduledPrice removes a window that has not started yet; anything
+// else is history and yields pgx.ErrNoRows.
+func (r *Postgres) DeleteScheduledPrice(ctx context.Context, productID, priceID uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM product_prices
+		WHERE id = $1 AND product_id = $2 AND effective_from > NOW()
+	`, priceID, productID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// SyncPrices copies the effective price into products.price wherever the
+// two differ, for one product or, with productID nil, the whole catalog, and
+// returns the products it changed. Full syncs take an advisory lock and do
+// nothing while another instance holds it.
+func (r *Postgres) SyncPrices(ctx context.Context, productID *uuid.UUID) ([]Product, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if productID == nil {
+		var locked bool
+		if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, priceSchedulerLock).Scan(&locked); err != nil {
+			return nil, err
+		}
+		if !locked {
+			return nil, nil
+		}
+	}
+
+	rows, err := tx.Query(ctx, `
+		UPDATE products p
+		SET price = e.price
+		FROM (
+			SELECT id, product_effective_price(id, NOW()) AS price
+			FROM products
+			WHERE deleted_at IS NULL AND ($1::uuid IS NULL OR id = $1)
+		) e
+		WHERE p.id = e.id AND e.price IS NOT NULL AND p.price <> e.price
+		RETURNING `+prefixedProductColumns+`
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	var changed []Product
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changed = append(changed, *p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
 )
 
 type Product struct {
@@ -18,11 +20,185 @@ type Product struct {
 	Metadata    map[string]any `json:"metadata,omitempty"`
 	IsActive    bool           `json:"is_active"`
 	IsDigital   bool           `json:"is_digital"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	Selling
+	// ParentID and OptionValues are set on variants only.
+	ParentID     *uuid.UUID        `json:"parent_id,omitempty"`
+	OptionValues map[string]string `json:"option_values,omitempty"`
+	// Options and Variants are filled in on detail reads of a parent product.
+	Options   []Option  `json:"options,omitempty"`
+	Variants  []Variant `json:"variants,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Sell modes decide what happens to orders beyond available stock.
+const (
+	SellInStockOnly = "in_stock_only"
+	SellPreorder    = "preorder"
+	SellBackorder   = "backorder"
+)
+
+// Selling is how a product is sold beyond its available stock. Preorders
+// wait for ReleaseDate, when set, and for stock; PreorderLimit caps the
+// units taken before release. Backorders wait for stock only.
+type Selling struct {
+	SellMode      string     `json:"sell_mode"`
+	ReleaseDate   *time.Time `json:"release_date,omitempty"`
+	PreorderLimit *int       `json:"preorder_limit,omitempty"`
+}
+
+// Option is one axis of a parent product's variant matrix, e.g. storage
+// with values 128GB, 256GB and 512GB.
+type Option struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// Variant is the matrix view of a variant product with its availability.
+type Variant struct {
+	ID           uuid.UUID         `json:"id"`
+	Name         string            `json:"name"`
+	SKU          string            `json:"sku"`
+	Price        float64           `json:"price"`
+	OptionValues map[string]string `json:"option_values"`
+	IsActive     bool              `json:"is_active"`
+	Available    int               `json:"available"`
+	InStock      bool              `json:"in_stock"`
+	SellMode     string            `json:"sell_mode"`
+	ReleaseDate  *time.Time        `json:"release_date,omitempty"`
+}
+
+type CreateProductInput struct {
+	Name        string
+	Description string
+	SKU         string
+	Price       float64
+	Category    string
+	Images      []string
+	Metadata    map[string]any
+	IsActive    bool
+	IsDigital   bool
+	// Selling defaults to in-stock-only when SellMode is empty.
+	Selling Selling
+	// ParentID and OptionValues create a variant of an existing product.
+	ParentID     *uuid.UUID
+	OptionValues map[string]string
+	// InitialStock seeds the inventory row and is recorded as an
+	// 'initial' inventory adjustment.
+	InitialStock int
+}
+
+// UpdateProductInput holds the fields to change; nil fields are left as is.
+type UpdateProductInput struct {
+	Name        *string
+	Description *string
+	SKU         *string
+	Price       *float64
+	Category    *string
+	Images      *[]string
+	Metadata    *map[string]any
+	IsDigital   *bool
+	IsActive    *bool
+	// Selling replaces all three selling fields when set.
+	Selling *Selling
+}
+
+// Sort orders accepted by Search.
+const (
+	SortRelevance = "relevance"
+	SortNewest    = "newest"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortNameAsc   = "name_asc"
+)
+
+// SearchParams filters the public catalog. Prices and stock of a parent
+// product are taken from its active variants when it has any.
+type SearchParams struct {
+	Query    string
+	Category string
+	MinPrice *float64
+	MaxPrice *float64
+	InStock  bool
+	// Attributes match top-level metadata keys or variant option values.
+	Attributes map[string]string
+	Sort       string
+	// Page drives keyset pagination for the newest sort; the other sorts
+	// page with Offset and ignore Page.Cursor.
+	Page   pagination.Params
+	Offset int
+}
+
+type SearchResult struct {
+	Items []Product
+	Page  pagination.Page
+	// Facets is only computed for the first page; later pages return nil.
+	Facets *Facets
+}
+
+// Facets are computed over the whole filtered result, not just the page.
+type Facets struct {
+	Categories []FacetValue            `json:"categories"`
+	Attributes map[string][]FacetValue `json:"attributes"`
+	Price      PriceRange              `json:"price"`
+	InStock    int                     `json:"in_stock"`
+}
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type PriceRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+type Suggestion struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	SKU      string    `json:"sku"`
+	Category string    `json:"category,omitempty"`
 }
 
 type Repository interface {
-	List(ctx context.Context, limit, offset int) ([]Product, error)
+	Search(ctx context.Context, params SearchParams) (*SearchResult, error)
+	// Suggest returns type-ahead matches for a partial query.
+	Suggest(ctx context.Context, prefix string, limit int) ([]Suggestion, error)
 	GetByID(ctx context.Context, id uuid.UUID) (*Product, error)
+
+	// GetAny returns a product regardless of is_active; soft-deleted products
+	// are still not found.
+	GetAny(ctx context.Context, id uuid.UUID) (*Product, error)
+	// Create inserts the product together with its inventory row and initial
+	// adjustment in a single transaction.
+	Create(ctx context.Context, input CreateProductInput) (*Product, error)
+	Update(ctx context.Context, id uuid.UUID, input UpdateProductInput) (*Product, error)
+	SetActive(ctx context.Context, id uuid.UUID, active bool) (*Product, error)
+	// SoftDelete deletes the product and, for a parent, all of its variants.
+	SoftDelete(ctx context.Context, id uuid.UUID) (*Product, error)
+	// AppendImage adds url to the product's images unless that would exceed
+	// maxImages, in which case pgx.ErrNoRows is returned.
+	AppendImage(ctx context.Context, id uuid.UUID, url string, maxImages int) (*Product, error)
+
+	GetOptions(ctx context.Context, productID uuid.UUID) ([]Option, error)
+	// SetOptions replaces the option axes of a parent product.
+	SetOptions(ctx context.Context, productID uuid.UUID, options []Option) error
+	// ListVariants returns the live variants of a parent with their
+	// availability; inactive variants are only included when asked for.
+	ListVariants(ctx context.Context, parentID uuid.UUID, includeInactive bool) ([]Variant, error)
+
+	// LookupSKUs reports which of skus are taken, including by deleted
+	// products and variants.
+	LookupSKUs(ctx context.Context, skus []string) (map[string]ExistingSKU, error)
+	// ImportBatch applies items in one transaction, which is rolled back
+	// rather than committed when dryRun is set.
+	ImportBatch(ctx context.Context, items []ImportItem, dryRun bool) ([]Product, error)
+	// Export calls fn for every live product, variants after their parent.
+	Export(ctx context.Context, fn func(*ExportRow) error) error
+
+	ListPrices(ctx context.Context, productID uuid.UUID, startedBy *time.Time) ([]Price, error)
+	CreatePrice(ctx context.Context, input CreatePriceInput) (*Price, error)
+	DeleteScheduledPrice(ctx context.Context, productID, priceID uuid.UUID) error
+	SyncPrices(ctx context.Context, productID *uuid.UUID) ([]Product, error)
 }
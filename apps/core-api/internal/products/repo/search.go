@@ -0,0 +1,321 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+)
+
+// sortClauses whitelists the ORDER BY for the offset-paged sorts. Every
+// clause ends on a unique column so pages are stable. SortNewest is keyset
+// paged and ordered by pagination.Params.Where instead.
+var sortClauses = map[string]string{
+	SortRelevance: "m.rank DESC, p.created_at DESC, p.id DESC",
+	SortPriceAsc:  "m.effective_price ASC, p.id ASC",
+	SortPriceDesc: "m.effective_price DESC, p.id ASC",
+	SortNameAsc:   "p.name ASC, p.id ASC",
+}
+
+// ResolveSort applies the defaults: relevance when there is a query, newest
+// otherwise. Relevance without a query falls back to newest.
+func ResolveSort(sort, query string) string {
+	hasQuery := strings.TrimSpace(query) != ""
+	if sort == "" && hasQuery {
+		return SortRelevance
+	}
+	if sort == "" || (sort == SortRelevance && !hasQuery) {
+		return SortNewest
+	}
+	return sort
+}
+
+// KeysetPaged reports whether a sort pages by cursor rather than offset.
+func KeysetPaged(sort string) bool {
+	_, offsetPaged := sortClauses[sort]
+	return !offsetPaged
+}
+
+func (r *Postgres) Search(ctx context.Context, params SearchParams) (*SearchResult, error) {
+	if params.Page.Limit <= 0 || params.Page.Limit > pagination.MaxLimit {
+		params.Page.Limit = pagination.DefaultLimit
+	}
+	if params.Offset < 0 {
+		params.Offset = 0
+	}
+
+	matched, args, err := matchQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	orderBy, offsetPaged := sortClauses[ResolveSort(params.Sort, params.Query)]
+
+	pageArgs := append([]any{}, args...)
+	var pageSQL string
+	if offsetPaged {
+		pageArgs = append(pageArgs, params.Page.Fetch(), params.Offset)
+		pageSQL = matched + `
+			SELECT ` + prefixedProductColumns + `
+			FROM m
+			JOIN products p ON p.id = m.match_id
+			ORDER BY ` + orderBy + `
+			LIMIT $` + strconv.Itoa(len(pageArgs)-1) + ` OFFSET $` + strconv.Itoa(len(pageArgs))
+	} else {
+		keyset, keysetOrder := params.Page.Where("p.created_at", "p.id", len(pageArgs)+1)
+		pageArgs = append(pageArgs, params.Page.Args()...)
+		pageArgs = append(pageArgs, params.Page.Fetch())
+		pageSQL = matched + `
+			SELECT ` + prefixedProductColumns + `
+			FROM m
+			JOIN products p ON p.id = m.match_id
+			WHERE ` + keyset + `
+			ORDER BY ` + keysetOrder + `
+			LIMIT $` + strconv.Itoa(len(pageArgs))
+	}
+
+	// Facets and counts describe the whole result, so they are only worth
+	// computing once per listing unless the caller asked for the total.
+	firstPage := params.Page.Cursor == nil
+	if offsetPaged {
+		firstPage = params.Offset == 0
+	}
+	withStats := firstPage || params.Page.IncludeTotal
+
+	batch := &pgx.Batch{}
+	batch.Queue(pageSQL, pageArgs...)
+	if withStats {
+		batch.Queue(matched+`
+			SELECT count(*), COALESCE(MIN(effective_price), 0), COALESCE(MAX(effective_price), 0), count(*) FILTER (WHERE available > 0)
+			FROM m`, args...)
+	}
+	if firstPage {
+		batch.Queue(matched+`
+			SELECT p.category, count(*)
+			FROM m
+			JOIN products p ON p.id = m.match_id
+			WHERE p.category IS NOT NULL
+			GROUP BY p.category
+			ORDER BY count(*) DESC, p.category ASC`, args...)
+		batch.Queue(matched+`
+			SELECT kv.key, kv.value, count(DISTINCT m.match_id)
+			FROM m
+			JOIN products v ON v.parent_id = m.match_id AND v.deleted_at IS NULL AND v.is_active = true
+			CROSS JOIN LATERAL jsonb_each_text(v.option_values) kv
+			GROUP BY kv.key, kv.value
+			ORDER BY kv.key ASC, count(DISTINCT m.match_id) DESC, kv.value ASC`, args...)
+	}
+
+	br := r.pool.SendBatch(ctx, batch)
+	defer br.Close()
+
+	rows, err := br.Query()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Product, 0, params.Page.Fetch())
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		items = append(items, *p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := &SearchResult{}
+	if offsetPaged {
+		if len(items) > params.Page.Limit {
+			items = items[:params.Page.Limit]
+		}
+		out.Items = items
+	} else {
+		out.Items, out.Page = pagination.Finish(params.Page, items, func(p Product) (time.Time, uuid.UUID) {
+			return p.CreatedAt, p.ID
+		})
+	}
+
+	if !withStats {
+		return out, nil
+	}
+	var total int
+	var facets Facets
+	if err := br.QueryRow().Scan(&total, &facets.Price.Min, &facets.Price.Max, &facets.InStock); err != nil {
+		return nil, err
+	}
+	if params.Page.IncludeTotal {
+		out.Page.Total = &total
+	}
+	if !firstPage {
+		return out, nil
+	}
+
+	facets.Categories = make([]FacetValue, 0)
+	facets.Attributes = make(map[string][]FacetValue)
+	rows, err = br.Query()
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var f FacetValue
+		if err := rows.Scan(&f.Value, &f.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		facets.Categories = append(facets.Categories, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = br.Query()
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key string
+		var f FacetValue
+		if err := rows.Scan(&key, &f.Value, &f.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		facets.Attributes[key] = append(facets.Attributes[key], f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out.Facets = &facets
+
+	return out, nil
+}
+
+// matchQuery returns the CTE, named m, of the products matching params'
+// query and filters, with the arguments it numbers from $1.
+func matchQuery(params SearchParams) (string, []any, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{"p.deleted_at IS NULL", "p.is_active = true", "p.parent_id IS NULL"}
+	rank := "0::real"
+	if q := strings.TrimSpace(params.Query); q != "" {
+		// The english config stems natural words, the simple one keeps SKUs
+		// and model numbers intact; a match on either counts.
+		qa := arg(q)
+		tsq := fmt.Sprintf("(websearch_to_tsquery('english', %[1]s) || websearch_to_tsquery('simple', %[1]s))", qa)
+		where = append(where, "p.search_vector @@ "+tsq)
+		rank = "ts_rank_cd(p.search_vector, " + tsq + ")"
+	}
+	if params.Category != "" {
+		where = append(where, "p.category = "+arg(params.Category))
+	}
+	if len(params.Attributes) > 0 {
+		attrs, err := json.Marshal(params.Attributes)
+		if err != nil {
+			return "", nil, err
+		}
+		aa := arg(string(attrs))
+		where = append(where, fmt.Sprintf(`(p.metadata @> %[1]s::jsonb OR EXISTS (
+			SELECT 1 FROM products v
+			WHERE v.parent_id = p.id AND v.deleted_at IS NULL AND v.is_active = true AND v.option_values @> %[1]s::jsonb
+		))`, aa))
+	}
+
+	post := []string{"true"}
+	if params.MinPrice != nil {
+		post = append(post, "effective_price >= "+arg(*params.MinPrice))
+	}
+	if params.MaxPrice != nil {
+		post = append(post, "effective_price <= "+arg(*params.MaxPrice))
+	}
+	if params.InStock {
+		post = append(post, "available > 0")
+	}
+
+	matched := `
+		WITH base AS (
+			SELECT p.id AS match_id,
+			       ` + rank + ` AS rank,
+			       COALESCE(vs.min_price, p.price::float8) AS effective_price,
+			       COALESCE(vs.available, pi.available, 0) AS available
+			FROM products p
+			LEFT JOIN LATERAL (
+				SELECT MIN(v.price)::float8 AS min_price, SUM(COALESCE(vi.available, 0))::int AS available
+				FROM products v
+				LEFT JOIN inventory vi ON vi.product_id = v.id
+				WHERE v.parent_id = p.id AND v.deleted_at IS NULL AND v.is_active = true
+			) vs ON true
+			LEFT JOIN inventory pi ON pi.product_id = p.id
+			WHERE ` + strings.Join(where, " AND ") + `
+		), m AS (
+			SELECT * FROM base WHERE ` + strings.Join(post, " AND ") + `
+		)`
+	return matched, args, nil
+}
+
+var suggestTerm = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// prefixQuery turns free text into a tsquery where every word must match and
+// the last one may be incomplete, e.g. "iphone 17 pr" -> "iphone & 17 & pr:*".
+// Only letters and digits survive, so the result is always valid syntax.
+func prefixQuery(text string) string {
+	terms := suggestTerm.FindAllString(strings.ToLower(text), 8)
+	if len(terms) == 0 {
+		return ""
+	}
+	terms[len(terms)-1] += ":*"
+	return strings.Join(terms, " & ")
+}
+
+func (r *Postgres) Suggest(ctx context.Context, prefix string, limit int) ([]Suggestion, error) {
+	if limit <= 0 || limit > 20 {
+		limit = 10
+	}
+	out := make([]Suggestion, 0, limit)
+	q := prefixQuery(prefix)
+	if q == "" {
+		return out, nil
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, name, sku, COALESCE(category, '')
+		FROM products,
+		     LATERAL (SELECT to_tsquery('simple', $1) || to_tsquery('english', $1) AS tsq) q
+		WHERE deleted_at IS NULL AND is_active = true AND parent_id IS NULL
+		  AND search_vector @@ q.tsq
+		ORDER BY ts_rank_cd(search_vector, q.tsq) DESC, name ASC
+		LIMIT $2
+	`, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Suggestion
+		if err := rows.Scan(&s.ID, &s.Name, &s.SKU, &s.Category); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,190 @@
+package repo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+)
+
+func TestResolveSort(t *testing.T) {
+	for _, tc := range []struct {
+		sort, query, want string
+	}{
+		{"", "", SortNewest},
+		{"", "iphone", SortRelevance},
+		{SortRelevance, "", SortNewest},
+		{SortRelevance, "  ", SortNewest},
+		{SortRelevance, "iphone", SortRelevance},
+		{SortPriceAsc, "iphone", SortPriceAsc},
+		{SortNameAsc, "", SortNameAsc},
+	} {
+		if got := ResolveSort(tc.sort, tc.query); got != tc.want {
+			t.Errorf("ResolveSort(%q, %q) = %q, want %q", tc.sort, tc.query, got, tc.want)
+		}
+	}
+	for sort, want := range map[string]bool{SortNewest: true, SortRelevance: false, SortPriceAsc: false, SortPriceDesc: false, SortNameAsc: false} {
+		if got := KeysetPaged(sort); got != want {
+			t.Errorf("KeysetPaged(%q) = %v, want %v", sort, got, want)
+		}
+	}
+}
+
+func TestPrefixQuery(t *testing.T) {
+	for in, want := range map[string]string{
+		"iphone 17 pr":        "iphone & 17 & pr:*",
+		"  IPHONE  ":          "iphone:*",
+		"pro's & (max) | !":   "pro & s & max:*",
+		"ünïcode":             "ünïcode:*",
+		"":                    "",
+		"&|!():*":             "",
+		"a b c d e f g h i j": "a & b & c & d & e & f & g & h:*",
+	} {
+		if got := prefixQuery(in); got != want {
+			t.Errorf("prefixQuery(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMatchQuery(t *testing.T) {
+	minPrice, maxPrice := 500.0, 1500.0
+
+	sql, args, err := matchQuery(SearchParams{})
+	if err != nil || len(args) != 0 {
+		t.Fatalf("matchQuery without filters = %v, %v; want no arguments", args, err)
+	}
+	for _, want := range []string{"p.deleted_at IS NULL AND p.is_active = true AND p.parent_id IS NULL", "0::real AS rank", "WHERE true"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("matchQuery without filters lacks %q:\n%s", want, sql)
+		}
+	}
+
+	sql, args, err = matchQuery(SearchParams{
+		Query:      " iphone pro ",
+		Category:   "smartphones",
+		Attributes: map[string]string{"Color": "Black"},
+		MinPrice:   &minPrice,
+		MaxPrice:   &maxPrice,
+		InStock:    true,
+	})
+	if err != nil {
+		t.Fatalf("matchQuery: %v", err)
+	}
+	wantArgs := []any{"iphone pro", "smartphones", `{"Color":"Black"}`, minPrice, maxPrice}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("args = %#v, want %#v", args, wantArgs)
+	}
+	for _, want := range []string{
+		"p.search_vector @@ (websearch_to_tsquery('english', $1) || websearch_to_tsquery('simple', $1))",
+		"ts_rank_cd(p.search_vector, (websearch_to_tsquery('english', $1)",
+		"p.category = $2",
+		"p.metadata @> $3::jsonb",
+		"v.option_values @> $3::jsonb",
+		"WHERE true AND effective_price >= $4 AND effective_price <= $5 AND available > 0",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("matchQuery lacks %q:\n%s", want, sql)
+		}
+	}
+}
+
+// TestSearch_FiltersSortsAndFacets searches a category of its own holding
+// a parent with two variants and an accessory.
+func TestSearch_FiltersSortsAndFacets(t *testing.T) {
+	ctx, r, prefix := newTestRepo(t)
+	// Only letters, so the category is a single word to the text parser.
+	category := "xq" + strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' {
+			return 'g' + c - '0'
+		}
+		return c
+	}, uuid.NewString()[:8])
+
+	parent, err := r.Create(ctx, CreateProductInput{Name: "iPhone 17", SKU: prefix + "IP17", Price: 1, Category: category, IsActive: true})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, v := range []struct {
+		sku, storage, color string
+		price               float64
+		stock               int
+	}{
+		{"IP17-128", "128GB", "Black", 999, 2},
+		{"IP17-256", "256GB", "White", 1099, 0},
+	} {
+		if _, err := r.Create(ctx, CreateProductInput{
+			Name: "iPhone 17 " + v.storage, SKU: prefix + v.sku, Price: v.price, Category: category, IsActive: true, InitialStock: v.stock,
+			ParentID: &parent.ID, OptionValues: map[string]string{"Storage": v.storage, "Color": v.color},
+		}); err != nil {
+			t.Fatalf("Create variant: %v", err)
+		}
+	}
+	if _, err := r.Create(ctx, CreateProductInput{
+		Name: "Silicone Case", SKU: prefix + "CASE", Price: 49, Category: category, IsActive: true,
+		Metadata: map[string]any{"Color": "Black"},
+	}); err != nil {
+		t.Fatalf("Create accessory: %v", err)
+	}
+
+	search := func(p SearchParams) *SearchResult {
+		t.Helper()
+		p.Category = category
+		res, err := r.Search(ctx, p)
+		if err != nil {
+			t.Fatalf("Search(%+v): %v", p, err)
+		}
+		return res
+	}
+	skus := func(res *SearchResult) []string {
+		out := make([]string, 0, len(res.Items))
+		for _, p := range res.Items {
+			out = append(out, strings.TrimPrefix(p.SKU, prefix))
+		}
+		return out
+	}
+
+	minPrice := 500.0
+	for name, tc := range map[string]struct {
+		params SearchParams
+		want   []string
+	}{
+		"newest first":               {params: SearchParams{}, want: []string{"CASE", "IP17"}},
+		"price from the variants":    {params: SearchParams{Sort: SortPriceDesc}, want: []string{"IP17", "CASE"}},
+		"min price":                  {params: SearchParams{MinPrice: &minPrice}, want: []string{"IP17"}},
+		"in stock through a variant": {params: SearchParams{InStock: true}, want: []string{"IP17"}},
+		"metadata or variant option": {params: SearchParams{Attributes: map[string]string{"Color": "Black"}, Sort: SortPriceAsc}, want: []string{"CASE", "IP17"}},
+		"variant option only":        {params: SearchParams{Attributes: map[string]string{"Storage": "256GB"}}, want: []string{"IP17"}},
+		"full text":                  {params: SearchParams{Query: "silicone"}, want: []string{"CASE"}},
+	} {
+		if got := skus(search(tc.params)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: items = %v, want %v", name, got, tc.want)
+		}
+	}
+
+	facets := search(SearchParams{}).Facets
+	if facets == nil {
+		t.Fatal("first page has no facets")
+	}
+	if want := []FacetValue{{Value: category, Count: 2}}; !reflect.DeepEqual(facets.Categories, want) {
+		t.Errorf("category facets = %+v, want %+v", facets.Categories, want)
+	}
+	if got := facets.Attributes["Storage"]; len(got) != 2 {
+		t.Errorf("storage facets = %+v, want 128GB and 256GB", got)
+	}
+	if facets.Price != (PriceRange{Min: 49, Max: 999}) || facets.InStock != 1 {
+		t.Errorf("price and stock facets = %+v, %d; want 49-999 and 1 in stock", facets.Price, facets.InStock)
+	}
+
+	page := search(SearchParams{Page: pagination.Params{Limit: 1, IncludeTotal: true}})
+	if len(page.Items) != 1 || page.Page.Total == nil || *page.Page.Total != 2 || page.Page.NextCursor == "" {
+		t.Errorf("first page of one = %+v, want one item, a total of 2 and a next cursor", page.Page)
+	}
+
+	got, err := r.Suggest(ctx, category[:6], 5)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Suggest(%q) = %+v, %v; want the two top-level products", category[:6], got, err)
+	}
+}
@@ -0,0 +1,600 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/cache"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
+	"github.com/kalen1o/iphone-storage/shared/events"
+	"github.com/kalen1o/iphone-storage/shared/kafka"
+)
+
+// Bulk file formats.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+const (
+	DefaultImportBatchSize = 200
+	MaxImportBatchSize     = 1000
+	// maxReportedErrors bounds the report; Failed still counts every row.
+	maxReportedErrors = 500
+	maxNDJSONLine     = 1 << 20
+)
+
+// ErrInvalidImport is wrapped with a message about a file-level problem,
+// such as an unknown format or CSV column. Row problems are reported in the
+// ImportReport instead.
+var ErrInvalidImport = errors.New("invalid import")
+
+// csvColumns is the column order of CSV exports. Imports accept any subset
+// in any order as long as sku is present; "initial_stock" may be used for
+// "stock", which only applies to new products.
+var csvColumns = []string{
+	"sku", "parent_sku", "option_values", "name", "description", "price", "category",
+	"images", "metadata", "is_active", "is_digital", "stock",
+}
+
+// ImportRecord is one product row of an import file. Nil and empty fields
+// are absent from the row: new products get the defaults, existing ones
+// keep their current values.
+type ImportRecord struct {
+	SKU          string          `json:"sku"`
+	Name         *string         `json:"name,omitempty"`
+	Description  *string         `json:"description,omitempty"`
+	Price        *float64        `json:"price,omitempty"`
+	Category     *string         `json:"category,omitempty"`
+	Images       json.RawMessage `json:"images,omitempty"`
+	Metadata     json.RawMessage `json:"metadata,omitempty"`
+	IsActive     *bool           `json:"is_active,omitempty"`
+	IsDigital    *bool           `json:"is_digital,omitempty"`
+	InitialStock *int            `json:"initial_stock,omitempty"`
+	Stock        *int            `json:"stock,omitempty"`
+	// ParentSKU and OptionValues appear in exports of variants. Existing
+	// variants can be updated by import; new ones are rejected.
+	ParentSKU    string            `json:"parent_sku,omitempty"`
+	OptionValues map[string]string `json:"option_values,omitempty"`
+}
+
+// RowError reports a rejected row. Row is the 1-based line in the file.
+type RowError struct {
+	Row     int    `json:"row"`
+	SKU     string `json:"sku,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// RecordReader yields import records with their row numbers. A *RowError
+// rejects that row only; any other error ends the import.
+type RecordReader interface {
+	Next() (ImportRecord, int, error)
+}
+
+// NewRecordReader reads CSV (with a header row) or NDJSON from r.
+func NewRecordReader(format string, r io.Reader) (RecordReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatNDJSON:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64<<10), maxNDJSONLine)
+		return &ndjsonReader{sc: sc}, nil
+	default:
+		return nil, fmt.Errorf("%w: format must be %s or %s", ErrInvalidImport, FormatCSV, FormatNDJSON)
+	}
+}
+
+type ndjsonReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func (n *ndjsonReader) Next() (ImportRecord, int, error) {
+	for n.sc.Scan() {
+		n.line++
+		line := bytes.TrimSpace(n.sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec ImportRecord
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			return rec, n.line, &RowError{Row: n.line, Message: "invalid json: " + err.Error()}
+		}
+		return rec, n.line, nil
+	}
+	if err := n.sc.Err(); err != nil {
+		return ImportRecord{}, n.line, fmt.Errorf("%w: line %d: %v", ErrInvalidImport, n.line+1, err)
+	}
+	return ImportRecord{}, n.line, io.EOF
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing csv header", ErrInvalidImport)
+	}
+
+	known := make(map[string]bool, len(csvColumns))
+	for _, c := range csvColumns {
+		known[c] = true
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if name == "initial_stock" {
+			name = "stock"
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("%w: unknown csv column %q", ErrInvalidImport, name)
+		}
+		if _, dup := columns[name]; dup {
+			return nil, fmt.Errorf("%w: duplicate csv column %q", ErrInvalidImport, name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["sku"]; !ok {
+		return nil, fmt.Errorf("%w: csv header must include sku", ErrInvalidImport)
+	}
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+func (c *csvReader) Next() (ImportRecord, int, error) {
+	fields, err := c.r.Read()
+	if err == io.EOF {
+		return ImportRecord{}, 0, io.EOF
+	}
+	var perr *csv.ParseError
+	if errors.As(err, &perr) && errors.Is(perr.Err, csv.ErrFieldCount) {
+		return ImportRecord{}, perr.StartLine, &RowError{Row: perr.StartLine, Message: "wrong number of fields"}
+	}
+	if err != nil {
+		// Quoting errors leave the reader's position unreliable.
+		return ImportRecord{}, 0, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	line, _ := c.r.FieldPos(0)
+
+	get := func(name string) string {
+		if i, ok := c.columns[name]; ok {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	rowErr := func(msg string) (ImportRecord, int, error) {
+		return ImportRecord{}, line, &RowError{Row: line, SKU: get("sku"), Message: msg}
+	}
+
+	rec := ImportRecord{SKU: get("sku"), ParentSKU: get("parent_sku")}
+	if v := get("name"); v != "" {
+		rec.Name = &v
+	}
+	if v := get("description"); v != "" {
+		rec.Description = &v
+	}
+	if v := get("category"); v != "" {
+		rec.Category = &v
+	}
+	if v := get("price"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return rowErr("price must be a number")
+		}
+		rec.Price = &f
+	}
+	for _, b := range []struct {
+		name string
+		dst  **bool
+	}{{"is_active", &rec.IsActive}, {"is_digital", &rec.IsDigital}} {
+		if v := get(b.name); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return rowErr(b.name + " must be true or false")
+			}
+			*b.dst = &parsed
+		}
+	}
+	if v := get("stock"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return rowErr("stock must be an integer")
+		}
+		rec.InitialStock = &n
+	}
+	// Images are a JSON array or, more conveniently in a spreadsheet,
+	// URLs separated by "|".
+	if v := get("images"); v != "" {
+		if strings.HasPrefix(v, "[") {
+			rec.Images = json.RawMessage(v)
+		} else {
+			parts := strings.Split(v, "|")
+			for i := range parts {
+				parts[i] = strings.TrimSpace(parts[i])
+			}
+			rec.Images, _ = json.Marshal(parts)
+		}
+	}
+	if v := get("metadata"); v != "" {
+		rec.Metadata = json.RawMessage(v)
+	}
+	return rec, line, nil
+}
+
+// ImportOptions controls a bulk import.
+type ImportOptions struct {
+	// DryRun validates and applies every batch inside a transaction that is
+	// rolled back, so the report shows exactly what a real run would do.
+	DryRun    bool
+	BatchSize int
+}
+
+// ImportReport summarises a bulk import.
+type ImportReport struct {
+	DryRun  bool       `json:"dry_run"`
+	Rows    int        `json:"rows"`
+	Created int        `json:"created"`
+	Updated int        `json:"updated"`
+	Failed  int        `json:"failed"`
+	Errors  []RowError `json:"errors"`
+	// ErrorsTruncated is set when more rows failed than Errors lists.
+	ErrorsTruncated bool `json:"errors_truncated,omitempty"`
+}
+
+func (r *ImportReport) fail(e RowError) {
+	r.Failed++
+	if len(r.Errors) < maxReportedErrors {
+		r.Errors = append(r.Errors, e)
+	} else {
+		r.ErrorsTruncated = true
+	}
+}
+
+type pendingRow struct {
+	row int
+	rec ImportRecord
+}
+
+// Import upserts products by SKU from rr. Rows are validated individually
+// and failures reported without stopping the import; the valid rows are
+// written in transactional batches, so a batch that fails at the database
+// leaves none of its rows behind. Only file-level problems return an error,
+// alongside the report of what was processed before them.
+func (s *Service) Import(ctx context.Context, rr RecordReader, opts ImportOptions) (*ImportReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultImportBatchSize
+	}
+	if opts.BatchSize > MaxImportBatchSize {
+		opts.BatchSize = MaxImportBatchSize
+	}
+
+	report := &ImportReport{DryRun: opts.DryRun, Errors: []RowError{}}
+	seen := make(map[string]int)
+	batch := make([]pendingRow, 0, opts.BatchSize)
+
+	for {
+		rec, row, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			report.Rows++
+			report.fail(*rowErr)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Rows++
+		rec.SKU = strings.TrimSpace(rec.SKU)
+		if err := validateSKU(rec.SKU); err != nil {
+			report.fail(RowError{Row: row, SKU: rec.SKU, Message: rowMessage(err)})
+			continue
+		}
+		if first, dup := seen[rec.SKU]; dup {
+			report.fail(RowError{Row: row, SKU: rec.SKU, Message: fmt.Sprintf("duplicate sku (first seen in row %d)", first)})
+			continue
+		}
+		seen[rec.SKU] = row
+
+		batch = append(batch, pendingRow{row: row, rec: rec})
+		if len(batch) == opts.BatchSize {
+			if err := s.importBatch(ctx, batch, opts.DryRun, report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := s.importBatch(ctx, batch, opts.DryRun, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func (s *Service) importBatch(ctx context.Context, batch []pendingRow, dryRun bool, report *ImportReport) error {
+	skus := make([]string, len(batch))
+	for i, p := range batch {
+		skus[i] = p.rec.SKU
+	}
+	existing, err := s.repo.LookupSKUs(ctx, skus)
+	if err != nil {
+		return err
+	}
+
+	items := make([]repo.ImportItem, 0, len(batch))
+	rows := make([]pendingRow, 0, len(batch))
+	for _, p := range batch {
+		item, err := importItem(p.rec, existing)
+		if err != nil {
+			report.fail(RowError{Row: p.row, SKU: p.rec.SKU, Message: rowMessage(err)})
+			continue
+		}
+		items = append(items, item)
+		rows = append(rows, p)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	products, err := s.repo.ImportBatch(ctx, items, dryRun)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		msg := "batch rolled back: " + rowMessage(mapWriteError(err))
+		for _, p := range rows {
+			report.fail(RowError{Row: p.row, SKU: p.rec.SKU, Message: msg})
+		}
+		return nil
+	}
+
+	msgs := make([]kafka.Message, 0, len(products))
+	for i := range products {
+		action := events.ProductActionUpdated
+		if items[i].Create != nil {
+			action = events.ProductActionCreated
+			report.Created++
+		} else {
+			report.Updated++
+		}
+		if b, err := productEvent(&products[i], action); err == nil {
+			msgs = append(msgs, kafka.Message{Key: []byte(products[i].ID.String()), Value: b})
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	_ = s.cache.Invalidate(ctx, cache.NamespaceCatalog)
+	if s.producer != nil {
+		_ = s.producer.PublishBatch(ctx, events.TopicProductsUpdated, msgs)
+	}
+	return nil
+}
+
+// importItem validates rec against the create or update rules, depending on
+// whether its SKU exists.
+func importItem(rec ImportRecord, existing map[string]repo.ExistingSKU) (repo.ImportItem, error) {
+	e, exists := existing[rec.SKU]
+	switch {
+	case exists && e.Deleted:
+		return repo.ImportItem{}, invalid("sku belongs to a deleted product")
+	case !exists && rec.ParentSKU != "":
+		return repo.ImportItem{}, invalid("new variants must be created through the variants endpoint")
+	}
+
+	var upd repo.UpdateProductInput
+	if rec.Name != nil {
+		name := strings.TrimSpace(*rec.Name)
+		if err := validateName(name); err != nil {
+			return repo.ImportItem{}, err
+		}
+		upd.Name = &name
+	}
+	if rec.Price != nil {
+		if err := validatePrice(*rec.Price); err != nil {
+			return repo.ImportItem{}, err
+		}
+		upd.Price = rec.Price
+	}
+	if rec.Description != nil {
+		d := strings.TrimSpace(*rec.Description)
+		upd.Description = &d
+	}
+	if rec.Category != nil {
+		c := strings.TrimSpace(*rec.Category)
+		upd.Category = &c
+	}
+	if len(rec.Images) > 0 {
+		images, err := parseImages(rec.Images)
+		if err != nil {
+			return repo.ImportItem{}, err
+		}
+		upd.Images = &images
+	}
+	if len(rec.Metadata) > 0 {
+		metadata, err := parseMetadata(rec.Metadata)
+		if err != nil {
+			return repo.ImportItem{}, err
+		}
+		upd.Metadata = &metadata
+	}
+	upd.IsActive = rec.IsActive
+	upd.IsDigital = rec.IsDigital
+
+	stock := rec.InitialStock
+	if stock == nil {
+		stock = rec.Stock
+	}
+	if stock != nil && *stock < 0 {
+		return repo.ImportItem{}, invalid("stock must be >= 0")
+	}
+
+	if exists {
+		return repo.ImportItem{ID: e.ID, Update: &upd}, nil
+	}
+
+	if upd.Name == nil {
+		return repo.ImportItem{}, invalid("name is required for new products")
+	}
+	if upd.Price == nil {
+		return repo.ImportItem{}, invalid("price is required for new products")
+	}
+	create := repo.CreateProductInput{
+		Name:     *upd.Name,
+		SKU:      rec.SKU,
+		Price:    *upd.Price,
+		Images:   []string{},
+		Metadata: map[string]any{},
+		IsActive: true,
+	}
+	if upd.Description != nil {
+		create.Description = *upd.Description
+	}
+	if upd.Category != nil {
+		create.Category = *upd.Category
+	}
+	if upd.Images != nil {
+		create.Images = *upd.Images
+	}
+	if upd.Metadata != nil {
+		create.Metadata = *upd.Metadata
+	}
+	if upd.IsActive != nil {
+		create.IsActive = *upd.IsActive
+	}
+	if upd.IsDigital != nil {
+		create.IsDigital = *upd.IsDigital
+	}
+	if stock != nil {
+		create.InitialStock = *stock
+	}
+	return repo.ImportItem{Create: &create}, nil
+}
+
+// rowMessage strips the sentinel prefix from validation errors, which is
+// noise in a per-row report.
+func rowMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidProduct):
+		return strings.TrimPrefix(err.Error(), ErrInvalidProduct.Error()+": ")
+	case errors.Is(err, ErrSKUTaken), errors.Is(err, ErrVariantExists):
+		return err.Error()
+	default:
+		return "database error"
+	}
+}
+
+// Export streams every live product, variants after their parent, in
+// format. The output can be fed back to Import.
+func (s *Service) Export(ctx context.Context, format string, w io.Writer) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvColumns); err != nil {
+			return err
+		}
+		n := 0
+		err := s.repo.Export(ctx, func(row *repo.ExportRow) error {
+			if err := cw.Write(csvRecord(row)); err != nil {
+				return err
+			}
+			// Flush periodically so large exports stream.
+			if n++; n%100 == 0 {
+				cw.Flush()
+			}
+			return cw.Error()
+		})
+		cw.Flush()
+		if err != nil {
+			return err
+		}
+		return cw.Error()
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		return s.repo.Export(ctx, func(row *repo.ExportRow) error {
+			return enc.Encode(exportRecord(row))
+		})
+	default:
+		return fmt.Errorf("%w: format must be %s or %s", ErrInvalidImport, FormatCSV, FormatNDJSON)
+	}
+}
+
+func exportRecord(row *repo.ExportRow) ImportRecord {
+	images, _ := json.Marshal(nonNil(row.Images))
+	metadata, _ := json.Marshal(row.Metadata)
+	if row.Metadata == nil {
+		metadata = []byte("{}")
+	}
+	return ImportRecord{
+		SKU:          row.SKU,
+		ParentSKU:    row.ParentSKU,
+		OptionValues: row.OptionValues,
+		Name:         &row.Name,
+		Description:  &row.Description,
+		Price:        &row.Price,
+		Category:     &row.Category,
+		Images:       images,
+		Metadata:     metadata,
+		IsActive:     &row.IsActive,
+		IsDigital:    &row.IsDigital,
+		Stock:        &row.OnHand,
+	}
+}
+
+func csvRecord(row *repo.ExportRow) []string {
+	optionValues := ""
+	if len(row.OptionValues) > 0 {
+		b, _ := json.Marshal(row.OptionValues)
+		optionValues = string(b)
+	}
+	metadata := ""
+	if len(row.Metadata) > 0 {
+		b, _ := json.Marshal(row.Metadata)
+		metadata = string(b)
+	}
+	return []string{
+		row.SKU,
+		row.ParentSKU,
+		optionValues,
+		row.Name,
+		row.Description,
+		strconv.FormatFloat(row.Price, 'f', -1, 64),
+		row.Category,
+		strings.Join(row.Images, "|"),
+		metadata,
+		strconv.FormatBool(row.IsActive),
+		strconv.FormatBool(row.IsDigital),
+		strconv.Itoa(row.OnHand),
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
+)
+
+// importRepo fakes the two repository calls Import makes; the embedded nil
+// interface panics if anything else is reached.
+type importRepo struct {
+	repo.Repository
+	existing map[string]repo.ExistingSKU
+	batches  [][]repo.ImportItem
+	dryRun   []bool
+}
+
+func (f *importRepo) LookupSKUs(_ context.Context, skus []string) (map[string]repo.ExistingSKU, error) {
+	out := map[string]repo.ExistingSKU{}
+	for _, s := range skus {
+		if e, ok := f.existing[s]; ok {
+			out[s] = e
+		}
+	}
+	return out, nil
+}
+
+func (f *importRepo) ImportBatch(_ context.Context, items []repo.ImportItem, dryRun bool) ([]repo.Product, error) {
+	f.batches = append(f.batches, items)
+	f.dryRun = append(f.dryRun, dryRun)
+	out := make([]repo.Product, len(items))
+	for i, it := range items {
+		out[i].ID = it.ID
+		if it.Create != nil {
+			out[i] = repo.Product{ID: uuid.New(), SKU: it.Create.SKU}
+		}
+	}
+	return out, nil
+}
+
+func TestImportCSV(t *testing.T) {
+	existingID := uuid.New()
+	fake := &importRepo{existing: map[string]repo.ExistingSKU{
+		"IP17-128": {ID: existingID},
+		"OLD-1":    {ID: uuid.New(), Deleted: true},
+	}}
+	svc := New(fake, nil, Options{})
+
+	input := strings.Join([]string{
+		"sku,name,price,category,images,metadata,initial_stock",
+		`IP17-256,iPhone 17 256GB,999,phones,/img/a.jpg|/img/b.jpg,"{""color"":""black""}",5`,
+		"IP17-128,,899,,,,",
+		"IP17-256,dup,1,,,,",
+		"BAD-PRICE,x,abc,,,,",
+		"OLD-1,Old,1,,,,",
+		"NEW-NONAME,,10,,,,",
+		"SHORT,row",
+		"NEG,Neg,-1,,,,",
+	}, "\n")
+	rr, err := NewRecordReader(FormatCSV, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("NewRecordReader: %v", err)
+	}
+	report, err := svc.Import(context.Background(), rr, ImportOptions{DryRun: true, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if report.Rows != 8 || report.Created != 1 || report.Updated != 1 || report.Failed != 6 {
+		t.Fatalf("report = %+v", report)
+	}
+	wantRows := map[int]string{4: "duplicate sku", 5: "price must be a number", 6: "deleted product", 7: "name is required", 8: "wrong number", 9: "price"}
+	for _, e := range report.Errors {
+		if want, ok := wantRows[e.Row]; !ok || !strings.Contains(e.Message, want) {
+			t.Errorf("row %d error %q, want containing %q", e.Row, e.Message, want)
+		}
+	}
+	for _, d := range fake.dryRun {
+		if !d {
+			t.Fatal("dry run not passed to the repository")
+		}
+	}
+
+	var create *repo.CreateProductInput
+	var update *repo.UpdateProductInput
+	for _, b := range fake.batches {
+		for _, it := range b {
+			if it.Create != nil {
+				create = it.Create
+			} else {
+				update = it.Update
+				if it.ID != existingID {
+					t.Errorf("update id = %s, want %s", it.ID, existingID)
+				}
+			}
+		}
+	}
+	if create == nil || create.InitialStock != 5 || len(create.Images) != 2 || create.Metadata["color"] != "black" || !create.IsActive {
+		t.Fatalf("create = %+v", create)
+	}
+	if update == nil || update.Price == nil || *update.Price != 899 || update.Name != nil || update.Images != nil {
+		t.Fatalf("update = %+v", update)
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	fake := &importRepo{}
+	svc := New(fake, nil, Options{})
+
+	in := `{"sku":"A-1","name":"A","price":1,"stock":3}
+
+{"sku":"B-1","name":"B","price":2,"bogus":true}
+{"sku":"C-1","name":"C","price":3,"parent_sku":"A-1","option_values":{"color":"red"}}
+`
+	rr, _ := NewRecordReader(FormatNDJSON, strings.NewReader(in))
+	report, err := svc.Import(context.Background(), rr, ImportOptions{})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if report.Created != 1 || report.Failed != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	if report.Errors[0].Row != 3 || report.Errors[1].Row != 4 {
+		t.Fatalf("errors = %+v", report.Errors)
+	}
+	if got := fake.batches[0][0].Create.InitialStock; got != 3 {
+		t.Fatalf("stock = %d, want 3", got)
+	}
+}
+
+func TestRecordReaderRejectsBadFiles(t *testing.T) {
+	for name, tc := range map[string]struct{ format, body string }{
+		"unknown format": {"xml", ""},
+		"no header":      {FormatCSV, ""},
+		"unknown column": {FormatCSV, "sku,colour\n"},
+		"no sku column":  {FormatCSV, "name,price\n"},
+	} {
+		if _, err := NewRecordReader(tc.format, strings.NewReader(tc.body)); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("%s: err = %v, want ErrInvalidImport", name, err)
+		}
+	}
+}
+
+func TestExportCSVRoundTrips(t *testing.T) {
+	row := &repo.ExportRow{
+		Product: repo.Product{
+			SKU: "IP17-256", Name: "iPhone, 17", Price: 999.5, Category: "phones",
+			Images: []string{"/a.jpg", "/b.jpg"}, Metadata: map[string]any{"color": "black"}, IsActive: true,
+		},
+		OnHand: 7,
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write(csvColumns)
+	_ = w.Write(csvRecord(row))
+	w.Flush()
+
+	rr, err := NewRecordReader(FormatCSV, &buf)
+	if err != nil {
+		t.Fatalf("NewRecordReader: %v", err)
+	}
+	rec, _, err := rr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	item, err := importItem(rec, nil)
+	if err != nil {
+		t.Fatalf("importItem: %v", err)
+	}
+	c := item.Create
+	if c.Name != row.Name || c.Price != row.Price || c.InitialStock != 7 || len(c.Images) != 2 || c.Metadata["color"] != "black" {
+		t.Fatalf("round trip = %+v", c)
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/imaging"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+	"github.com/kalen1o/iphone-storage/shared/events"
+)
+
+var (
+	ErrImageTooLarge      = errors.New("image too large")
+	ErrUnsupportedImage   = errors.New("unsupported image type (jpeg or png only)")
+	ErrStorageUnavailable = errors.New("image storage is not configured")
+)
+
+const defaultMaxImageBytes = 8 << 20
+
+// imageSizes are the downscaled variants stored next to every original,
+// named by their role and bounded on the longest side.
+var imageSizes = []struct {
+	Name    string
+	MaxSide int
+}{
+	{"thumb", 200},
+	{"medium", 800},
+}
+
+// UploadedImage describes the stored original and its variants.
+type UploadedImage struct {
+	URL      string            `json:"url"`
+	Width    int               `json:"width"`
+	Height   int               `json:"height"`
+	Variants map[string]string `json:"variants"`
+}
+
+// MaxImageBytes is the largest upload UploadImage accepts.
+func (s *Service) MaxImageBytes() int64 {
+	return s.maxImageBytes
+}
+
+// UploadImage validates data by content, stores it with its resized variants
+// and appends the original's URL to the product's images. Keys are derived
+// from the content hash, so URLs are stable and uploading the same file
+// twice is a no-op.
+func (s *Service) UploadImage(ctx context.Context, productID uuid.UUID, data []byte) (*repo.Product, *UploadedImage, error) {
+	if s.media == nil {
+		return nil, nil, ErrStorageUnavailable
+	}
+	if int64(len(data)) > s.maxImageBytes {
+		return nil, nil, ErrImageTooLarge
+	}
+
+	p, err := s.repo.GetAny(ctx, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	format, err := imaging.Sniff(data)
+	if err != nil {
+		return nil, nil, ErrUnsupportedImage
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return nil, nil, invalid("file is not a valid image")
+	}
+
+	sum := sha256.Sum256(data)
+	base := fmt.Sprintf("products/%s/%s", productID, hex.EncodeToString(sum[:12]))
+	originalKey := base + "/original." + format.Ext
+
+	out := &UploadedImage{
+		URL:      s.media.URL(originalKey),
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+		Variants: make(map[string]string, len(imageSizes)),
+	}
+
+	if slices.Contains(p.Images, out.URL) {
+		for _, size := range imageSizes {
+			out.Variants[size.Name] = s.media.URL(base + "/" + size.Name + "." + format.Ext)
+		}
+		return p, out, nil
+	}
+	if len(p.Images) >= maxImages {
+		return nil, nil, invalid(fmt.Sprintf("at most %d images are allowed", maxImages))
+	}
+
+	// Variants go up first: once the original's URL is on the product,
+	// clients may ask for any of them.
+	for _, size := range imageSizes {
+		b, err := imaging.Encode(imaging.Fit(img, size.MaxSide), format)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := base + "/" + size.Name + "." + format.Ext
+		if err := s.media.Put(ctx, key, format.ContentType, b); err != nil {
+			return nil, nil, fmt.Errorf("store %s: %w", key, err)
+		}
+		out.Variants[size.Name] = s.media.URL(key)
+	}
+	if err := s.media.Put(ctx, originalKey, format.ContentType, data); err != nil {
+		return nil, nil, fmt.Errorf("store %s: %w", originalKey, err)
+	}
+
+	p, err = s.repo.AppendImage(ctx, productID, out.URL, maxImages)
+	if err != nil {
+		if util.IsNotFound(err) {
+			// The product was full (or deleted) by the time we got here.
+			return nil, nil, invalid(fmt.Sprintf("at most %d images are allowed", maxImages))
+		}
+		return nil, nil, err
+	}
+	s.publish(ctx, p, events.ProductActionUpdated)
+	return p, out, nil
+}
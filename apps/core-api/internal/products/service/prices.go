@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/cache"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
+	"github.com/kalen1o/iphone-storage/shared/events"
+	"github.com/kalen1o/iphone-storage/shared/logging"
+)
+
+// Price window statuses, derived from the clock and the other windows.
+const (
+	PriceScheduled  = "scheduled"
+	PriceActive     = "active"
+	PriceSuperseded = "superseded"
+	PriceExpired    = "expired"
+)
+
+const (
+	maxPriceReasonLen = 200
+	// priceBackdateGrace tolerates clock skew between the admin's client and
+	// the server when effective_from is "now".
+	priceBackdateGrace = time.Minute
+)
+
+const DefaultPriceSchedulerInterval = 30 * time.Second
+
+// PriceHistory returns the price windows of a product, latest start first.
+// The public history leaves out scheduled windows and hides inactive
+// products; the admin one includes both.
+func (s *Service) PriceHistory(ctx context.Context, productID uuid.UUID, admin bool) ([]repo.Price, error) {
+	now := time.Now()
+	var startedBy *time.Time
+	if admin {
+		if _, err := s.repo.GetAny(ctx, productID); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := s.repo.GetByID(ctx, productID); err != nil {
+			return nil, err
+		}
+		startedBy = &now
+	}
+	prices, err := s.repo.ListPrices(ctx, productID, startedBy)
+	if err != nil {
+		return nil, err
+	}
+	setPriceStatuses(prices, now)
+	return prices, nil
+}
+
+// setPriceStatuses labels windows ordered latest start first, mirroring
+// product_effective_price: the first started, unexpired window is active.
+func setPriceStatuses(prices []repo.Price, now time.Time) {
+	activeSeen := false
+	for i := range prices {
+		p := &prices[i]
+		switch {
+		case p.EffectiveFrom.After(now):
+			p.Status = PriceScheduled
+		case p.EffectiveTo != nil && !p.EffectiveTo.After(now):
+			p.Status = PriceExpired
+		case !activeSeen:
+			p.Status = PriceActive
+			activeSeen = true
+		default:
+			p.Status = PriceSuperseded
+		}
+	}
+}
+
+type SchedulePriceInput struct {
+	Price float64
+	// EffectiveFrom defaults to now.
+	EffectiveFrom *time.Time
+	// EffectiveTo nil leaves the window open-ended.
+	EffectiveTo *time.Time
+	Reason      string
+	CreatedBy   *uuid.UUID
+}
+
+// SchedulePrice adds a price window. A window starting now takes effect
+// immediately; later ones are applied by the price scheduler.
+func (s *Service) SchedulePrice(ctx context.Context, productID uuid.UUID, in SchedulePriceInput) (*repo.Price, error) {
+	if err := validatePrice(in.Price); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	from := now
+	if in.EffectiveFrom != nil {
+		if in.EffectiveFrom.Before(now.Add(-priceBackdateGrace)) {
+			return nil, invalid("effective_from must not be in the past")
+		}
+		if in.EffectiveFrom.After(now) {
+			from = *in.EffectiveFrom
+		}
+	}
+	if in.EffectiveTo != nil && !in.EffectiveTo.After(from) {
+		return nil, invalid("effective_to must be after effective_from")
+	}
+	reason := strings.TrimSpace(in.Reason)
+	if len(reason) > maxPriceReasonLen {
+		return nil, invalid(fmt.Sprintf("reason must be at most %d characters", maxPriceReasonLen))
+	}
+
+	price, err := s.repo.CreatePrice(ctx, repo.CreatePriceInput{
+		ProductID:     productID,
+		Price:         in.Price,
+		EffectiveFrom: from,
+		EffectiveTo:   in.EffectiveTo,
+		Reason:        reason,
+		CreatedBy:     in.CreatedBy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	price.Status = PriceScheduled
+	if !price.EffectiveFrom.After(time.Now()) {
+		price.Status = PriceActive
+		if _, err := s.syncPrices(ctx, &productID); err != nil {
+			return nil, err
+		}
+	}
+	return price, nil
+}
+
+// CancelScheduledPrice deletes a window that has not started. Started
+// windows are history; end them with a new window instead.
+func (s *Service) CancelScheduledPrice(ctx context.Context, productID, priceID uuid.UUID) error {
+	return s.repo.DeleteScheduledPrice(ctx, productID, priceID)
+}
+
+// RunPriceScheduler applies price windows as they start and end, until ctx
+// is done. Every instance may run it; an advisory lock lets one sync at a
+// time.
+func (s *Service) RunPriceScheduler(ctx context.Context, interval time.Duration, log *logging.Logger) error {
+	if interval <= 0 {
+		interval = DefaultPriceSchedulerInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			n, err := s.syncPrices(ctx, nil)
+			if err != nil {
+				if errors.Is(err, context.Canceled) {
+					return err
+				}
+				log.Error("price sync failed", map[string]any{"err": err.Error()})
+				continue
+			}
+			if n > 0 {
+				log.Info("prices updated", map[string]any{"products": n})
+			}
+		}
+	}
+}
+
+// syncPrices brings products.price in line with the effective price and
+// announces every product whose price moved.
+func (s *Service) syncPrices(ctx context.Context, productID *uuid.UUID) (int, error) {
+	changed, err := s.repo.SyncPrices(ctx, productID)
+	if err != nil {
+		return 0, err
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	_ = s.cache.Invalidate(ctx, cache.NamespaceCatalog)
+	if s.producer != nil {
+		for i := range changed {
+			if b, err := productEvent(&changed[i], events.ProductActionPriceChanged); err == nil {
+				_ = s.producer.Publish(ctx, events.TopicProductsUpdated, []byte(changed[i].ID.String()), b)
+			}
+		}
+	}
+	return len(changed), nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
+)
+
+func TestSetPriceStatuses(t *testing.T) {
+	now := time.Date(2026, 11, 27, 12, 0, 0, 0, time.UTC)
+	at := func(h int) *time.Time { v := now.Add(time.Duration(h) * time.Hour); return &v }
+
+	// Latest start first, as ListPrices returns them.
+	prices := []repo.Price{
+		{EffectiveFrom: *at(48)},                       // next sale
+		{EffectiveFrom: *at(-2), EffectiveTo: at(-1)},  // flash sale, over
+		{EffectiveFrom: *at(-24), EffectiveTo: at(24)}, // black friday sale
+		{EffectiveFrom: *at(-240)},                     // base price
+		{EffectiveFrom: *at(-480), EffectiveTo: at(-240)},
+	}
+	setPriceStatuses(prices, now)
+
+	want := []string{PriceScheduled, PriceExpired, PriceActive, PriceSuperseded, PriceExpired}
+	for i, p := range prices {
+		if p.Status != want[i] {
+			t.Errorf("prices[%d].Status = %q, want %q", i, p.Status, want[i])
+		}
+	}
+}
+
+// priceRepo records CreatePrice calls; anything else panics.
+type priceRepo struct {
+	repo.Repository
+	created []repo.CreatePriceInput
+}
+
+func (f *priceRepo) CreatePrice(_ context.Context, in repo.CreatePriceInput) (*repo.Price, error) {
+	f.created = append(f.created, in)
+	return &repo.Price{ID: uuid.New(), ProductID: in.ProductID, Price: in.Price, EffectiveFrom: in.EffectiveFrom, EffectiveTo: in.EffectiveTo}, nil
+}
+
+func TestSchedulePriceValidation(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time { v := now.Add(d); return &v }
+
+	for name, in := range map[string]SchedulePriceInput{
+		"negative price":  {Price: -1, EffectiveFrom: at(time.Hour)},
+		"backdated":       {Price: 10, EffectiveFrom: at(-time.Hour)},
+		"empty window":    {Price: 10, EffectiveFrom: at(time.Hour), EffectiveTo: at(time.Hour)},
+		"ends before now": {Price: 10, EffectiveTo: at(-time.Second)},
+	} {
+		fake := &priceRepo{}
+		_, err := New(fake, nil, Options{}).SchedulePrice(context.Background(), uuid.New(), in)
+		if !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("%s: err = %v, want ErrInvalidProduct", name, err)
+		}
+		if len(fake.created) != 0 {
+			t.Errorf("%s: price was stored", name)
+		}
+	}
+
+	fake := &priceRepo{}
+	p, err := New(fake, nil, Options{}).SchedulePrice(context.Background(), uuid.New(), SchedulePriceInput{
+		Price: 799, EffectiveFrom: at(24 * time.Hour), EffectiveTo: at(48 * time.Hour), Reason: "  sale ",
+	})
+	if err != nil {
+		t.Fatalf("SchedulePrice: %v", err)
+	}
+	if p.Status != PriceScheduled || fake.created[0].Reason != "sale" {
+		t.Fatalf("price = %+v, input = %+v", p, fake.created[0])
+	}
+}
@@ -2,24 +2,710 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
+	inventoryrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/cache"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/storage"
 	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+	"github.com/kalen1o/iphone-storage/shared/events"
+	"github.com/kalen1o/iphone-storage/shared/kafka"
 )
 
+var (
+	// ErrInvalidProduct is wrapped with a message naming the offending field.
+	ErrInvalidProduct = errors.New("invalid product")
+	ErrSKUTaken       = errors.New("sku already exists")
+	ErrVariantExists  = errors.New("variant with these option values already exists")
+	// ErrInvalidSearch is wrapped with a message naming the bad parameter.
+	ErrInvalidSearch = errors.New("invalid search")
+)
+
+const (
+	maxImages        = 20
+	maxOptions       = 3
+	maxOptionValues  = 30
+	maxOptionNameLen = 50
+)
+
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+type Options struct {
+	// Cache may be nil to disable caching of public reads.
+	Cache    *cache.Cache
+	CacheTTL time.Duration
+	// Media stores uploaded images; uploads fail when it is nil.
+	Media         storage.Store
+	MaxImageBytes int64
+}
+
 type Service struct {
-	repo repo.Repository
+	repo          repo.Repository
+	producer      *kafka.Producer
+	cache         *cache.Cache
+	cacheTTL      time.Duration
+	media         storage.Store
+	maxImageBytes int64
 }
 
-func New(r repo.Repository) *Service {
-	return &Service{repo: r}
+func New(r repo.Repository, producer *kafka.Producer, opts Options) *Service {
+	if opts.MaxImageBytes <= 0 {
+		opts.MaxImageBytes = defaultMaxImageBytes
+	}
+	return &Service{
+		repo:          r,
+		producer:      producer,
+		cache:         opts.Cache,
+		cacheTTL:      opts.CacheTTL,
+		media:         opts.Media,
+		maxImageBytes: opts.MaxImageBytes,
+	}
 }
 
-func (s *Service) List(ctx context.Context, limit, offset int) ([]repo.Product, error) {
-	return s.repo.List(ctx, limit, offset)
+const (
+	maxSearchQueryLen = 200
+	maxAttributes     = 5
+)
+
+var validSorts = map[string]struct{}{
+	repo.SortRelevance: {},
+	repo.SortNewest:    {},
+	repo.SortPriceAsc:  {},
+	repo.SortPriceDesc: {},
+	repo.SortNameAsc:   {},
 }
 
+// Search lists the public catalog with optional full-text query, filters,
+// sort and facet counts.
+func (s *Service) Search(ctx context.Context, params repo.SearchParams) (*repo.SearchResult, error) {
+	params.Query = strings.TrimSpace(params.Query)
+	if len(params.Query) > maxSearchQueryLen {
+		return nil, fmt.Errorf("%w: q must be at most %d characters", ErrInvalidSearch, maxSearchQueryLen)
+	}
+	if params.Sort != "" {
+		if _, ok := validSorts[params.Sort]; !ok {
+			return nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidSearch, params.Sort)
+		}
+	}
+	if params.MinPrice != nil && *params.MinPrice < 0 {
+		return nil, fmt.Errorf("%w: min_price must be >= 0", ErrInvalidSearch)
+	}
+	if params.MinPrice != nil && params.MaxPrice != nil && *params.MinPrice > *params.MaxPrice {
+		return nil, fmt.Errorf("%w: min_price must not exceed max_price", ErrInvalidSearch)
+	}
+	if len(params.Attributes) > maxAttributes {
+		return nil, fmt.Errorf("%w: at most %d attribute filters are allowed", ErrInvalidSearch, maxAttributes)
+	}
+	if params.Offset < 0 {
+		return nil, fmt.Errorf("%w: offset must be >= 0", ErrInvalidSearch)
+	}
+	if repo.KeysetPaged(repo.ResolveSort(params.Sort, params.Query)) {
+		if params.Offset > 0 {
+			return nil, fmt.Errorf("%w: use cursor instead of offset with sort=newest", ErrInvalidSearch)
+		}
+	} else if params.Page.Cursor != nil {
+		return nil, fmt.Errorf("%w: cursor is only supported with sort=newest; use offset", ErrInvalidSearch)
+	}
+	return cache.Get(ctx, s.cache, cache.Spec{
+		Endpoint:   "products.search",
+		Key:        cache.HashKey(params),
+		Namespaces: []string{cache.NamespaceCatalog, cache.NamespaceStock},
+		TTL:        s.cacheTTL,
+	}, func(ctx context.Context) (*repo.SearchResult, error) {
+		return s.repo.Search(ctx, params)
+	})
+}
+
+func (s *Service) Suggest(ctx context.Context, prefix string, limit int) ([]repo.Suggestion, error) {
+	prefix = strings.TrimSpace(prefix)
+	if len(prefix) > maxSearchQueryLen {
+		prefix = prefix[:maxSearchQueryLen]
+	}
+	return cache.Get(ctx, s.cache, cache.Spec{
+		Endpoint:   "products.suggest",
+		Key:        cache.HashKey([]any{prefix, limit}),
+		Namespaces: []string{cache.NamespaceCatalog},
+		TTL:        s.cacheTTL,
+	}, func(ctx context.Context) ([]repo.Suggestion, error) {
+		return s.repo.Suggest(ctx, prefix, limit)
+	})
+}
+
+// GetByID returns an active product. For a parent product the option matrix
+// and its active variants with availability are included.
 func (s *Service) GetByID(ctx context.Context, id uuid.UUID) (*repo.Product, error) {
-	return s.repo.GetByID(ctx, id)
+	// Variant availability is part of the detail, hence the stock namespace.
+	return cache.Get(ctx, s.cache, cache.Spec{
+		Endpoint:   "products.get",
+		Key:        id.String(),
+		Namespaces: []string{cache.NamespaceCatalog, cache.NamespaceStock},
+		TTL:        s.cacheTTL,
+	}, func(ctx context.Context) (*repo.Product, error) {
+		p, err := s.repo.GetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.loadVariants(ctx, p, false); err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+}
+
+// GetForAdmin returns a product including inactive ones and inactive variants.
+func (s *Service) GetForAdmin(ctx context.Context, id uuid.UUID) (*repo.Product, error) {
+	p, err := s.repo.GetAny(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadVariants(ctx, p, true); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *Service) loadVariants(ctx context.Context, p *repo.Product, includeInactive bool) error {
+	if p.ParentID != nil {
+		return nil
+	}
+	options, err := s.repo.GetOptions(ctx, p.ID)
+	if err != nil {
+		return err
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	variants, err := s.repo.ListVariants(ctx, p.ID, includeInactive)
+	if err != nil {
+		return err
+	}
+	p.Options = options
+	p.Variants = variants
+	return nil
+}
+
+// CreateInput carries images and metadata as raw JSON so their shape can be
+// validated before anything is written.
+type CreateInput struct {
+	Name         string
+	Description  string
+	SKU          string
+	Price        float64
+	Category     string
+	Images       json.RawMessage
+	Metadata     json.RawMessage
+	IsActive     *bool
+	IsDigital    bool
+	InitialStock int
+	Selling      repo.Selling
+}
+
+type UpdateInput struct {
+	Name        *string
+	Description *string
+	SKU         *string
+	Price       *float64
+	Category    *string
+	Images      json.RawMessage
+	Metadata    json.RawMessage
+	IsDigital   *bool
+	// The selling fields are merged into the product's current ones.
+	SellMode      *string
+	ReleaseDate   *time.Time
+	PreorderLimit *int
+}
+
+func (s *Service) Create(ctx context.Context, in CreateInput) (*repo.Product, error) {
+	name := strings.TrimSpace(in.Name)
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	sku := strings.TrimSpace(in.SKU)
+	if err := validateSKU(sku); err != nil {
+		return nil, err
+	}
+	if err := validatePrice(in.Price); err != nil {
+		return nil, err
+	}
+	if in.InitialStock < 0 {
+		return nil, invalid("initial_stock must be >= 0")
+	}
+	images, err := parseImages(in.Images)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := parseMetadata(in.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	active := true
+	if in.IsActive != nil {
+		active = *in.IsActive
+	}
+	selling, err := normalizeSelling(in.Selling)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := s.repo.Create(ctx, repo.CreateProductInput{
+		Name:         name,
+		Description:  strings.TrimSpace(in.Description),
+		SKU:          sku,
+		Price:        in.Price,
+		Category:     strings.TrimSpace(in.Category),
+		Images:       images,
+		Metadata:     metadata,
+		IsActive:     active,
+		IsDigital:    in.IsDigital,
+		InitialStock: in.InitialStock,
+		Selling:      selling,
+	})
+	if err != nil {
+		return nil, mapWriteError(err)
+	}
+	s.publish(ctx, p, events.ProductActionCreated)
+	return p, nil
+}
+
+func (s *Service) Update(ctx context.Context, id uuid.UUID, in UpdateInput) (*repo.Product, error) {
+	var out repo.UpdateProductInput
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		if err := validateName(name); err != nil {
+			return nil, err
+		}
+		out.Name = &name
+	}
+	if in.SKU != nil {
+		sku := strings.TrimSpace(*in.SKU)
+		if err := validateSKU(sku); err != nil {
+			return nil, err
+		}
+		out.SKU = &sku
+	}
+	if in.Price != nil {
+		if err := validatePrice(*in.Price); err != nil {
+			return nil, err
+		}
+		out.Price = in.Price
+	}
+	if in.Description != nil {
+		d := strings.TrimSpace(*in.Description)
+		out.Description = &d
+	}
+	if in.Category != nil {
+		c := strings.TrimSpace(*in.Category)
+		out.Category = &c
+	}
+	if len(in.Images) > 0 {
+		images, err := parseImages(in.Images)
+		if err != nil {
+			return nil, err
+		}
+		out.Images = &images
+	}
+	if len(in.Metadata) > 0 {
+		metadata, err := parseMetadata(in.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		out.Metadata = &metadata
+	}
+	out.IsDigital = in.IsDigital
+	if in.SellMode != nil || in.ReleaseDate != nil || in.PreorderLimit != nil {
+		cur, err := s.repo.GetAny(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		selling := cur.Selling
+		if in.SellMode != nil {
+			selling.SellMode = *in.SellMode
+		}
+		if in.ReleaseDate != nil {
+			selling.ReleaseDate = in.ReleaseDate
+		}
+		if in.PreorderLimit != nil {
+			selling.PreorderLimit = in.PreorderLimit
+		}
+		if selling, err = normalizeSelling(selling); err != nil {
+			return nil, err
+		}
+		out.Selling = &selling
+	}
+
+	p, err := s.repo.Update(ctx, id, out)
+	if err != nil {
+		return nil, mapWriteError(err)
+	}
+	s.publish(ctx, p, events.ProductActionUpdated)
+	return p, nil
+}
+
+// SetOptions replaces the option axes of a parent product. Existing variants
+// must still fit the new axes.
+func (s *Service) SetOptions(ctx context.Context, productID uuid.UUID, options []repo.Option) (*repo.Product, error) {
+	options, err := normalizeOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.repo.GetAny(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if p.ParentID != nil {
+		return nil, invalid("options can only be set on a parent product")
+	}
+	variants, err := s.repo.ListVariants(ctx, productID, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range variants {
+		if _, err := matchOptionValues(options, v.OptionValues); err != nil {
+			return nil, invalid(fmt.Sprintf("variant %s does not fit the new options", v.SKU))
+		}
+	}
+
+	if err := s.repo.SetOptions(ctx, productID, options); err != nil {
+		return nil, err
+	}
+	s.publish(ctx, p, events.ProductActionUpdated)
+	return s.GetForAdmin(ctx, productID)
+}
+
+// VariantInput describes a new variant. Name, price and images default to
+// the parent's when left empty.
+type VariantInput struct {
+	Name         string
+	SKU          string
+	Price        *float64
+	OptionValues map[string]string
+	Images       json.RawMessage
+	IsActive     *bool
+	InitialStock int
+}
+
+// CreateVariant adds a variant under a parent product. OptionValues must name
+// exactly one allowed value for every option of the parent.
+func (s *Service) CreateVariant(ctx context.Context, parentID uuid.UUID, in VariantInput) (*repo.Product, error) {
+	parent, err := s.repo.GetAny(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ParentID != nil {
+		return nil, invalid("variants cannot have variants")
+	}
+	options, err := s.repo.GetOptions(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(options) == 0 {
+		return nil, invalid("set options on the parent product first")
+	}
+	values, err := matchOptionValues(options, in.OptionValues)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(in.Name)
+	if name == "" {
+		parts := []string{parent.Name}
+		for _, o := range options {
+			parts = append(parts, values[o.Name])
+		}
+		name = strings.Join(parts, " ")
+	}
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	sku := strings.TrimSpace(in.SKU)
+	if err := validateSKU(sku); err != nil {
+		return nil, err
+	}
+	price := parent.Price
+	if in.Price != nil {
+		price = *in.Price
+	}
+	if err := validatePrice(price); err != nil {
+		return nil, err
+	}
+	if in.InitialStock < 0 {
+		return nil, invalid("initial_stock must be >= 0")
+	}
+	images := parent.Images
+	if len(in.Images) > 0 {
+		if images, err = parseImages(in.Images); err != nil {
+			return nil, err
+		}
+	}
+	active := true
+	if in.IsActive != nil {
+		active = *in.IsActive
+	}
+
+	p, err := s.repo.Create(ctx, repo.CreateProductInput{
+		Name:         name,
+		Description:  parent.Description,
+		SKU:          sku,
+		Price:        price,
+		Category:     parent.Category,
+		Images:       images,
+		IsActive:     active,
+		IsDigital:    parent.IsDigital,
+		ParentID:     &parent.ID,
+		OptionValues: values,
+		InitialStock: in.InitialStock,
+		// Variants launch with their parent.
+		Selling: parent.Selling,
+	})
+	if err != nil {
+		return nil, mapWriteError(err)
+	}
+	s.publish(ctx, p, events.ProductActionCreated)
+	return p, nil
+}
+
+func (s *Service) SetActive(ctx context.Context, id uuid.UUID, active bool) (*repo.Product, error) {
+	p, err := s.repo.SetActive(ctx, id, active)
+	if err != nil {
+		return nil, err
+	}
+	action := events.ProductActionDeactivated
+	if active {
+		action = events.ProductActionActivated
+	}
+	s.publish(ctx, p, action)
+	return p, nil
+}
+
+func (s *Service) Delete(ctx context.Context, id uuid.UUID) error {
+	p, err := s.repo.SoftDelete(ctx, id)
+	if err != nil {
+		return err
+	}
+	s.publish(ctx, p, events.ProductActionDeleted)
+	return nil
+}
+
+func (s *Service) publish(ctx context.Context, p *repo.Product, action string) {
+	// The products.updated consumer invalidates too; doing it here as well
+	// lets the admin read their own write immediately.
+	_ = s.cache.Invalidate(ctx, cache.NamespaceCatalog)
+
+	if s.producer == nil {
+		return
+	}
+	b, err := productEvent(p, action)
+	if err == nil {
+		_ = s.producer.Publish(ctx, events.TopicProductsUpdated, []byte(p.ID.String()), b)
+	}
+}
+
+func productEvent(p *repo.Product, action string) ([]byte, error) {
+	return events.Marshal(events.Envelope[events.ProductsUpdatedData]{
+		EventID:     uuid.NewString(),
+		Type:        events.TypeProductsUpdated,
+		OccurredAt:  time.Now().UTC(),
+		AggregateID: p.ID.String(),
+		Data: events.ProductsUpdatedData{
+			ProductID: p.ID.String(),
+			SKU:       p.SKU,
+			Action:    action,
+			Price:     p.Price,
+			IsActive:  p.IsActive,
+			Deleted:   action == events.ProductActionDeleted,
+		},
+	})
+}
+
+func invalid(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidProduct, msg)
+}
+
+func mapWriteError(err error) error {
+	if util.IsUniqueViolation(err, "products_sku_key") {
+		return ErrSKUTaken
+	}
+	if util.IsUniqueViolation(err, "idx_products_variant_options") {
+		return ErrVariantExists
+	}
+	if errors.Is(err, inventoryrepo.ErrNoWarehouse) {
+		return invalid("initial_stock needs an active warehouse")
+	}
+	return err
+}
+
+func normalizeOptions(in []repo.Option) ([]repo.Option, error) {
+	if len(in) == 0 || len(in) > maxOptions {
+		return nil, invalid(fmt.Sprintf("between 1 and %d options are required", maxOptions))
+	}
+	seen := make(map[string]struct{}, len(in))
+	out := make([]repo.Option, 0, len(in))
+	for _, o := range in {
+		name := strings.TrimSpace(o.Name)
+		if name == "" || len(name) > maxOptionNameLen {
+			return nil, invalid(fmt.Sprintf("option names must be 1-%d characters", maxOptionNameLen))
+		}
+		key := strings.ToLower(name)
+		if _, dup := seen[key]; dup {
+			return nil, invalid(fmt.Sprintf("duplicate option %q", name))
+		}
+		seen[key] = struct{}{}
+
+		if len(o.Values) == 0 || len(o.Values) > maxOptionValues {
+			return nil, invalid(fmt.Sprintf("option %q needs between 1 and %d values", name, maxOptionValues))
+		}
+		valueSeen := make(map[string]struct{}, len(o.Values))
+		values := make([]string, 0, len(o.Values))
+		for _, v := range o.Values {
+			v = strings.TrimSpace(v)
+			if v == "" || len(v) > maxOptionNameLen {
+				return nil, invalid(fmt.Sprintf("option %q has an empty or too long value", name))
+			}
+			if _, dup := valueSeen[strings.ToLower(v)]; dup {
+				return nil, invalid(fmt.Sprintf("option %q has duplicate value %q", name, v))
+			}
+			valueSeen[strings.ToLower(v)] = struct{}{}
+			values = append(values, v)
+		}
+		out = append(out, repo.Option{Name: name, Values: values})
+	}
+	return out, nil
+}
+
+// matchOptionValues checks that values picks one allowed value for every
+// option and nothing else. Matching is case-insensitive; the result uses the
+// canonical option names and values.
+func matchOptionValues(options []repo.Option, values map[string]string) (map[string]string, error) {
+	if len(values) != len(options) {
+		return nil, invalid("option_values must name one value for every option")
+	}
+	lookup := make(map[string]string, len(values))
+	for k, v := range values {
+		lookup[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	out := make(map[string]string, len(options))
+	for _, o := range options {
+		v, ok := lookup[strings.ToLower(o.Name)]
+		if !ok {
+			return nil, invalid(fmt.Sprintf("option_values is missing %q", o.Name))
+		}
+		canonical := ""
+		for _, allowed := range o.Values {
+			if strings.EqualFold(allowed, v) {
+				canonical = allowed
+				break
+			}
+		}
+		if canonical == "" {
+			return nil, invalid(fmt.Sprintf("%q is not a value of option %q", v, o.Name))
+		}
+		out[o.Name] = canonical
+	}
+	return out, nil
+}
+
+func validateName(name string) error {
+	if name == "" || len(name) > 255 {
+		return invalid("name is required and must be at most 255 characters")
+	}
+	return nil
+}
+
+func validateSKU(sku string) error {
+	if !skuPattern.MatchString(sku) {
+		return invalid("sku must be 1-100 letters, digits, '.', '_' or '-'")
+	}
+	return nil
+}
+
+func validatePrice(price float64) error {
+	// DECIMAL(10, 2) tops out just below 1e8.
+	if math.IsNaN(price) || price < 0 || price >= 1e8 {
+		return invalid("price must be between 0 and 99999999.99")
+	}
+	return nil
+}
+
+// normalizeSelling defaults the sell mode and drops the preorder settings
+// from other modes. A preorder limit of 0 means no limit.
+func normalizeSelling(s repo.Selling) (repo.Selling, error) {
+	switch s.SellMode {
+	case "":
+		s.SellMode = repo.SellInStockOnly
+	case repo.SellInStockOnly, repo.SellPreorder, repo.SellBackorder:
+	default:
+		return s, invalid("sell_mode must be in_stock_only, preorder or backorder")
+	}
+	if s.SellMode != repo.SellPreorder {
+		s.ReleaseDate, s.PreorderLimit = nil, nil
+		return s, nil
+	}
+	if s.PreorderLimit != nil {
+		if *s.PreorderLimit < 0 {
+			return s, invalid("preorder_limit must be >= 0")
+		}
+		if *s.PreorderLimit == 0 {
+			s.PreorderLimit = nil
+		}
+	}
+	return s, nil
+}
+
+// parseImages accepts a JSON array of absolute http(s) URLs or root-relative
+// paths. An absent value yields an empty list.
+func parseImages(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return []string{}, nil
+	}
+	var images []string
+	if err := json.Unmarshal(raw, &images); err != nil {
+		return nil, invalid("images must be an array of strings")
+	}
+	if len(images) > maxImages {
+		return nil, invalid(fmt.Sprintf("at most %d images are allowed", maxImages))
+	}
+	for i, img := range images {
+		img = strings.TrimSpace(img)
+		if !validImageRef(img) {
+			return nil, invalid(fmt.Sprintf("images[%d] must be an http(s) URL or an absolute path", i))
+		}
+		images[i] = img
+	}
+	return images, nil
+}
+
+func validImageRef(s string) bool {
+	if s == "" || len(s) > 2048 {
+		return false
+	}
+	if strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") {
+		return true
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// parseMetadata accepts a JSON object. An absent value yields an empty object.
+func parseMetadata(raw json.RawMessage) (map[string]any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return map[string]any{}, nil
+	}
+	var metadata map[string]any
+	if err := json.Unmarshal(raw, &metadata); err != nil || metadata == nil {
+		return nil, invalid("metadata must be a JSON object")
+	}
+	return metadata, nil
 }
@@ -0,0 +1,471 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	inventoryrepo "github.com/kalen1o/iphone-storage/apps/core-api/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/pagination"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/products/repo"
+)
+
+// crudRepo records writes and serves current from GetAny; anything else
+// panics.
+type crudRepo struct {
+	repo.Repository
+	current  *repo.Product
+	err      error
+	created  []repo.CreateProductInput
+	updated  []repo.UpdateProductInput
+	gotAnyOf []uuid.UUID
+}
+
+func (f *crudRepo) GetAny(_ context.Context, id uuid.UUID) (*repo.Product, error) {
+	f.gotAnyOf = append(f.gotAnyOf, id)
+	if f.current == nil {
+		return nil, errors.New("no product")
+	}
+	p := *f.current
+	return &p, nil
+}
+
+func (f *crudRepo) Create(_ context.Context, in repo.CreateProductInput) (*repo.Product, error) {
+	f.created = append(f.created, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &repo.Product{ID: uuid.New(), Name: in.Name, SKU: in.SKU, Price: in.Price, ParentID: in.ParentID}, nil
+}
+
+func (f *crudRepo) Update(_ context.Context, id uuid.UUID, in repo.UpdateProductInput) (*repo.Product, error) {
+	f.updated = append(f.updated, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &repo.Product{ID: id}, nil
+}
+
+func TestCreate_Validation(t *testing.T) {
+	valid := func(change func(*CreateInput)) CreateInput {
+		in := CreateInput{Name: "iPhone 17", SKU: "IP17-256", Price: 999}
+		change(&in)
+		return in
+	}
+	for name, in := range map[string]CreateInput{
+		"no name":             valid(func(in *CreateInput) { in.Name = "  " }),
+		"long name":           valid(func(in *CreateInput) { in.Name = string(make([]byte, 256)) }),
+		"no sku":              valid(func(in *CreateInput) { in.SKU = "" }),
+		"sku with spaces":     valid(func(in *CreateInput) { in.SKU = "IP 17" }),
+		"sku starting with -": valid(func(in *CreateInput) { in.SKU = "-IP17" }),
+		"negative price":      valid(func(in *CreateInput) { in.Price = -1 }),
+		"price too large":     valid(func(in *CreateInput) { in.Price = 1e8 }),
+		"NaN price":           valid(func(in *CreateInput) { in.Price = math.NaN() }),
+		"negative stock":      valid(func(in *CreateInput) { in.InitialStock = -1 }),
+		"images not an array": valid(func(in *CreateInput) { in.Images = json.RawMessage(`"/a.jpg"`) }),
+		"relative image":      valid(func(in *CreateInput) { in.Images = json.RawMessage(`["a.jpg"]`) }),
+		"metadata array":      valid(func(in *CreateInput) { in.Metadata = json.RawMessage(`[1]`) }),
+		"unknown sell mode":   valid(func(in *CreateInput) { in.Selling.SellMode = "raffle" }),
+		"negative preorders": valid(func(in *CreateInput) {
+			limit := -1
+			in.Selling = repo.Selling{SellMode: repo.SellPreorder, PreorderLimit: &limit}
+		}),
+	} {
+		fake := &crudRepo{}
+		if _, err := New(fake, nil, Options{}).Create(context.Background(), in); !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("%s: err = %v, want ErrInvalidProduct", name, err)
+		}
+		if len(fake.created) != 0 {
+			t.Errorf("%s: product was created", name)
+		}
+	}
+}
+
+func TestCreate_NormalizesInput(t *testing.T) {
+	fake := &crudRepo{}
+	release := time.Date(2026, 9, 19, 8, 0, 0, 0, time.UTC)
+	_, err := New(fake, nil, Options{}).Create(context.Background(), CreateInput{
+		Name:         " iPhone 17 ",
+		Description:  " The new one ",
+		SKU:          " IP17-256 ",
+		Price:        999,
+		Category:     " smartphones ",
+		Images:       json.RawMessage(`[" /img/front.jpg ", "https://cdn.example.com/back.jpg"]`),
+		InitialStock: 5,
+		// Release date is dropped outside of preorders.
+		Selling: repo.Selling{SellMode: repo.SellBackorder, ReleaseDate: &release},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	want := repo.CreateProductInput{
+		Name:         "iPhone 17",
+		Description:  "The new one",
+		SKU:          "IP17-256",
+		Price:        999,
+		Category:     "smartphones",
+		Images:       []string{"/img/front.jpg", "https://cdn.example.com/back.jpg"},
+		Metadata:     map[string]any{},
+		IsActive:     true,
+		InitialStock: 5,
+		Selling:      repo.Selling{SellMode: repo.SellBackorder},
+	}
+	if len(fake.created) != 1 || !reflect.DeepEqual(fake.created[0], want) {
+		t.Fatalf("created = %+v, want %+v", fake.created, want)
+	}
+}
+
+func TestCreate_MapsConstraintErrors(t *testing.T) {
+	for constraint, want := range map[string]error{
+		"products_sku_key":             ErrSKUTaken,
+		"idx_products_variant_options": ErrVariantExists,
+	} {
+		fake := &crudRepo{err: &pgconn.PgError{Code: "23505", ConstraintName: constraint}}
+		_, err := New(fake, nil, Options{}).Create(context.Background(), CreateInput{Name: "iPhone 17", SKU: "IP17-256", Price: 999})
+		if !errors.Is(err, want) {
+			t.Errorf("%s: err = %v, want %v", constraint, err, want)
+		}
+	}
+
+	fake := &crudRepo{err: inventoryrepo.ErrNoWarehouse}
+	_, err := New(fake, nil, Options{}).Create(context.Background(), CreateInput{Name: "iPhone 17", SKU: "IP17-256", Price: 999, InitialStock: 3})
+	if !errors.Is(err, ErrInvalidProduct) {
+		t.Errorf("no warehouse: err = %v, want ErrInvalidProduct", err)
+	}
+}
+
+func TestUpdate_MergesSellingIntoTheCurrentProduct(t *testing.T) {
+	release := time.Date(2026, 9, 19, 8, 0, 0, 0, time.UTC)
+	limit, zero := 500, 0
+	preorder := repo.Selling{SellMode: repo.SellPreorder, ReleaseDate: &release, PreorderLimit: &limit}
+	inStock := repo.SellInStockOnly
+	later := release.Add(24 * time.Hour)
+
+	for name, tc := range map[string]struct {
+		in   UpdateInput
+		want *repo.Selling
+	}{
+		"selling untouched": {in: UpdateInput{}},
+		"new release date keeps the limit": {
+			in:   UpdateInput{ReleaseDate: &later},
+			want: &repo.Selling{SellMode: repo.SellPreorder, ReleaseDate: &later, PreorderLimit: &limit},
+		},
+		"zero limit removes it": {
+			in:   UpdateInput{PreorderLimit: &zero},
+			want: &repo.Selling{SellMode: repo.SellPreorder, ReleaseDate: &release},
+		},
+		"leaving preorder clears its settings": {
+			in:   UpdateInput{SellMode: &inStock},
+			want: &repo.Selling{SellMode: repo.SellInStockOnly},
+		},
+	} {
+		fake := &crudRepo{current: &repo.Product{Selling: preorder}}
+		if _, err := New(fake, nil, Options{}).Update(context.Background(), uuid.New(), tc.in); err != nil {
+			t.Fatalf("%s: Update: %v", name, err)
+		}
+		if got := fake.updated[0].Selling; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Selling = %+v, want %+v", name, got, tc.want)
+		}
+		if tc.want == nil && len(fake.gotAnyOf) != 0 {
+			t.Errorf("%s: current product read without a selling change", name)
+		}
+	}
+}
+
+func TestUpdate_Validation(t *testing.T) {
+	empty, bad, negative := "", "IP 17", -0.01
+	for name, in := range map[string]UpdateInput{
+		"empty name":     {Name: &empty},
+		"bad sku":        {SKU: &bad},
+		"negative price": {Price: &negative},
+		"bad images":     {Images: json.RawMessage(`["//cdn.example.com/a.jpg"]`)},
+		"bad metadata":   {Metadata: json.RawMessage(`"color"`)},
+	} {
+		fake := &crudRepo{}
+		if _, err := New(fake, nil, Options{}).Update(context.Background(), uuid.New(), in); !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("%s: err = %v, want ErrInvalidProduct", name, err)
+		}
+		if len(fake.updated) != 0 {
+			t.Errorf("%s: product was updated", name)
+		}
+	}
+
+	// An empty description or category is passed on to clear the column.
+	fake := &crudRepo{}
+	blank := "  "
+	if _, err := New(fake, nil, Options{}).Update(context.Background(), uuid.New(), UpdateInput{Description: &blank, Category: &blank}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if in := fake.updated[0]; in.Description == nil || *in.Description != "" || in.Category == nil || *in.Category != "" {
+		t.Fatalf("update = %+v, want empty description and category", in)
+	}
+}
+
+func TestParseImagesAndMetadata(t *testing.T) {
+	for raw, want := range map[string][]string{
+		``:     {},
+		`null`: {},
+		`[]`:   {},
+		`["/a.jpg", " http://cdn.example.com/b.png "]`: {"/a.jpg", "http://cdn.example.com/b.png"},
+	} {
+		got, err := parseImages(json.RawMessage(raw))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("parseImages(%s) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{`{}`, `[1]`, `["ftp://example.com/a.jpg"]`, `["https://"]`, `[""]`} {
+		if _, err := parseImages(json.RawMessage(raw)); !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("parseImages(%s): err = %v, want ErrInvalidProduct", raw, err)
+		}
+	}
+	tooMany, _ := json.Marshal(make([]string, maxImages+1))
+	if _, err := parseImages(tooMany); !errors.Is(err, ErrInvalidProduct) {
+		t.Errorf("parseImages of %d images: err = %v, want ErrInvalidProduct", maxImages+1, err)
+	}
+
+	if got, err := parseMetadata(json.RawMessage(`{"storage": "256GB"}`)); err != nil || got["storage"] != "256GB" {
+		t.Errorf("parseMetadata = %v, %v", got, err)
+	}
+	for _, raw := range []string{`[]`, `"x"`, `1`, `{`} {
+		if _, err := parseMetadata(json.RawMessage(raw)); !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("parseMetadata(%s): err = %v, want ErrInvalidProduct", raw, err)
+		}
+	}
+}
+
+func TestNormalizeOptions(t *testing.T) {
+	got, err := normalizeOptions([]repo.Option{
+		{Name: " Storage ", Values: []string{" 128GB", "256GB "}},
+		{Name: "Color", Values: []string{"Black", "White"}},
+	})
+	want := []repo.Option{{Name: "Storage", Values: []string{"128GB", "256GB"}}, {Name: "Color", Values: []string{"Black", "White"}}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("normalizeOptions = %+v, %v; want %+v", got, err, want)
+	}
+
+	values := func(n int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = strconv.Itoa(i)
+		}
+		return out
+	}
+	for name, in := range map[string][]repo.Option{
+		"none":             nil,
+		"too many":         {{Name: "a", Values: []string{"x"}}, {Name: "b", Values: []string{"x"}}, {Name: "c", Values: []string{"x"}}, {Name: "d", Values: []string{"x"}}},
+		"blank name":       {{Name: " ", Values: []string{"x"}}},
+		"duplicate name":   {{Name: "Color", Values: []string{"x"}}, {Name: "color", Values: []string{"y"}}},
+		"no values":        {{Name: "Color"}},
+		"too many values":  {{Name: "Color", Values: values(maxOptionValues + 1)}},
+		"blank value":      {{Name: "Color", Values: []string{"Black", " "}}},
+		"duplicate value":  {{Name: "Color", Values: []string{"Black", "black"}}},
+		"long option name": {{Name: string(make([]byte, maxOptionNameLen+1)), Values: []string{"x"}}},
+	} {
+		if _, err := normalizeOptions(in); !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("%s: err = %v, want ErrInvalidProduct", name, err)
+		}
+	}
+}
+
+func TestMatchOptionValues(t *testing.T) {
+	options := []repo.Option{{Name: "Storage", Values: []string{"128GB", "256GB"}}, {Name: "Color", Values: []string{"Black", "White"}}}
+
+	got, err := matchOptionValues(options, map[string]string{" storage": "256gb ", "COLOR": "white"})
+	want := map[string]string{"Storage": "256GB", "Color": "White"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("matchOptionValues = %v, %v; want the canonical %v", got, err, want)
+	}
+
+	for name, values := range map[string]map[string]string{
+		"missing option": {"Storage": "128GB"},
+		"unknown option": {"Storage": "128GB", "Finish": "Matte"},
+		"unknown value":  {"Storage": "1TB", "Color": "Black"},
+		"extra option":   {"Storage": "128GB", "Color": "Black", "Finish": "Matte"},
+	} {
+		if _, err := matchOptionValues(options, values); !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("%s: err = %v, want ErrInvalidProduct", name, err)
+		}
+	}
+}
+
+// variantRepo is crudRepo with a parent's options and variants.
+type variantRepo struct {
+	crudRepo
+	options  []repo.Option
+	variants []repo.Variant
+	setTo    [][]repo.Option
+}
+
+func (f *variantRepo) GetOptions(context.Context, uuid.UUID) ([]repo.Option, error) {
+	return f.options, nil
+}
+
+func (f *variantRepo) ListVariants(context.Context, uuid.UUID, bool) ([]repo.Variant, error) {
+	return f.variants, nil
+}
+
+func (f *variantRepo) SetOptions(_ context.Context, _ uuid.UUID, options []repo.Option) error {
+	f.setTo = append(f.setTo, options)
+	f.options = options
+	return nil
+}
+
+func TestCreateVariant_DefaultsToTheParent(t *testing.T) {
+	release := time.Date(2026, 9, 19, 8, 0, 0, 0, time.UTC)
+	parent := &repo.Product{
+		ID: uuid.New(), Name: "iPhone 17", Description: "The new one", Price: 999, Category: "smartphones",
+		Images: []string{"/img/iphone.jpg"}, Selling: repo.Selling{SellMode: repo.SellPreorder, ReleaseDate: &release},
+	}
+	fake := &variantRepo{
+		crudRepo: crudRepo{current: parent},
+		options:  []repo.Option{{Name: "Storage", Values: []string{"128GB", "256GB"}}, {Name: "Color", Values: []string{"Black", "White"}}},
+	}
+	svc := New(fake, nil, Options{})
+
+	if _, err := svc.CreateVariant(context.Background(), parent.ID, VariantInput{
+		SKU: "IP17-256-WHT", OptionValues: map[string]string{"color": "white", "storage": "256gb"}, InitialStock: 3,
+	}); err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+	want := repo.CreateProductInput{
+		Name: "iPhone 17 256GB White", Description: "The new one", SKU: "IP17-256-WHT", Price: 999, Category: "smartphones",
+		Images: []string{"/img/iphone.jpg"}, IsActive: true, ParentID: &parent.ID,
+		OptionValues: map[string]string{"Storage": "256GB", "Color": "White"}, InitialStock: 3, Selling: parent.Selling,
+	}
+	if len(fake.created) != 1 || !reflect.DeepEqual(fake.created[0], want) {
+		t.Fatalf("created = %+v, want %+v", fake.created, want)
+	}
+
+	price := 1099.0
+	if _, err := svc.CreateVariant(context.Background(), parent.ID, VariantInput{
+		Name: "iPhone 17 Special", SKU: "IP17-SPECIAL", Price: &price, Images: json.RawMessage(`["/img/special.jpg"]`),
+		OptionValues: map[string]string{"Storage": "128GB", "Color": "Black"},
+	}); err != nil {
+		t.Fatalf("CreateVariant with overrides: %v", err)
+	}
+	if got := fake.created[1]; got.Name != "iPhone 17 Special" || got.Price != price || !reflect.DeepEqual(got.Images, []string{"/img/special.jpg"}) {
+		t.Fatalf("created = %+v, want the given name, price and images", got)
+	}
+}
+
+func TestCreateVariant_Rejects(t *testing.T) {
+	parentID := uuid.New()
+	options := []repo.Option{{Name: "Color", Values: []string{"Black"}}}
+	black := map[string]string{"Color": "Black"}
+
+	for name, tc := range map[string]struct {
+		parent  repo.Product
+		options []repo.Option
+		in      VariantInput
+	}{
+		"variant of a variant":   {parent: repo.Product{ParentID: &parentID}, options: options, in: VariantInput{SKU: "V", OptionValues: black}},
+		"parent without options": {in: VariantInput{SKU: "V", OptionValues: black}},
+		"value outside options":  {options: options, in: VariantInput{SKU: "V", OptionValues: map[string]string{"Color": "Red"}}},
+		"bad sku":                {options: options, in: VariantInput{SKU: "V 1", OptionValues: black}},
+		"negative stock":         {options: options, in: VariantInput{SKU: "V", OptionValues: black, InitialStock: -1}},
+	} {
+		tc.parent.Name = "iPhone 17"
+		fake := &variantRepo{crudRepo: crudRepo{current: &tc.parent}, options: tc.options}
+		if _, err := New(fake, nil, Options{}).CreateVariant(context.Background(), uuid.New(), tc.in); !errors.Is(err, ErrInvalidProduct) {
+			t.Errorf("%s: err = %v, want ErrInvalidProduct", name, err)
+		}
+		if len(fake.created) != 0 {
+			t.Errorf("%s: variant was created", name)
+		}
+	}
+}
+
+func TestSetOptions_KeepsExistingVariantsValid(t *testing.T) {
+	fake := &variantRepo{
+		crudRepo: crudRepo{current: &repo.Product{ID: uuid.New(), Name: "iPhone 17"}},
+		options:  []repo.Option{{Name: "Color", Values: []string{"Black", "White"}}},
+		variants: []repo.Variant{{SKU: "IP17-WHT", OptionValues: map[string]string{"Color": "White"}}},
+	}
+	svc := New(fake, nil, Options{})
+
+	if _, err := svc.SetOptions(context.Background(), fake.current.ID, []repo.Option{{Name: "Color", Values: []string{"Black"}}}); !errors.Is(err, ErrInvalidProduct) {
+		t.Fatalf("SetOptions dropping a used value: err = %v, want ErrInvalidProduct", err)
+	}
+	if len(fake.setTo) != 0 {
+		t.Fatal("options were replaced")
+	}
+	if _, err := svc.SetOptions(context.Background(), fake.current.ID, []repo.Option{{Name: "Color", Values: []string{"Black", "White", "Blue"}}}); err != nil {
+		t.Fatalf("SetOptions adding a value: %v", err)
+	}
+
+	variant := &repo.Product{ID: uuid.New(), ParentID: &fake.current.ID}
+	fake.current = variant
+	if _, err := svc.SetOptions(context.Background(), variant.ID, []repo.Option{{Name: "Color", Values: []string{"Black"}}}); !errors.Is(err, ErrInvalidProduct) {
+		t.Fatalf("SetOptions on a variant: err = %v, want ErrInvalidProduct", err)
+	}
+}
+
+// searchRepo records the searches that reach it.
+type searchRepo struct {
+	repo.Repository
+	searched []repo.SearchParams
+}
+
+func (f *searchRepo) Search(_ context.Context, p repo.SearchParams) (*repo.SearchResult, error) {
+	f.searched = append(f.searched, p)
+	return &repo.SearchResult{}, nil
+}
+
+func TestSearch_Validation(t *testing.T) {
+	low, high, negative := 50.0, 100.0, -1.0
+	cursor := &pagination.Cursor{CreatedAt: time.Now(), ID: uuid.New(), Direction: pagination.Next}
+	attrs := map[string]string{}
+	for i := 0; i <= maxAttributes; i++ {
+		attrs[strconv.Itoa(i)] = "x"
+	}
+
+	for name, p := range map[string]repo.SearchParams{
+		"long query":          {Query: strings.Repeat("a", maxSearchQueryLen+1)},
+		"unknown sort":        {Sort: "popularity"},
+		"negative min price":  {MinPrice: &negative},
+		"min above max":       {MinPrice: &high, MaxPrice: &low},
+		"too many attributes": {Attributes: attrs},
+		"negative offset":     {Offset: -1},
+		"offset with newest":  {Sort: repo.SortNewest, Offset: 20},
+		"offset by default":   {Offset: 20},
+		"cursor with price":   {Sort: repo.SortPriceAsc, Page: pagination.Params{Cursor: cursor}},
+		"cursor with a query": {Query: "iphone", Page: pagination.Params{Cursor: cursor}},
+	} {
+		fake := &searchRepo{}
+		if _, err := New(fake, nil, Options{}).Search(context.Background(), p); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("%s: err = %v, want ErrInvalidSearch", name, err)
+		}
+		if len(fake.searched) != 0 {
+			t.Errorf("%s: search reached the repository", name)
+		}
+	}
+
+	for name, p := range map[string]repo.SearchParams{
+		"defaults":            {},
+		"offset with a query": {Query: "iphone", Offset: 20},
+		"cursor by default":   {Page: pagination.Params{Cursor: cursor}},
+		"price range":         {MinPrice: &low, MaxPrice: &high, Sort: repo.SortPriceDesc},
+	} {
+		fake := &searchRepo{}
+		if _, err := New(fake, nil, Options{}).Search(context.Background(), p); err != nil || len(fake.searched) != 1 {
+			t.Errorf("%s: err = %v, searches = %d; want one search", name, err, len(fake.searched))
+		}
+	}
+
+	fake := &searchRepo{}
+	if _, err := New(fake, nil, Options{}).Search(context.Background(), repo.SearchParams{Query: "  iphone  "}); err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if q := fake.searched[0].Query; q != "iphone" {
+		t.Errorf("query = %q, want it trimmed", q)
+	}
+}
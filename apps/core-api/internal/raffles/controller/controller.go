@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/http/middleware"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/raffles/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/raffles/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+type Controller struct {
+	svc *service.Service
+}
+
+func New(svc *service.Service) *Controller {
+	return &Controller{svc: svc}
+}
+
+type RaffleListResponse struct {
+	Items []repo.Raffle `json:"items"`
+}
+
+type CreateRaffleRequest struct {
+	// ProductID is the product sold by the raffle; a product with variants
+	// is raffled per variant.
+	ProductID uuid.UUID `json:"product_id"`
+	// Quantity is the number of units drawn, one per winner.
+	Quantity       int       `json:"quantity"`
+	EntriesOpenAt  time.Time `json:"entries_open_at"`
+	EntriesCloseAt time.Time `json:"entries_close_at"`
+	DrawAt         time.Time `json:"draw_at"`
+	// ClaimWindowSeconds is how long a winner has to order before the unit
+	// passes to the next entry.
+	ClaimWindowSeconds int `json:"claim_window_seconds"`
+}
+
+// ListRaffles godoc
+// @Summary List live raffles
+// @Tags raffles
+// @Produce json
+// @Success 200 {object} RaffleListResponse
+// @Router /api/raffles [get]
+func (c *Controller) ListRaffles(w http.ResponseWriter, r *http.Request) {
+	c.list(w, r, true)
+}
+
+// GetRaffle godoc
+// @Summary Get a raffle
+// @Description The seed is revealed once the raffle is drawn; seed_hash is its SHA-256.
+// @Tags raffles
+// @Produce json
+// @Param id path string true "Raffle ID (uuid)"
+// @Success 200 {object} repo.Raffle
+// @Failure 404 {object} map[string]any
+// @Router /api/raffles/{id} [get]
+func (c *Controller) GetRaffle(w http.ResponseWriter, r *http.Request) {
+	id, ok := raffleID(w, r)
+	if !ok {
+		return
+	}
+	raffle, err := c.svc.Get(r.Context(), id)
+	if err != nil {
+		writeRaffleError(w, err, "failed to get raffle")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, raffle)
+}
+
+// GetDraw godoc
+// @Summary Get a raffle's draw
+// @Description Every entry in rank order with its draw_hash, the hex SHA-256 of the seed followed by the entrant's user id. Entries are ranked by draw_hash, then user id.
+// @Tags raffles
+// @Produce json
+// @Param id path string true "Raffle ID (uuid)"
+// @Success 200 {object} service.Draw
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any "not drawn yet"
+// @Router /api/raffles/{id}/draw [get]
+func (c *Controller) GetDraw(w http.ResponseWriter, r *http.Request) {
+	id, ok := raffleID(w, r)
+	if !ok {
+		return
+	}
+	draw, err := c.svc.Draw(r.Context(), id)
+	if err != nil {
+		writeRaffleError(w, err, "failed to get draw")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, draw)
+}
+
+// EnterRaffle godoc
+// @Summary Enter a raffle
+// @Description Entering again returns the same entry.
+// @Tags raffles
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Raffle ID (uuid)"
+// @Success 200 {object} repo.Entry
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any "code raffle_entries_closed"
+// @Router /api/raffles/{id}/entries [post]
+func (c *Controller) EnterRaffle(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	id, ok := raffleID(w, r)
+	if !ok {
+		return
+	}
+	entry, err := c.svc.Enter(r.Context(), id, userID)
+	if err != nil {
+		writeRaffleError(w, err, "failed to enter raffle")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, entry)
+}
+
+// GetMyEntry godoc
+// @Summary Get my raffle entry
+// @Description A won entry may order one unit until claim_expires_at.
+// @Tags raffles
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Raffle ID (uuid)"
+// @Success 200 {object} repo.Entry
+// @Failure 404 {object} map[string]any
+// @Router /api/raffles/{id}/entry [get]
+func (c *Controller) GetMyEntry(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	id, ok := raffleID(w, r)
+	if !ok {
+		return
+	}
+	entry, err := c.svc.Entry(r.Context(), id, userID)
+	if err != nil {
+		writeRaffleError(w, err, "failed to get entry")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpjson.WriteJSON(w, http.StatusOK, entry)
+}
+
+// AdminListRaffles godoc
+// @Summary List raffles
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} RaffleListResponse
+// @Router /api/admin/raffles [get]
+func (c *Controller) AdminListRaffles(w http.ResponseWriter, r *http.Request) {
+	c.list(w, r, false)
+}
+
+// CreateRaffle godoc
+// @Summary Schedule a raffle
+// @Description While the raffle is live its product can only be ordered by winners. Raffled products must not be on the inventory fast path.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body CreateRaffleRequest true "Raffle"
+// @Success 201 {object} repo.Raffle
+// @Failure 400 {object} map[string]any
+// @Failure 409 {object} map[string]any "the product already has a live raffle"
+// @Router /api/admin/raffles [post]
+func (c *Controller) CreateRaffle(w http.ResponseWriter, r *http.Request) {
+	var req CreateRaffleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	raffle, err := c.svc.Create(r.Context(), service.CreateInput{
+		ProductID:          req.ProductID,
+		Quantity:           req.Quantity,
+		EntriesOpenAt:      req.EntriesOpenAt,
+		EntriesCloseAt:     req.EntriesCloseAt,
+		DrawAt:             req.DrawAt,
+		ClaimWindowSeconds: req.ClaimWindowSeconds,
+	})
+	if err != nil {
+		writeRaffleError(w, err, "failed to create raffle")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusCreated, raffle)
+}
+
+// CancelRaffle godoc
+// @Summary Cancel a raffle
+// @Description Units held for winners are given back; units already ordered stay with their orders.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Raffle ID (uuid)"
+// @Success 200 {object} repo.Raffle
+// @Failure 404 {object} map[string]any
+// @Failure 409 {object} map[string]any "not live"
+// @Router /api/admin/raffles/{id}/cancel [post]
+func (c *Controller) CancelRaffle(w http.ResponseWriter, r *http.Request) {
+	id, ok := raffleID(w, r)
+	if !ok {
+		return
+	}
+	raffle, err := c.svc.Cancel(r.Context(), id)
+	if err != nil {
+		writeRaffleError(w, err, "failed to cancel raffle")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, raffle)
+}
+
+func (c *Controller) list(w http.ResponseWriter, r *http.Request, live bool) {
+	items, err := c.svc.List(r.Context(), live)
+	if err != nil {
+		httpjson.WriteError(w, http.StatusInternalServerError, "failed to list raffles")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, RaffleListResponse{Items: items})
+}
+
+func raffleID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid id")
+		return uuid.UUID{}, false
+	}
+	return id, true
+}
+
+func currentUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	raw, ok := middleware.UserIDFromContext(r.Context())
+	if ok {
+		if id, err := uuid.Parse(raw); err == nil {
+			return id, true
+		}
+	}
+	httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+	return uuid.UUID{}, false
+}
+
+func writeRaffleError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidRaffle):
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrEntriesClosed):
+		httpjson.WriteErrorCode(w, http.StatusConflict, "raffle_entries_closed", err.Error())
+	case errors.Is(err, service.ErrRaffleExists), errors.Is(err, service.ErrNotLive), errors.Is(err, service.ErrNotDrawn):
+		httpjson.WriteError(w, http.StatusConflict, err.Error())
+	case util.IsNotFound(err):
+		httpjson.WriteError(w, http.StatusNotFound, "not found")
+	default:
+		httpjson.WriteError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,138 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+// raffleColumns are read from raffles aliased r; the seed is only revealed
+// once the raffle is drawn.
+const raffleColumns = `r.id, r.product_id, r.quantity, r.entries_open_at, r.entries_close_at, r.draw_at,
+	r.claim_window_seconds, r.seed_hash, CASE WHEN r.drawn_at IS NULL THEN '' ELSE convert_from(r.seed, 'UTF8') END,
+	r.status, (SELECT COUNT(*) FROM raffle_entries e WHERE e.raffle_id = r.id)::int,
+	r.drawn_at, r.completed_at, r.created_at, r.updated_at`
+
+func scanRaffle(row pgx.Row) (*Raffle, error) {
+	var r Raffle
+	if err := row.Scan(&r.ID, &r.ProductID, &r.Quantity, &r.EntriesOpenAt, &r.EntriesCloseAt, &r.DrawAt,
+		&r.ClaimWindowSeconds, &r.SeedHash, &r.Seed, &r.Status, &r.Entries,
+		&r.DrawnAt, &r.CompletedAt, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+const entryColumns = `id, raffle_id, status, rank, COALESCE(draw_hash, ''), won_at, claim_expires_at, order_id, created_at`
+
+func scanEntry(row pgx.Row) (*Entry, error) {
+	var e Entry
+	if err := row.Scan(&e.ID, &e.RaffleID, &e.Status, &e.Rank, &e.DrawHash, &e.WonAt, &e.ClaimExpiresAt,
+		&e.OrderID, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (p *Postgres) Create(ctx context.Context, in CreateRaffleInput) (*Raffle, error) {
+	return scanRaffle(p.pool.QueryRow(ctx, `
+		WITH r AS (
+			INSERT INTO raffles (product_id, quantity, entries_open_at, entries_close_at, draw_at,
+			                     claim_window_seconds, seed, seed_hash)
+			SELECT pr.id, $2, $3, $4, $5, $6, convert_to($7, 'UTF8'), $8
+			FROM products pr
+			WHERE pr.id = $1 AND pr.deleted_at IS NULL
+			  AND NOT EXISTS (SELECT 1 FROM products v WHERE v.parent_id = pr.id AND v.deleted_at IS NULL)
+			RETURNING *
+		)
+		SELECT `+raffleColumns+` FROM r
+	`, in.ProductID, in.Quantity, in.EntriesOpenAt, in.EntriesCloseAt, in.DrawAt, in.ClaimWindowSeconds, in.Seed, in.SeedHash))
+}
+
+func (p *Postgres) List(ctx context.Context, live bool) ([]Raffle, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+raffleColumns+`
+		FROM raffles r
+		WHERE NOT $1 OR r.status IN ('scheduled', 'drawn')
+		ORDER BY r.draw_at DESC, r.id
+	`, live)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Raffle, 0)
+	for rows.Next() {
+		r, err := scanRaffle(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *r)
+	}
+	return out, rows.Err()
+}
+
+func (p *Postgres) GetByID(ctx context.Context, id uuid.UUID) (*Raffle, error) {
+	return scanRaffle(p.pool.QueryRow(ctx, `SELECT `+raffleColumns+` FROM raffles r WHERE r.id = $1`, id))
+}
+
+func (p *Postgres) Cancel(ctx context.Context, id uuid.UUID) (*Raffle, error) {
+	return scanRaffle(p.pool.QueryRow(ctx, `
+		WITH r AS (
+			UPDATE raffles
+			SET status = 'cancelled', updated_at = NOW()
+			WHERE id = $1 AND status IN ('scheduled', 'drawn')
+			RETURNING *
+		)
+		SELECT `+raffleColumns+` FROM r
+	`, id))
+}
+
+func (p *Postgres) Enter(ctx context.Context, raffleID, userID uuid.UUID) (*Entry, error) {
+	e, err := scanEntry(p.pool.QueryRow(ctx, `
+		INSERT INTO raffle_entries (raffle_id, user_id)
+		SELECT r.id, $2
+		FROM raffles r
+		WHERE r.id = $1 AND r.status = 'scheduled' AND NOW() >= r.entries_open_at AND NOW() < r.entries_close_at
+		ON CONFLICT (raffle_id, user_id) DO NOTHING
+		RETURNING `+entryColumns,
+		raffleID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p.GetEntry(ctx, raffleID, userID)
+	}
+	return e, err
+}
+
+func (p *Postgres) GetEntry(ctx context.Context, raffleID, userID uuid.UUID) (*Entry, error) {
+	return scanEntry(p.pool.QueryRow(ctx, `
+		SELECT `+entryColumns+` FROM raffle_entries WHERE raffle_id = $1 AND user_id = $2
+	`, raffleID, userID))
+}
+
+func (p *Postgres) ListDrawn(ctx context.Context, raffleID uuid.UUID) ([]DrawnEntry, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT id, rank, draw_hash, status
+		FROM raffle_entries
+		WHERE raffle_id = $1 AND rank IS NOT NULL
+		ORDER BY rank
+	`, raffleID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (DrawnEntry, error) {
+		var e DrawnEntry
+		err := row.Scan(&e.ID, &e.Rank, &e.DrawHash, &e.Status)
+		return e, err
+	})
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Raffle statuses; see migration 018.
+const (
+	StatusScheduled = "scheduled"
+	StatusDrawn     = "drawn"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+// Raffle sells Quantity units of a product to entrants drawn at random.
+// inventory-service draws it at DrawAt and passes unclaimed units on.
+type Raffle struct {
+	ID                 uuid.UUID `json:"id"`
+	ProductID          uuid.UUID `json:"product_id"`
+	Quantity           int       `json:"quantity"`
+	EntriesOpenAt      time.Time `json:"entries_open_at"`
+	EntriesCloseAt     time.Time `json:"entries_close_at"`
+	DrawAt             time.Time `json:"draw_at"`
+	ClaimWindowSeconds int       `json:"claim_window_seconds"`
+	// SeedHash is the hex SHA-256 of Seed, published before the draw so the
+	// seed cannot be changed afterwards. Seed is revealed once drawn.
+	SeedHash    string     `json:"seed_hash"`
+	Seed        string     `json:"seed,omitempty"`
+	Status      string     `json:"status"`
+	Entries     int        `json:"entries"`
+	DrawnAt     *time.Time `json:"drawn_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Entry is a customer's entry in a raffle.
+type Entry struct {
+	ID       uuid.UUID `json:"id"`
+	RaffleID uuid.UUID `json:"raffle_id"`
+	// Status is entered, won, claimed, purchased, forfeited or lost. A won
+	// entry may order one unit until ClaimExpiresAt.
+	Status         string     `json:"status"`
+	Rank           *int       `json:"rank,omitempty"`
+	DrawHash       string     `json:"draw_hash,omitempty"`
+	WonAt          *time.Time `json:"won_at,omitempty"`
+	ClaimExpiresAt *time.Time `json:"claim_expires_at,omitempty"`
+	OrderID        *uuid.UUID `json:"order_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// DrawnEntry is an entry as published in a raffle's draw, without its
+// customer.
+type DrawnEntry struct {
+	ID       uuid.UUID `json:"id"`
+	Rank     int       `json:"rank"`
+	DrawHash string    `json:"draw_hash"`
+	Status   string    `json:"status"`
+}
+
+type CreateRaffleInput struct {
+	ProductID          uuid.UUID
+	Quantity           int
+	EntriesOpenAt      time.Time
+	EntriesCloseAt     time.Time
+	DrawAt             time.Time
+	ClaimWindowSeconds int
+	Seed               string
+	SeedHash           string
+}
+
+type Repository interface {
+	// Create returns pgx.ErrNoRows if the product does not exist or has
+	// variants.
+	Create(ctx context.Context, in CreateRaffleInput) (*Raffle, error)
+	// List returns raffles newest first; live only returns those scheduled
+	// or drawn.
+	List(ctx context.Context, live bool) ([]Raffle, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*Raffle, error)
+	// Cancel cancels a scheduled or drawn raffle; inventory-service gives
+	// back the units it holds. It returns pgx.ErrNoRows if the raffle is
+	// not live.
+	Cancel(ctx context.Context, id uuid.UUID) (*Raffle, error)
+
+	// Enter enters the user in a raffle taking entries, or returns their
+	// entry. It returns pgx.ErrNoRows if the raffle is not taking entries
+	// and the user has none.
+	Enter(ctx context.Context, raffleID, userID uuid.UUID) (*Entry, error)
+	GetEntry(ctx context.Context, raffleID, userID uuid.UUID) (*Entry, error)
+	// ListDrawn returns a drawn raffle's entries in rank order.
+	ListDrawn(ctx context.Context, raffleID uuid.UUID) ([]DrawnEntry, error)
+}
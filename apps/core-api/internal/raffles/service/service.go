@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/raffles/repo"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/util"
+)
+
+var (
+	// ErrInvalidRaffle is wrapped with a message naming the offending field.
+	ErrInvalidRaffle = errors.New("invalid raffle")
+	ErrRaffleExists  = errors.New("product already has a live raffle")
+	ErrNotLive       = errors.New("raffle is not live")
+	ErrEntriesClosed = errors.New("raffle is not taking entries")
+	ErrNotDrawn      = errors.New("raffle has not been drawn")
+)
+
+type Service struct {
+	repo repo.Repository
+	now  func() time.Time
+}
+
+func New(r repo.Repository) *Service {
+	return &Service{repo: r, now: time.Now}
+}
+
+type CreateInput struct {
+	ProductID          uuid.UUID
+	Quantity           int
+	EntriesOpenAt      time.Time
+	EntriesCloseAt     time.Time
+	DrawAt             time.Time
+	ClaimWindowSeconds int
+}
+
+// Create schedules a raffle with a fresh random seed. Only the seed's hash
+// is shown until the draw.
+func (s *Service) Create(ctx context.Context, in CreateInput) (*repo.Raffle, error) {
+	switch {
+	case in.ProductID == uuid.Nil:
+		return nil, invalidRaffle("product_id is required")
+	case in.Quantity <= 0:
+		return nil, invalidRaffle("quantity must be > 0")
+	case in.EntriesOpenAt.IsZero() || in.EntriesCloseAt.IsZero() || in.DrawAt.IsZero():
+		return nil, invalidRaffle("entries_open_at, entries_close_at and draw_at are required")
+	case !in.EntriesCloseAt.After(in.EntriesOpenAt):
+		return nil, invalidRaffle("entries_close_at must be after entries_open_at")
+	case in.DrawAt.Before(in.EntriesCloseAt):
+		return nil, invalidRaffle("draw_at must not be before entries_close_at")
+	case !in.EntriesCloseAt.After(s.now()):
+		return nil, invalidRaffle("entries_close_at must be in the future")
+	case in.ClaimWindowSeconds <= 0:
+		return nil, invalidRaffle("claim_window_seconds must be > 0")
+	}
+
+	seed, seedHash, err := newSeed()
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.repo.Create(ctx, repo.CreateRaffleInput{
+		ProductID:          in.ProductID,
+		Quantity:           in.Quantity,
+		EntriesOpenAt:      in.EntriesOpenAt,
+		EntriesCloseAt:     in.EntriesCloseAt,
+		DrawAt:             in.DrawAt,
+		ClaimWindowSeconds: in.ClaimWindowSeconds,
+		Seed:               seed,
+		SeedHash:           seedHash,
+	})
+	switch {
+	case util.IsNotFound(err):
+		return nil, invalidRaffle("product not found or has variants; raffle a specific variant")
+	case util.IsUniqueViolation(err, "idx_raffles_live_product"):
+		return nil, ErrRaffleExists
+	}
+	return r, err
+}
+
+// newSeed returns a random seed, as the hex text that is hashed and later
+// revealed, and its hex SHA-256.
+func newSeed() (seed, seedHash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	seed = hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(seed))
+	return seed, hex.EncodeToString(sum[:]), nil
+}
+
+func (s *Service) List(ctx context.Context, live bool) ([]repo.Raffle, error) {
+	return s.repo.List(ctx, live)
+}
+
+func (s *Service) Get(ctx context.Context, id uuid.UUID) (*repo.Raffle, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+// Cancel cancels a live raffle. Units already ordered by winners stay with
+// their orders.
+func (s *Service) Cancel(ctx context.Context, id uuid.UUID) (*repo.Raffle, error) {
+	r, err := s.repo.Cancel(ctx, id)
+	if util.IsNotFound(err) {
+		if _, getErr := s.repo.GetByID(ctx, id); getErr != nil {
+			return nil, getErr
+		}
+		return nil, ErrNotLive
+	}
+	return r, err
+}
+
+// Enter enters the user in the raffle. Entering again returns the same
+// entry.
+func (s *Service) Enter(ctx context.Context, raffleID, userID uuid.UUID) (*repo.Entry, error) {
+	e, err := s.repo.Enter(ctx, raffleID, userID)
+	if util.IsNotFound(err) {
+		if _, getErr := s.repo.GetByID(ctx, raffleID); getErr != nil {
+			return nil, getErr
+		}
+		return nil, ErrEntriesClosed
+	}
+	return e, err
+}
+
+// Entry returns the user's entry in the raffle.
+func (s *Service) Entry(ctx context.Context, raffleID, userID uuid.UUID) (*repo.Entry, error) {
+	return s.repo.GetEntry(ctx, raffleID, userID)
+}
+
+// Draw is a drawn raffle with its entries in rank order: each entry's
+// draw_hash is the hex SHA-256 of the seed followed by the entrant's user
+// id, so with the seed a customer can check their own entry, and anyone
+// that ranks follow draw_hash.
+type Draw struct {
+	Raffle  repo.Raffle       `json:"raffle"`
+	Entries []repo.DrawnEntry `json:"entries"`
+}
+
+func (s *Service) Draw(ctx context.Context, raffleID uuid.UUID) (*Draw, error) {
+	r, err := s.repo.GetByID(ctx, raffleID)
+	if err != nil {
+		return nil, err
+	}
+	if r.DrawnAt == nil {
+		return nil, ErrNotDrawn
+	}
+	entries, err := s.repo.ListDrawn(ctx, raffleID)
+	if err != nil {
+		return nil, err
+	}
+	return &Draw{Raffle: *r, Entries: entries}, nil
+}
+
+func invalidRaffle(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRaffle, msg)
+}
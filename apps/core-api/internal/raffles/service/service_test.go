@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCreateValidates(t *testing.T) {
+	now := time.Date(2026, 9, 1, 9, 0, 0, 0, time.UTC)
+	s := &Service{now: func() time.Time { return now }}
+	valid := CreateInput{
+		ProductID:          uuid.New(),
+		Quantity:           50,
+		EntriesOpenAt:      now,
+		EntriesCloseAt:     now.Add(48 * time.Hour),
+		DrawAt:             now.Add(49 * time.Hour),
+		ClaimWindowSeconds: 3600,
+	}
+
+	for name, edit := range map[string]func(*CreateInput){
+		"no product":          func(in *CreateInput) { in.ProductID = uuid.Nil },
+		"no units":            func(in *CreateInput) { in.Quantity = 0 },
+		"no draw time":        func(in *CreateInput) { in.DrawAt = time.Time{} },
+		"entries close first": func(in *CreateInput) { in.EntriesCloseAt = in.EntriesOpenAt },
+		"draw before close":   func(in *CreateInput) { in.DrawAt = in.EntriesCloseAt.Add(-time.Minute) },
+		"entries already closed": func(in *CreateInput) {
+			in.EntriesOpenAt, in.EntriesCloseAt = now.Add(-2*time.Hour), now.Add(-time.Hour)
+		},
+		"no claim window": func(in *CreateInput) { in.ClaimWindowSeconds = 0 },
+	} {
+		in := valid
+		edit(&in)
+		if _, err := s.Create(context.Background(), in); !errors.Is(err, ErrInvalidRaffle) {
+			t.Errorf("%s: err = %v, want ErrInvalidRaffle", name, err)
+		}
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	seed, seedHash, err := newSeed()
+	if err != nil {
+		t.Fatalf("newSeed: %v", err)
+	}
+	if len(seed) != 64 {
+		t.Fatalf("seed = %q, want 64 hex characters", seed)
+	}
+	sum := sha256.Sum256([]byte(seed))
+	if seedHash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("seed hash = %s, want sha256 of the seed", seedHash)
+	}
+	if other, _, _ := newSeed(); other == seed {
+		t.Fatalf("newSeed returned the same seed twice")
+	}
+}
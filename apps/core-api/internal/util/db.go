@@ -4,8 +4,33 @@ import (
 	"errors"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func IsNotFound(err error) bool {
 	return errors.Is(err, pgx.ErrNoRows)
 }
+
+// IsUniqueViolation reports whether err is a Postgres unique_violation,
+// optionally restricted to the named constraint.
+func IsUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return false
+	}
+	return constraint == "" || pgErr.ConstraintName == constraint
+}
+
+// IsForeignKeyViolation reports whether err is a Postgres
+// foreign_key_violation, e.g. a reference to a row that does not exist.
+func IsForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+// IsCheckViolation reports whether err is a Postgres check_violation, a
+// write the table's CHECK constraints reject.
+func IsCheckViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23514"
+}
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/http/middleware"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/platform/httpjson"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/waitingroom/service"
+	"github.com/kalen1o/iphone-storage/shared/logging"
+)
+
+// TicketHeader carries the queue ticket on status polls and orders.
+const TicketHeader = "X-Queue-Ticket"
+
+type Controller struct {
+	svc *service.Service
+	log *logging.Logger
+}
+
+func New(svc *service.Service, log *logging.Logger) *Controller {
+	return &Controller{svc: svc, log: log}
+}
+
+type OpenRequest struct {
+	// AdmitPerSecond replaces the admission rate when set.
+	AdmitPerSecond float64 `json:"admit_per_second,omitempty"`
+}
+
+// unavailableRetryAfter is the Retry-After of orders turned away while the
+// waiting room cannot be reached.
+const unavailableRetryAfter = "5"
+
+// RequireAdmission guards order creation while the waiting room is open:
+// the request must carry an admitted ticket of the user, within its
+// checkout window. It must run after Authenticate. If Redis is down orders
+// get a 503 unless the room was last seen closed or is configured to fail
+// open; see service.Service.AdmitsWhileUnavailable.
+func (c *Controller) RequireAdmission(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userIDFromRequest(r)
+		if !ok {
+			httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		st, err := c.svc.Admit(r.Context(), r.Header.Get(TicketHeader), userID)
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, service.ErrTicketRequired):
+			httpjson.WriteErrorCode(w, http.StatusForbidden, "queue_ticket_required", "join the queue before checking out")
+		case errors.Is(err, service.ErrInvalidTicket):
+			httpjson.WriteErrorCode(w, http.StatusForbidden, "queue_ticket_invalid", err.Error())
+		case errors.Is(err, service.ErrNotAdmitted):
+			w.Header().Set("Retry-After", strconv.FormatInt(max(st.EstimatedWaitSeconds, 1), 10))
+			httpjson.WriteErrorCode(w, http.StatusTooManyRequests, "queue_not_admitted", err.Error())
+		case errors.Is(err, service.ErrWindowExpired):
+			httpjson.WriteErrorCode(w, http.StatusForbidden, "checkout_window_expired", err.Error())
+		case c.svc.AdmitsWhileUnavailable():
+			c.log.Error("waiting room unavailable; admitting order", map[string]any{"err": err.Error()})
+			next.ServeHTTP(w, r)
+		default:
+			c.log.Error("waiting room unavailable; turning order away", map[string]any{"err": err.Error()})
+			w.Header().Set("Retry-After", unavailableRetryAfter)
+			httpjson.WriteErrorCode(w, http.StatusServiceUnavailable, "waiting_room_unavailable", "waiting room unavailable, try again shortly")
+		}
+	})
+}
+
+// GetRoom godoc
+// @Summary Get waiting room state
+// @Description While the room is open, orders need an admitted queue ticket.
+// @Tags queue
+// @Produce json
+// @Success 200 {object} service.RoomStatus
+// @Router /api/queue [get]
+func (c *Controller) GetRoom(w http.ResponseWriter, r *http.Request) {
+	st, err := c.svc.Status(r.Context())
+	if err != nil {
+		httpjson.WriteError(w, http.StatusServiceUnavailable, "waiting room unavailable")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpjson.WriteJSON(w, http.StatusOK, st)
+}
+
+// JoinQueue godoc
+// @Summary Join the waiting room
+// @Description Returns a ticket holding the user's place; joining again returns the same place. Send the ticket in the X-Queue-Ticket header when polling and ordering.
+// @Tags queue
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} service.TicketStatus
+// @Failure 409 {object} map[string]any "code waiting_room_closed"
+// @Router /api/queue/tickets [post]
+func (c *Controller) JoinQueue(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	st, err := c.svc.Join(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrRoomClosed) {
+			httpjson.WriteErrorCode(w, http.StatusConflict, "waiting_room_closed", err.Error())
+			return
+		}
+		httpjson.WriteError(w, http.StatusServiceUnavailable, "waiting room unavailable")
+		return
+	}
+	httpjson.WriteJSON(w, http.StatusOK, st)
+}
+
+// GetTicketStatus godoc
+// @Summary Get queue position
+// @Description Polling an admitted ticket starts its checkout window.
+// @Tags queue
+// @Security BearerAuth
+// @Produce json
+// @Param X-Queue-Ticket header string true "Queue ticket"
+// @Success 200 {object} service.TicketStatus
+// @Failure 403 {object} map[string]any "code queue_ticket_invalid"
+// @Router /api/queue/status [get]
+func (c *Controller) GetTicketStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromRequest(r)
+	if !ok {
+		httpjson.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	st, err := c.svc.TicketStatus(r.Context(), r.Header.Get(TicketHeader), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidTicket) {
+			httpjson.WriteErrorCode(w, http.StatusForbidden, "queue_ticket_invalid", err.Error())
+			return
+		}
+		httpjson.WriteError(w, http.StatusServiceUnavailable, "waiting room unavailable")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httpjson.WriteJSON(w, http.StatusOK, st)
+}
+
+// OpenRoom godoc
+// @Summary Open the waiting room
+// @Description Opens a closed room with a new queue, or resumes a paused or draining one.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body OpenRequest false "Admission rate"
+// @Success 200 {object} service.RoomStatus
+// @Failure 400 {object} map[string]any
+// @Router /api/admin/queue/open [post]
+func (c *Controller) OpenRoom(w http.ResponseWriter, r *http.Request) {
+	var req OpenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httpjson.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	st, err := c.svc.Open(r.Context(), req.AdmitPerSecond)
+	c.writeRoom(w, st, err)
+}
+
+// PauseRoom godoc
+// @Summary Pause admissions
+// @Description Visitors may still join.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} service.RoomStatus
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/queue/pause [post]
+func (c *Controller) PauseRoom(w http.ResponseWriter, r *http.Request) {
+	st, err := c.svc.Pause(r.Context())
+	c.writeRoom(w, st, err)
+}
+
+// DrainRoom godoc
+// @Summary Drain the waiting room
+// @Description Stops taking visitors and keeps admitting those still waiting.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} service.RoomStatus
+// @Failure 409 {object} map[string]any
+// @Router /api/admin/queue/drain [post]
+func (c *Controller) DrainRoom(w http.ResponseWriter, r *http.Request) {
+	st, err := c.svc.Drain(r.Context())
+	c.writeRoom(w, st, err)
+}
+
+// CloseRoom godoc
+// @Summary Close the waiting room
+// @Description Orders no longer need a ticket; outstanding tickets are void.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} service.RoomStatus
+// @Router /api/admin/queue/close [post]
+func (c *Controller) CloseRoom(w http.ResponseWriter, r *http.Request) {
+	st, err := c.svc.Close(r.Context())
+	c.writeRoom(w, st, err)
+}
+
+func (c *Controller) writeRoom(w http.ResponseWriter, st service.RoomStatus, err error) {
+	switch {
+	case err == nil:
+		httpjson.WriteJSON(w, http.StatusOK, st)
+	case errors.Is(err, service.ErrInvalidRate):
+		httpjson.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrInvalidTransition):
+		httpjson.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		httpjson.WriteError(w, http.StatusServiceUnavailable, "waiting room unavailable")
+	}
+}
+
+func userIDFromRequest(r *http.Request) (uuid.UUID, bool) {
+	raw, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		return uuid.UUID{}, false
+	}
+	id, err := uuid.Parse(raw)
+	return id, err == nil
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+
+	authservice "github.com/kalen1o/iphone-storage/apps/core-api/internal/auth/service"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/http/middleware"
+	"github.com/kalen1o/iphone-storage/apps/core-api/internal/waitingroom/service"
+	"github.com/kalen1o/iphone-storage/shared/logging"
+)
+
+type versions struct{}
+
+func (versions) TokenVersion(context.Context, uuid.UUID) (int, error) { return 0, nil }
+
+func TestRequireAdmission_RedisDown(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 50 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	jwt := authservice.NewJWT("test-secret", time.Hour)
+	token, err := jwt.GenerateToken(uuid.NewString(), "buyer@example.com", "customer", 0, false)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	auth := middleware.NewAuthMiddleware(jwt, nil, versions{})
+
+	for name, tc := range map[string]struct {
+		failOpen   bool
+		wantStatus int
+	}{
+		"fails closed": {wantStatus: http.StatusServiceUnavailable},
+		"fail open":    {failOpen: true, wantStatus: http.StatusNoContent},
+	} {
+		c := New(service.New(rdb, service.Options{Secret: "secret", FailOpen: tc.failOpen}), logging.New("core-api", "test"))
+		h := auth.Authenticate(c.RequireAdmission(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})))
+
+		req := httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s: status = %d, want %d", name, rec.Code, tc.wantStatus)
+		}
+		if tc.wantStatus == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After on a 503", name)
+		}
+	}
+}
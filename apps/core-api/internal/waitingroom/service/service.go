@@ -0,0 +1,373 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// States of the waiting room. While it is closed orders need no ticket.
+// Opening it starts a new queue; tickets from an earlier opening are void.
+const (
+	StateClosed = "closed"
+	// StateOpen takes new visitors and admits them.
+	StateOpen = "open"
+	// StatePaused takes new visitors but admits nobody.
+	StatePaused = "paused"
+	// StateDraining takes no new visitors and admits those still waiting.
+	StateDraining = "draining"
+)
+
+var (
+	ErrRoomClosed     = errors.New("waiting room is not taking visitors")
+	ErrTicketRequired = errors.New("queue ticket required")
+	ErrInvalidTicket  = errors.New("invalid queue ticket")
+	// ErrNotAdmitted is wrapped with the ticket's position.
+	ErrNotAdmitted       = errors.New("not admitted yet")
+	ErrWindowExpired     = errors.New("checkout window has expired")
+	ErrInvalidRate       = errors.New("admit_per_second must be a positive number")
+	ErrInvalidTransition = errors.New("invalid waiting room state change")
+)
+
+const (
+	keyState    = "waitingroom:state"
+	keyEpoch    = "waitingroom:epoch"
+	keySeq      = "waitingroom:seq"
+	keyAdmitted = "waitingroom:admitted"
+	keyLast     = "waitingroom:last"
+	keyRate     = "waitingroom:rate"
+	keyUsers    = "waitingroom:users"
+)
+
+func windowKey(epoch, seq int64) string {
+	return "waitingroom:window:" + strconv.FormatInt(epoch, 10) + ":" + strconv.FormatInt(seq, 10)
+}
+
+// advanceScript admits the visitors due since the last call, at the room's
+// rate by the Redis clock, so every core-api instance agrees without a
+// ticker. Time spent paused or with nobody waiting is not banked.
+// KEYS: state, seq, admitted, last, rate, epoch. ARGV: default rate.
+var advanceScript = redis.NewScript(`
+local state = redis.call('GET', KEYS[1]) or 'closed'
+local seq = tonumber(redis.call('GET', KEYS[2]) or '0')
+local admitted = tonumber(redis.call('GET', KEYS[3]) or '0')
+local rate = tonumber(redis.call('GET', KEYS[5]) or ARGV[1])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local last = tonumber(redis.call('GET', KEYS[4]) or now)
+if (state == 'open' or state == 'draining') and admitted < seq then
+	local n = math.floor((now - last) * rate / 1000)
+	if n > 0 then
+		admitted = math.min(admitted + n, seq)
+		redis.call('SET', KEYS[3], admitted)
+		last = last + n * 1000 / rate
+	end
+else
+	last = now
+end
+if admitted >= seq then last = now end
+redis.call('SET', KEYS[4], string.format('%.3f', last))
+return {state, seq, admitted, tostring(rate), tonumber(redis.call('GET', KEYS[6]) or '0')}
+`)
+
+// joinScript hands out the next place in the queue, or the user's place if
+// they already have one.
+// KEYS: state, seq, users. ARGV: user id. Returns -1 if the room takes no
+// visitors.
+var joinScript = redis.NewScript(`
+local state = redis.call('GET', KEYS[1]) or 'closed'
+if state == 'closed' then return -1 end
+local seq = redis.call('HGET', KEYS[3], ARGV[1])
+if seq then return tonumber(seq) end
+if state == 'draining' then return -1 end
+seq = redis.call('INCR', KEYS[2])
+redis.call('HSET', KEYS[3], ARGV[1], seq)
+return seq
+`)
+
+// openScript opens the room, starting a new queue if it was closed.
+// KEYS: state, epoch, seq, admitted, last, users.
+var openScript = redis.NewScript(`
+local state = redis.call('GET', KEYS[1]) or 'closed'
+if state == 'closed' then
+	redis.call('DEL', KEYS[3], KEYS[4], KEYS[5], KEYS[6])
+	redis.call('INCR', KEYS[2])
+end
+redis.call('SET', KEYS[1], 'open')
+return state
+`)
+
+type Options struct {
+	// Secret signs queue tickets.
+	Secret string
+	// AdmitPerSecond is used until an admin opens the room with a rate.
+	AdmitPerSecond float64
+	// CheckoutWindow is how long an admitted ticket may place orders,
+	// from when it is first seen admitted.
+	CheckoutWindow time.Duration
+	// FailOpen lets orders through while Redis is unavailable, whatever
+	// the room's state; see AdmitsWhileUnavailable.
+	FailOpen bool
+}
+
+// Service runs a launch waiting room in Redis. Visitors join and get a
+// signed ticket holding their place; they are admitted in order at a fixed
+// rate, and an admitted ticket may place orders for a checkout window.
+// Places are not given back: a visitor who leaves still uses up their
+// admission.
+type Service struct {
+	rdb         *redis.Client
+	secret      []byte
+	defaultRate float64
+	window      time.Duration
+	failOpen    bool
+	// lastState is the state last read from Redis, "" until one is.
+	lastState atomic.Value
+}
+
+func New(rdb *redis.Client, opts Options) *Service {
+	if opts.AdmitPerSecond <= 0 {
+		opts.AdmitPerSecond = 10
+	}
+	if opts.CheckoutWindow <= 0 {
+		opts.CheckoutWindow = 10 * time.Minute
+	}
+	s := &Service{rdb: rdb, secret: []byte(opts.Secret), defaultRate: opts.AdmitPerSecond, window: opts.CheckoutWindow, failOpen: opts.FailOpen}
+	s.lastState.Store("")
+	return s
+}
+
+// AdmitsWhileUnavailable reports whether orders may go through when
+// Admit fails because Redis is unavailable: with FailOpen, or when the
+// room was last seen closed. A room that was open, or never seen, turns
+// orders away rather than let them past the queue.
+func (s *Service) AdmitsWhileUnavailable() bool {
+	return s.failOpen || s.lastState.Load() == StateClosed
+}
+
+// RoomStatus describes the room and its queue.
+type RoomStatus struct {
+	State          string  `json:"state"`
+	AdmitPerSecond float64 `json:"admit_per_second"`
+	// Joined counts the tickets handed out since the room opened, Admitted
+	// those let through.
+	Joined   int64 `json:"joined"`
+	Admitted int64 `json:"admitted"`
+	Waiting  int64 `json:"waiting"`
+	epoch    int64
+}
+
+// TicketStatus is a visitor's place in the queue.
+type TicketStatus struct {
+	Ticket string `json:"ticket"`
+	State  string `json:"state"`
+	// Position is how many visitors are ahead, counting this one; zero
+	// once admitted.
+	Position             int64      `json:"position"`
+	Admitted             bool       `json:"admitted"`
+	EstimatedWaitSeconds int64      `json:"estimated_wait_seconds"`
+	CheckoutExpiresAt    *time.Time `json:"checkout_expires_at,omitempty"`
+}
+
+func (s *Service) advance(ctx context.Context) (RoomStatus, error) {
+	res, err := advanceScript.Run(ctx, s.rdb,
+		[]string{keyState, keySeq, keyAdmitted, keyLast, keyRate, keyEpoch}, s.defaultRate).Slice()
+	if err != nil {
+		return RoomStatus{}, err
+	}
+	if len(res) != 5 {
+		return RoomStatus{}, fmt.Errorf("waiting room: unexpected script result %v", res)
+	}
+	st := RoomStatus{State: fmt.Sprint(res[0])}
+	st.Joined, _ = res[1].(int64)
+	st.Admitted, _ = res[2].(int64)
+	st.AdmitPerSecond, _ = strconv.ParseFloat(fmt.Sprint(res[3]), 64)
+	st.epoch, _ = res[4].(int64)
+	st.Waiting = st.Joined - st.Admitted
+	s.lastState.Store(st.State)
+	return st, nil
+}
+
+// Status returns the room's state and queue.
+func (s *Service) Status(ctx context.Context) (RoomStatus, error) {
+	return s.advance(ctx)
+}
+
+// Join puts the user in the queue, or returns their existing place.
+func (s *Service) Join(ctx context.Context, userID uuid.UUID) (*TicketStatus, error) {
+	seq, err := joinScript.Run(ctx, s.rdb, []string{keyState, keySeq, keyUsers}, userID.String()).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if seq < 0 {
+		return nil, ErrRoomClosed
+	}
+	room, err := s.advance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.ticketStatus(ctx, room, ticket{Epoch: room.epoch, Seq: seq, UserID: userID})
+}
+
+// TicketStatus returns the place of the user's ticket. A ticket seen
+// admitted for the first time starts its checkout window.
+func (s *Service) TicketStatus(ctx context.Context, token string, userID uuid.UUID) (*TicketStatus, error) {
+	room, t, err := s.verify(ctx, token, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.ticketStatus(ctx, room, t)
+}
+
+// Admit checks that the user may place an order now: the room is closed,
+// or the ticket is admitted and within its checkout window. The ticket's
+// status is returned with ErrNotAdmitted; it is nil while the room is
+// closed.
+func (s *Service) Admit(ctx context.Context, token string, userID uuid.UUID) (*TicketStatus, error) {
+	room, err := s.advance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if room.State == StateClosed {
+		return nil, nil
+	}
+	if token == "" {
+		return nil, ErrTicketRequired
+	}
+	t, err := s.check(room, token, userID)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.ticketStatus(ctx, room, t)
+	if err != nil {
+		return nil, err
+	}
+	if !st.Admitted {
+		return st, fmt.Errorf("%w: position %d", ErrNotAdmitted, st.Position)
+	}
+	if time.Now().After(*st.CheckoutExpiresAt) {
+		return st, ErrWindowExpired
+	}
+	return st, nil
+}
+
+func (s *Service) verify(ctx context.Context, token string, userID uuid.UUID) (RoomStatus, ticket, error) {
+	room, err := s.advance(ctx)
+	if err != nil {
+		return RoomStatus{}, ticket{}, err
+	}
+	t, err := s.check(room, token, userID)
+	return room, t, err
+}
+
+// check accepts tickets of the user from the room's current opening.
+func (s *Service) check(room RoomStatus, token string, userID uuid.UUID) (ticket, error) {
+	t, err := parseTicket(s.secret, token)
+	if err != nil {
+		return ticket{}, err
+	}
+	if room.State == StateClosed || t.Epoch != room.epoch || t.UserID != userID || t.Seq > room.Joined {
+		return ticket{}, ErrInvalidTicket
+	}
+	return t, nil
+}
+
+func (s *Service) ticketStatus(ctx context.Context, room RoomStatus, t ticket) (*TicketStatus, error) {
+	st := &TicketStatus{Ticket: signTicket(s.secret, t), State: room.State}
+	if t.Seq > room.Admitted {
+		st.Position = t.Seq - room.Admitted
+		if room.AdmitPerSecond > 0 {
+			st.EstimatedWaitSeconds = int64(math.Ceil(float64(st.Position) / room.AdmitPerSecond))
+		}
+		return st, nil
+	}
+
+	st.Admitted = true
+	expiresAt, err := s.startWindow(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	st.CheckoutExpiresAt = &expiresAt
+	return st, nil
+}
+
+// startWindow returns when the ticket's checkout window ends, starting it
+// now if it has not started. The record outlives the window so an expired
+// ticket cannot start another.
+func (s *Service) startWindow(ctx context.Context, t ticket) (time.Time, error) {
+	key := windowKey(t.Epoch, t.Seq)
+	expiresAt := time.Now().Add(s.window).UnixMilli()
+	if err := s.rdb.SetNX(ctx, key, expiresAt, s.window+24*time.Hour).Err(); err != nil {
+		return time.Time{}, err
+	}
+	ms, err := s.rdb.Get(ctx, key).Int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
+
+// Open opens the room, or resumes it when paused or draining. A closed
+// room starts a new queue. A rate above zero replaces the admission rate.
+func (s *Service) Open(ctx context.Context, admitPerSecond float64) (RoomStatus, error) {
+	if admitPerSecond < 0 || math.IsNaN(admitPerSecond) || math.IsInf(admitPerSecond, 0) {
+		return RoomStatus{}, ErrInvalidRate
+	}
+	// Settle admissions at the old state and rate before changing them.
+	if _, err := s.advance(ctx); err != nil {
+		return RoomStatus{}, err
+	}
+	if admitPerSecond > 0 {
+		if err := s.rdb.Set(ctx, keyRate, strconv.FormatFloat(admitPerSecond, 'f', -1, 64), 0).Err(); err != nil {
+			return RoomStatus{}, err
+		}
+	}
+	if err := openScript.Run(ctx, s.rdb, []string{keyState, keyEpoch, keySeq, keyAdmitted, keyLast, keyUsers}).Err(); err != nil {
+		return RoomStatus{}, err
+	}
+	return s.advance(ctx)
+}
+
+// Pause stops admitting; visitors may still join.
+func (s *Service) Pause(ctx context.Context) (RoomStatus, error) {
+	return s.transition(ctx, StatePaused, StateOpen, StateDraining)
+}
+
+// Drain stops taking visitors and admits those still waiting.
+func (s *Service) Drain(ctx context.Context) (RoomStatus, error) {
+	return s.transition(ctx, StateDraining, StateOpen, StatePaused)
+}
+
+// Close closes the room: orders need no ticket and existing tickets are
+// void.
+func (s *Service) Close(ctx context.Context) (RoomStatus, error) {
+	return s.transition(ctx, StateClosed, StateOpen, StatePaused, StateDraining)
+}
+
+func (s *Service) transition(ctx context.Context, to string, from ...string) (RoomStatus, error) {
+	room, err := s.advance(ctx)
+	if err != nil {
+		return RoomStatus{}, err
+	}
+	if room.State == to {
+		return room, nil
+	}
+	allowed := false
+	for _, f := range from {
+		allowed = allowed || room.State == f
+	}
+	if !allowed {
+		return RoomStatus{}, fmt.Errorf("%w: cannot go from %s to %s", ErrInvalidTransition, room.State, to)
+	}
+	if err := s.rdb.Set(ctx, keyState, to, 0).Err(); err != nil {
+		return RoomStatus{}, err
+	}
+	return s.advance(ctx)
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// newTestService returns a Service on the test Redis database with an
+// empty, closed room, skipping the test if Redis is unreachable. The room's
+// keys are global, so these tests do not run in parallel.
+func newTestService(t *testing.T, rate float64) (context.Context, *Service) {
+	t.Helper()
+
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	db, _ := strconv.Atoi(os.Getenv("TEST_REDIS_DB"))
+	if db == 0 {
+		db = 15
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	rdb := redis.NewClient(&redis.Options{Addr: addr, DB: db})
+	t.Cleanup(func() { _ = rdb.Close() })
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		t.Skipf("skipping integration test: cannot reach redis (%v)", err)
+	}
+	reset := func() {
+		keys, _ := rdb.Keys(context.Background(), "waitingroom:*").Result()
+		if len(keys) > 0 {
+			_ = rdb.Del(context.Background(), keys...).Err()
+		}
+	}
+	reset()
+	t.Cleanup(reset)
+
+	return ctx, New(rdb, Options{Secret: "secret", AdmitPerSecond: rate, CheckoutWindow: time.Minute})
+}
+
+// rewind moves the room's admission clock back by d, as if d had passed
+// since admissions were last settled.
+func rewind(t *testing.T, ctx context.Context, s *Service, d time.Duration) {
+	t.Helper()
+	now, err := s.rdb.Time(ctx).Result()
+	if err != nil {
+		t.Fatalf("redis time: %v", err)
+	}
+	last := strconv.FormatInt(now.Add(-d).UnixMilli(), 10)
+	if err := s.rdb.Set(ctx, keyLast, last, 0).Err(); err != nil {
+		t.Fatalf("rewind: %v", err)
+	}
+}
+
+func join(t *testing.T, ctx context.Context, s *Service, userID uuid.UUID) *TicketStatus {
+	t.Helper()
+	st, err := s.Join(ctx, userID)
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	return st
+}
+
+func TestAdvance_AdmitsAtTheRoomRate(t *testing.T) {
+	ctx, s := newTestService(t, 1)
+	if _, err := s.Open(ctx, 0); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	users := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	tickets := make([]string, len(users))
+	for i, u := range users {
+		tickets[i] = join(t, ctx, s, u).Ticket
+	}
+	if _, err := s.Admit(ctx, tickets[0], users[0]); !errors.Is(err, ErrNotAdmitted) {
+		t.Fatalf("Admit before any time passed: err = %v, want ErrNotAdmitted", err)
+	}
+
+	// 2.5s at one a second admits two; the half second carries over.
+	rewind(t, ctx, s, 2500*time.Millisecond)
+	room, err := s.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if room.Joined != 3 || room.Admitted != 2 || room.Waiting != 1 {
+		t.Fatalf("room = %+v, want 3 joined and 2 admitted", room)
+	}
+	for i := 0; i < 2; i++ {
+		st, err := s.Admit(ctx, tickets[i], users[i])
+		if err != nil || st.CheckoutExpiresAt == nil {
+			t.Fatalf("Admit ticket %d = %+v, %v; want admitted with a checkout window", i+1, st, err)
+		}
+	}
+	st, err := s.Admit(ctx, tickets[2], users[2])
+	if !errors.Is(err, ErrNotAdmitted) || st.Position != 1 || st.EstimatedWaitSeconds != 1 {
+		t.Fatalf("Admit ticket 3 = %+v, %v; want position 1, about a second away", st, err)
+	}
+	if _, err := s.Admit(ctx, tickets[0], users[1]); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("Admit with another user's ticket: err = %v, want ErrInvalidTicket", err)
+	}
+	if _, err := s.Admit(ctx, "", users[0]); !errors.Is(err, ErrTicketRequired) {
+		t.Fatalf("Admit without a ticket: err = %v, want ErrTicketRequired", err)
+	}
+
+	// Admissions stop at the last visitor, and idle time is not banked.
+	rewind(t, ctx, s, time.Minute)
+	if room, _ = s.Status(ctx); room.Admitted != 3 {
+		t.Fatalf("admitted = %d, want all 3", room.Admitted)
+	}
+	join(t, ctx, s, uuid.New())
+	if room, _ = s.Status(ctx); room.Admitted != 3 || room.Waiting != 1 {
+		t.Fatalf("room after an idle minute = %+v, want the new visitor waiting", room)
+	}
+}
+
+func TestTransitions_PauseDrainClose(t *testing.T) {
+	ctx, s := newTestService(t, 1)
+
+	for name, change := range map[string]func(context.Context) (RoomStatus, error){
+		"pause": s.Pause,
+		"drain": s.Drain,
+	} {
+		if _, err := change(ctx); !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("%s a closed room: err = %v, want ErrInvalidTransition", name, err)
+		}
+	}
+	if _, err := s.Join(ctx, uuid.New()); !errors.Is(err, ErrRoomClosed) {
+		t.Fatalf("Join a closed room: err = %v, want ErrRoomClosed", err)
+	}
+	if st, err := s.Admit(ctx, "", uuid.New()); st != nil || err != nil {
+		t.Fatalf("Admit while closed = %+v, %v; want through without a ticket", st, err)
+	}
+
+	if _, err := s.Open(ctx, -1); !errors.Is(err, ErrInvalidRate) {
+		t.Fatalf("Open with a negative rate: err = %v, want ErrInvalidRate", err)
+	}
+	if room, err := s.Open(ctx, 2); err != nil || room.State != StateOpen || room.AdmitPerSecond != 2 {
+		t.Fatalf("Open = %+v, %v; want open at 2 a second", room, err)
+	}
+	first, second := uuid.New(), uuid.New()
+	join(t, ctx, s, first)
+
+	// Paused: visitors join but nobody is admitted, however long it lasts.
+	if room, err := s.Pause(ctx); err != nil || room.State != StatePaused {
+		t.Fatalf("Pause = %+v, %v", room, err)
+	}
+	ticket := join(t, ctx, s, second).Ticket
+	rewind(t, ctx, s, time.Minute)
+	if room, _ := s.Status(ctx); room.Admitted != 0 || room.Joined != 2 {
+		t.Fatalf("room while paused = %+v, want 2 joined and none admitted", room)
+	}
+
+	// Draining: no new visitors, those waiting are still admitted.
+	if room, err := s.Drain(ctx); err != nil || room.State != StateDraining {
+		t.Fatalf("Drain = %+v, %v", room, err)
+	}
+	if _, err := s.Join(ctx, uuid.New()); !errors.Is(err, ErrRoomClosed) {
+		t.Fatalf("Join a draining room: err = %v, want ErrRoomClosed", err)
+	}
+	if again := join(t, ctx, s, second); again.Position != 2 {
+		t.Fatalf("rejoin while draining = %+v, want the same place", again)
+	}
+	rewind(t, ctx, s, time.Second)
+	if _, err := s.Admit(ctx, ticket, second); err != nil {
+		t.Fatalf("Admit while draining: %v", err)
+	}
+
+	// Resuming keeps the queue.
+	if room, err := s.Open(ctx, 0); err != nil || room.State != StateOpen || room.Joined != 2 {
+		t.Fatalf("resume = %+v, %v; want the same queue open", room, err)
+	}
+	if s.AdmitsWhileUnavailable() {
+		t.Fatal("AdmitsWhileUnavailable with the room open, want orders turned away")
+	}
+	if room, err := s.Close(ctx); err != nil || room.State != StateClosed {
+		t.Fatalf("Close = %+v, %v", room, err)
+	}
+	if !s.AdmitsWhileUnavailable() {
+		t.Fatal("AdmitsWhileUnavailable with the room closed, want orders through")
+	}
+}
+
+func TestOpen_VoidsTicketsOfEarlierOpenings(t *testing.T) {
+	ctx, s := newTestService(t, 1)
+	if _, err := s.Open(ctx, 0); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	userID := uuid.New()
+	old := join(t, ctx, s, userID).Ticket
+	rewind(t, ctx, s, time.Second)
+	if _, err := s.Admit(ctx, old, userID); err != nil {
+		t.Fatalf("Admit: %v", err)
+	}
+
+	if _, err := s.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := s.TicketStatus(ctx, old, userID); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("TicketStatus while closed: err = %v, want ErrInvalidTicket", err)
+	}
+	if _, err := s.Open(ctx, 0); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := s.Admit(ctx, old, userID); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("Admit with a ticket from the last opening: err = %v, want ErrInvalidTicket", err)
+	}
+
+	// The new queue starts again from the first place.
+	fresh := join(t, ctx, s, userID)
+	if fresh.Ticket == old || fresh.Position != 1 {
+		t.Fatalf("join after reopening = %+v, want a new ticket at place 1", fresh)
+	}
+}
+
+func TestAdmitsWhileUnavailable(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 50 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	for _, failOpen := range []bool{false, true} {
+		s := New(rdb, Options{Secret: "secret", FailOpen: failOpen})
+		if _, err := s.Admit(context.Background(), "", uuid.New()); err == nil {
+			t.Fatal("Admit without redis succeeded, want an error")
+		}
+		if got := s.AdmitsWhileUnavailable(); got != failOpen {
+			t.Errorf("AdmitsWhileUnavailable with FailOpen %v and the room never seen = %v", failOpen, got)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ticket is the signed claim a visitor presents: their place in the queue
+// of one opening of the room, for one user.
+type ticket struct {
+	Epoch  int64     `json:"e"`
+	Seq    int64     `json:"s"`
+	UserID uuid.UUID `json:"u"`
+}
+
+func signTicket(secret []byte, t ticket) string {
+	payload, _ := json.Marshal(t)
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + base64.RawURLEncoding.EncodeToString(ticketMAC(secret, body))
+}
+
+// parseTicket verifies the token's signature and returns its ticket.
+func parseTicket(secret []byte, token string) (ticket, error) {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ticket{}, ErrInvalidTicket
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, ticketMAC(secret, body)) {
+		return ticket{}, ErrInvalidTicket
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return ticket{}, ErrInvalidTicket
+	}
+	var t ticket
+	if err := json.Unmarshal(payload, &t); err != nil || t.Seq <= 0 {
+		return ticket{}, ErrInvalidTicket
+	}
+	return t, nil
+}
+
+func ticketMAC(secret []byte, body string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("queue-ticket:" + body))
+	return m.Sum(nil)
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTicketRoundTrip(t *testing.T) {
+	secret := []byte("secret")
+	want := ticket{Epoch: 3, Seq: 42, UserID: uuid.New()}
+
+	got, err := parseTicket(secret, signTicket(secret, want))
+	if err != nil {
+		t.Fatalf("parseTicket: %v", err)
+	}
+	if got != want {
+		t.Fatalf("parseTicket = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseTicketRejectsTampering(t *testing.T) {
+	secret := []byte("secret")
+	token := signTicket(secret, ticket{Epoch: 1, Seq: 500, UserID: uuid.New()})
+	body, sig, _ := strings.Cut(token, ".")
+	forged, _, _ := strings.Cut(signTicket([]byte("other"), ticket{Epoch: 1, Seq: 1, UserID: uuid.New()}), ".")
+
+	for name, token := range map[string]string{
+		"empty":           "",
+		"no signature":    body,
+		"wrong secret":    signTicket([]byte("other"), ticket{Epoch: 1, Seq: 500, UserID: uuid.New()}),
+		"swapped body":    forged + "." + sig,
+		"bad encoding":    body + ".!!",
+		"zero position":   signTicket(secret, ticket{Epoch: 1, UserID: uuid.New()}),
+		"not base64 json": "e30." + sig,
+	} {
+		if _, err := parseTicket(secret, token); !errors.Is(err, ErrInvalidTicket) {
+			t.Errorf("%s: err = %v, want ErrInvalidTicket", name, err)
+		}
+	}
+}
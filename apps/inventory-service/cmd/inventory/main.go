@@ -9,6 +9,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
@@ -59,8 +60,18 @@ func main() {
 	producer := kafka.NewProducer(cfg.Kafka.Brokers, cfg.Kafka.ClientID)
 	defer func() { _ = producer.Close() }()
 
-	r := inventoryrepo.NewPostgres(pool)
-	svc := inventoryservice.New(r, redisClient, producer, log)
+	strategy, err := inventoryrepo.ParseStrategy(os.Getenv("INVENTORY_ALLOCATION_STRATEGY"))
+	if err != nil {
+		log.Error("invalid allocation strategy", map[string]any{"err": err.Error()})
+		os.Exit(1)
+	}
+	hotProducts, err := inventoryservice.ParseProductIDs(os.Getenv("INVENTORY_FAST_PATH_PRODUCTS"))
+	if err != nil {
+		log.Error("invalid fast-path products", map[string]any{"err": err.Error()})
+		os.Exit(1)
+	}
+	r := inventoryrepo.NewPostgres(pool).WithStrategy(strategy)
+	svc := inventoryservice.New(r, redisClient, producer, log).WithFastPath(hotProducts)
 	ctrl := inventorycontroller.New(svc)
 
 	stop := make(chan os.Signal, 1)
@@ -74,6 +85,7 @@ func main() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", inventoryHealth)
 	mux.HandleFunc("/version", inventoryVersion)
+	mux.Handle("/debug/vars", expvar.Handler())
 	mux.Handle("/swagger/", httpSwagger.WrapHandler)
 
 	srv := &http.Server{
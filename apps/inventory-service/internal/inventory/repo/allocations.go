@@ -0,0 +1,230 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrPreorderLimit is returned by Reserve when a preorder would exceed the
+// product's preorder_limit.
+var ErrPreorderLimit = errors.New("preorder limit reached")
+
+// Sell modes of a product; see migration 010.
+const (
+	SellInStockOnly = "in_stock_only"
+	SellPreorder    = "preorder"
+	SellBackorder   = "backorder"
+)
+
+// Kinds of a waiting allocation.
+const (
+	KindPreorder  = "preorder"
+	KindBackorder = "backorder"
+)
+
+// Order statuses of orders with lines waiting for stock.
+const (
+	OrderStatusPreordered  = "preordered"
+	OrderStatusBackordered = "backordered"
+)
+
+// Reservation describes how Reserve placed an order.
+type Reservation struct {
+	// Waiting lists the lines queued for stock that has not arrived.
+	Waiting []OrderItem
+	// Preorder is set when any waiting line is a preorder.
+	Preorder bool
+}
+
+// AwaitingStock reports whether any line was queued rather than reserved.
+func (r *Reservation) AwaitingStock() bool { return len(r.Waiting) > 0 }
+
+// stockState is the locked inventory row of a product with its sell mode.
+type stockState struct {
+	available     int
+	sellMode      string
+	releaseDate   *time.Time
+	preorderLimit *int
+	// queued is the quantity already waiting in the product's queue.
+	queued int
+}
+
+// placeLine decides how a line of qty is placed: reserved now (kind ""),
+// or queued as a preorder or backorder. Nobody jumps the queue: while lines
+// are waiting, new ones wait behind them even if stock would cover them.
+func placeLine(st stockState, qty int, now time.Time) (kind string, err error) {
+	prerelease := st.sellMode == SellPreorder && st.releaseDate != nil && st.releaseDate.After(now)
+	mustWait := prerelease || (st.queued > 0 && st.sellMode != SellInStockOnly)
+	if !mustWait && st.available >= qty {
+		return "", nil
+	}
+
+	switch st.sellMode {
+	case SellPreorder:
+		if st.preorderLimit != nil && st.queued+qty > *st.preorderLimit {
+			return "", ErrPreorderLimit
+		}
+		return KindPreorder, nil
+	case SellBackorder:
+		return KindBackorder, nil
+	default:
+		return "", ErrOutOfStock
+	}
+}
+
+// cancelWaiting cancels the order's waiting lines.
+func cancelWaiting(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE inventory_allocations
+		SET status = 'cancelled'
+		WHERE order_id = $1 AND status = 'pending'
+	`, orderID)
+	return err
+}
+
+// AllocateWaiting fills queued lines from available stock, oldest first per
+// product, and returns the orders whose last waiting line was filled; those
+// are moved back to payment_required and their payment window starts. A
+// line that does not fit stops its product's queue so later, smaller lines
+// cannot overtake it. Preorders are only filled once the release date has
+// passed.
+func (r *Postgres) AllocateWaiting(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT DISTINCT a.product_id
+		FROM inventory_allocations a
+		JOIN products p ON p.id = a.product_id
+		JOIN inventory i ON i.product_id = a.product_id
+		WHERE a.status = 'pending' AND i.available > 0
+		  AND (p.sell_mode <> 'preorder' OR p.release_date IS NULL OR p.release_date <= NOW())
+	`)
+	if err != nil {
+		return nil, err
+	}
+	productIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+
+	var ready []uuid.UUID
+	for _, pid := range productIDs {
+		orders, err := r.allocateProduct(ctx, pid)
+		if err != nil {
+			return ready, err
+		}
+		ready = append(ready, orders...)
+	}
+	return ready, nil
+}
+
+// allocateProduct runs one product's queue in its own transaction so the
+// inventory row is not held across products.
+func (r *Postgres) allocateProduct(ctx context.Context, productID uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var available int
+	if err := tx.QueryRow(ctx, `
+		SELECT LEAST(i.available, `+activeStockSQL+`) FROM inventory i WHERE i.product_id = $1 FOR UPDATE
+	`, productID).Scan(&available); err != nil {
+		return nil, err
+	}
+
+	// Lines of orders cancelled meanwhile are left for Release to cancel.
+	rows, err := tx.Query(ctx, `
+		SELECT a.id, a.order_id, a.quantity, COALESCE(o.shipping_country, ''), COALESCE(o.shipping_region, '')
+		FROM inventory_allocations a
+		JOIN orders o ON o.id = a.order_id
+		WHERE a.product_id = $1 AND a.status = 'pending'
+		  AND o.status IN ('preordered', 'backordered')
+		ORDER BY a.created_at, a.id
+		FOR UPDATE OF a
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	type line struct {
+		id, orderID uuid.UUID
+		qty         int
+		dest        Destination
+	}
+	var fill []line
+	for rows.Next() {
+		var l line
+		if err := rows.Scan(&l.id, &l.orderID, &l.qty, &l.dest.Country, &l.dest.Region); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if l.qty > available {
+			break
+		}
+		available -= l.qty
+		fill = append(fill, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(fill) == 0 {
+		return nil, nil
+	}
+
+	touched := make([]uuid.UUID, 0, len(fill))
+	for _, l := range fill {
+		if err := move(ctx, tx, movement{
+			productID:   productID,
+			typ:         EntrySale,
+			quantity:    l.qty,
+			reason:      "allocated to waiting order",
+			referenceID: &l.orderID,
+		}, func(lv Levels) Levels { return reserveLevels(lv, l.qty) }); err != nil {
+			return nil, err
+		}
+		items := []OrderItem{{ProductID: productID, Quantity: l.qty}}
+		if err := reserveAtWarehouses(ctx, tx, l.orderID, items, l.dest, r.strategy); err != nil {
+			return nil, err
+		}
+		if err := holdReservations(ctx, tx, l.orderID, items, nil); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE inventory_allocations SET status = 'allocated', allocated_at = NOW() WHERE id = $1
+		`, l.id); err != nil {
+			return nil, err
+		}
+		touched = append(touched, l.orderID)
+	}
+
+	rows, err = tx.Query(ctx, `
+		UPDATE orders o
+		SET status = 'payment_required', updated_at = NOW()
+		WHERE o.id = ANY($1::uuid[]) AND o.status IN ('preordered', 'backordered')
+		  AND NOT EXISTS (
+			SELECT 1 FROM inventory_allocations a WHERE a.order_id = o.id AND a.status = 'pending'
+		  )
+		RETURNING o.id
+	`, touched)
+	if err != nil {
+		return nil, err
+	}
+	ready, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+	if len(ready) > 0 {
+		if err := startPaymentWindow(ctx, tx, ready, time.Now().Add(r.reservationTTL)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return ready, nil
+}
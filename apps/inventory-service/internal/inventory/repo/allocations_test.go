@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPlaceLine(t *testing.T) {
+	now := time.Date(2026, 9, 1, 9, 0, 0, 0, time.UTC)
+	future, past := now.Add(72*time.Hour), now.Add(-time.Hour)
+	limit := 10
+
+	for name, tc := range map[string]struct {
+		st       stockState
+		qty      int
+		wantKind string
+		wantErr  error
+	}{
+		"in stock":                            {st: stockState{available: 5, sellMode: SellInStockOnly}, qty: 2},
+		"in stock only, short":                {st: stockState{available: 1, sellMode: SellInStockOnly}, qty: 2, wantErr: ErrOutOfStock},
+		"backorder, in stock":                 {st: stockState{available: 5, sellMode: SellBackorder}, qty: 2},
+		"backorder, short":                    {st: stockState{available: 1, sellMode: SellBackorder}, qty: 2, wantKind: KindBackorder},
+		"backorder, queue ahead":              {st: stockState{available: 5, sellMode: SellBackorder, queued: 3}, qty: 1, wantKind: KindBackorder},
+		"preorder before release":             {st: stockState{available: 50, sellMode: SellPreorder, releaseDate: &future}, qty: 1, wantKind: KindPreorder},
+		"preorder after release":              {st: stockState{available: 5, sellMode: SellPreorder, releaseDate: &past}, qty: 1},
+		"preorder at the cap":                 {st: stockState{sellMode: SellPreorder, releaseDate: &future, preorderLimit: &limit, queued: 9}, qty: 1, wantKind: KindPreorder},
+		"preorder over the cap":               {st: stockState{sellMode: SellPreorder, releaseDate: &future, preorderLimit: &limit, queued: 9}, qty: 2, wantErr: ErrPreorderLimit},
+		"in stock only ignores a stale queue": {st: stockState{available: 5, sellMode: SellInStockOnly, queued: 3}, qty: 1},
+	} {
+		kind, err := placeLine(tc.st, tc.qty, now)
+		if !errors.Is(err, tc.wantErr) || kind != tc.wantKind {
+			t.Errorf("%s: placeLine = (%q, %v), want (%q, %v)", name, kind, err, tc.wantKind, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// HotStock admits orders for hot products against counters in Redis
+// instead of the inventory row lock, for launches where every order goes
+// for the same few products.
+//
+// Postgres stays the source of truth. An admitted order is queued and
+// written behind with ApplyReservation; until then its units are pending.
+// A product's counter is what may still be admitted: Postgres available
+// less the pending units. Resync resets it from Postgres and errs low when
+// writes or debits race with it, so admitting never gets ahead of Postgres. Units
+// returned to Postgres reach the counter at the next resync; units taken
+// outside the fast path are debited right away.
+type HotStock struct {
+	rdb *redis.Client
+}
+
+func NewHotStock(rdb *redis.Client) *HotStock {
+	return &HotStock{rdb: rdb}
+}
+
+// TakeResult is the outcome of HotStock.Take.
+type TakeResult int
+
+const (
+	// HotTaken means the order was admitted and queued.
+	HotTaken TakeResult = iota
+	// HotSoldOut means a counter is short of the order's quantity.
+	HotSoldOut
+	// HotNotLoaded means a product has no counter; reserve in Postgres.
+	HotNotLoaded
+	// HotDuplicate means the order was already admitted.
+	HotDuplicate
+)
+
+// HotOrder is an admitted order waiting to be written to Postgres.
+type HotOrder struct {
+	OrderID uuid.UUID
+	Items   []OrderItem
+}
+
+type hotItem struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+}
+
+const (
+	hotOrdersKey = "inventory:hot:orders"
+	hotQueueKey  = "inventory:hot:queue"
+)
+
+func hotCounterKey(productID uuid.UUID) string { return "inventory:hot:" + productID.String() }
+func hotPendingKey(productID uuid.UUID) string { return hotCounterKey(productID) + ":pending" }
+func hotAppliedKey(productID uuid.UUID) string { return hotCounterKey(productID) + ":applied" }
+func hotDebitedKey(productID uuid.UUID) string { return hotCounterKey(productID) + ":debited" }
+
+// takeScript checks every counter before taking anything, so an order is
+// admitted whole or not at all.
+// KEYS: counter and pending key per product, the orders hash, the queue.
+// ARGV: order id, payload, quantity per product.
+var takeScript = redis.NewScript(`
+local n = (#KEYS - 2) / 2
+for i = 1, n do
+	local v = redis.call('GET', KEYS[2*i-1])
+	if not v then return 2 end
+	if tonumber(v) < tonumber(ARGV[i+2]) then return 1 end
+end
+if redis.call('HSETNX', KEYS[2*n+1], ARGV[1], ARGV[2]) == 0 then return 3 end
+for i = 1, n do
+	redis.call('DECRBY', KEYS[2*i-1], ARGV[i+2])
+	redis.call('INCRBY', KEYS[2*i], ARGV[i+2])
+end
+redis.call('RPUSH', KEYS[2*n+2], ARGV[1])
+return 0
+`)
+
+// settleScript removes a queued order and its pending units, counting them
+// as applied when ARGV[2] is "1".
+// KEYS: pending and applied key per product, the orders hash, the queue.
+// ARGV: order id, applied flag, quantity per product.
+var settleScript = redis.NewScript(`
+local n = (#KEYS - 2) / 2
+redis.call('LREM', KEYS[2*n+2], 0, ARGV[1])
+if redis.call('HDEL', KEYS[2*n+1], ARGV[1]) == 0 then return 0 end
+for i = 1, n do
+	redis.call('DECRBY', KEYS[2*i-1], ARGV[i+2])
+	if ARGV[2] == '1' then redis.call('INCRBY', KEYS[2*i], ARGV[i+2]) end
+end
+return 1
+`)
+
+// resyncScript sets the counter to Postgres available less the pending
+// units, and less the units applied and debited since available was read:
+// those may or may not be in it.
+// KEYS: counter, pending, applied, debited.
+// ARGV: available, applied and debited before the read.
+var resyncScript = redis.NewScript(`
+local pending = tonumber(redis.call('GET', KEYS[2]) or '0')
+local applied = tonumber(redis.call('GET', KEYS[3]) or '0')
+local debited = tonumber(redis.call('GET', KEYS[4]) or '0')
+local v = tonumber(ARGV[1]) - pending - (applied - tonumber(ARGV[2])) - (debited - tonumber(ARGV[3]))
+if v < 0 then v = 0 end
+redis.call('SET', KEYS[1], v)
+return v
+`)
+
+// debitScript lowers a loaded counter, not below zero, and counts the
+// units as debited whether it is loaded or not, for a resync in flight.
+// KEYS: counter, debited. ARGV: quantity.
+var debitScript = redis.NewScript(`
+redis.call('INCRBY', KEYS[2], ARGV[1])
+local v = redis.call('GET', KEYS[1])
+if not v then return -1 end
+v = math.max(tonumber(v) - tonumber(ARGV[1]), 0)
+redis.call('SET', KEYS[1], v)
+return v
+`)
+
+// mergeItems sums the quantities of each product, in first-seen order.
+func mergeItems(items []OrderItem) []OrderItem {
+	out := make([]OrderItem, 0, len(items))
+	index := map[uuid.UUID]int{}
+	for _, it := range items {
+		if i, ok := index[it.ProductID]; ok {
+			out[i].Quantity += it.Quantity
+			continue
+		}
+		index[it.ProductID] = len(out)
+		out = append(out, it)
+	}
+	return out
+}
+
+// Take admits the order if every product's counter covers it.
+func (h *HotStock) Take(ctx context.Context, orderID uuid.UUID, items []OrderItem) (TakeResult, error) {
+	items = mergeItems(items)
+	payload := make([]hotItem, 0, len(items))
+	keys := make([]string, 0, 2*len(items)+2)
+	args := []any{orderID.String(), nil}
+	for _, it := range items {
+		if it.Quantity <= 0 {
+			return 0, errors.New("quantity must be > 0")
+		}
+		payload = append(payload, hotItem{ProductID: it.ProductID, Quantity: it.Quantity})
+		keys = append(keys, hotCounterKey(it.ProductID), hotPendingKey(it.ProductID))
+		args = append(args, it.Quantity)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	args[1] = b
+	keys = append(keys, hotOrdersKey, hotQueueKey)
+
+	n, err := takeScript.Run(ctx, h.rdb, keys, args...).Int()
+	if err != nil {
+		return 0, err
+	}
+	return TakeResult(n), nil
+}
+
+// Pending returns up to limit queued orders, oldest first.
+func (h *HotStock) Pending(ctx context.Context, limit int) ([]HotOrder, error) {
+	ids, err := h.rdb.LRange(ctx, hotQueueKey, 0, int64(limit)-1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	vals, err := h.rdb.HMGet(ctx, hotOrdersKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]HotOrder, 0, len(ids))
+	for i, v := range vals {
+		raw, ok := v.(string)
+		if !ok {
+			// Settled between the two reads.
+			_ = h.rdb.LRem(ctx, hotQueueKey, 0, ids[i]).Err()
+			continue
+		}
+		o, err := decodeHotOrder(ids[i], raw)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+// Get returns the order if it is still waiting to be written to Postgres.
+func (h *HotStock) Get(ctx context.Context, orderID uuid.UUID) (HotOrder, bool, error) {
+	raw, err := h.rdb.HGet(ctx, hotOrdersKey, orderID.String()).Result()
+	if errors.Is(err, redis.Nil) {
+		return HotOrder{}, false, nil
+	}
+	if err != nil {
+		return HotOrder{}, false, err
+	}
+	o, err := decodeHotOrder(orderID.String(), raw)
+	return o, err == nil, err
+}
+
+func decodeHotOrder(id, raw string) (HotOrder, error) {
+	orderID, err := uuid.Parse(id)
+	if err != nil {
+		return HotOrder{}, fmt.Errorf("hot order %q: %w", id, err)
+	}
+	var items []hotItem
+	if err := json.Unmarshal([]byte(raw), &items); err != nil {
+		return HotOrder{}, fmt.Errorf("hot order %s: %w", id, err)
+	}
+	o := HotOrder{OrderID: orderID, Items: make([]OrderItem, 0, len(items))}
+	for _, it := range items {
+		o.Items = append(o.Items, OrderItem{ProductID: it.ProductID, Quantity: it.Quantity})
+	}
+	return o, nil
+}
+
+// Ack removes an order written to Postgres from the queue.
+func (h *HotStock) Ack(ctx context.Context, o HotOrder) error {
+	return h.settle(ctx, o, true)
+}
+
+// Drop removes an order Postgres rejected from the queue. Its units are not
+// given back to the counter; the next resync does that.
+func (h *HotStock) Drop(ctx context.Context, o HotOrder) error {
+	return h.settle(ctx, o, false)
+}
+
+func (h *HotStock) settle(ctx context.Context, o HotOrder, applied bool) error {
+	keys := make([]string, 0, 2*len(o.Items)+2)
+	flag := "0"
+	if applied {
+		flag = "1"
+	}
+	args := []any{o.OrderID.String(), flag}
+	for _, it := range o.Items {
+		keys = append(keys, hotPendingKey(it.ProductID), hotAppliedKey(it.ProductID))
+		args = append(args, it.Quantity)
+	}
+	keys = append(keys, hotOrdersKey, hotQueueKey)
+	return settleScript.Run(ctx, h.rdb, keys, args...).Err()
+}
+
+// HotWrites counts the units of a product written to Postgres so far:
+// Applied by write-behind, Debited for stock taken outside the fast path.
+type HotWrites struct {
+	Applied int
+	Debited int
+}
+
+// Writes returns the HotWrites of each product; pass them to Resync.
+func (h *HotStock) Writes(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]HotWrites, error) {
+	out := make(map[uuid.UUID]HotWrites, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	keys := make([]string, 0, 2*len(ids))
+	for _, id := range ids {
+		keys = append(keys, hotAppliedKey(id), hotDebitedKey(id))
+	}
+	vals, err := h.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]int, len(vals))
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			if counts[i], err = strconv.Atoi(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i, id := range ids {
+		out[id] = HotWrites{Applied: counts[2*i], Debited: counts[2*i+1]}
+	}
+	return out, nil
+}
+
+// Resync sets the product's counter from its Postgres available stock,
+// read after before was; see Writes. It returns the new counter.
+func (h *HotStock) Resync(ctx context.Context, productID uuid.UUID, available int, before HotWrites) (int, error) {
+	return resyncScript.Run(ctx, h.rdb,
+		[]string{hotCounterKey(productID), hotPendingKey(productID), hotAppliedKey(productID), hotDebitedKey(productID)},
+		available, before.Applied, before.Debited).Int()
+}
+
+// Unload removes the product's counter so its orders go to Postgres.
+// Orders already admitted are still written behind.
+func (h *HotStock) Unload(ctx context.Context, productID uuid.UUID) error {
+	return h.rdb.Del(ctx, hotCounterKey(productID)).Err()
+}
+
+// Debit lowers the counters of loaded products by stock taken outside the
+// fast path.
+func (h *HotStock) Debit(ctx context.Context, items []OrderItem) error {
+	for _, it := range mergeItems(items) {
+		keys := []string{hotCounterKey(it.ProductID), hotDebitedKey(it.ProductID)}
+		if err := debitScript.Run(ctx, h.rdb, keys, it.Quantity).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
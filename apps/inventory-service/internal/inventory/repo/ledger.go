@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Ledger entry types written by this service; see migration 012.
+const (
+	EntrySale           = "sale"
+	EntryRelease        = "release"
+	EntryFulfillment    = "fulfillment"
+	EntryReconciliation = "reconciliation"
+)
+
+// Levels are the stock counts of an inventory row.
+type Levels struct {
+	Available int `json:"available"`
+	Reserved  int `json:"reserved"`
+	OnHand    int `json:"on_hand"`
+}
+
+// movement describes a ledger entry; the levels are filled in by move.
+type movement struct {
+	productID   uuid.UUID
+	typ         string
+	quantity    int
+	reason      string
+	referenceID *uuid.UUID
+}
+
+func reserveLevels(l Levels, qty int) Levels {
+	return Levels{Available: l.Available - qty, Reserved: l.Reserved + qty, OnHand: l.OnHand}
+}
+
+// releaseLevels returns qty reserved units to available. Reserved is
+// clamped at zero, as it always has been, so a double release cannot take
+// it negative.
+func releaseLevels(l Levels, qty int) Levels {
+	return Levels{Available: l.Available + qty, Reserved: max(l.Reserved-qty, 0), OnHand: l.OnHand}
+}
+
+// fulfillLevels takes qty reserved units out of the warehouse.
+func fulfillLevels(l Levels, qty int) Levels {
+	return Levels{Available: l.Available, Reserved: max(l.Reserved-qty, 0), OnHand: max(l.OnHand-qty, 0)}
+}
+
+// move locks the product's inventory row, applies fn to it and records the
+// change in the ledger, all in tx. It returns pgx.ErrNoRows if the product
+// has no inventory row.
+func move(ctx context.Context, tx pgx.Tx, m movement, fn func(Levels) Levels) error {
+	before, err := lockLevels(ctx, tx, m.productID)
+	if err != nil {
+		return err
+	}
+	after := fn(before)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE inventory
+		SET available = $2, reserved = $3, on_hand = $4, updated_at = NOW()
+		WHERE product_id = $1
+	`, m.productID, after.Available, after.Reserved, after.OnHand); err != nil {
+		return err
+	}
+	return recordEntry(ctx, tx, m, before, after)
+}
+
+// lockLevels locks the product's inventory row in tx and returns its levels.
+func lockLevels(ctx context.Context, tx pgx.Tx, productID uuid.UUID) (Levels, error) {
+	var l Levels
+	err := tx.QueryRow(ctx, `
+		SELECT available, reserved, on_hand FROM inventory WHERE product_id = $1 FOR UPDATE
+	`, productID).Scan(&l.Available, &l.Reserved, &l.OnHand)
+	return l, err
+}
+
+func recordEntry(ctx context.Context, tx pgx.Tx, m movement, before, after Levels) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO inventory_adjustments (product_id, adjustment_type, quantity,
+		                                   available_before, available_after,
+		                                   reserved_before, reserved_after,
+		                                   on_hand_before, on_hand_after, reason, reference_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11)
+	`, m.productID, m.typ, m.quantity, before.Available, after.Available,
+		before.Reserved, after.Reserved, before.OnHand, after.OnHand, m.reason, m.referenceID)
+	return err
+}
+
+// Drift is a product whose inventory row differs from its replayed ledger.
+type Drift struct {
+	ProductID uuid.UUID
+	Row       Levels
+	Ledger    Levels
+}
+
+// FindDrift replays every product's ledger and returns those whose
+// inventory row disagrees. The row and its ledger are read in a single
+// statement, so a concurrent mutation (which writes both in one
+// transaction) cannot show up as drift.
+func (r *Postgres) FindDrift(ctx context.Context) ([]Drift, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT i.product_id, i.available, i.reserved, i.on_hand, l.available, l.reserved, l.on_hand
+		FROM inventory i
+		CROSS JOIN LATERAL inventory_ledger_balance(i.product_id) l
+		WHERE (l.available, l.reserved, l.on_hand) IS DISTINCT FROM (i.available, i.reserved, i.on_hand)
+		ORDER BY i.product_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Drift
+	for rows.Next() {
+		var d Drift
+		if err := rows.Scan(&d.ProductID, &d.Row.Available, &d.Row.Reserved, &d.Row.OnHand,
+			&d.Ledger.Available, &d.Ledger.Reserved, &d.Ledger.OnHand); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// RepairDrift records a reconciliation entry taking the product's ledger to
+// its inventory row. The row is kept: it is what reservations were checked
+// against, so drift means a change was made without a ledger entry (a
+// manual fix or a seed script), not that the row is wrong. It reports
+// whether an entry was needed once the row was locked.
+func (r *Postgres) RepairDrift(ctx context.Context, productID uuid.UUID) (bool, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	row, err := lockLevels(ctx, tx, productID)
+	if err != nil {
+		return false, err
+	}
+	var ledger Levels
+	if err := tx.QueryRow(ctx, `
+		SELECT available, reserved, on_hand FROM inventory_ledger_balance($1)
+	`, productID).Scan(&ledger.Available, &ledger.Reserved, &ledger.OnHand); err != nil {
+		return false, err
+	}
+	if row == ledger {
+		return false, nil
+	}
+
+	m := movement{
+		productID: productID,
+		typ:       EntryReconciliation,
+		quantity:  row.OnHand - ledger.OnHand,
+		reason:    "ledger drift repaired by reconciliation",
+	}
+	if err := recordEntry(ctx, tx, m, ledger, row); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,30 @@
+package repo
+
+import "testing"
+
+func TestLevelChanges(t *testing.T) {
+	// Reserve 3 for an order, release 1 of them, ship the other 2.
+	l := reserveLevels(Levels{Available: 10, OnHand: 10}, 3)
+	if want := (Levels{Available: 7, Reserved: 3, OnHand: 10}); l != want {
+		t.Fatalf("reserveLevels = %+v, want %+v", l, want)
+	}
+	l = releaseLevels(l, 1)
+	if want := (Levels{Available: 8, Reserved: 2, OnHand: 10}); l != want {
+		t.Fatalf("releaseLevels = %+v, want %+v", l, want)
+	}
+	l = fulfillLevels(l, 2)
+	if want := (Levels{Available: 8, Reserved: 0, OnHand: 8}); l != want {
+		t.Fatalf("fulfillLevels = %+v, want %+v", l, want)
+	}
+}
+
+func TestReleaseLevelsClampsReserved(t *testing.T) {
+	got := releaseLevels(Levels{Available: 5, Reserved: 1, OnHand: 6}, 3)
+	if want := (Levels{Available: 8, Reserved: 0, OnHand: 6}); got != want {
+		t.Fatalf("releaseLevels = %+v, want %+v", got, want)
+	}
+	got = fulfillLevels(Levels{Reserved: 1, OnHand: 1}, 3)
+	if want := (Levels{}); got != want {
+		t.Fatalf("fulfillLevels = %+v, want %+v", got, want)
+	}
+}
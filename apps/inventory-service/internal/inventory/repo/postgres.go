@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -12,10 +13,21 @@ import (
 var ErrOutOfStock = errors.New("out of stock")
 
 type Postgres struct {
-	pool *pgxpool.Pool
+	pool           *pgxpool.Pool
+	strategy       string
+	reservationTTL time.Duration
 }
 
-func NewPostgres(pool *pgxpool.Pool) *Postgres { return &Postgres{pool: pool} }
+func NewPostgres(pool *pgxpool.Pool) *Postgres {
+	return &Postgres{pool: pool, strategy: StrategyPriority, reservationTTL: 10 * time.Minute}
+}
+
+// WithStrategy sets how reservations are split across warehouses; see
+// ParseStrategy.
+func (r *Postgres) WithStrategy(strategy string) *Postgres {
+	r.strategy = strategy
+	return r
+}
 
 type OrderItem struct {
 	ProductID uuid.UUID
@@ -47,132 +59,263 @@ func (r *Postgres) GetOrderStatus(ctx context.Context, orderID uuid.UUID) (strin
 	return status, nil
 }
 
-func (r *Postgres) Reserve(ctx context.Context, orderID uuid.UUID, items []OrderItem) error {
+// Reserve moves the order's quantities from available to reserved. Lines
+// of preorder or backorder products that cannot be reserved now are queued
+// in inventory_allocations instead, and the order is moved to preordered or
+// backordered. Any line of an in-stock-only product that does not fit fails
+// the whole reservation with ErrOutOfStock. Reserved lines are held in
+// inventory_reservations until the order is paid, cancelled or expires.
+// An order is reserved once; asking again returns ErrAlreadyReserved. An
+// order that is no longer awaiting payment is not reserved and returns
+// ErrOrderNotPending.
+func (r *Postgres) Reserve(ctx context.Context, orderID uuid.UUID, items []OrderItem) (*Reservation, error) {
 	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	for _, it := range items {
-		if it.Quantity <= 0 {
-			return errors.New("quantity must be > 0")
-		}
-		tag, err := tx.Exec(ctx, `
-			WITH updated AS (
-				UPDATE inventory
-				SET available = available - $2, reserved = reserved + $2, updated_at = NOW()
-				WHERE product_id = $1 AND available >= $2
-				RETURNING product_id, available + $2 AS available_before, available AS available_after
-			)
-			INSERT INTO inventory_adjustments (product_id, adjustment_type, quantity, available_before, available_after, reason, reference_id)
-			SELECT product_id, 'sale', $2, available_before, available_after, 'reserved for order', $3
-			FROM updated
-		`, it.ProductID, it.Quantity, orderID)
-		if err != nil {
-			return err
-		}
-		if tag.RowsAffected() != 1 {
-			return ErrOutOfStock
-		}
+	res, err := r.reserve(ctx, tx, orderID, items, false)
+	if err != nil {
+		return nil, err
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ApplyReservation writes the reservation of an order admitted by HotStock.
+// It is safe to call more than once for an order: it returns false if the
+// order's stock is already reserved. Hot products are sold in stock only,
+// so a line that would have to wait fails with ErrOutOfStock. An order
+// cancelled before it was written behind fails with ErrOrderNotPending.
+func (r *Postgres) ApplyReservation(ctx context.Context, orderID uuid.UUID, items []OrderItem) (bool, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
+	res, err := r.reserve(ctx, tx, orderID, items, true)
+	if errors.Is(err, ErrAlreadyReserved) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if res.AwaitingStock() {
+		return false, ErrOutOfStock
+	}
 	if err := tx.Commit(ctx); err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	return true, nil
 }
 
-func (r *Postgres) GetOrderItems(ctx context.Context, orderID uuid.UUID) ([]OrderItem, error) {
+// HotAvailable returns the available stock of those of the products that
+// can be sold through HotStock: active and sold in stock only.
+func (r *Postgres) HotAvailable(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]int, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT product_id, quantity
-		FROM order_items
-		WHERE order_id = $1
-		ORDER BY created_at ASC
-	`, orderID)
+		SELECT i.product_id, LEAST(i.available, `+activeStockSQL+`)
+		FROM inventory i
+		JOIN products p ON p.id = i.product_id
+		WHERE i.product_id = ANY($1::uuid[]) AND p.sell_mode = 'in_stock_only'
+		  AND p.is_active AND p.deleted_at IS NULL
+	`, ids)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	out := make([]OrderItem, 0)
+	out := make(map[uuid.UUID]int, len(ids))
 	for rows.Next() {
-		var it OrderItem
-		if err := rows.Scan(&it.ProductID, &it.Quantity); err != nil {
+		var id uuid.UUID
+		var available int
+		if err := rows.Scan(&id, &available); err != nil {
 			return nil, err
 		}
-		out = append(out, it)
+		out[id] = available
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return out, nil
+	return out, rows.Err()
 }
 
-func (r *Postgres) Release(ctx context.Context, orderID uuid.UUID) error {
-	items, err := r.GetOrderItems(ctx, orderID)
-	if err != nil {
-		return err
+func (r *Postgres) reserve(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, items []OrderItem, admitted bool) (*Reservation, error) {
+	if err := claimOrder(ctx, tx, orderID); err != nil {
+		return nil, err
 	}
-
-	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
-	if err != nil {
-		return err
+	if err := lockOrder(ctx, tx, orderID, admitted); err != nil {
+		return nil, err
+	}
+	if err := claimRaffleUnits(ctx, tx, orderID); err != nil {
+		return nil, err
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
 
+	res := &Reservation{}
+	now := time.Now()
+	var reserved []OrderItem
+	// taken counts the units of each product reserved by earlier lines,
+	// which come off warehouse stock only once all lines are placed.
+	taken := map[uuid.UUID]int{}
 	for _, it := range items {
 		if it.Quantity <= 0 {
+			return nil, errors.New("quantity must be > 0")
+		}
+
+		var st stockState
+		var active int
+		err := tx.QueryRow(ctx, `
+			SELECT i.available, `+activeStockSQL+`, p.sell_mode, p.release_date, p.preorder_limit,
+			       COALESCE((SELECT SUM(a.quantity) FROM inventory_allocations a
+			                 WHERE a.product_id = i.product_id AND a.status = 'pending'), 0)
+			FROM inventory i
+			JOIN products p ON p.id = i.product_id
+			WHERE i.product_id = $1
+			FOR UPDATE OF i
+		`, it.ProductID).Scan(&st.available, &active, &st.sellMode, &st.releaseDate, &st.preorderLimit, &st.queued)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrOutOfStock
+		}
+		if err != nil {
+			return nil, err
+		}
+		st.available = min(st.available, active-taken[it.ProductID])
+
+		kind, err := placeLine(st, it.Quantity, now)
+		if err != nil {
+			return nil, err
+		}
+		if kind != "" {
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO inventory_allocations (order_id, product_id, quantity, kind)
+				VALUES ($1, $2, $3, $4)
+			`, orderID, it.ProductID, it.Quantity, kind); err != nil {
+				return nil, err
+			}
+			res.Waiting = append(res.Waiting, it)
+			res.Preorder = res.Preorder || kind == KindPreorder
 			continue
 		}
-		_, err := tx.Exec(ctx, `
-			UPDATE inventory
-			SET available = available + $2,
-			    reserved = GREATEST(reserved - $2, 0),
-			    updated_at = NOW()
-			WHERE product_id = $1
-		`, it.ProductID, it.Quantity)
+
+		if err := move(ctx, tx, movement{
+			productID:   it.ProductID,
+			typ:         EntrySale,
+			quantity:    it.Quantity,
+			reason:      "reserved for order",
+			referenceID: &orderID,
+		}, func(l Levels) Levels { return reserveLevels(l, it.Quantity) }); err != nil {
+			return nil, err
+		}
+		reserved = append(reserved, it)
+		taken[it.ProductID] += it.Quantity
+	}
+
+	if len(reserved) > 0 {
+		dest, err := orderDestination(ctx, tx, orderID)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if err := reserveAtWarehouses(ctx, tx, orderID, reserved, dest, r.strategy); err != nil {
+			return nil, err
+		}
+		// The payment window of an order waiting for stock starts when its
+		// last line is allocated.
+		var expiresAt *time.Time
+		if !res.AwaitingStock() {
+			t := now.Add(r.reservationTTL)
+			expiresAt = &t
+		}
+		if err := holdReservations(ctx, tx, orderID, reserved, expiresAt); err != nil {
+			return nil, err
 		}
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return err
+	if res.AwaitingStock() {
+		status := OrderStatusBackordered
+		if res.Preorder {
+			status = OrderStatusPreordered
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE orders
+			SET status = $2, updated_at = NOW()
+			WHERE id = $1 AND status = 'payment_required'
+		`, orderID, status); err != nil {
+			return nil, err
+		}
 	}
-	return nil
+	return res, nil
+}
+
+// Release returns the order's held reservations to available. Lines still
+// waiting for stock hold nothing; they are cancelled instead. It returns
+// the released quantities by product, none if the order was already
+// settled.
+func (r *Postgres) Release(ctx context.Context, orderID uuid.UUID) ([]OrderItem, error) {
+	return r.settle(ctx, orderID, ReservationReleased)
+}
+
+// Expire is Release for an order whose payment window has passed.
+func (r *Postgres) Expire(ctx context.Context, orderID uuid.UUID) ([]OrderItem, error) {
+	return r.settle(ctx, orderID, ReservationExpired)
 }
 
+// Finalize ships the order's held reservations out of on_hand.
 func (r *Postgres) Finalize(ctx context.Context, orderID uuid.UUID) error {
-	items, err := r.GetOrderItems(ctx, orderID)
-	if err != nil {
-		return err
-	}
+	_, err := r.settle(ctx, orderID, ReservationCommitted)
+	return err
+}
 
+func (r *Postgres) settle(ctx context.Context, orderID uuid.UUID, status string) ([]OrderItem, error) {
 	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	for _, it := range items {
-		if it.Quantity <= 0 {
-			continue
-		}
-		_, err := tx.Exec(ctx, `
-			UPDATE inventory
-			SET reserved = GREATEST(reserved - $2, 0),
-			    on_hand = GREATEST(on_hand - $2, 0),
-			    updated_at = NOW()
-			WHERE product_id = $1
-		`, it.ProductID, it.Quantity)
-		if err != nil {
-			return err
+	items, err := settleReservations(ctx, tx, orderID, status)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// settleReservations ends the order's held reservations with status and
+// moves their stock: out of on_hand when committed, back to available
+// otherwise.
+func settleReservations(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, status string) ([]OrderItem, error) {
+	committed := status == ReservationCommitted
+	if !committed {
+		if err := cancelWaiting(ctx, tx, orderID); err != nil {
+			return nil, err
 		}
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return err
+	items, err := endReservations(ctx, tx, orderID, status)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range items {
+		m := movement{
+			productID:   it.ProductID,
+			typ:         EntryRelease,
+			quantity:    it.Quantity,
+			reason:      "reservation " + status,
+			referenceID: &orderID,
+		}
+		fn := func(l Levels) Levels { return releaseLevels(l, it.Quantity) }
+		if committed {
+			m.typ, m.reason = EntryFulfillment, "order paid"
+			fn = func(l Levels) Levels { return fulfillLevels(l, it.Quantity) }
+		}
+		if err := move(ctx, tx, m, fn); err != nil {
+			return nil, err
+		}
+		if err := settleAtWarehouses(ctx, tx, orderID, it.ProductID, it.Quantity, committed); err != nil {
+			return nil, err
+		}
 	}
-	return nil
+	return items, nil
 }
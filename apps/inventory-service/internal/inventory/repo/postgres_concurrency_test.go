@@ -5,17 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	redis "github.com/redis/go-redis/v9"
 )
 
-func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
-	t.Parallel()
+const (
+	initialStock = 25
+	requests     = 80
+)
+
+// concurrencyFixture is a product with initialStock units split across two
+// warehouses.
+type concurrencyFixture struct {
+	ctx       context.Context
+	pool      *pgxpool.Pool
+	productID uuid.UUID
+}
+
+func newConcurrencyFixture(t *testing.T) *concurrencyFixture {
+	t.Helper()
 
 	dbURL := os.Getenv("TEST_DATABASE_URL")
 	if dbURL == "" {
@@ -23,19 +39,19 @@ func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	t.Cleanup(cancel)
 
 	pool, err := pgxpool.New(ctx, dbURL)
 	if err != nil {
 		t.Skipf("skipping integration test: cannot create pool (%v)", err)
 	}
-	defer pool.Close()
+	t.Cleanup(pool.Close)
 
 	if err := pool.Ping(ctx); err != nil {
 		t.Skipf("skipping integration test: cannot reach postgres (%v)", err)
 	}
 
-	for _, tbl := range []string{"products", "inventory", "inventory_adjustments"} {
+	for _, tbl := range []string{"products", "inventory", "inventory_adjustments", "warehouse_stock", "inventory_reservations"} {
 		var exists bool
 		if err := pool.QueryRow(ctx, `
 			SELECT EXISTS (
@@ -51,27 +67,10 @@ func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
 		}
 	}
 
-	repo := NewPostgres(pool)
-
 	productID := uuid.New()
 	skuPrefix := "TEST-IPHONE-CONCURRENCY-"
 	sku := skuPrefix + uuid.NewString()
-	initialStock := 25
-	requests := 80
-
-	_, _ = pool.Exec(ctx, `
-		DELETE FROM inventory_adjustments
-		WHERE product_id IN (
-			SELECT id FROM products WHERE sku LIKE $1
-		)
-	`, skuPrefix+"%")
-	_, _ = pool.Exec(ctx, `
-		DELETE FROM inventory
-		WHERE product_id IN (
-			SELECT id FROM products WHERE sku LIKE $1
-		)
-	`, skuPrefix+"%")
-	_, _ = pool.Exec(ctx, `DELETE FROM products WHERE sku LIKE $1`, skuPrefix+"%")
+	warehousePrefix := "TEST-WH-" + productID.String()[:8] + "-"
 
 	_, err = pool.Exec(ctx, `
 		INSERT INTO products (id, name, description, sku, price, category, is_active, is_digital)
@@ -84,9 +83,12 @@ func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
 		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cleanupCancel()
 		_, _ = pool.Exec(cleanupCtx, `DELETE FROM inventory_adjustments WHERE product_id = $1`, productID)
+		_, _ = pool.Exec(cleanupCtx, `DELETE FROM order_item_sources WHERE product_id = $1`, productID)
+		_, _ = pool.Exec(cleanupCtx, `DELETE FROM inventory_reservations WHERE product_id = $1`, productID)
+		_, _ = pool.Exec(cleanupCtx, `DELETE FROM warehouse_stock WHERE product_id = $1`, productID)
+		_, _ = pool.Exec(cleanupCtx, `DELETE FROM warehouses WHERE code LIKE $1`, warehousePrefix+"%")
 		_, _ = pool.Exec(cleanupCtx, `DELETE FROM inventory WHERE product_id = $1`, productID)
 		_, _ = pool.Exec(cleanupCtx, `DELETE FROM products WHERE id = $1`, productID)
-		_, _ = pool.Exec(cleanupCtx, `DELETE FROM products WHERE sku LIKE $1`, skuPrefix+"%")
 	})
 
 	_, err = pool.Exec(ctx, `
@@ -97,6 +99,28 @@ func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
 		t.Fatalf("insert inventory: %v", err)
 	}
 
+	// Split the stock across two warehouses so reservations draw on both.
+	for i, qty := range []int{initialStock - 10, 10} {
+		_, err = pool.Exec(ctx, `
+			WITH w AS (
+				INSERT INTO warehouses (code, name, priority) VALUES ($1, $1, $2) RETURNING id
+			)
+			INSERT INTO warehouse_stock (product_id, warehouse_id, available, reserved, on_hand)
+			SELECT $3, id, $4, 0, $4 FROM w
+		`, fmt.Sprintf("%s%d", warehousePrefix, i), i, productID, qty)
+		if err != nil {
+			t.Fatalf("insert warehouse stock: %v", err)
+		}
+	}
+
+	return &concurrencyFixture{ctx: ctx, pool: pool, productID: productID}
+}
+
+// race runs requests concurrent single-unit reservations through reserve
+// and checks that exactly initialStock succeed.
+func race(t *testing.T, reserve func() error) {
+	t.Helper()
+
 	var succeeded int32
 	var outOfStock int32
 	var unexpectedErrs int32
@@ -109,9 +133,7 @@ func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
 			defer wg.Done()
 			<-start
 
-			err := repo.Reserve(ctx, uuid.New(), []OrderItem{
-				{ProductID: productID, Quantity: 1},
-			})
+			err := reserve()
 			if err == nil {
 				atomic.AddInt32(&succeeded, 1)
 				return
@@ -135,15 +157,20 @@ func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
 	if got := int(atomic.LoadInt32(&outOfStock)); got != requests-initialStock {
 		t.Fatalf("out-of-stock responses = %d, want %d", got, requests-initialStock)
 	}
+}
+
+// assertSoldOut checks that all stock is reserved, once, in Postgres.
+func (f *concurrencyFixture) assertSoldOut(t *testing.T) {
+	t.Helper()
 
 	var available int
 	var reserved int
 	var onHand int
-	if err := pool.QueryRow(ctx, `
+	if err := f.pool.QueryRow(f.ctx, `
 		SELECT available, reserved, on_hand
 		FROM inventory
 		WHERE product_id = $1
-	`, productID).Scan(&available, &reserved, &onHand); err != nil {
+	`, f.productID).Scan(&available, &reserved, &onHand); err != nil {
 		t.Fatalf("query final inventory: %v", err)
 	}
 
@@ -157,17 +184,691 @@ func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
 		t.Fatalf("on_hand = %d, want %d", onHand, initialStock)
 	}
 
+	if err := f.pool.QueryRow(f.ctx, `
+		SELECT COALESCE(SUM(available), 0), COALESCE(SUM(reserved), 0), COALESCE(SUM(on_hand), 0)
+		FROM warehouse_stock
+		WHERE product_id = $1
+	`, f.productID).Scan(&available, &reserved, &onHand); err != nil {
+		t.Fatalf("query warehouse stock: %v", err)
+	}
+	if available != 0 || reserved != initialStock || onHand != initialStock {
+		t.Fatalf("warehouse stock = %d/%d/%d, want 0/%d/%d", available, reserved, onHand, initialStock, initialStock)
+	}
+
 	var adjustments int
-	if err := pool.QueryRow(ctx, `
+	if err := f.pool.QueryRow(f.ctx, `
 		SELECT COUNT(*)
 		FROM inventory_adjustments
 		WHERE product_id = $1
-	`, productID).Scan(&adjustments); err != nil {
+	`, f.productID).Scan(&adjustments); err != nil {
 		t.Fatalf("count adjustments: %v", err)
 	}
 	if adjustments != initialStock {
 		t.Fatalf("adjustments = %d, want %d", adjustments, initialStock)
 	}
 
+	var held int
+	if err := f.pool.QueryRow(f.ctx, `
+		SELECT COALESCE(SUM(quantity), 0)
+		FROM inventory_reservations
+		WHERE product_id = $1 AND status = 'held' AND expires_at IS NOT NULL
+	`, f.productID).Scan(&held); err != nil {
+		t.Fatalf("sum held reservations: %v", err)
+	}
+	if held != initialStock {
+		t.Fatalf("held reservations = %d, want %d", held, initialStock)
+	}
+}
+
+func TestReserve_HighConcurrencyDoesNotOversell(t *testing.T) {
+	t.Parallel()
+
+	f := newConcurrencyFixture(t)
+	repo := NewPostgres(f.pool)
+
+	race(t, func() error {
+		_, err := repo.Reserve(f.ctx, uuid.New(), []OrderItem{
+			{ProductID: f.productID, Quantity: 1},
+		})
+		return err
+	})
+	f.assertSoldOut(t)
+
 	t.Logf("concurrency test passed: %s", fmt.Sprintf("%d/%d reservations succeeded", initialStock, requests))
 }
+
+// TestReserve_SkipsStockAtInactiveWarehouses deactivates the fixture's
+// 10-unit warehouse and checks that only the other warehouse's stock is
+// sold, and that a line it cannot cover is out of stock rather than an
+// error from the warehouse plan.
+func TestReserve_SkipsStockAtInactiveWarehouses(t *testing.T) {
+	t.Parallel()
+
+	f := newConcurrencyFixture(t)
+	repo := NewPostgres(f.pool)
+	if _, err := f.pool.Exec(f.ctx, `
+		UPDATE warehouses SET is_active = false
+		WHERE priority = 1 AND id IN (SELECT warehouse_id FROM warehouse_stock WHERE product_id = $1)
+	`, f.productID); err != nil {
+		t.Fatalf("deactivate warehouse: %v", err)
+	}
+	const active = initialStock - 10
+
+	hot, err := repo.HotAvailable(f.ctx, []uuid.UUID{f.productID})
+	if err != nil || hot[f.productID] != active {
+		t.Fatalf("HotAvailable = %v, %v; want %d", hot, err, active)
+	}
+
+	// Two lines of the same product that together exceed the active stock.
+	split := []OrderItem{{ProductID: f.productID, Quantity: active - 2}, {ProductID: f.productID, Quantity: 3}}
+	if _, err := repo.Reserve(f.ctx, uuid.New(), split); !errors.Is(err, ErrOutOfStock) {
+		t.Fatalf("reserve past the active stock: err = %v, want ErrOutOfStock", err)
+	}
+	if _, err := repo.Reserve(f.ctx, uuid.New(), []OrderItem{{ProductID: f.productID, Quantity: active}}); err != nil {
+		t.Fatalf("reserve the active stock: %v", err)
+	}
+	if _, err := repo.Reserve(f.ctx, uuid.New(), []OrderItem{{ProductID: f.productID, Quantity: 1}}); !errors.Is(err, ErrOutOfStock) {
+		t.Fatalf("reserve with only inactive stock left: err = %v, want ErrOutOfStock", err)
+	}
+
+	var available int
+	if err := f.pool.QueryRow(f.ctx, `
+		SELECT available FROM inventory WHERE product_id = $1
+	`, f.productID).Scan(&available); err != nil {
+		t.Fatalf("query inventory: %v", err)
+	}
+	if available != initialStock-active {
+		t.Fatalf("available = %d, want the %d inactive units", available, initialStock-active)
+	}
+}
+
+// TestSettle_IsIdempotent reserves two orders, then pays one and cancels
+// the other twice each, as redelivered events would.
+func TestSettle_IsIdempotent(t *testing.T) {
+	t.Parallel()
+
+	f := newConcurrencyFixture(t)
+	repo := NewPostgres(f.pool)
+
+	paid, cancelled := uuid.New(), uuid.New()
+	for _, orderID := range []uuid.UUID{paid, cancelled} {
+		if _, err := repo.Reserve(f.ctx, orderID, []OrderItem{{ProductID: f.productID, Quantity: 3}}); err != nil {
+			t.Fatalf("reserve: %v", err)
+		}
+	}
+	if _, err := repo.Reserve(f.ctx, paid, []OrderItem{{ProductID: f.productID, Quantity: 3}}); !errors.Is(err, ErrAlreadyReserved) {
+		t.Fatalf("second reserve err = %v, want ErrAlreadyReserved", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := repo.Finalize(f.ctx, paid); err != nil {
+			t.Fatalf("finalize: %v", err)
+		}
+		released, err := repo.Release(f.ctx, cancelled)
+		if err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		got := 0
+		for _, it := range released {
+			got += it.Quantity
+		}
+		want := 3
+		if i > 0 {
+			want = 0
+		}
+		if got != want {
+			t.Fatalf("release #%d released %d, want %d", i+1, got, want)
+		}
+	}
+
+	var available, reserved, onHand int
+	if err := f.pool.QueryRow(f.ctx, `
+		SELECT available, reserved, on_hand FROM inventory WHERE product_id = $1
+	`, f.productID).Scan(&available, &reserved, &onHand); err != nil {
+		t.Fatalf("query inventory: %v", err)
+	}
+	if available != initialStock-3 || reserved != 0 || onHand != initialStock-3 {
+		t.Fatalf("inventory = %d/%d/%d, want %d/0/%d", available, reserved, onHand, initialStock-3, initialStock-3)
+	}
+
+	for orderID, want := range map[uuid.UUID]string{paid: ReservationCommitted, cancelled: ReservationReleased} {
+		var status string
+		if err := f.pool.QueryRow(f.ctx, `
+			SELECT status FROM inventory_reservations WHERE order_id = $1
+		`, orderID).Scan(&status); err != nil {
+			t.Fatalf("query reservation: %v", err)
+		}
+		if status != want {
+			t.Fatalf("reservation status = %q, want %q", status, want)
+		}
+	}
+}
+
+// newHotStock returns a HotStock on the test Redis database, skipping the
+// test if Redis is unreachable. The fixture product's keys are removed when
+// the test ends.
+func newHotStock(t *testing.T, f *concurrencyFixture) (*HotStock, *redis.Client) {
+	t.Helper()
+
+	redisAddr := os.Getenv("TEST_REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	// A database of its own, away from a running service's queue.
+	redisDB, _ := strconv.Atoi(os.Getenv("TEST_REDIS_DB"))
+	if redisDB == 0 {
+		redisDB = 15
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr, DB: redisDB})
+	t.Cleanup(func() { _ = rdb.Close() })
+	if err := rdb.Ping(f.ctx).Err(); err != nil {
+		t.Skipf("skipping integration test: cannot reach redis (%v)", err)
+	}
+	t.Cleanup(func() {
+		_ = rdb.Del(context.Background(), hotCounterKey(f.productID), hotPendingKey(f.productID),
+			hotAppliedKey(f.productID), hotDebitedKey(f.productID)).Err()
+	})
+	return NewHotStock(rdb), rdb
+}
+
+// TestHotStock_HighConcurrencyDoesNotOversell runs the same race through the
+// Redis fast path, then writes the admitted orders behind with two workers
+// and checks Postgres ends up as with direct reservations.
+func TestHotStock_HighConcurrencyDoesNotOversell(t *testing.T) {
+	t.Parallel()
+
+	f := newConcurrencyFixture(t)
+	repo := NewPostgres(f.pool)
+
+	hot, rdb := newHotStock(t, f)
+	ids := []uuid.UUID{f.productID}
+	writes, err := hot.Writes(f.ctx, ids)
+	if err != nil {
+		t.Fatalf("read writes: %v", err)
+	}
+	available, err := repo.HotAvailable(f.ctx, ids)
+	if err != nil {
+		t.Fatalf("read available: %v", err)
+	}
+	if n, err := hot.Resync(f.ctx, f.productID, available[f.productID], writes[f.productID]); err != nil || n != initialStock {
+		t.Fatalf("resync = %d, %v; want %d", n, err, initialStock)
+	}
+
+	race(t, func() error {
+		res, err := hot.Take(f.ctx, uuid.New(), []OrderItem{{ProductID: f.productID, Quantity: 1}})
+		switch {
+		case err != nil:
+			return err
+		case res == HotSoldOut:
+			return ErrOutOfStock
+		case res != HotTaken:
+			return fmt.Errorf("take = %d", res)
+		}
+		return nil
+	})
+
+	// Nothing is in Postgres until it is written behind.
+	if got, _ := repo.HotAvailable(f.ctx, ids); got[f.productID] != initialStock {
+		t.Fatalf("postgres available before write-behind = %d, want %d", got[f.productID], initialStock)
+	}
+
+	// Both workers see the same queue, as two service instances would;
+	// ApplyReservation must reserve each order once.
+	var wg sync.WaitGroup
+	var applyErrs int32
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				orders, err := hot.Pending(f.ctx, 10)
+				if err != nil || len(orders) == 0 {
+					return
+				}
+				for _, o := range orders {
+					if _, err := repo.ApplyReservation(f.ctx, o.OrderID, o.Items); err != nil {
+						atomic.AddInt32(&applyErrs, 1)
+						return
+					}
+					if err := hot.Ack(f.ctx, o); err != nil {
+						atomic.AddInt32(&applyErrs, 1)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&applyErrs); n != 0 {
+		t.Fatalf("write-behind errors: %d", n)
+	}
+
+	f.assertSoldOut(t)
+
+	counters, err := rdb.MGet(f.ctx, hotCounterKey(f.productID), hotPendingKey(f.productID), hotAppliedKey(f.productID)).Result()
+	if err != nil {
+		t.Fatalf("read counters: %v", err)
+	}
+	want := []any{"0", "0", strconv.Itoa(writes[f.productID].Applied + initialStock)}
+	for i := range want {
+		if counters[i] != want[i] {
+			t.Fatalf("counter/pending/applied = %v, want %v", counters, want)
+		}
+	}
+
+	// A resync after the writes agrees with Postgres.
+	writes, _ = hot.Writes(f.ctx, ids)
+	if n, err := hot.Resync(f.ctx, f.productID, 0, writes[f.productID]); err != nil || n != 0 {
+		t.Fatalf("resync after write-behind = %d, %v; want 0", n, err)
+	}
+}
+
+// TestHotStock_ResyncDoesNotLoseDebits reserves stock in Postgres and
+// debits the counter while resyncs read Postgres, and checks the counter
+// never ends up above what Postgres has left.
+func TestHotStock_ResyncDoesNotLoseDebits(t *testing.T) {
+	t.Parallel()
+
+	f := newConcurrencyFixture(t)
+	repo := NewPostgres(f.pool)
+	hot, _ := newHotStock(t, f)
+	ids := []uuid.UUID{f.productID}
+
+	// resync runs between reading Postgres and setting the counter.
+	resync := func(between func()) (int, error) {
+		writes, err := hot.Writes(f.ctx, ids)
+		if err != nil {
+			return 0, err
+		}
+		available, err := repo.HotAvailable(f.ctx, ids)
+		if err != nil {
+			return 0, err
+		}
+		between()
+		return hot.Resync(f.ctx, f.productID, available[f.productID], writes[f.productID])
+	}
+	reserve := func() error {
+		items := []OrderItem{{ProductID: f.productID, Quantity: 1}}
+		if _, err := repo.Reserve(f.ctx, uuid.New(), items); err != nil {
+			return err
+		}
+		return hot.Debit(f.ctx, items)
+	}
+
+	if n, err := resync(func() {}); err != nil || n != initialStock {
+		t.Fatalf("resync = %d, %v; want %d", n, err, initialStock)
+	}
+
+	// A reservation debited after the resync read Postgres.
+	var reserveErr error
+	n, err := resync(func() { reserveErr = reserve() })
+	if reserveErr != nil {
+		t.Fatalf("reserve: %v", reserveErr)
+	}
+	if err != nil || n != initialStock-1 {
+		t.Fatalf("resync around a debit = %d, %v; want %d", n, err, initialStock-1)
+	}
+
+	// The same at random: Postgres reservations and resyncs at once.
+	done := make(chan struct{})
+	var resyncs sync.WaitGroup
+	var errs int32
+	resyncs.Add(1)
+	go func() {
+		defer resyncs.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if _, err := resync(func() {}); err != nil {
+					atomic.AddInt32(&errs, 1)
+				}
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < initialStock-1; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := reserve(); err != nil {
+				atomic.AddInt32(&errs, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	resyncs.Wait()
+	if n := atomic.LoadInt32(&errs); n != 0 {
+		t.Fatalf("reserve and resync errors: %d", n)
+	}
+
+	// Every unit is reserved in Postgres, so nothing may be admitted.
+	counter, err := hot.rdb.Get(f.ctx, hotCounterKey(f.productID)).Int()
+	if err != nil {
+		t.Fatalf("read counter: %v", err)
+	}
+	if counter != 0 {
+		t.Fatalf("counter = %d after postgres sold out, want 0", counter)
+	}
+}
+
+// TestRaffle_CascadesUnclaimedUnits draws two units among four entries and
+// checks that a lapsed offer and a cancelled claim each pass their unit on
+// in rank order.
+func TestRaffle_CascadesUnclaimedUnits(t *testing.T) {
+	t.Parallel()
+
+	f := newConcurrencyFixture(t)
+	var exists bool
+	if err := f.pool.QueryRow(f.ctx, `SELECT to_regclass('public.raffle_entries') IS NOT NULL`).Scan(&exists); err != nil || !exists {
+		t.Skip("skipping integration test: table raffle_entries is missing, run migrations first")
+	}
+	repo := NewPostgres(f.pool)
+
+	raffleID := uuid.New()
+	users := make([]uuid.UUID, 4)
+	var orders []uuid.UUID
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, _ = f.pool.Exec(ctx, `DELETE FROM raffles WHERE id = $1`, raffleID)
+		_, _ = f.pool.Exec(ctx, `DELETE FROM order_item_sources WHERE order_id = ANY($1::uuid[])`, orders)
+		_, _ = f.pool.Exec(ctx, `DELETE FROM inventory_reservations WHERE order_id = ANY($1::uuid[])`, orders)
+		_, _ = f.pool.Exec(ctx, `DELETE FROM orders WHERE id = ANY($1::uuid[])`, orders)
+		_, _ = f.pool.Exec(ctx, `DELETE FROM users WHERE id = ANY($1::uuid[])`, users)
+	})
+
+	if _, err := f.pool.Exec(f.ctx, `
+		INSERT INTO raffles (id, product_id, quantity, entries_open_at, entries_close_at, draw_at,
+		                     claim_window_seconds, seed, seed_hash)
+		VALUES ($1, $2, 2, NOW() - INTERVAL '2 hours', NOW() - INTERVAL '1 hour', NOW() - INTERVAL '1 hour',
+		        3600, 'test-seed', encode(sha256('test-seed'), 'hex'))
+	`, raffleID, f.productID); err != nil {
+		t.Fatalf("insert raffle: %v", err)
+	}
+	for i := range users {
+		users[i] = uuid.New()
+		if _, err := f.pool.Exec(f.ctx, `
+			INSERT INTO users (id, email, password_hash) VALUES ($1, $2, 'x')
+		`, users[i], users[i].String()+"@raffle.test"); err != nil {
+			t.Fatalf("insert user: %v", err)
+		}
+		if _, err := f.pool.Exec(f.ctx, `
+			INSERT INTO raffle_entries (raffle_id, user_id) VALUES ($1, $2)
+		`, raffleID, users[i]); err != nil {
+			t.Fatalf("insert entry: %v", err)
+		}
+	}
+
+	run := func() RaffleProgress {
+		t.Helper()
+		progress, err := repo.RunRaffles(f.ctx)
+		if err != nil {
+			t.Fatalf("run raffles: %v", err)
+		}
+		for _, p := range progress {
+			if p.RaffleID == raffleID {
+				return p
+			}
+		}
+		t.Fatalf("raffle was not run")
+		return RaffleProgress{}
+	}
+	// byRank returns the entries' users and statuses in draw order.
+	byRank := func() (ranked []uuid.UUID, statuses []string) {
+		t.Helper()
+		rows, err := f.pool.Query(f.ctx, `
+			SELECT user_id, status FROM raffle_entries WHERE raffle_id = $1 ORDER BY rank
+		`, raffleID)
+		if err != nil {
+			t.Fatalf("query entries: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var u uuid.UUID
+			var s string
+			if err := rows.Scan(&u, &s); err != nil {
+				t.Fatalf("scan entry: %v", err)
+			}
+			ranked, statuses = append(ranked, u), append(statuses, s)
+		}
+		return ranked, statuses
+	}
+	assertHeld := func(want int) {
+		t.Helper()
+		var available, reserved int
+		if err := f.pool.QueryRow(f.ctx, `
+			SELECT available, reserved FROM inventory WHERE product_id = $1
+		`, f.productID).Scan(&available, &reserved); err != nil {
+			t.Fatalf("query inventory: %v", err)
+		}
+		if available != initialStock-want || reserved != want {
+			t.Fatalf("inventory = %d/%d, want %d/%d", available, reserved, initialStock-want, want)
+		}
+	}
+
+	if p := run(); !p.Drawn || p.Entries != 4 || len(p.Offered) != 2 {
+		t.Fatalf("draw = %+v, want 4 entries drawn and 2 offered", p)
+	}
+	ranked, _ := byRank()
+	assertHeld(2)
+
+	// claim creates an order for the user's offer the way core-api does,
+	// claiming the entry in the same transaction.
+	claim := func(user uuid.UUID) uuid.UUID {
+		t.Helper()
+		orderID := uuid.New()
+		orders = append(orders, orderID)
+		if err := pgx.BeginFunc(f.ctx, f.pool, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(f.ctx, `
+				INSERT INTO orders (id, user_id, status) VALUES ($1, $2, 'payment_required')
+			`, orderID, user); err != nil {
+				return err
+			}
+			_, err := tx.Exec(f.ctx, `
+				UPDATE raffle_entries SET status = 'claimed', order_id = $3
+				WHERE raffle_id = $1 AND user_id = $2 AND status = 'won'
+			`, raffleID, user, orderID)
+			return err
+		}); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		return orderID
+	}
+	lapse := func(user uuid.UUID) {
+		t.Helper()
+		if _, err := f.pool.Exec(f.ctx, `
+			UPDATE raffle_entries SET claim_expires_at = NOW() - INTERVAL '1 second' WHERE raffle_id = $1 AND user_id = $2
+		`, raffleID, user); err != nil {
+			t.Fatalf("lapse offer: %v", err)
+		}
+	}
+
+	// The first winner orders their unit; the second lets the offer lapse.
+	// The first claim window also ends before inventory-service reserves the
+	// order, which must not forfeit the claim.
+	orderID := claim(ranked[0])
+	lapse(ranked[0])
+	lapse(ranked[1])
+	if p := run(); p.Forfeited != 1 || len(p.Offered) != 1 {
+		t.Fatalf("after lapse = %+v, want 1 forfeited and 1 offered", p)
+	}
+	assertHeld(2)
+	if _, err := repo.Reserve(f.ctx, orderID, []OrderItem{{ProductID: f.productID, Quantity: 1}}); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	assertHeld(2)
+
+	// The first winner's order is then cancelled.
+	if _, err := f.pool.Exec(f.ctx, `UPDATE orders SET status = 'cancelled' WHERE id = $1`, orderID); err != nil {
+		t.Fatalf("cancel order: %v", err)
+	}
+	if _, err := repo.Release(f.ctx, orderID); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if p := run(); p.Forfeited != 1 || len(p.Offered) != 1 {
+		t.Fatalf("after cancel = %+v, want 1 forfeited and 1 offered", p)
+	}
+
+	_, statuses := byRank()
+	want := []string{"forfeited", "forfeited", "won", "won"}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("entry statuses = %v, want %v", statuses, want)
+		}
+	}
+	assertHeld(2)
+
+	// An order cancelled before inventory-service reserved it still holds
+	// its unit until the raffle job forfeits the claim.
+	cancelled := claim(ranked[2])
+	if _, err := f.pool.Exec(f.ctx, `UPDATE orders SET status = 'cancelled' WHERE id = $1`, cancelled); err != nil {
+		t.Fatalf("cancel order: %v", err)
+	}
+	if p := run(); p.Forfeited != 1 || len(p.Offered) != 0 {
+		t.Fatalf("after cancel before reserve = %+v, want 1 forfeited and none offered", p)
+	}
+	assertHeld(1)
+}
+
+// order inserts an order in status and removes it, with whatever was
+// reserved for it, when the test ends.
+func (f *concurrencyFixture) order(t *testing.T, status string) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	if _, err := f.pool.Exec(f.ctx, `INSERT INTO orders (id, status) VALUES ($1, $2)`, id, status); err != nil {
+		t.Fatalf("insert order: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_, _ = f.pool.Exec(ctx, `DELETE FROM inventory_allocations WHERE order_id = $1`, id)
+		_, _ = f.pool.Exec(ctx, `DELETE FROM orders WHERE id = $1`, id)
+	})
+	return id
+}
+
+// TestReserve_SkipsCancelledOrders cancels orders before orders.created is
+// handled, as core-api's CancelForUser can, and checks nothing is reserved
+// for them. A fast-path order paid before it is written behind is still
+// applied.
+func TestReserve_SkipsCancelledOrders(t *testing.T) {
+	t.Parallel()
+
+	f := newConcurrencyFixture(t)
+	repo := NewPostgres(f.pool)
+	items := []OrderItem{{ProductID: f.productID, Quantity: 2}}
+
+	cancelled := f.order(t, "cancelled")
+	if _, err := repo.Reserve(f.ctx, cancelled, items); !errors.Is(err, ErrOrderNotPending) {
+		t.Fatalf("reserve cancelled order: err = %v, want ErrOrderNotPending", err)
+	}
+	if _, err := repo.ApplyReservation(f.ctx, cancelled, items); !errors.Is(err, ErrOrderNotPending) {
+		t.Fatalf("apply cancelled order: err = %v, want ErrOrderNotPending", err)
+	}
+	var rows int
+	if err := f.pool.QueryRow(f.ctx, `
+		SELECT (SELECT COUNT(*) FROM inventory_reservations WHERE order_id = $1)
+		     + (SELECT COUNT(*) FROM inventory_allocations WHERE order_id = $1)
+	`, cancelled).Scan(&rows); err != nil {
+		t.Fatalf("count reservations: %v", err)
+	}
+	if rows != 0 {
+		t.Fatalf("cancelled order has %d reservation rows, want 0", rows)
+	}
+
+	paid := f.order(t, "paid")
+	if _, err := repo.Reserve(f.ctx, paid, items); !errors.Is(err, ErrOrderNotPending) {
+		t.Fatalf("reserve paid order: err = %v, want ErrOrderNotPending", err)
+	}
+	if ok, err := repo.ApplyReservation(f.ctx, paid, items); err != nil || !ok {
+		t.Fatalf("apply paid fast-path order = %v, %v; want applied", ok, err)
+	}
+
+	var available, reserved int
+	if err := f.pool.QueryRow(f.ctx, `
+		SELECT available, reserved FROM inventory WHERE product_id = $1
+	`, f.productID).Scan(&available, &reserved); err != nil {
+		t.Fatalf("query inventory: %v", err)
+	}
+	if available != initialStock-2 || reserved != 2 {
+		t.Fatalf("inventory = %d/%d, want %d/2", available, reserved, initialStock-2)
+	}
+}
+
+// TestExpireDue_ReleasesCancelledOrders checks that held lines of cancelled
+// orders are released by the sweep when nothing else releases them: lines
+// of an order cancelled while it waited for stock, which have no expiry,
+// and an order whose orders.cancelled was lost before its window passed.
+// An order cancelled before it was reserved holds nothing to release.
+func TestExpireDue_ReleasesCancelledOrders(t *testing.T) {
+	t.Parallel()
+
+	f := newConcurrencyFixture(t)
+	repo := NewPostgres(f.pool)
+	items := []OrderItem{{ProductID: f.productID, Quantity: 2}}
+
+	waiting, lost, recent := f.order(t, "payment_required"), f.order(t, "payment_required"), f.order(t, "payment_required")
+	for _, id := range []uuid.UUID{waiting, lost, recent} {
+		if _, err := repo.Reserve(f.ctx, id, items); err != nil {
+			t.Fatalf("reserve: %v", err)
+		}
+	}
+	// The first stands for an order with another line still waiting for
+	// stock, which leaves its reserved lines without a payment window.
+	if _, err := f.pool.Exec(f.ctx, `
+		UPDATE inventory_reservations SET expires_at = NULL WHERE order_id = $1
+	`, waiting); err != nil {
+		t.Fatalf("clear expiry: %v", err)
+	}
+	// Cancel all three without releasing, two of them past the grace
+	// period.
+	if _, err := f.pool.Exec(f.ctx, `
+		UPDATE orders SET status = 'cancelled',
+		    updated_at = CASE WHEN id = $3 THEN NOW() ELSE NOW() - INTERVAL '1 hour' END
+		WHERE id = ANY(ARRAY[$1, $2, $3]::uuid[])
+	`, waiting, lost, recent); err != nil {
+		t.Fatalf("cancel orders: %v", err)
+	}
+	before := f.order(t, "cancelled")
+	if _, err := repo.Reserve(f.ctx, before, items); !errors.Is(err, ErrOrderNotPending) {
+		t.Fatalf("reserve order cancelled first: err = %v, want ErrOrderNotPending", err)
+	}
+
+	settled := map[uuid.UUID]string{}
+	for {
+		batch, err := repo.ExpireDue(f.ctx, 100)
+		if err != nil {
+			t.Fatalf("expire due: %v", err)
+		}
+		for _, st := range batch {
+			settled[st.OrderID] = st.Status
+		}
+		if len(batch) < 100 {
+			break
+		}
+	}
+	for _, id := range []uuid.UUID{waiting, lost} {
+		if settled[id] != ReservationReleased {
+			t.Fatalf("order settled as %q, want %q", settled[id], ReservationReleased)
+		}
+	}
+	if _, ok := settled[recent]; ok {
+		t.Fatal("an order cancelled within the grace period was released by the sweep")
+	}
+	if _, ok := settled[before]; ok {
+		t.Fatal("an order that was never reserved was settled")
+	}
+
+	var available, reserved int
+	if err := f.pool.QueryRow(f.ctx, `
+		SELECT available, reserved FROM inventory WHERE product_id = $1
+	`, f.productID).Scan(&available, &reserved); err != nil {
+		t.Fatalf("query inventory: %v", err)
+	}
+	if available != initialStock-2 || reserved != 2 {
+		t.Fatalf("inventory = %d/%d, want only the recent order's %d/2", available, reserved, initialStock-2)
+	}
+}
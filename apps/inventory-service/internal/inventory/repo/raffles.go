@@ -0,0 +1,447 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Statuses of a raffle and of its entries; see migration 018.
+const (
+	RaffleScheduled = "scheduled"
+	RaffleDrawn     = "drawn"
+	RaffleCompleted = "completed"
+	RaffleCancelled = "cancelled"
+)
+
+// entrant is a raffle entry and the user who entered it.
+type entrant struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+// drawnEntry is an entry's place in a raffle's draw.
+type drawnEntry struct {
+	ID   uuid.UUID
+	Hash string
+	Rank int
+}
+
+// drawOrder ranks the entries of a raffle drawn with seed: by the hex
+// SHA-256 of the seed followed by the entrant's user id, then by user id.
+// Entry ids are generated after the seed is committed and could be picked
+// to suit it; a user's id is not up to the raffle. Anyone holding the
+// revealed seed can check the ranking, and each customer their own hash.
+func drawOrder(seed []byte, entrants []entrant) []drawnEntry {
+	type hashed struct {
+		entrant
+		hash string
+	}
+	all := make([]hashed, 0, len(entrants))
+	for _, e := range entrants {
+		sum := sha256.Sum256(append(bytes.Clone(seed), e.UserID.String()...))
+		all = append(all, hashed{entrant: e, hash: hex.EncodeToString(sum[:])})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].hash != all[j].hash {
+			return all[i].hash < all[j].hash
+		}
+		return all[i].UserID.String() < all[j].UserID.String()
+	})
+	out := make([]drawnEntry, len(all))
+	for i, e := range all {
+		out[i] = drawnEntry{ID: e.ID, Hash: e.hash, Rank: i + 1}
+	}
+	return out
+}
+
+// RaffleProgress is what one pass of RunRaffles did to a raffle.
+type RaffleProgress struct {
+	RaffleID  uuid.UUID
+	ProductID uuid.UUID
+	// Drawn is set on the pass that drew the raffle, with its entry count.
+	Drawn   bool
+	Entries int
+	// Offered are the entries newly offered a unit; Forfeited counts the
+	// offers and claims that lapsed.
+	Offered   []uuid.UUID
+	Forfeited int
+	// Status is the raffle's status after the pass.
+	Status string
+}
+
+// Changed reports whether the pass did anything.
+func (p RaffleProgress) Changed() bool {
+	return p.Drawn || len(p.Offered) > 0 || p.Forfeited > 0 || p.Status == RaffleCompleted
+}
+
+// RunRaffles draws the raffles whose draw is due and moves drawn ones on:
+// offers that lapsed or whose order was cancelled are forfeited, and their
+// units offered to the next entries. Cancelled raffles give back the units
+// they hold. Each raffle is handled in a transaction of its own holding its
+// row, so replicas running this at once skip each other's raffles.
+// Raffles handled before an error are returned with it.
+func (r *Postgres) RunRaffles(ctx context.Context) ([]RaffleProgress, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT r.id
+		FROM raffles r
+		WHERE (r.status = 'scheduled' AND r.draw_at <= NOW())
+		   OR r.status = 'drawn'
+		   OR (r.status = 'cancelled' AND EXISTS (
+			SELECT 1 FROM raffle_entries e
+			WHERE e.raffle_id = r.id
+			  AND (e.status IN ('entered', 'won') OR (e.status = 'claimed' AND e.handed_over_at IS NULL))))
+		ORDER BY r.draw_at, r.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+
+	var out []RaffleProgress
+	for _, id := range ids {
+		p, ok, err := r.runRaffle(ctx, id)
+		if err != nil {
+			return out, err
+		}
+		if ok {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (r *Postgres) runRaffle(ctx context.Context, raffleID uuid.UUID) (RaffleProgress, bool, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return RaffleProgress{}, false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	p := RaffleProgress{RaffleID: raffleID}
+	var seed []byte
+	var quantity, claimWindow int
+	err = tx.QueryRow(ctx, `
+		SELECT product_id, quantity, claim_window_seconds, seed, status
+		FROM raffles
+		WHERE id = $1
+		FOR UPDATE SKIP LOCKED
+	`, raffleID).Scan(&p.ProductID, &quantity, &claimWindow, &seed, &p.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return RaffleProgress{}, false, nil
+	}
+	if err != nil {
+		return RaffleProgress{}, false, err
+	}
+
+	switch p.Status {
+	case RaffleCancelled:
+		if p.Forfeited, err = forfeitOffers(ctx, tx, raffleID, p.ProductID, true); err != nil {
+			return RaffleProgress{}, false, err
+		}
+		claims, err := forfeitClaims(ctx, tx, raffleID, p.ProductID)
+		if err != nil {
+			return RaffleProgress{}, false, err
+		}
+		p.Forfeited += claims
+		if err := loseEntries(ctx, tx, raffleID); err != nil {
+			return RaffleProgress{}, false, err
+		}
+		return p, true, tx.Commit(ctx)
+	case RaffleScheduled:
+		if p.Entries, err = drawRaffle(ctx, tx, raffleID, seed); err != nil {
+			return RaffleProgress{}, false, err
+		}
+		p.Drawn, p.Status = true, RaffleDrawn
+	}
+
+	// Claims follow their orders: paid ones are final, cancelled ones pass
+	// their unit on.
+	if _, err := tx.Exec(ctx, `
+		UPDATE raffle_entries e
+		SET status = 'purchased', updated_at = NOW()
+		FROM orders o
+		WHERE e.raffle_id = $1 AND e.status = 'claimed' AND o.id = e.order_id
+		  AND o.status IN ('paid', 'processing', 'shipped', 'delivered')
+	`, raffleID); err != nil {
+		return RaffleProgress{}, false, err
+	}
+	if p.Forfeited, err = forfeitClaims(ctx, tx, raffleID, p.ProductID); err != nil {
+		return RaffleProgress{}, false, err
+	}
+
+	lapsed, err := forfeitOffers(ctx, tx, raffleID, p.ProductID, false)
+	if err != nil {
+		return RaffleProgress{}, false, err
+	}
+	p.Forfeited += lapsed
+
+	if p.Offered, err = offerUnits(ctx, tx, raffleID, p.ProductID, quantity, time.Duration(claimWindow)*time.Second); err != nil {
+		return RaffleProgress{}, false, err
+	}
+
+	done, err := raffleDone(ctx, tx, raffleID, quantity)
+	if err != nil {
+		return RaffleProgress{}, false, err
+	}
+	if done {
+		if err := loseEntries(ctx, tx, raffleID); err != nil {
+			return RaffleProgress{}, false, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE raffles SET status = 'completed', completed_at = NOW(), updated_at = NOW() WHERE id = $1
+		`, raffleID); err != nil {
+			return RaffleProgress{}, false, err
+		}
+		p.Status = RaffleCompleted
+	}
+	return p, true, tx.Commit(ctx)
+}
+
+// drawRaffle ranks the raffle's entries and marks it drawn. It returns the
+// number of entries.
+func drawRaffle(ctx context.Context, tx pgx.Tx, raffleID uuid.UUID, seed []byte) (int, error) {
+	rows, err := tx.Query(ctx, `SELECT id, user_id FROM raffle_entries WHERE raffle_id = $1`, raffleID)
+	if err != nil {
+		return 0, err
+	}
+	entrants, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (entrant, error) {
+		var e entrant
+		err := row.Scan(&e.ID, &e.UserID)
+		return e, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	drawn := drawOrder(seed, entrants)
+	entryIDs := make([]uuid.UUID, len(drawn))
+	hashes := make([]string, len(drawn))
+	ranks := make([]int32, len(drawn))
+	for i, d := range drawn {
+		entryIDs[i], hashes[i], ranks[i] = d.ID, d.Hash, int32(d.Rank)
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE raffle_entries e
+		SET draw_hash = d.hash, rank = d.rank, updated_at = NOW()
+		FROM unnest($1::uuid[], $2::text[], $3::int[]) AS d(id, hash, rank)
+		WHERE e.id = d.id
+	`, entryIDs, hashes, ranks); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE raffles SET status = 'drawn', drawn_at = NOW(), updated_at = NOW() WHERE id = $1
+	`, raffleID); err != nil {
+		return 0, err
+	}
+	return len(drawn), nil
+}
+
+// forfeitOffers ends the raffle's offers, only those past their claim
+// window unless all is set, and gives their held units back to available.
+func forfeitOffers(ctx context.Context, tx pgx.Tx, raffleID, productID uuid.UUID, all bool) (int, error) {
+	rows, err := tx.Query(ctx, `
+		UPDATE raffle_entries
+		SET status = 'forfeited', updated_at = NOW()
+		WHERE raffle_id = $1 AND status = 'won' AND ($2 OR claim_expires_at <= NOW())
+		RETURNING id
+	`, raffleID, all)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := move(ctx, tx, movement{
+			productID:   productID,
+			typ:         EntryRelease,
+			quantity:    1,
+			reason:      "raffle offer forfeited",
+			referenceID: &id,
+		}, func(l Levels) Levels { return releaseLevels(l, 1) }); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// forfeitClaims ends the raffle's claims whose order was cancelled or
+// deleted. A cancelled order gave back the unit it reserved; one cancelled
+// before inventory-service handed the unit over still holds it, and the
+// hold is given back to available here.
+func forfeitClaims(ctx context.Context, tx pgx.Tx, raffleID, productID uuid.UUID) (int, error) {
+	rows, err := tx.Query(ctx, `
+		UPDATE raffle_entries e
+		SET status = 'forfeited', updated_at = NOW()
+		WHERE e.raffle_id = $1 AND e.status = 'claimed'
+		  AND (e.order_id IS NULL OR EXISTS (SELECT 1 FROM orders o WHERE o.id = e.order_id AND o.status = 'cancelled'))
+		RETURNING e.id, e.handed_over_at IS NULL
+	`, raffleID)
+	if err != nil {
+		return 0, err
+	}
+	type claim struct {
+		id   uuid.UUID
+		held bool
+	}
+	claims, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (claim, error) {
+		var c claim
+		err := row.Scan(&c.id, &c.held)
+		return c, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range claims {
+		if !c.held {
+			continue
+		}
+		if err := move(ctx, tx, movement{
+			productID:   productID,
+			typ:         EntryRelease,
+			quantity:    1,
+			reason:      "raffle claim forfeited",
+			referenceID: &c.id,
+		}, func(l Levels) Levels { return releaseLevels(l, 1) }); err != nil {
+			return 0, err
+		}
+	}
+	return len(claims), nil
+}
+
+// offerUnits offers a unit to each next entry in rank order while the
+// raffle has units that are neither offered nor claimed and the product
+// has stock to hold for them.
+func offerUnits(ctx context.Context, tx pgx.Tx, raffleID, productID uuid.UUID, quantity int, claimWindow time.Duration) ([]uuid.UUID, error) {
+	var taken int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM raffle_entries WHERE raffle_id = $1 AND status IN ('won', 'claimed', 'purchased')
+	`, raffleID).Scan(&taken); err != nil {
+		return nil, err
+	}
+	slots := quantity - taken
+	if slots <= 0 {
+		return nil, nil
+	}
+
+	var available int
+	if err := tx.QueryRow(ctx, `
+		SELECT available FROM inventory WHERE product_id = $1 FOR UPDATE
+	`, productID).Scan(&available); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id
+		FROM raffle_entries
+		WHERE raffle_id = $1 AND status = 'entered'
+		ORDER BY rank
+		LIMIT $2
+		FOR UPDATE
+	`, raffleID, min(slots, available))
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(claimWindow)
+	for _, id := range ids {
+		if err := move(ctx, tx, movement{
+			productID:   productID,
+			typ:         EntrySale,
+			quantity:    1,
+			reason:      "held for raffle winner",
+			referenceID: &id,
+		}, func(l Levels) Levels { return reserveLevels(l, 1) }); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE raffle_entries
+			SET status = 'won', won_at = NOW(), claim_expires_at = $2, updated_at = NOW()
+			WHERE id = $1
+		`, id, expiresAt); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// raffleDone reports whether a drawn raffle is over: every unit is paid
+// for, or no entry holds or is still waiting for one.
+func raffleDone(ctx context.Context, tx pgx.Tx, raffleID uuid.UUID, quantity int) (bool, error) {
+	var purchased, open, waiting int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE status = 'purchased'),
+		       COUNT(*) FILTER (WHERE status IN ('won', 'claimed')),
+		       COUNT(*) FILTER (WHERE status = 'entered')
+		FROM raffle_entries
+		WHERE raffle_id = $1
+	`, raffleID).Scan(&purchased, &open, &waiting); err != nil {
+		return false, err
+	}
+	return open == 0 && (waiting == 0 || purchased >= quantity), nil
+}
+
+// loseEntries ends the entries a unit never reached.
+func loseEntries(ctx context.Context, tx pgx.Tx, raffleID uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE raffle_entries SET status = 'lost', updated_at = NOW() WHERE raffle_id = $1 AND status = 'entered'
+	`, raffleID)
+	return err
+}
+
+// claimRaffleUnits hands the units held for the raffle entries the order
+// claimed over to it: their holds are given back to available, for the
+// order to reserve in the same transaction. core-api claims the entries
+// when it creates the order.
+func claimRaffleUnits(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) error {
+	rows, err := tx.Query(ctx, `
+		UPDATE raffle_entries e
+		SET handed_over_at = NOW(), updated_at = NOW()
+		FROM raffles r
+		WHERE e.order_id = $1 AND e.status = 'claimed' AND e.handed_over_at IS NULL AND r.id = e.raffle_id
+		RETURNING e.id, r.product_id
+	`, orderID)
+	if err != nil {
+		return err
+	}
+	type claim struct{ entryID, productID uuid.UUID }
+	claims, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (claim, error) {
+		var c claim
+		err := row.Scan(&c.entryID, &c.productID)
+		return c, err
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range claims {
+		if err := move(ctx, tx, movement{
+			productID:   c.productID,
+			typ:         EntryRelease,
+			quantity:    1,
+			reason:      "raffle unit claimed by order",
+			referenceID: &c.entryID,
+		}, func(l Levels) Levels { return releaseLevels(l, 1) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDrawOrder(t *testing.T) {
+	seed := []byte("launch-seed")
+	a := entrant{ID: uuid.New(), UserID: uuid.MustParse("00000000-0000-0000-0000-000000000001")}
+	b := entrant{ID: uuid.New(), UserID: uuid.MustParse("00000000-0000-0000-0000-000000000002")}
+	c := entrant{ID: uuid.New(), UserID: uuid.MustParse("00000000-0000-0000-0000-000000000003")}
+
+	// The hashes are what `printf 'launch-seed<user id>' | sha256sum`
+	// prints, so an auditor can check a draw without this code.
+	want := []drawnEntry{
+		{ID: b.ID, Hash: "08f6e7b108e2a84d21ded861efec4c6f3ccadc86b297fcf86d471c0ccce1c8e5", Rank: 1},
+		{ID: a.ID, Hash: "977dda92d0ed37803a8b8a8ce844edd4f70e44b6c856be3aabe9e0561f7d3784", Rank: 2},
+		{ID: c.ID, Hash: "f036d3b8360e846c0b171f3373ab292360900fe6cbdec0eadd2bf2308991c572", Rank: 3},
+	}
+	for _, entrants := range [][]entrant{{a, b, c}, {c, b, a}} {
+		got := drawOrder(seed, entrants)
+		if len(got) != len(want) {
+			t.Fatalf("drawOrder returned %d entries, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("drawOrder(%v)[%d] = %+v, want %+v", entrants, i, got[i], want[i])
+			}
+		}
+	}
+
+	// Entry ids play no part: new ones leave the ranking as it was.
+	renamed := []entrant{{ID: uuid.New(), UserID: a.UserID}, {ID: uuid.New(), UserID: b.UserID}, {ID: uuid.New(), UserID: c.UserID}}
+	if got := drawOrder(seed, renamed); got[0].Hash != want[0].Hash || got[0].ID != renamed[1].ID {
+		t.Errorf("new entry ids changed the draw: %+v", got)
+	}
+
+	if other := drawOrder([]byte("other-seed"), []entrant{a, b, c}); other[0].Hash == want[0].Hash {
+		t.Errorf("a different seed gave the same draw")
+	}
+}
@@ -0,0 +1,257 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrAlreadyReserved is returned by Reserve for an order whose stock was
+// already reserved, e.g. when orders.created is delivered twice.
+var ErrAlreadyReserved = errors.New("order already reserved")
+
+// ErrOrderNotPending is returned by Reserve for an order that is no longer
+// awaiting payment, e.g. one the customer cancelled before orders.created
+// was handled.
+var ErrOrderNotPending = errors.New("order no longer awaiting payment")
+
+// Statuses of an inventory_reservations row; see migration 016.
+const (
+	ReservationHeld      = "held"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// WithReservationTTL sets how long a reservation is held for payment.
+func (r *Postgres) WithReservationTTL(ttl time.Duration) *Postgres {
+	r.reservationTTL = ttl
+	return r
+}
+
+// claimOrder makes the transaction the only one reserving the order and
+// fails with ErrAlreadyReserved if an earlier one already did. The write-
+// behind worker and an order's payment or cancellation may apply a fast-path
+// order at the same time; the lock makes the second one wait and see the
+// first.
+func claimOrder(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))`, orderID.String()); err != nil {
+		return err
+	}
+	var done bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM inventory_reservations WHERE order_id = $1)
+		    OR EXISTS (SELECT 1 FROM inventory_allocations WHERE order_id = $1)
+	`, orderID).Scan(&done); err != nil {
+		return err
+	}
+	if done {
+		return ErrAlreadyReserved
+	}
+	return nil
+}
+
+// lockOrder holds the order row until the transaction ends and fails with
+// ErrOrderNotPending unless the order still awaits payment, so a
+// cancellation either lands first and is seen here or waits for the
+// reservation and releases it. An order admitted by HotStock may have been
+// paid before it is written behind, so admitted orders only need to be
+// neither cancelled nor refunded. Orders are written by core-api; one that
+// is not there is reserved as before.
+func lockOrder(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, admitted bool) error {
+	var status string
+	var deleted bool
+	err := tx.QueryRow(ctx, `
+		SELECT status, deleted_at IS NOT NULL FROM orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&status, &deleted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case deleted:
+		return ErrOrderNotPending
+	case status == "payment_required":
+		return nil
+	case admitted && status != "cancelled" && status != "refunded":
+		return nil
+	}
+	return ErrOrderNotPending
+}
+
+// holdReservations records reserved lines as held. A nil expiresAt leaves
+// the payment window to startPaymentWindow.
+func holdReservations(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, items []OrderItem, expiresAt *time.Time) error {
+	for _, it := range items {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO inventory_reservations (order_id, product_id, quantity, expires_at)
+			VALUES ($1, $2, $3, $4)
+		`, orderID, it.ProductID, it.Quantity, expiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startPaymentWindow sets the expiry of the orders' held reservations.
+func startPaymentWindow(ctx context.Context, tx pgx.Tx, orderIDs []uuid.UUID, expiresAt time.Time) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE inventory_reservations
+		SET expires_at = $2, updated_at = NOW()
+		WHERE order_id = ANY($1::uuid[]) AND status = 'held'
+	`, orderIDs, expiresAt)
+	return err
+}
+
+// endReservations moves the order's held reservations to status and
+// returns their quantities by product. Rows that are no longer held are
+// left alone, so ending an order twice ends nothing the second time.
+func endReservations(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, status string) ([]OrderItem, error) {
+	rows, err := tx.Query(ctx, `
+		UPDATE inventory_reservations
+		SET status = $2, updated_at = NOW()
+		WHERE order_id = $1 AND status = 'held'
+		RETURNING product_id, quantity
+	`, orderID, status)
+	if err != nil {
+		return nil, err
+	}
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (OrderItem, error) {
+		var it OrderItem
+		err := row.Scan(&it.ProductID, &it.Quantity)
+		return it, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeItems(items), nil
+}
+
+// Settlement is a held reservation ended by ExpireDue.
+type Settlement struct {
+	OrderID uuid.UUID
+	// Status is what the reservation became: expired when the order was
+	// cancelled for want of payment. An order that was paid or cancelled
+	// without its reservation being settled, e.g. because the event was
+	// lost, is committed or released instead.
+	Status string
+	Items  []OrderItem
+	// Lag is how long past its expiry the reservation was settled.
+	Lag time.Duration
+}
+
+// settlementFor returns how the held reservation of an order in status is
+// settled once its payment window has passed, and whether the order must
+// be cancelled first.
+func settlementFor(orderStatus string) (status string, cancel bool) {
+	switch orderStatus {
+	case "payment_required":
+		return ReservationExpired, true
+	case "paid", "processing", "shipped", "delivered":
+		return ReservationCommitted, false
+	default:
+		return ReservationReleased, false
+	}
+}
+
+// cancelledGrace is how long a cancelled order's held reservation is left
+// for its orders.cancelled event to release before ExpireDue does.
+const cancelledGrace = time.Minute
+
+// dueReservations selects the held reservations ExpireDue settles: those
+// past their payment window, and those of orders cancelled more than
+// cancelledGrace ago whatever their expiry. The latter include lines of an
+// order cancelled while it waited for stock, which have no expiry, and any
+// order whose release was missed. $1 is cancelledGrace in seconds.
+const dueReservations = `
+	FROM inventory_reservations r
+	JOIN orders o ON o.id = r.order_id
+	WHERE r.status = 'held'
+	  AND ((r.expires_at <= NOW() AND o.status NOT IN ('pending', 'preordered', 'backordered'))
+	       OR (o.status = 'cancelled' AND o.updated_at <= NOW() - $1::float8 * INTERVAL '1 second'))
+`
+
+// dueAt is when a due reservation became due.
+const dueAt = `CASE WHEN r.expires_at <= NOW() THEN r.expires_at ELSE o.updated_at + $1::float8 * INTERVAL '1 second' END`
+
+// ExpireDue settles up to limit orders whose reservations are due, see
+// dueReservations, oldest first, and returns them. Each order is settled in
+// a transaction of its own that holds the order row: replicas running
+// ExpireDue at once skip each other's orders, and a payment arriving for
+// an order being expired waits to see it cancelled. Orders settled before
+// an error are returned with it.
+func (r *Postgres) ExpireDue(ctx context.Context, limit int) ([]Settlement, error) {
+	var out []Settlement
+	for len(out) < limit {
+		st, ok, err := r.expireNext(ctx)
+		if err != nil {
+			return out, err
+		}
+		if !ok {
+			break
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+func (r *Postgres) expireNext(ctx context.Context) (Settlement, bool, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return Settlement{}, false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var st Settlement
+	var orderStatus string
+	var lagSeconds float64
+	err = tx.QueryRow(ctx, `
+		SELECT o.id, o.status, EXTRACT(EPOCH FROM NOW() - `+dueAt+`)::float8
+		`+dueReservations+`
+		ORDER BY `+dueAt+`
+		LIMIT 1
+		FOR UPDATE OF o SKIP LOCKED
+	`, cancelledGrace.Seconds()).Scan(&st.OrderID, &orderStatus, &lagSeconds)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Settlement{}, false, nil
+	}
+	if err != nil {
+		return Settlement{}, false, err
+	}
+	st.Lag = time.Duration(lagSeconds * float64(time.Second))
+
+	var cancel bool
+	st.Status, cancel = settlementFor(orderStatus)
+	if cancel {
+		if _, err := tx.Exec(ctx, `
+			UPDATE orders SET status = 'cancelled', updated_at = NOW() WHERE id = $1
+		`, st.OrderID); err != nil {
+			return Settlement{}, false, err
+		}
+	}
+	if st.Items, err = settleReservations(ctx, tx, st.OrderID, st.Status); err != nil {
+		return Settlement{}, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Settlement{}, false, err
+	}
+	return st, true, nil
+}
+
+// ExpiryLag returns how long the oldest due reservation has been waiting
+// to be expired, zero if none is.
+func (r *Postgres) ExpiryLag(ctx context.Context) (time.Duration, error) {
+	var seconds float64
+	err := r.pool.QueryRow(ctx, `
+		SELECT COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(`+dueAt+`)), 0)::float8
+		`+dueReservations, cancelledGrace.Seconds()).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -0,0 +1,22 @@
+package repo
+
+import "testing"
+
+func TestSettlementFor(t *testing.T) {
+	for orderStatus, want := range map[string]struct {
+		status string
+		cancel bool
+	}{
+		"payment_required": {ReservationExpired, true},
+		"paid":             {ReservationCommitted, false},
+		"shipped":          {ReservationCommitted, false},
+		"delivered":        {ReservationCommitted, false},
+		"cancelled":        {ReservationReleased, false},
+		"refunded":         {ReservationReleased, false},
+	} {
+		status, cancel := settlementFor(orderStatus)
+		if status != want.status || cancel != want.cancel {
+			t.Errorf("%s: settlementFor = %s, %v; want %s, %v", orderStatus, status, cancel, want.status, want.cancel)
+		}
+	}
+}
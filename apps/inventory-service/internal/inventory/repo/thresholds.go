@@ -0,0 +1,39 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ThresholdCrossing is a product that went below its low-stock threshold
+// (Low) or back up to it.
+type ThresholdCrossing struct {
+	ProductID uuid.UUID
+	Available int
+	Threshold int
+	Low       bool
+}
+
+// CheckStockThresholds flags products that crossed their low-stock
+// threshold since the last check, and returns them. A nil productIDs checks
+// every product. Each crossing is returned once: the flag is flipped in the
+// same statement that finds it.
+func (r *Postgres) CheckStockThresholds(ctx context.Context, productIDs []uuid.UUID) ([]ThresholdCrossing, error) {
+	rows, err := r.pool.Query(ctx, `
+		UPDATE inventory
+		SET low_stock_since = CASE WHEN low_stock_since IS NULL THEN NOW() END
+		WHERE ($1::uuid[] IS NULL OR product_id = ANY($1::uuid[]))
+		  AND COALESCE(available < low_stock_threshold, false) <> (low_stock_since IS NOT NULL)
+		RETURNING product_id, available, COALESCE(low_stock_threshold, 0), low_stock_since IS NOT NULL
+	`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (ThresholdCrossing, error) {
+		var c ThresholdCrossing
+		err := row.Scan(&c.ProductID, &c.Available, &c.Threshold, &c.Low)
+		return c, err
+	})
+}
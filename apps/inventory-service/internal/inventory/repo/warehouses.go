@@ -0,0 +1,354 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Strategies for splitting a reservation across warehouses.
+const (
+	// StrategyPriority ships from warehouses in priority order.
+	StrategyPriority = "priority"
+	// StrategyNearest prefers warehouses in the shipping region, then the
+	// shipping country, then priority order.
+	StrategyNearest = "nearest"
+	// StrategyFewestShipments picks the warehouses that cover the most of
+	// the order first, so as few warehouses as possible ship it.
+	StrategyFewestShipments = "fewest_shipments"
+)
+
+var ErrUnknownStrategy = errors.New("unknown allocation strategy")
+
+// ParseStrategy validates a strategy name; empty means StrategyPriority.
+func ParseStrategy(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return StrategyPriority, nil
+	case StrategyPriority, StrategyNearest, StrategyFewestShipments:
+		return s, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownStrategy, s)
+	}
+}
+
+// Destination is where an order ships to; both fields may be empty.
+type Destination struct {
+	Country string
+	Region  string
+}
+
+// warehouseStock is what a warehouse can ship of one product.
+type warehouseStock struct {
+	warehouseID uuid.UUID
+	country     string
+	region      string
+	priority    int
+	available   int
+}
+
+// pick takes quantity units of a product from a warehouse.
+type pick struct {
+	productID   uuid.UUID
+	warehouseID uuid.UUID
+	quantity    int
+}
+
+// distance ranks a warehouse for StrategyNearest: 0 in the shipping region,
+// 1 in the shipping country, 2 elsewhere or when the destination is unknown.
+func distance(w warehouseStock, dest Destination) int {
+	if dest.Country == "" || !strings.EqualFold(w.country, dest.Country) {
+		return 2
+	}
+	if dest.Region != "" && strings.EqualFold(w.region, dest.Region) {
+		return 0
+	}
+	return 1
+}
+
+// planAllocation splits items across warehouses. stock lists the
+// warehouses holding each product and is consumed as units are picked. It
+// returns ErrOutOfStock if the warehouses cannot cover an item, which
+// means the inventory totals and warehouse_stock disagree.
+func planAllocation(strategy string, items []OrderItem, stock map[uuid.UUID][]warehouseStock, dest Destination) ([]pick, error) {
+	var picks []pick
+	take := func(productID uuid.UUID, w *warehouseStock, need int) int {
+		n := min(w.available, need)
+		if n > 0 {
+			w.available -= n
+			picks = append(picks, pick{productID: productID, warehouseID: w.warehouseID, quantity: n})
+		}
+		return n
+	}
+
+	if strategy == StrategyFewestShipments {
+		need := make([]int, len(items))
+		for i, it := range items {
+			need[i] = it.Quantity
+		}
+		for {
+			best, bestCover := uuid.Nil, 0
+			bestPriority := 0
+			for id, cover := range coverByWarehouse(items, need, stock) {
+				if cover.units > bestCover ||
+					(cover.units == bestCover && (cover.priority < bestPriority ||
+						(cover.priority == bestPriority && id.String() < best.String()))) {
+					best, bestCover, bestPriority = id, cover.units, cover.priority
+				}
+			}
+			if bestCover == 0 {
+				break
+			}
+			for i, it := range items {
+				for j := range stock[it.ProductID] {
+					if w := &stock[it.ProductID][j]; w.warehouseID == best {
+						need[i] -= take(it.ProductID, w, need[i])
+					}
+				}
+			}
+		}
+		for _, n := range need {
+			if n > 0 {
+				return nil, ErrOutOfStock
+			}
+		}
+		return picks, nil
+	}
+
+	for _, ws := range stock {
+		sort.SliceStable(ws, func(i, j int) bool {
+			if strategy == StrategyNearest {
+				if di, dj := distance(ws[i], dest), distance(ws[j], dest); di != dj {
+					return di < dj
+				}
+			}
+			if ws[i].priority != ws[j].priority {
+				return ws[i].priority < ws[j].priority
+			}
+			return ws[i].warehouseID.String() < ws[j].warehouseID.String()
+		})
+	}
+	for _, it := range items {
+		need := it.Quantity
+		for j := range stock[it.ProductID] {
+			if need == 0 {
+				break
+			}
+			need -= take(it.ProductID, &stock[it.ProductID][j], need)
+		}
+		if need > 0 {
+			return nil, ErrOutOfStock
+		}
+	}
+	return picks, nil
+}
+
+type cover struct {
+	units    int
+	priority int
+}
+
+// coverByWarehouse returns how many of the still needed units each
+// warehouse could ship.
+func coverByWarehouse(items []OrderItem, need []int, stock map[uuid.UUID][]warehouseStock) map[uuid.UUID]cover {
+	out := map[uuid.UUID]cover{}
+	// An order may list a product twice; count a warehouse's stock once.
+	left := map[[2]uuid.UUID]int{}
+	for i, it := range items {
+		for _, w := range stock[it.ProductID] {
+			key := [2]uuid.UUID{it.ProductID, w.warehouseID}
+			if _, ok := left[key]; !ok {
+				left[key] = w.available
+			}
+			n := min(left[key], need[i])
+			left[key] -= n
+			c := out[w.warehouseID]
+			c.units += n
+			c.priority = w.priority
+			out[w.warehouseID] = c
+		}
+	}
+	return out
+}
+
+// orderDestination returns where the order ships to.
+func orderDestination(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) (Destination, error) {
+	var d Destination
+	err := tx.QueryRow(ctx, `
+		SELECT COALESCE(shipping_country, ''), COALESCE(shipping_region, '') FROM orders WHERE id = $1
+	`, orderID).Scan(&d.Country, &d.Region)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Destination{}, nil
+	}
+	return d, err
+}
+
+// activeStockSQL is the available stock of inventory row i's product at
+// active warehouses. inventory.available still counts units at deactivated
+// warehouses, which reserveAtWarehouses does not take from, so what can be
+// reserved is the lesser of the two.
+const activeStockSQL = `
+	COALESCE((
+		SELECT SUM(s.available)
+		FROM warehouse_stock s
+		JOIN warehouses w ON w.id = s.warehouse_id
+		WHERE s.product_id = i.product_id AND w.is_active
+	), 0)
+`
+
+// reserveAtWarehouses takes the items from warehouse stock using strategy
+// and records where they came from. The callers hold the products'
+// inventory row locks and have already moved the totals.
+func reserveAtWarehouses(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, items []OrderItem, dest Destination, strategy string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	productIDs := make([]uuid.UUID, 0, len(items))
+	for _, it := range items {
+		productIDs = append(productIDs, it.ProductID)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT s.product_id, s.warehouse_id, COALESCE(w.country, ''), COALESCE(w.region, ''), w.priority, s.available
+		FROM warehouse_stock s
+		JOIN warehouses w ON w.id = s.warehouse_id
+		WHERE s.product_id = ANY($1::uuid[]) AND s.available > 0 AND w.is_active
+		ORDER BY s.product_id, s.warehouse_id
+		FOR UPDATE OF s
+	`, productIDs)
+	if err != nil {
+		return err
+	}
+	stock := map[uuid.UUID][]warehouseStock{}
+	for rows.Next() {
+		var pid uuid.UUID
+		var w warehouseStock
+		if err := rows.Scan(&pid, &w.warehouseID, &w.country, &w.region, &w.priority, &w.available); err != nil {
+			rows.Close()
+			return err
+		}
+		stock[pid] = append(stock[pid], w)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	picks, err := planAllocation(strategy, items, stock, dest)
+	if err != nil {
+		return err
+	}
+	for _, p := range picks {
+		if _, err := tx.Exec(ctx, `
+			UPDATE warehouse_stock
+			SET available = available - $3, reserved = reserved + $3, updated_at = NOW()
+			WHERE product_id = $1 AND warehouse_id = $2
+		`, p.productID, p.warehouseID, p.quantity); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO order_item_sources (order_id, product_id, warehouse_id, quantity)
+			VALUES ($1, $2, $3, $4)
+		`, orderID, p.productID, p.warehouseID, p.quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleAtWarehouses releases (fulfilled false) or ships (fulfilled true)
+// qty reserved units of the order's product at the warehouses they were
+// reserved from. Units without a source, reserved before warehouses
+// existed, are taken from the warehouse holding most reserved units.
+func settleAtWarehouses(ctx context.Context, tx pgx.Tx, orderID, productID uuid.UUID, qty int, fulfilled bool) error {
+	status, stockSQL := "released", `
+		UPDATE warehouse_stock
+		SET available = available + $3, reserved = GREATEST(reserved - $3, 0), updated_at = NOW()
+		WHERE product_id = $1 AND warehouse_id = $2
+	`
+	if fulfilled {
+		status, stockSQL = "fulfilled", `
+			UPDATE warehouse_stock
+			SET reserved = GREATEST(reserved - $3, 0), on_hand = GREATEST(on_hand - $3, 0), updated_at = NOW()
+			WHERE product_id = $1 AND warehouse_id = $2
+		`
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, warehouse_id, quantity
+		FROM order_item_sources
+		WHERE order_id = $1 AND product_id = $2 AND status = 'reserved'
+		ORDER BY created_at, id
+		FOR UPDATE
+	`, orderID, productID)
+	if err != nil {
+		return err
+	}
+	type source struct {
+		id, warehouseID uuid.UUID
+		qty             int
+	}
+	var sources []source
+	for rows.Next() {
+		var s source
+		if err := rows.Scan(&s.id, &s.warehouseID, &s.qty); err != nil {
+			rows.Close()
+			return err
+		}
+		sources = append(sources, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range sources {
+		if qty == 0 {
+			break
+		}
+		n := min(s.qty, qty)
+		qty -= n
+		if _, err := tx.Exec(ctx, stockSQL, productID, s.warehouseID, n); err != nil {
+			return err
+		}
+		// A partly settled source is split so the rest stays reserved.
+		if n < s.qty {
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO order_item_sources (order_id, product_id, warehouse_id, quantity)
+				VALUES ($1, $2, $3, $4)
+			`, orderID, productID, s.warehouseID, s.qty-n); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE order_item_sources SET status = $2, quantity = $3 WHERE id = $1
+		`, s.id, status, n); err != nil {
+			return err
+		}
+	}
+	if qty == 0 {
+		return nil
+	}
+
+	var warehouseID uuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT warehouse_id FROM warehouse_stock
+		WHERE product_id = $1
+		ORDER BY reserved DESC, warehouse_id
+		LIMIT 1
+		FOR UPDATE
+	`, productID).Scan(&warehouseID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, stockSQL, productID, warehouseID, qty)
+	return err
+}
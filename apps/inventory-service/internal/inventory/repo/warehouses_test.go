@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPlanAllocation(t *testing.T) {
+	phone, case_ := uuid.MustParse("00000000-0000-0000-0000-0000000000a1"), uuid.MustParse("00000000-0000-0000-0000-0000000000a2")
+	east := uuid.MustParse("00000000-0000-0000-0000-00000000000e")
+	west := uuid.MustParse("00000000-0000-0000-0000-00000000000f")
+	berlin := uuid.MustParse("00000000-0000-0000-0000-0000000000b0")
+
+	// east ships first by priority; west is in California; berlin has
+	// both products.
+	stock := func() map[uuid.UUID][]warehouseStock {
+		return map[uuid.UUID][]warehouseStock{
+			phone: {
+				{warehouseID: east, country: "US", region: "NY", priority: 1, available: 2},
+				{warehouseID: west, country: "US", region: "CA", priority: 2, available: 5},
+				{warehouseID: berlin, country: "DE", priority: 3, available: 5},
+			},
+			case_: {
+				{warehouseID: east, country: "US", region: "NY", priority: 1, available: 5},
+				{warehouseID: berlin, country: "DE", priority: 3, available: 5},
+			},
+		}
+	}
+	order := []OrderItem{{ProductID: phone, Quantity: 3}, {ProductID: case_, Quantity: 1}}
+
+	for name, tc := range map[string]struct {
+		strategy string
+		dest     Destination
+		want     []pick
+	}{
+		"priority splits across warehouses in order": {
+			strategy: StrategyPriority,
+			want: []pick{
+				{productID: phone, warehouseID: east, quantity: 2},
+				{productID: phone, warehouseID: west, quantity: 1},
+				{productID: case_, warehouseID: east, quantity: 1},
+			},
+		},
+		"nearest prefers the shipping region": {
+			strategy: StrategyNearest,
+			dest:     Destination{Country: "us", Region: "ca"},
+			want: []pick{
+				{productID: phone, warehouseID: west, quantity: 3},
+				{productID: case_, warehouseID: east, quantity: 1},
+			},
+		},
+		"nearest falls back to the country": {
+			strategy: StrategyNearest,
+			dest:     Destination{Country: "DE"},
+			want: []pick{
+				{productID: phone, warehouseID: berlin, quantity: 3},
+				{productID: case_, warehouseID: berlin, quantity: 1},
+			},
+		},
+		"fewest shipments ships from one warehouse": {
+			strategy: StrategyFewestShipments,
+			want: []pick{
+				{productID: phone, warehouseID: berlin, quantity: 3},
+				{productID: case_, warehouseID: berlin, quantity: 1},
+			},
+		},
+	} {
+		got, err := planAllocation(tc.strategy, order, stock(), tc.dest)
+		if err != nil {
+			t.Errorf("%s: planAllocation: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: picks = %+v, want %+v", name, got, tc.want)
+		}
+	}
+
+	_, err := planAllocation(StrategyPriority, []OrderItem{{ProductID: case_, Quantity: 11}}, stock(), Destination{})
+	if !errors.Is(err, ErrOutOfStock) {
+		t.Fatalf("err = %v, want ErrOutOfStock", err)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	if s, err := ParseStrategy(""); err != nil || s != StrategyPriority {
+		t.Fatalf("ParseStrategy(\"\") = %q, %v", s, err)
+	}
+	if s, err := ParseStrategy(" Nearest "); err != nil || s != StrategyNearest {
+		t.Fatalf("ParseStrategy(Nearest) = %q, %v", s, err)
+	}
+	if _, err := ParseStrategy("closest"); !errors.Is(err, ErrUnknownStrategy) {
+		t.Fatalf("err = %v, want ErrUnknownStrategy", err)
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	inventoryrepo "github.com/kalen1o/iphone-storage/apps/inventory-service/internal/inventory/repo"
+)
+
+// ExpiryStats holds the reservation expiry counters, published as the
+// "reservation_expiry" expvar: expired, committed and released count the
+// settled reservations by outcome, errors the failed passes. lag_seconds is
+// how long the oldest due reservation has been waiting after the last
+// pass, settle_lag_seconds how far past expiry the last pass settled one.
+var ExpiryStats = expvar.NewMap("reservation_expiry")
+
+var (
+	expiryLag       = new(expvar.Float)
+	expirySettleLag = new(expvar.Float)
+)
+
+func init() {
+	ExpiryStats.Set("lag_seconds", expiryLag)
+	ExpiryStats.Set("settle_lag_seconds", expirySettleLag)
+}
+
+// expireReservations cancels the orders whose payment window has passed.
+// Every replica runs it; ExpireDue hands each due order to one of them. A
+// full batch means there is a backlog, so the next one starts right away.
+func (s *Service) expireReservations(ctx context.Context) error {
+	if s.sweepInterval <= 0 {
+		return nil
+	}
+	t := time.NewTicker(s.sweepInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		for s.expireBatch(ctx) == s.expiryBatchSize && ctx.Err() == nil {
+		}
+		s.recordExpiryLag(ctx)
+	}
+}
+
+// expireBatch settles one batch and returns its size, zero on error so the
+// worker backs off until the next tick.
+func (s *Service) expireBatch(ctx context.Context) int {
+	settled, err := s.repo.ExpireDue(ctx, s.expiryBatchSize)
+	if err != nil {
+		ExpiryStats.Add("errors", 1)
+		s.log.Error("failed to expire reservations", map[string]any{"err": err.Error()})
+	}
+	// Orders settled before an error are committed and still need their
+	// events.
+	var lag time.Duration
+	for _, st := range settled {
+		id := st.OrderID.String()
+		ExpiryStats.Add(st.Status, 1)
+		lag = max(lag, st.Lag)
+		s.cleanupReservation(ctx, id)
+		s.checkThresholds(ctx, productIDs(st.Items))
+		if st.Status == inventoryrepo.ReservationExpired {
+			s.publishInventoryReleased(ctx, id, "reservation_expired")
+			s.publishOrderCancelled(ctx, id, "reservation_expired")
+			continue
+		}
+		s.log.Info("settled reservation missed by order events", map[string]any{"order_id": id, "status": st.Status})
+	}
+	if len(settled) > 0 {
+		expirySettleLag.Set(lag.Seconds())
+	}
+	if err != nil {
+		return 0
+	}
+	return len(settled)
+}
+
+func (s *Service) recordExpiryLag(ctx context.Context) {
+	lag, err := s.repo.ExpiryLag(ctx)
+	if err != nil {
+		s.log.Error("failed to read expiry lag", map[string]any{"err": err.Error()})
+		return
+	}
+	expiryLag.Set(lag.Seconds())
+	if lag > 10*s.sweepInterval {
+		s.log.Error("reservation expiry is falling behind", map[string]any{"lag": lag.String()})
+	}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	inventoryrepo "github.com/kalen1o/iphone-storage/apps/inventory-service/internal/inventory/repo"
+)
+
+// hotBatchSize is how many admitted orders a write-behind tick applies.
+const hotBatchSize = 100
+
+// ParseProductIDs parses a comma-separated list of product IDs.
+func ParseProductIDs(raw string) ([]uuid.UUID, error) {
+	var out []uuid.UUID
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product id %q: %w", part, err)
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// WithFastPath reserves orders for the given products through Redis
+// counters (see inventoryrepo.HotStock) and writes them to Postgres behind.
+// Orders with any other product still reserve in Postgres. No products
+// leaves the fast path off.
+func (s *Service) WithFastPath(productIDs []uuid.UUID) *Service {
+	if len(productIDs) == 0 {
+		return s
+	}
+	s.hot = inventoryrepo.NewHotStock(s.redis)
+	s.hotProducts = make(map[uuid.UUID]bool, len(productIDs))
+	for _, id := range productIDs {
+		s.hotProducts[id] = true
+	}
+	s.hotFlushInterval = envDuration("INVENTORY_FAST_PATH_FLUSH_INTERVAL", 100*time.Millisecond)
+	s.hotResyncInterval = envDuration("INVENTORY_FAST_PATH_RESYNC_INTERVAL", 5*time.Second)
+	return s
+}
+
+// allHot reports whether every line is for a fast-path product.
+func (s *Service) allHot(items []inventoryrepo.OrderItem) bool {
+	if s.hot == nil || len(items) == 0 {
+		return false
+	}
+	for _, it := range items {
+		if !s.hotProducts[it.ProductID] {
+			return false
+		}
+	}
+	return true
+}
+
+// hotItems returns the lines for fast-path products.
+func (s *Service) hotItems(items []inventoryrepo.OrderItem) []inventoryrepo.OrderItem {
+	var out []inventoryrepo.OrderItem
+	for _, it := range items {
+		if s.hotProducts[it.ProductID] {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+// debitHot lowers the fast-path counters by stock reserved in Postgres.
+func (s *Service) debitHot(ctx context.Context, items []inventoryrepo.OrderItem) {
+	if s.hot == nil {
+		return
+	}
+	if hot := s.hotItems(items); len(hot) > 0 {
+		if err := s.hot.Debit(ctx, hot); err != nil {
+			s.log.Error("failed to debit fast-path stock", map[string]any{"err": err.Error()})
+		}
+	}
+}
+
+// writeBehind applies admitted orders to Postgres.
+func (s *Service) writeBehind(ctx context.Context) error {
+	t := time.NewTicker(s.hotFlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			orders, err := s.hot.Pending(ctx, hotBatchSize)
+			if err != nil {
+				s.log.Error("failed to read fast-path queue", map[string]any{"err": err.Error()})
+				continue
+			}
+			for _, o := range orders {
+				s.applyHot(ctx, o)
+			}
+		}
+	}
+}
+
+// flushHot applies the order now if it is still queued, so payment,
+// cancellation and expiry see its reservation in Postgres.
+func (s *Service) flushHot(ctx context.Context, orderID uuid.UUID) {
+	if s.hot == nil {
+		return
+	}
+	o, ok, err := s.hot.Get(ctx, orderID)
+	if err != nil {
+		s.log.Error("failed to read fast-path order", map[string]any{"err": err.Error(), "order_id": orderID.String()})
+		return
+	}
+	if ok {
+		s.applyHot(ctx, o)
+	}
+}
+
+func (s *Service) applyHot(ctx context.Context, o inventoryrepo.HotOrder) {
+	id := o.OrderID.String()
+	_, err := s.repo.ApplyReservation(ctx, o.OrderID, o.Items)
+	switch {
+	case err == nil:
+		if err := s.hot.Ack(ctx, o); err != nil {
+			s.log.Error("failed to ack fast-path order", map[string]any{"err": err.Error(), "order_id": id})
+		}
+		s.checkThresholds(ctx, productIDs(o.Items))
+	case errors.Is(err, inventoryrepo.ErrOutOfStock), errors.Is(err, inventoryrepo.ErrPreorderLimit):
+		// Postgres lost stock the counter still had, e.g. to a write-off
+		// since the last resync. The order was admitted but cannot be
+		// kept.
+		s.log.Error("fast-path order rejected by postgres", map[string]any{"order_id": id})
+		if err := s.hot.Drop(ctx, o); err != nil {
+			s.log.Error("failed to drop fast-path order", map[string]any{"err": err.Error(), "order_id": id})
+		}
+		s.rejectOrder(ctx, o.OrderID, "out_of_stock")
+	case errors.Is(err, inventoryrepo.ErrOrderNotPending):
+		// Cancelled before it was written behind; the next resync gives
+		// its units back to the counter.
+		if err := s.hot.Drop(ctx, o); err != nil {
+			s.log.Error("failed to drop fast-path order", map[string]any{"err": err.Error(), "order_id": id})
+		}
+		s.cleanupReservation(ctx, id)
+	default:
+		// Left queued for the next tick.
+		s.log.Error("failed to apply fast-path order", map[string]any{"err": err.Error(), "order_id": id})
+	}
+}
+
+// resyncHotStock loads the fast-path counters from Postgres at start and
+// then periodically, picking up stock returned by releases and receipts.
+func (s *Service) resyncHotStock(ctx context.Context) error {
+	t := time.NewTicker(s.hotResyncInterval)
+	defer t.Stop()
+
+	for {
+		s.resyncHot(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Service) resyncHot(ctx context.Context) {
+	ids := make([]uuid.UUID, 0, len(s.hotProducts))
+	for id := range s.hotProducts {
+		ids = append(ids, id)
+	}
+	// Writes must be read before Postgres; see HotStock.Resync.
+	writes, err := s.hot.Writes(ctx, ids)
+	if err != nil {
+		s.log.Error("failed to resync fast-path stock", map[string]any{"err": err.Error()})
+		return
+	}
+	available, err := s.repo.HotAvailable(ctx, ids)
+	if err != nil {
+		s.log.Error("failed to resync fast-path stock", map[string]any{"err": err.Error()})
+		return
+	}
+
+	for _, id := range ids {
+		avail, ok := available[id]
+		if !ok {
+			// Not sellable through the fast path (any more), e.g. switched
+			// to preorders: its orders go to Postgres.
+			if err := s.hot.Unload(ctx, id); err != nil {
+				s.log.Error("failed to unload fast-path product", map[string]any{"err": err.Error(), "product_id": id.String()})
+			}
+			continue
+		}
+		if _, err := s.hot.Resync(ctx, id, avail, writes[id]); err != nil {
+			s.log.Error("failed to resync fast-path stock", map[string]any{"err": err.Error(), "product_id": id.String()})
+		}
+	}
+}
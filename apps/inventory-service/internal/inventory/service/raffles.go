@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runRaffles draws raffles when their draw is due and passes the units of
+// lapsed offers and cancelled claims on to the next entries. A raffle's
+// units are claimed through Reserve, so raffle products must not be on the
+// fast path.
+func (s *Service) runRaffles(ctx context.Context) error {
+	if s.raffleInterval <= 0 {
+		return nil
+	}
+	t := time.NewTicker(s.raffleInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			s.progressRaffles(ctx)
+		}
+	}
+}
+
+func (s *Service) progressRaffles(ctx context.Context) {
+	progress, err := s.repo.RunRaffles(ctx)
+	if err != nil {
+		s.log.Error("failed to run raffles", map[string]any{"err": err.Error()})
+	}
+	// Raffles handled before an error are committed.
+	var changed []uuid.UUID
+	for _, p := range progress {
+		if !p.Changed() {
+			continue
+		}
+		changed = append(changed, p.ProductID)
+		s.log.Info("raffle progressed", map[string]any{
+			"raffle_id": p.RaffleID.String(),
+			"drawn":     p.Drawn,
+			"entries":   p.Entries,
+			"offered":   len(p.Offered),
+			"forfeited": p.Forfeited,
+			"status":    p.Status,
+		})
+	}
+	if len(changed) > 0 {
+		s.checkThresholds(ctx, changed)
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// reconcileLedger periodically replays the inventory ledger and reports
+// products whose inventory row has drifted from it. With
+// INVENTORY_RECONCILE_REPAIR set, drift is also recorded as a
+// reconciliation entry so the ledger explains the row again.
+func (s *Service) reconcileLedger(ctx context.Context) error {
+	if s.reconcileInterval <= 0 {
+		return nil
+	}
+	t := time.NewTicker(s.reconcileInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			s.reconcile(ctx)
+		}
+	}
+}
+
+func (s *Service) reconcile(ctx context.Context) {
+	drift, err := s.repo.FindDrift(ctx)
+	if err != nil {
+		s.log.Error("failed to reconcile inventory ledger", map[string]any{"err": err.Error()})
+		return
+	}
+
+	repaired := 0
+	for _, d := range drift {
+		s.log.Error("inventory ledger drift", map[string]any{
+			"product_id":       d.ProductID.String(),
+			"available":        d.Row.Available,
+			"reserved":         d.Row.Reserved,
+			"on_hand":          d.Row.OnHand,
+			"ledger_available": d.Ledger.Available,
+			"ledger_reserved":  d.Ledger.Reserved,
+			"ledger_on_hand":   d.Ledger.OnHand,
+			"repair":           s.reconcileRepair,
+		})
+		if !s.reconcileRepair {
+			continue
+		}
+		ok, err := s.repo.RepairDrift(ctx, d.ProductID)
+		if err != nil {
+			s.log.Error("failed to repair inventory ledger drift", map[string]any{"err": err.Error(), "product_id": d.ProductID.String()})
+			continue
+		}
+		if ok {
+			repaired++
+		}
+	}
+	s.log.Info("inventory ledger reconciled", map[string]any{"drifted": len(drift), "repaired": repaired})
+}
@@ -2,8 +2,6 @@ package service
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"os"
 	"strconv"
@@ -19,26 +17,47 @@ import (
 	sharedredis "github.com/kalen1o/iphone-storage/shared/redis"
 )
 
+// consumerName keys this service's processed events apart from those of
+// other services consuming the same topics.
+const consumerName = "inventory-service"
+
 type Service struct {
 	repo     *inventoryrepo.Postgres
 	log      *logging.Logger
 	redis    *redis.Client
 	producer *sharedkafka.Producer
 
-	reservationTTL   time.Duration
-	sweepInterval    time.Duration
-	processedEventTTL time.Duration
+	reservationTTL     time.Duration
+	sweepInterval      time.Duration
+	expiryBatchSize    int
+	processedEventTTL  time.Duration
+	allocationInterval time.Duration
+	reconcileInterval  time.Duration
+	reconcileRepair    bool
+	raffleInterval     time.Duration
+
+	// hot is set when the fast path is on; see WithFastPath.
+	hot               *inventoryrepo.HotStock
+	hotProducts       map[uuid.UUID]bool
+	hotFlushInterval  time.Duration
+	hotResyncInterval time.Duration
 }
 
 func New(r *inventoryrepo.Postgres, redisClient *redis.Client, producer *sharedkafka.Producer, log *logging.Logger) *Service {
+	reservationTTL := envDuration("INVENTORY_RESERVATION_TTL", 10*time.Minute)
 	return &Service{
-		repo:              r,
-		redis:             redisClient,
-		producer:          producer,
-		log:               log,
-		reservationTTL:    envDuration("INVENTORY_RESERVATION_TTL", 10*time.Minute),
-		sweepInterval:     envDuration("INVENTORY_RESERVATION_SWEEP_INTERVAL", 2*time.Second),
-		processedEventTTL: envDuration("INVENTORY_PROCESSED_EVENT_TTL", 24*time.Hour),
+		repo:               r.WithReservationTTL(reservationTTL),
+		redis:              redisClient,
+		producer:           producer,
+		log:                log,
+		reservationTTL:     reservationTTL,
+		sweepInterval:      envDuration("INVENTORY_RESERVATION_SWEEP_INTERVAL", 2*time.Second),
+		expiryBatchSize:    envInt("INVENTORY_EXPIRY_BATCH_SIZE", 100),
+		processedEventTTL:  envDuration("INVENTORY_PROCESSED_EVENT_TTL", 24*time.Hour),
+		allocationInterval: envDuration("INVENTORY_ALLOCATION_INTERVAL", 10*time.Second),
+		reconcileInterval:  envDuration("INVENTORY_RECONCILE_INTERVAL", time.Hour),
+		reconcileRepair:    envBool("INVENTORY_RECONCILE_REPAIR", false),
+		raffleInterval:     envDuration("INVENTORY_RAFFLE_INTERVAL", 5*time.Second),
 	}
 }
 
@@ -54,19 +73,49 @@ func envDuration(key string, def time.Duration) time.Duration {
 	return d
 }
 
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+func envBool(key string, def bool) bool {
+	b, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return b
+}
+
 func (s *Service) Run(ctx context.Context, brokers []string, groupID string) error {
 	s.log.Info("service running", map[string]any{
-		"reservation_ttl":      s.reservationTTL.String(),
-		"sweep_interval":       s.sweepInterval.String(),
-		"processed_event_ttl":  s.processedEventTTL.String(),
+		"reservation_ttl":     s.reservationTTL.String(),
+		"sweep_interval":      s.sweepInterval.String(),
+		"expiry_batch_size":   s.expiryBatchSize,
+		"processed_event_ttl": s.processedEventTTL.String(),
+		"allocation_interval": s.allocationInterval.String(),
+		"reconcile_interval":  s.reconcileInterval.String(),
+		"reconcile_repair":    s.reconcileRepair,
+		"raffle_interval":     s.raffleInterval.String(),
+		"fast_path_products":  len(s.hotProducts),
 	})
 
-	errCh := make(chan error, 5)
+	errCh := make(chan error, 10)
 
 	go func() { errCh <- s.consumeOrdersCreated(ctx, brokers, groupID) }()
 	go func() { errCh <- s.consumeOrdersPaid(ctx, brokers, groupID) }()
 	go func() { errCh <- s.consumeOrdersCancelled(ctx, brokers, groupID) }()
-	go func() { errCh <- s.sweepExpiredReservations(ctx) }()
+	go func() { errCh <- s.expireReservations(ctx) }()
+	go func() { errCh <- s.allocateWaitingOrders(ctx) }()
+	go func() { errCh <- s.consumeInventoryAdjusted(ctx, brokers, groupID) }()
+	go func() { errCh <- s.reconcileLedger(ctx) }()
+	go func() { errCh <- s.runRaffles(ctx) }()
+	if s.hot != nil {
+		go func() { errCh <- s.writeBehind(ctx) }()
+		go func() { errCh <- s.resyncHotStock(ctx) }()
+	}
 
 	select {
 	case <-ctx.Done():
@@ -141,8 +190,9 @@ func (s *Service) consumeOrdersPaid(ctx context.Context, brokers []string, group
 
 		orderID, err := uuid.Parse(env.Data.OrderID)
 		if err == nil {
-			if s.hasReservation(ctx, env.Data.OrderID) {
-				_ = s.repo.Finalize(ctx, orderID)
+			s.flushHot(ctx, orderID)
+			if err := s.repo.Finalize(ctx, orderID); err != nil {
+				s.log.Error("failed to finalize reservation", map[string]any{"err": err.Error(), "order_id": env.Data.OrderID})
 			}
 			s.cleanupReservation(ctx, env.Data.OrderID)
 		}
@@ -174,15 +224,47 @@ func (s *Service) consumeOrdersCancelled(ctx context.Context, brokers []string,
 		}
 		orderID, err := uuid.Parse(env.Data.OrderID)
 		if err == nil {
-			if s.hasReservation(ctx, env.Data.OrderID) {
-				_ = s.repo.Release(ctx, orderID)
-			}
+			s.flushHot(ctx, orderID)
+			s.release(ctx, orderID)
 			s.cleanupReservation(ctx, env.Data.OrderID)
 		}
 		_ = c.Commit(ctx, msg)
 	}
 }
 
+// consumeInventoryAdjusted runs the allocation job as soon as an admin adds
+// stock, so waiting orders do not sit until the next tick, and checks the
+// product's low-stock threshold. Stock taken away is debited from the
+// fast-path counter.
+func (s *Service) consumeInventoryAdjusted(ctx context.Context, brokers []string, groupID string) error {
+	c := sharedkafka.NewConsumer(sharedkafka.ConsumerConfig{
+		Brokers: brokers,
+		GroupID: groupID,
+		Topic:   events.TopicInventoryAdjusted,
+	})
+	defer func() { _ = c.Close() }()
+
+	for {
+		msg, err := c.Fetch(ctx)
+		if err != nil {
+			return err
+		}
+		var env events.Envelope[events.InventoryAdjustedData]
+		if err := events.Unmarshal(msg.Value, &env); err == nil {
+			if env.Data.AvailableAfter > env.Data.AvailableBefore {
+				s.allocateWaiting(ctx)
+			}
+			if pid, err := uuid.Parse(env.Data.ProductID); err == nil {
+				if taken := env.Data.AvailableBefore - env.Data.AvailableAfter; taken > 0 {
+					s.debitHot(ctx, []inventoryrepo.OrderItem{{ProductID: pid, Quantity: taken}})
+				}
+				s.checkThresholds(ctx, []uuid.UUID{pid})
+			}
+		}
+		_ = c.Commit(ctx, msg)
+	}
+}
+
 func (s *Service) handleOrdersCreated(ctx context.Context, env events.Envelope[events.OrdersCreatedData]) error {
 	if env.Data.OrderID == "" {
 		return errors.New("missing order_id")
@@ -209,52 +291,88 @@ func (s *Service) handleOrdersCreated(ctx context.Context, env events.Envelope[e
 		return nil
 	}
 
-	if err := s.repo.Reserve(ctx, orderID, items); err != nil {
-		s.cleanupReservation(ctx, env.Data.OrderID)
-		if errors.Is(err, inventoryrepo.ErrOutOfStock) {
-			_, _ = s.repo.UpdateOrderStatusIf(ctx, orderID, "payment_required", "cancelled")
-			s.publishInventoryOutOfStock(ctx, env.Data.OrderID, "out_of_stock")
-			s.publishOrderCancelled(ctx, env.Data.OrderID, "out_of_stock")
+	if s.allHot(items) {
+		// Reserve checks this under the order's lock; the fast path does
+		// not touch Postgres, so an order cancelled before orders.created
+		// was handled would otherwise be admitted and reported reserved.
+		// One cancelled after this check is dropped when written behind.
+		if status, err := s.repo.GetOrderStatus(ctx, orderID); err == nil && status != "payment_required" {
+			s.skipOrder(ctx, orderID)
+			return nil
+		}
+		switch taken, err := s.hot.Take(ctx, orderID, items); {
+		case err != nil:
+			s.log.Error("fast-path reservation failed, using postgres", map[string]any{
+				"err":      err.Error(),
+				"order_id": env.Data.OrderID,
+			})
+		case taken == inventoryrepo.HotTaken || taken == inventoryrepo.HotDuplicate:
+			s.publishInventoryReserved(ctx, env.Data.OrderID)
+			return nil
+		case taken == inventoryrepo.HotSoldOut:
+			s.rejectOrder(ctx, orderID, "out_of_stock")
 			return nil
 		}
-		return err
 	}
 
-	s.publishInventoryReserved(ctx, env.Data.OrderID)
-	return nil
-}
-
-func (s *Service) tryCreateReservation(ctx context.Context, orderID string) (bool, error) {
-	key := sharedredis.Key("reservation:order", orderID)
-	zkey := "reservations:orders"
-	exp := time.Now().Add(s.reservationTTL)
-
-	ok, err := s.redis.SetNX(ctx, key, "1", s.reservationTTL).Result()
+	res, err := s.repo.Reserve(ctx, orderID, items)
 	if err != nil {
-		return false, err
-	}
-	if !ok {
-		return false, nil
+		switch {
+		case errors.Is(err, inventoryrepo.ErrAlreadyReserved):
+			// Reserved by an earlier delivery that lost its Redis key.
+		case errors.Is(err, inventoryrepo.ErrOrderNotPending):
+			s.skipOrder(ctx, orderID)
+		case errors.Is(err, inventoryrepo.ErrOutOfStock):
+			s.rejectOrder(ctx, orderID, "out_of_stock")
+		case errors.Is(err, inventoryrepo.ErrPreorderLimit):
+			s.rejectOrder(ctx, orderID, "preorder_limit_reached")
+		default:
+			s.cleanupReservation(ctx, env.Data.OrderID)
+			return err
+		}
+		return nil
 	}
+	s.debitHot(ctx, items)
+	s.checkThresholds(ctx, productIDs(items))
 
-	if err := s.redis.ZAdd(ctx, zkey, redis.Z{Score: float64(exp.Unix()), Member: orderID}).Err(); err != nil {
-		_ = s.redis.Del(ctx, key).Err()
-		return false, err
+	if res.AwaitingStock() {
+		// The payment window starts when the allocation job fills the
+		// last waiting line, so there is nothing to expire until then.
+		s.cleanupReservation(ctx, env.Data.OrderID)
+		s.publishInventoryAwaitingStock(ctx, env.Data.OrderID, res)
+		return nil
 	}
-	return true, nil
+
+	s.publishInventoryReserved(ctx, env.Data.OrderID)
+	return nil
 }
 
-func (s *Service) cleanupReservation(ctx context.Context, orderID string) {
-	key := sharedredis.Key("reservation:order", orderID)
-	_ = s.redis.Del(ctx, key).Err()
-	_ = s.redis.ZRem(ctx, "reservations:orders", orderID).Err()
+// skipOrder drops an order that was cancelled before its stock was
+// reserved; there is nothing to reserve or release.
+func (s *Service) skipOrder(ctx context.Context, orderID uuid.UUID) {
+	id := orderID.String()
+	s.cleanupReservation(ctx, id)
+	s.log.Info("order no longer awaiting payment, not reserving", map[string]any{"order_id": id})
 }
 
-func (s *Service) sweepExpiredReservations(ctx context.Context) error {
-	if s.sweepInterval <= 0 {
+// rejectOrder cancels an order whose stock could not be reserved.
+func (s *Service) rejectOrder(ctx context.Context, orderID uuid.UUID, reason string) {
+	id := orderID.String()
+	s.cleanupReservation(ctx, id)
+	_, _ = s.repo.UpdateOrderStatusIf(ctx, orderID, "payment_required", "cancelled")
+	s.publishInventoryOutOfStock(ctx, id, reason)
+	s.publishOrderCancelled(ctx, id, reason)
+}
+
+// allocateWaitingOrders periodically fills preorder and backorder queues
+// from stock that has arrived, and starts the payment window of every
+// order that is now fully reserved. It also sweeps the low-stock
+// thresholds.
+func (s *Service) allocateWaitingOrders(ctx context.Context) error {
+	if s.allocationInterval <= 0 {
 		return nil
 	}
-	t := time.NewTicker(s.sweepInterval)
+	t := time.NewTicker(s.allocationInterval)
 	defer t.Stop()
 
 	for {
@@ -262,73 +380,55 @@ func (s *Service) sweepExpiredReservations(ctx context.Context) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-t.C:
-			now := time.Now().Unix()
-			expired, err := s.redis.ZRangeByScore(ctx, "reservations:orders", &redis.ZRangeBy{
-				Min: "-inf",
-				Max: strconv.FormatInt(now, 10),
-				// Keep batch small.
-				Offset: 0,
-				Count:  50,
-			}).Result()
-			if err != nil {
-				continue
-			}
-
-			for _, orderID := range expired {
-				s.handleExpiredReservation(ctx, orderID)
-			}
+			s.allocateWaiting(ctx)
+			s.checkThresholds(ctx, nil)
 		}
 	}
 }
 
-func (s *Service) handleExpiredReservation(ctx context.Context, orderID string) {
-	// If the reservation key still exists, it hasn't expired yet; ignore.
-	key := sharedredis.Key("reservation:order", orderID)
-	exists, err := s.redis.Exists(ctx, key).Result()
-	if err == nil && exists == 1 {
-		ttl, err := s.redis.TTL(ctx, key).Result()
-		if err == nil && ttl > 0 {
-			exp := time.Now().Add(ttl)
-			_ = s.redis.ZAdd(ctx, "reservations:orders", redis.Z{Score: float64(exp.Unix()), Member: orderID}).Err()
-		} else {
-			_ = s.redis.ZRem(ctx, "reservations:orders", orderID).Err()
-		}
-		return
-	}
-
-	oid, err := uuid.Parse(orderID)
+func (s *Service) allocateWaiting(ctx context.Context) {
+	ready, err := s.repo.AllocateWaiting(ctx)
 	if err != nil {
-		s.cleanupReservation(ctx, orderID)
-		return
+		s.log.Error("failed to allocate waiting orders", map[string]any{"err": err.Error()})
 	}
-
-	// Only cancel if still awaiting payment.
-	updated, err := s.repo.UpdateOrderStatusIf(ctx, oid, "payment_required", "cancelled")
-	if err == nil && updated {
-		_ = s.repo.Release(ctx, oid)
-		s.publishInventoryReleased(ctx, orderID, "reservation_expired")
-		s.publishOrderCancelled(ctx, orderID, "reservation_expired")
+	// Orders filled before an error are committed and still need to move on.
+	for _, orderID := range ready {
+		s.publishInventoryReserved(ctx, orderID.String())
+	}
+	if len(ready) > 0 {
+		s.log.Info("waiting orders allocated", map[string]any{"orders": len(ready)})
 	}
+}
 
-	s.cleanupReservation(ctx, orderID)
+// tryCreateReservation marks the order as being reserved, so a redelivered
+// orders.created is dropped without a trip to Postgres. The reservation
+// itself, and its expiry, live in inventory_reservations.
+func (s *Service) tryCreateReservation(ctx context.Context, orderID string) (bool, error) {
+	key := sharedredis.Key("reservation:order", orderID)
+	return s.redis.SetNX(ctx, key, "1", s.reservationTTL).Result()
 }
 
-func (s *Service) hasReservation(ctx context.Context, orderID string) bool {
+func (s *Service) cleanupReservation(ctx context.Context, orderID string) {
 	key := sharedredis.Key("reservation:order", orderID)
-	exists, err := s.redis.Exists(ctx, key).Result()
+	_ = s.redis.Del(ctx, key).Err()
+}
+
+// release returns a cancelled order's reservation and checks the
+// thresholds of the products it gave back.
+func (s *Service) release(ctx context.Context, orderID uuid.UUID) {
+	items, err := s.repo.Release(ctx, orderID)
 	if err != nil {
-		return true
+		s.log.Error("failed to release reservation", map[string]any{"err": err.Error(), "order_id": orderID.String()})
+		return
 	}
-	return exists == 1
+	s.checkThresholds(ctx, productIDs(items))
 }
 
 func (s *Service) markEventProcessed(ctx context.Context, eventID string) bool {
 	if eventID == "" {
 		return true
 	}
-	sum := sha256.Sum256([]byte(eventID))
-	key := "processed:event:" + hex.EncodeToString(sum[:])
-	ok, err := s.redis.SetNX(ctx, key, "1", s.processedEventTTL).Result()
+	ok, err := sharedredis.MarkEventProcessed(ctx, s.redis, consumerName, eventID, s.processedEventTTL)
 	if err != nil {
 		// fail open to avoid stalling the system
 		return true
@@ -351,6 +451,25 @@ func (s *Service) publishInventoryReserved(ctx context.Context, orderID string)
 	_ = s.producer.Publish(ctx, events.TopicInventoryReserved, []byte(orderID), b)
 }
 
+func (s *Service) publishInventoryAwaitingStock(ctx context.Context, orderID string, res *inventoryrepo.Reservation) {
+	waiting := make([]events.OrderItem, 0, len(res.Waiting))
+	for _, it := range res.Waiting {
+		waiting = append(waiting, events.OrderItem{ProductID: it.ProductID.String(), Quantity: it.Quantity})
+	}
+	payload := events.Envelope[events.InventoryAwaitingStockData]{
+		EventID:     uuid.NewString(),
+		Type:        events.TypeInventoryAwaitingStock,
+		OccurredAt:  time.Now().UTC(),
+		AggregateID: orderID,
+		Data:        events.InventoryAwaitingStockData{OrderID: orderID, Preorder: res.Preorder, Waiting: waiting},
+	}
+	b, err := events.Marshal(payload)
+	if err != nil {
+		return
+	}
+	_ = s.producer.Publish(ctx, events.TopicInventoryAwaitingStock, []byte(orderID), b)
+}
+
 func (s *Service) publishInventoryOutOfStock(ctx context.Context, orderID, reason string) {
 	payload := events.Envelope[events.InventoryOutOfStockData]{
 		EventID:     uuid.NewString(),
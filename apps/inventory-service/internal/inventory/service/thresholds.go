@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	inventoryrepo "github.com/kalen1o/iphone-storage/apps/inventory-service/internal/inventory/repo"
+	"github.com/kalen1o/iphone-storage/shared/events"
+)
+
+// checkThresholds publishes inventory.low_stock or inventory.restocked for
+// the products that crossed their low-stock threshold; nil checks every
+// product. It runs after each change to available stock, and as a sweep on
+// the allocation tick to catch changes made elsewhere. Finalize moves
+// reserved units out of on_hand without touching available, so it never
+// crosses a threshold.
+func (s *Service) checkThresholds(ctx context.Context, productIDs []uuid.UUID) {
+	if productIDs != nil && len(productIDs) == 0 {
+		return
+	}
+	crossings, err := s.repo.CheckStockThresholds(ctx, productIDs)
+	if err != nil {
+		s.log.Error("failed to check low-stock thresholds", map[string]any{"err": err.Error()})
+		return
+	}
+	for _, c := range crossings {
+		s.publishStockLevel(ctx, c)
+	}
+}
+
+func productIDs(items []inventoryrepo.OrderItem) []uuid.UUID {
+	out := make([]uuid.UUID, 0, len(items))
+	for _, it := range items {
+		out = append(out, it.ProductID)
+	}
+	return out
+}
+
+func (s *Service) publishStockLevel(ctx context.Context, c inventoryrepo.ThresholdCrossing) {
+	topic, typ := events.TopicInventoryRestocked, events.TypeInventoryRestocked
+	if c.Low {
+		topic, typ = events.TopicInventoryLowStock, events.TypeInventoryLowStock
+	}
+	productID := c.ProductID.String()
+	payload := events.Envelope[events.InventoryStockLevelData]{
+		EventID:     uuid.NewString(),
+		Type:        typ,
+		OccurredAt:  time.Now().UTC(),
+		AggregateID: productID,
+		Data: events.InventoryStockLevelData{
+			ProductID:         productID,
+			Available:         c.Available,
+			LowStockThreshold: c.Threshold,
+		},
+	}
+	b, err := events.Marshal(payload)
+	if err != nil {
+		return
+	}
+	_ = s.producer.Publish(ctx, topic, []byte(productID), b)
+	s.log.Info(string(typ), map[string]any{"product_id": productID, "available": c.Available, "low_stock_threshold": c.Threshold})
+}
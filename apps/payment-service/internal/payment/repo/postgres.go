@@ -7,6 +7,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/kalen1o/iphone-storage/shared/ledger"
 )
 
 var ErrNotPaymentRequired = errors.New("order not payment_required")
@@ -42,6 +44,11 @@ type PaymentResult struct {
 	Status    string
 }
 
+// CreateOrUpdatePaymentAndSetOrderStatus settles an order's payment. Gift
+// card and store credit held for the order pay first and the provider is
+// charged the rest, with succeeded as the outcome of that charge; when the
+// held tender covers the whole total the provider is not charged. A paid
+// order's tender is captured, a failed one's given back.
 func (r *Postgres) CreateOrUpdatePaymentAndSetOrderStatus(ctx context.Context, orderID uuid.UUID, succeeded bool) (*PaymentResult, error) {
 	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
@@ -53,6 +60,7 @@ func (r *Postgres) CreateOrUpdatePaymentAndSetOrderStatus(ctx context.Context, o
 		SELECT status, total::float8, currency
 		FROM orders
 		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
 	`, orderID)
 	var status string
 	var total float64
@@ -64,6 +72,15 @@ func (r *Postgres) CreateOrUpdatePaymentAndSetOrderStatus(ctx context.Context, o
 		return nil, ErrNotPaymentRequired
 	}
 
+	held, err := lockedHeldTender(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	due := ledger.ToCents(total) - sumHeld(held)
+	if due <= 0 {
+		succeeded = true
+	}
+
 	finalPaymentStatus := "failed"
 	finalOrderStatus := "cancelled"
 	if succeeded {
@@ -71,16 +88,46 @@ func (r *Postgres) CreateOrUpdatePaymentAndSetOrderStatus(ctx context.Context, o
 		finalOrderStatus = "paid"
 	}
 
-	providerPaymentID := "fake:" + orderID.String()
 	var paymentID uuid.UUID
-	row = tx.QueryRow(ctx, `
-		INSERT INTO payments (order_id, provider, provider_payment_id, amount, currency, status, metadata)
-		VALUES ($1, 'other', $2, $3, $4, $5, '{}'::jsonb)
-		ON CONFLICT (provider_payment_id)
-		DO UPDATE SET status = EXCLUDED.status, updated_at = NOW()
-		RETURNING id
-	`, orderID, providerPaymentID, total, currency, finalPaymentStatus)
-	if err := row.Scan(&paymentID); err != nil {
+	if due > 0 {
+		providerPaymentID := "fake:" + orderID.String()
+		row = tx.QueryRow(ctx, `
+			INSERT INTO payments (order_id, provider, provider_payment_id, amount, currency, status, metadata)
+			VALUES ($1, 'other', $2, $3, $4, $5, '{}'::jsonb)
+			ON CONFLICT (provider_payment_id)
+			DO UPDATE SET status = EXCLUDED.status, updated_at = NOW()
+			RETURNING id
+		`, orderID, providerPaymentID, ledger.FromCents(due), currency, finalPaymentStatus)
+		if err := row.Scan(&paymentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if succeeded {
+		// Each gift card and the store credit is recorded as a payment of
+		// its own, so the order's payments add up to its total.
+		for _, h := range held {
+			var id uuid.UUID
+			if err := tx.QueryRow(ctx, `
+				INSERT INTO payments (order_id, provider, provider_payment_id, amount, currency, status,
+				                      payment_method_id, payment_method_type, metadata)
+				VALUES ($1, 'other', $2, $3, $4, 'succeeded', $5, $6, '{}'::jsonb)
+				ON CONFLICT (provider_payment_id)
+				DO UPDATE SET status = EXCLUDED.status, updated_at = NOW()
+				RETURNING id
+			`, orderID, h.kind+":"+orderID.String()+":"+h.accountID.String(), ledger.FromCents(h.cents), currency,
+				h.accountID.String(), h.kind).Scan(&id); err != nil {
+				return nil, err
+			}
+			if paymentID == uuid.Nil {
+				paymentID = id
+			}
+		}
+		err = captureTender(ctx, tx, orderID, currency, held)
+	} else {
+		err = releaseTender(ctx, tx, orderID, currency, held)
+	}
+	if err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,183 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kalen1o/iphone-storage/shared/ledger"
+)
+
+// ErrNotPending is returned when a purchased gift card has already been
+// charged for.
+var ErrNotPending = errors.New("gift card not pending")
+
+// heldTender is gift card or store credit core-api set aside for an order
+// when it was placed, per source account.
+type heldTender struct {
+	accountID uuid.UUID
+	kind      string
+	cents     int64
+}
+
+// lockedHeldTender returns what is still held for the order, per source.
+// Call it with the order row locked: holds are only captured or released
+// under that lock, so the result cannot change before the caller commits.
+func lockedHeldTender(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) ([]heldTender, error) {
+	var held int64
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(e.amount_cents), 0)::bigint
+		FROM ledger_transactions t
+		JOIN ledger_entries e ON e.transaction_id = t.id
+		JOIN ledger_accounts a ON a.id = e.account_id
+		WHERE t.order_id = $1 AND a.kind = 'held'
+	`, orderID).Scan(&held); err != nil {
+		return nil, err
+	}
+	if held == 0 {
+		return nil, nil
+	}
+
+	// Nothing has been captured, so the holds less the releases are still
+	// held.
+	rows, err := tx.Query(ctx, `
+		SELECT a.id, a.kind, -SUM(e.amount_cents)::bigint
+		FROM ledger_transactions t
+		JOIN ledger_entries e ON e.transaction_id = t.id
+		JOIN ledger_accounts a ON a.id = e.account_id
+		WHERE t.order_id = $1 AND t.kind IN ('hold', 'release') AND a.kind IN ('gift_card', 'store_credit')
+		GROUP BY a.id, a.kind
+		HAVING SUM(e.amount_cents) <> 0
+		ORDER BY a.kind, a.id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (heldTender, error) {
+		var h heldTender
+		err := row.Scan(&h.accountID, &h.kind, &h.cents)
+		return h, err
+	})
+}
+
+func sumHeld(held []heldTender) int64 {
+	var sum int64
+	for _, h := range held {
+		sum += h.cents
+	}
+	return sum
+}
+
+// captureTender moves what is held for a paid order to redeemed.
+func captureTender(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, currency string, held []heldTender) error {
+	if len(held) == 0 {
+		return nil
+	}
+	heldID, err := ledger.SystemAccount(ctx, tx, "held", currency)
+	if err != nil {
+		return err
+	}
+	redeemedID, err := ledger.SystemAccount(ctx, tx, "redeemed", currency)
+	if err != nil {
+		return err
+	}
+	sum := sumHeld(held)
+	_, err = ledger.Post(ctx, tx, ledger.Transaction{Kind: "capture", OrderID: &orderID},
+		ledger.Posting{AccountID: heldID, Cents: -sum}, ledger.Posting{AccountID: redeemedID, Cents: sum})
+	return err
+}
+
+// releaseTender gives what is held for a cancelled order back to the gift
+// cards and store credit it came from.
+func releaseTender(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, currency string, held []heldTender) error {
+	if len(held) == 0 {
+		return nil
+	}
+	heldID, err := ledger.SystemAccount(ctx, tx, "held", currency)
+	if err != nil {
+		return err
+	}
+	postings := []ledger.Posting{{AccountID: heldID, Cents: -sumHeld(held)}}
+	for _, h := range held {
+		postings = append(postings, ledger.Posting{AccountID: h.accountID, Cents: h.cents})
+	}
+	_, err = ledger.Post(ctx, tx, ledger.Transaction{Kind: "release", OrderID: &orderID}, postings...)
+	return err
+}
+
+// ReleaseTender gives back what is held for the order if it has been
+// cancelled. It reports whether anything was released; calling it again
+// releases nothing.
+func (r *Postgres) ReleaseTender(ctx context.Context, orderID uuid.UUID) (bool, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var status, currency string
+	if err := tx.QueryRow(ctx, `
+		SELECT status, COALESCE(currency, 'USD') FROM orders WHERE id = $1 FOR UPDATE
+	`, orderID).Scan(&status, &currency); err != nil {
+		return false, err
+	}
+	if status != "cancelled" {
+		return false, nil
+	}
+	held, err := lockedHeldTender(ctx, tx, orderID)
+	if err != nil || len(held) == 0 {
+		return false, err
+	}
+	if err := releaseTender(ctx, tx, orderID, currency, held); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// FundGiftCard settles the charge for a purchased gift card: on success the
+// card is funded from sales and activated, otherwise it is marked failed.
+// It returns ErrNotPending if the card was already settled.
+func (r *Postgres) FundGiftCard(ctx context.Context, cardID uuid.UUID, providerPaymentID string, succeeded bool) (string, error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var accountID uuid.UUID
+	var status, currency string
+	var amount int64
+	if err := tx.QueryRow(ctx, `
+		SELECT account_id, status, currency, initial_amount_cents
+		FROM gift_cards WHERE id = $1
+		FOR UPDATE
+	`, cardID).Scan(&accountID, &status, &currency, &amount); err != nil {
+		return "", err
+	}
+	if status != "pending" {
+		return "", ErrNotPending
+	}
+
+	status = "failed"
+	if succeeded {
+		status = "active"
+		salesID, err := ledger.SystemAccount(ctx, tx, "sales", currency)
+		if err != nil {
+			return "", err
+		}
+		if _, err := ledger.Post(ctx, tx, ledger.Transaction{Kind: "purchase", Reference: providerPaymentID},
+			ledger.Posting{AccountID: salesID, Cents: -amount}, ledger.Posting{AccountID: accountID, Cents: amount}); err != nil {
+			return "", err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE gift_cards
+		SET status = $2, activated_at = CASE WHEN $2 = 'active' THEN NOW() END, updated_at = NOW()
+		WHERE id = $1
+	`, cardID, status); err != nil {
+		return "", err
+	}
+	return status, tx.Commit(ctx)
+}
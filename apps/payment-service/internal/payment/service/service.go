@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"os"
 	"time"
@@ -18,6 +17,10 @@ import (
 	sharedredis "github.com/kalen1o/iphone-storage/shared/redis"
 )
 
+// consumerName keys this service's processed events apart from those of
+// other services consuming the same topics.
+const consumerName = "payment-service"
+
 type Service struct {
 	repo     *paymentrepo.Postgres
 	log      *logging.Logger
@@ -53,7 +56,19 @@ func (s *Service) Run(ctx context.Context, brokers []string, groupID string) err
 	s.log.Info("service running", map[string]any{
 		"processed_event_ttl": s.processedEventTTL.String(),
 	})
-	return s.consumeInventoryReserved(ctx, brokers, groupID)
+
+	errCh := make(chan error, 3)
+
+	go func() { errCh <- s.consumeInventoryReserved(ctx, brokers, groupID) }()
+	go func() { errCh <- s.consumeOrdersCancelled(ctx, brokers, groupID) }()
+	go func() { errCh <- s.consumeGiftCardsPurchased(ctx, brokers, groupID) }()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
 }
 
 func (s *Service) consumeInventoryReserved(ctx context.Context, brokers []string, groupID string) error {
@@ -110,8 +125,9 @@ func (s *Service) handleInventoryReserved(ctx context.Context, env events.Envelo
 		}
 	}
 
-	succeeded := fakePaymentOutcome(env.Data.OrderID)
-	res, err := s.repo.CreateOrUpdatePaymentAndSetOrderStatus(ctx, orderID, succeeded)
+	// The outcome is only used for what gift card and store credit leave
+	// for the provider to charge.
+	res, err := s.repo.CreateOrUpdatePaymentAndSetOrderStatus(ctx, orderID, fakePaymentOutcome(env.Data.OrderID))
 	if err != nil {
 		if errors.Is(err, paymentrepo.ErrNotPaymentRequired) {
 			return nil
@@ -119,7 +135,7 @@ func (s *Service) handleInventoryReserved(ctx context.Context, env events.Envelo
 		return err
 	}
 
-	if succeeded {
+	if res.Status == "succeeded" {
 		s.publishOrdersPaid(ctx, env.Data.OrderID)
 		s.publishPaymentSucceeded(ctx, env.Data.OrderID, res.PaymentID.String())
 		return nil
@@ -139,9 +155,7 @@ func (s *Service) markEventProcessed(ctx context.Context, eventID string) bool {
 	if s.redis == nil || eventID == "" {
 		return true
 	}
-	sum := sha256.Sum256([]byte(eventID))
-	key := "processed:event:" + hex.EncodeToString(sum[:])
-	ok, err := s.redis.SetNX(ctx, key, "1", s.processedEventTTL).Result()
+	ok, err := sharedredis.MarkEventProcessed(ctx, s.redis, consumerName, eventID, s.processedEventTTL)
 	if err != nil {
 		return true
 	}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+
+	paymentrepo "github.com/kalen1o/iphone-storage/apps/payment-service/internal/payment/repo"
+	"github.com/kalen1o/iphone-storage/shared/events"
+	sharedkafka "github.com/kalen1o/iphone-storage/shared/kafka"
+)
+
+// consumeOrdersCancelled gives back the gift card and store credit held for
+// orders cancelled before payment: by the customer, on reservation expiry
+// or for lack of stock.
+func (s *Service) consumeOrdersCancelled(ctx context.Context, brokers []string, groupID string) error {
+	c := sharedkafka.NewConsumer(sharedkafka.ConsumerConfig{
+		Brokers: brokers,
+		GroupID: groupID,
+		Topic:   events.TopicOrdersCancelled,
+	})
+	defer func() { _ = c.Close() }()
+
+	for {
+		msg, err := c.Fetch(ctx)
+		if err != nil {
+			return err
+		}
+		var env events.Envelope[events.OrdersCancelledData]
+		if err := events.Unmarshal(msg.Value, &env); err != nil {
+			_ = c.Commit(ctx, msg)
+			continue
+		}
+		if !s.markEventProcessed(ctx, env.EventID) {
+			_ = c.Commit(ctx, msg)
+			continue
+		}
+		if orderID, err := uuid.Parse(env.Data.OrderID); err == nil {
+			if _, err := s.repo.ReleaseTender(ctx, orderID); err != nil {
+				s.log.Error("failed to release held tender", map[string]any{
+					"err":      err.Error(),
+					"order_id": env.Data.OrderID,
+				})
+			}
+		}
+		_ = c.Commit(ctx, msg)
+	}
+}
+
+// consumeGiftCardsPurchased charges customers for the gift cards they buy
+// and funds the cards that are paid for.
+func (s *Service) consumeGiftCardsPurchased(ctx context.Context, brokers []string, groupID string) error {
+	c := sharedkafka.NewConsumer(sharedkafka.ConsumerConfig{
+		Brokers: brokers,
+		GroupID: groupID,
+		Topic:   events.TopicGiftCardsPurchased,
+	})
+	defer func() { _ = c.Close() }()
+
+	for {
+		msg, err := c.Fetch(ctx)
+		if err != nil {
+			return err
+		}
+		var env events.Envelope[events.GiftCardsPurchasedData]
+		if err := events.Unmarshal(msg.Value, &env); err != nil {
+			_ = c.Commit(ctx, msg)
+			continue
+		}
+		if !s.markEventProcessed(ctx, env.EventID) {
+			_ = c.Commit(ctx, msg)
+			continue
+		}
+		if err := s.handleGiftCardPurchased(ctx, env.Data); err != nil {
+			s.log.Error("failed to handle gift_cards.purchased", map[string]any{
+				"err":          err.Error(),
+				"gift_card_id": env.Data.GiftCardID,
+			})
+		}
+		_ = c.Commit(ctx, msg)
+	}
+}
+
+func (s *Service) handleGiftCardPurchased(ctx context.Context, data events.GiftCardsPurchasedData) error {
+	cardID, err := uuid.Parse(data.GiftCardID)
+	if err != nil {
+		return err
+	}
+	succeeded := fakePaymentOutcome(data.GiftCardID)
+	status, err := s.repo.FundGiftCard(ctx, cardID, "fake:gift_card:"+data.GiftCardID, succeeded)
+	if errors.Is(err, paymentrepo.ErrNotPending) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.log.Info("gift card purchase settled", map[string]any{
+		"gift_card_id": data.GiftCardID,
+		"status":       status,
+	})
+	return nil
+}
@@ -33,12 +33,19 @@ create_topic "payments.initiated"
 create_topic "payments.succeeded"
 create_topic "payments.failed"
 create_topic "payments.webhook_received"
+create_topic "gift_cards.purchased"
 
 echo "Inventory topics:"
 create_topic "inventory.reserved"
 create_topic "inventory.released"
 create_topic "inventory.out_of_stock"
 create_topic "inventory.adjusted"
+create_topic "inventory.awaiting_stock"
+create_topic "inventory.low_stock"
+create_topic "inventory.restocked"
+
+echo "Product topics:"
+create_topic "products.updated"
 
 echo "DLQ topic:"
 create_topic "events.dlq"
@@ -15,7 +15,13 @@ type Config struct {
 	Redis     RedisConfig
 	Service   ServiceConfig
 	JWT       JWTConfig
+	TwoFactor TwoFactorConfig
 	RateLimit RateLimitConfig
+	Cache     CacheConfig
+	Storage   StorageConfig
+	Pricing   PricingConfig
+	// WaitingRoom controls the core-api launch waiting room.
+	WaitingRoom WaitingRoomConfig
 }
 
 type DatabaseConfig struct {
@@ -60,6 +66,71 @@ type JWTConfig struct {
 	Expiry time.Duration
 }
 
+type TwoFactorConfig struct {
+	Issuer        string
+	RequiredRoles []string
+	ChallengeTTL  time.Duration
+	// MaxFailures failed codes in a row lock a user's second factor for Lockout.
+	MaxFailures int
+	Lockout     time.Duration
+}
+
+// CacheConfig controls the core-api read-through cache.
+type CacheConfig struct {
+	Enabled         bool
+	CatalogTTL      time.Duration
+	AvailabilityTTL time.Duration
+	// RefreshAhead is the fraction of a TTL before expiry at which reads
+	// start refreshing an entry in the background.
+	RefreshAhead float64
+	// InvalidationGroupID is the Kafka consumer group for cache invalidation.
+	InvalidationGroupID string
+}
+
+// StorageConfig selects where uploaded media such as product images is kept.
+type StorageConfig struct {
+	// Backend is "local" or "s3".
+	Backend string
+	// LocalDir is the root directory of the local backend.
+	LocalDir string
+	// PublicBaseURL prefixes object keys to form the URLs handed to clients.
+	PublicBaseURL string
+	// S3Endpoint is the base URL of an S3-compatible API, e.g. MinIO.
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	// S3PathStyle addresses buckets as endpoint/bucket rather than
+	// bucket.endpoint, as MinIO expects.
+	S3PathStyle bool
+	// MaxImageBytes caps a single image upload. Keep it under the gateway's
+	// client_max_body_size.
+	MaxImageBytes int64
+}
+
+// PricingConfig controls the core-api price scheduler.
+type PricingConfig struct {
+	// SchedulerInterval is how often scheduled price windows are applied;
+	// a price starts or ends at most this late.
+	SchedulerInterval time.Duration
+}
+
+// WaitingRoomConfig controls the queue that admits buyers to checkout
+// during a launch. The room is opened and paused by admins; these are its
+// defaults.
+type WaitingRoomConfig struct {
+	// Secret signs queue tickets. It defaults to the JWT secret.
+	Secret string
+	// AdmitPerSecond is the admission rate of a room opened without one.
+	AdmitPerSecond float64
+	// CheckoutWindow is how long an admitted ticket may place orders.
+	CheckoutWindow time.Duration
+	// FailOpen lets orders through while Redis is down even if the room
+	// may be open.
+	FailOpen bool
+}
+
 type RateLimitConfig struct {
 	RequestsPerMinute int
 }
@@ -103,9 +174,44 @@ func Load() (*Config, error) {
 			Secret: getEnv("JWT_SECRET", "change-me"),
 			Expiry: getEnvAsDuration("JWT_EXPIRY", 24*time.Hour),
 		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:        getEnv("TOTP_ISSUER", "iPhone Storage"),
+			RequiredRoles: getEnvAsSlice("AUTH_2FA_REQUIRED_ROLES", []string{}),
+			ChallengeTTL:  getEnvAsDuration("AUTH_2FA_CHALLENGE_TTL", 5*time.Minute),
+			MaxFailures:   getEnvAsInt("AUTH_2FA_MAX_FAILURES", 5),
+			Lockout:       getEnvAsDuration("AUTH_2FA_LOCKOUT", 15*time.Minute),
+		},
 		RateLimit: RateLimitConfig{
 			RequestsPerMinute: getEnvAsInt("RATE_LIMIT_REQUESTS_PER_MINUTE", 60),
 		},
+		Cache: CacheConfig{
+			Enabled:             getEnvAsBool("CACHE_ENABLED", true),
+			CatalogTTL:          getEnvAsDuration("CACHE_CATALOG_TTL", time.Minute),
+			AvailabilityTTL:     getEnvAsDuration("CACHE_AVAILABILITY_TTL", 5*time.Second),
+			RefreshAhead:        getEnvAsFloat("CACHE_REFRESH_AHEAD", 0.2),
+			InvalidationGroupID: getEnv("CACHE_INVALIDATION_GROUP_ID", "core-api-cache"),
+		},
+		Storage: StorageConfig{
+			Backend:       getEnv("STORAGE_BACKEND", "local"),
+			LocalDir:      getEnv("STORAGE_LOCAL_DIR", "./data/media"),
+			PublicBaseURL: getEnv("STORAGE_PUBLIC_BASE_URL", "/api/media"),
+			S3Endpoint:    getEnv("S3_ENDPOINT", ""),
+			S3Region:      getEnv("S3_REGION", "us-east-1"),
+			S3Bucket:      getEnv("S3_BUCKET", ""),
+			S3AccessKey:   getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey:   getEnv("S3_SECRET_KEY", ""),
+			S3PathStyle:   getEnvAsBool("S3_PATH_STYLE", true),
+			MaxImageBytes: int64(getEnvAsInt("STORAGE_MAX_IMAGE_BYTES", 8<<20)),
+		},
+		Pricing: PricingConfig{
+			SchedulerInterval: getEnvAsDuration("PRICE_SCHEDULER_INTERVAL", 30*time.Second),
+		},
+		WaitingRoom: WaitingRoomConfig{
+			Secret:         getEnv("WAITING_ROOM_SECRET", getEnv("JWT_SECRET", "change-me")),
+			AdmitPerSecond: getEnvAsFloat("WAITING_ROOM_ADMIT_PER_SECOND", 10),
+			CheckoutWindow: getEnvAsDuration("WAITING_ROOM_CHECKOUT_WINDOW", 10*time.Minute),
+			FailOpen:       getEnvAsBool("WAITING_ROOM_FAIL_OPEN", false),
+		},
 	}, nil
 }
 
@@ -172,3 +278,21 @@ func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,7 @@
+-- Track GDPR erasure of users and the orders whose PII was anonymized
+
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,26 @@
+-- Product variants: a parent product declares options (e.g. storage, color)
+-- and each variant is a product row of its own with parent_id set, so that
+-- inventory, reservations and order_items keep keying on product_id.
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS option_values JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+CREATE INDEX IF NOT EXISTS idx_products_parent_id ON products(parent_id) WHERE parent_id IS NOT NULL;
+
+-- One live variant per option combination.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_variant_options
+    ON products(parent_id, option_values)
+    WHERE parent_id IS NOT NULL AND deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS product_options (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    option_values TEXT[] NOT NULL CHECK (cardinality(option_values) > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(product_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_options_product_id ON product_options(product_id);
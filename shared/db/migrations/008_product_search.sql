@@ -0,0 +1,16 @@
+-- Full-text search over the catalog. Name and SKU weigh more than the
+-- description; SKUs use the 'simple' config so they are not stemmed.
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('english'::regconfig, coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('simple'::regconfig, coalesce(sku, '')), 'A') ||
+        setweight(to_tsvector('simple'::regconfig, coalesce(category, '')), 'B') ||
+        setweight(to_tsvector('english'::regconfig, coalesce(description, '')), 'C')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_products_price ON products(price);
+CREATE INDEX IF NOT EXISTS idx_products_metadata ON products USING GIN (metadata jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_products_option_values ON products USING GIN (option_values jsonb_path_ops)
+    WHERE parent_id IS NOT NULL;
@@ -0,0 +1,44 @@
+-- Price history and scheduled price changes.
+--
+-- Every price a product has had, or is scheduled to have, is a row with an
+-- effective window. Windows may overlap: the effective price at a moment is
+-- the one from the most recently started window that is still open, so a
+-- week-long sale can be laid over an open-ended base price, and a manual
+-- change (starting now) beats whatever started before it.
+-- products.price mirrors the effective price for catalog reads and is kept
+-- in sync by the core-api price scheduler.
+
+CREATE TABLE IF NOT EXISTS product_prices (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    price DECIMAL(10, 2) NOT NULL CHECK (price >= 0),
+    effective_from TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- NULL means open-ended.
+    effective_to TIMESTAMP WITH TIME ZONE,
+    reason TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK (effective_to IS NULL OR effective_to > effective_from)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_prices_product
+    ON product_prices(product_id, effective_from DESC, created_at DESC);
+
+-- Seed the history with the current prices.
+INSERT INTO product_prices (product_id, price, effective_from, reason)
+SELECT p.id, p.price, COALESCE(p.created_at, NOW()), 'initial price'
+FROM products p
+WHERE NOT EXISTS (SELECT 1 FROM product_prices pp WHERE pp.product_id = p.id);
+
+-- The effective price of a product at a moment, or NULL if it has no
+-- price window covering it.
+CREATE OR REPLACE FUNCTION product_effective_price(p_product_id UUID, p_at TIMESTAMP WITH TIME ZONE)
+RETURNS DECIMAL(10, 2) AS $$
+    SELECT pp.price
+    FROM product_prices pp
+    WHERE pp.product_id = p_product_id
+      AND pp.effective_from <= p_at
+      AND (pp.effective_to IS NULL OR pp.effective_to > p_at)
+    ORDER BY pp.effective_from DESC, pp.created_at DESC
+    LIMIT 1
+$$ LANGUAGE SQL STABLE;
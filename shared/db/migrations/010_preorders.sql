@@ -0,0 +1,38 @@
+-- Pre-orders and backorders.
+--
+-- A product's sell mode decides what happens to an order line that cannot be
+-- reserved from available stock:
+--   in_stock_only  the order is cancelled as out of stock (the default)
+--   preorder       the line waits for the release date and incoming stock,
+--                  up to preorder_limit units (NULL means no cap)
+--   backorder      the line waits for incoming stock
+-- Waiting lines are kept in inventory_allocations and filled first in, first
+-- out by the inventory-service allocation job; the order sits in preordered
+-- or backordered until its last line is filled and then goes on to payment.
+
+ALTER TABLE products
+    ADD COLUMN IF NOT EXISTS sell_mode VARCHAR(20) NOT NULL DEFAULT 'in_stock_only'
+        CHECK (sell_mode IN ('in_stock_only', 'preorder', 'backorder')),
+    ADD COLUMN IF NOT EXISTS release_date TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS preorder_limit INTEGER CHECK (preorder_limit > 0);
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN (
+    'pending', 'payment_required', 'preordered', 'backordered', 'paid', 'processing', 'shipped', 'delivered', 'cancelled', 'refunded'
+));
+
+CREATE TABLE IF NOT EXISTS inventory_allocations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('preorder', 'backorder')),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'allocated', 'cancelled')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    allocated_at TIMESTAMP WITH TIME ZONE
+);
+
+-- The allocation queue of a product, oldest first.
+CREATE INDEX IF NOT EXISTS idx_inventory_allocations_queue
+    ON inventory_allocations(product_id, created_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_inventory_allocations_order_id ON inventory_allocations(order_id);
@@ -0,0 +1,9 @@
+-- Admin stock adjustments.
+--
+-- Receipts, count corrections and write-offs change on_hand as well as
+-- available, so adjustments record both, and who made them.
+
+ALTER TABLE inventory_adjustments
+    ADD COLUMN IF NOT EXISTS on_hand_before INTEGER,
+    ADD COLUMN IF NOT EXISTS on_hand_after INTEGER,
+    ADD COLUMN IF NOT EXISTS created_by UUID REFERENCES users(id) ON DELETE SET NULL;
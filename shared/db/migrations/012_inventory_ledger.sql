@@ -0,0 +1,47 @@
+-- Inventory ledger.
+--
+-- Every change to an inventory row is recorded in inventory_adjustments with
+-- the row's available, reserved and on_hand before and after it, so summing
+-- (after - before) over a product's entries replays its current row:
+--   sale            units reserved for an order (available -> reserved)
+--   release         a cancelled or expired reservation (reserved -> available)
+--   fulfillment     a paid order leaving the warehouse (reserved and on_hand)
+--   reconciliation  drift between the ledger and the row, recorded by the
+--                   inventory-service reconciliation job
+-- Rows written before this migration did not record reserved (nor, before
+-- 011, on_hand); an opening reconciliation entry per product makes the
+-- ledger explain the current rows from here on.
+
+ALTER TABLE inventory_adjustments
+    ADD COLUMN IF NOT EXISTS reserved_before INTEGER,
+    ADD COLUMN IF NOT EXISTS reserved_after INTEGER;
+
+ALTER TABLE inventory_adjustments DROP CONSTRAINT IF EXISTS inventory_adjustments_adjustment_type_check;
+ALTER TABLE inventory_adjustments ADD CONSTRAINT inventory_adjustments_adjustment_type_check CHECK (adjustment_type IN (
+    'initial', 'purchase', 'sale', 'return', 'correction', 'adjustment', 'release', 'fulfillment', 'reconciliation'
+));
+
+-- A product's ledger, newest first.
+CREATE INDEX IF NOT EXISTS idx_inventory_adjustments_ledger
+    ON inventory_adjustments(product_id, created_at DESC, id DESC);
+
+-- A product's ledger replayed: the sum of the changes recorded for it.
+CREATE OR REPLACE FUNCTION inventory_ledger_balance(p_product_id UUID)
+RETURNS TABLE (available INTEGER, reserved INTEGER, on_hand INTEGER) AS $$
+    SELECT COALESCE(SUM(a.available_after - a.available_before), 0)::int,
+           COALESCE(SUM(a.reserved_after - a.reserved_before), 0)::int,
+           COALESCE(SUM(a.on_hand_after - a.on_hand_before), 0)::int
+    FROM inventory_adjustments a
+    WHERE a.product_id = p_product_id
+$$ LANGUAGE SQL STABLE;
+
+INSERT INTO inventory_adjustments (product_id, adjustment_type, quantity,
+                                   available_before, available_after,
+                                   reserved_before, reserved_after,
+                                   on_hand_before, on_hand_after, reason)
+SELECT i.product_id, 'reconciliation', i.on_hand - l.on_hand,
+       l.available, i.available, l.reserved, i.reserved, l.on_hand, i.on_hand,
+       'ledger opening balance'
+FROM inventory i
+CROSS JOIN LATERAL inventory_ledger_balance(i.product_id) l
+WHERE (l.available, l.reserved, l.on_hand) IS DISTINCT FROM (i.available, i.reserved, i.on_hand);
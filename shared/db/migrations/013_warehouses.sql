@@ -0,0 +1,90 @@
+-- Warehouses.
+--
+-- Stock is held per (product, warehouse) in warehouse_stock. The inventory
+-- row of a product stays the total across warehouses, so availability checks,
+-- the preorder queue and the ledger keep working on one row; warehouse_stock
+-- rows are only changed while holding that row's lock, in the same
+-- transaction. inventory.location is superseded by warehouse_stock.
+--
+-- Reservations record which warehouses they were taken from in
+-- order_item_sources, so releases and shipments go back to the same place.
+
+CREATE TABLE IF NOT EXISTS warehouses (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(50) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    -- ISO 3166-1 alpha-2 and state or province, matched against the
+    -- order's shipping address by the nearest-warehouse strategy.
+    country VARCHAR(2),
+    region VARCHAR(100),
+    -- Lower ships first; also the tie-break of the other strategies.
+    priority INTEGER NOT NULL DEFAULT 100,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS warehouse_stock (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+    available INTEGER NOT NULL DEFAULT 0 CHECK (available >= 0),
+    reserved INTEGER NOT NULL DEFAULT 0 CHECK (reserved >= 0),
+    on_hand INTEGER NOT NULL DEFAULT 0 CHECK (on_hand >= 0),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (product_id, warehouse_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_stock_warehouse_id ON warehouse_stock(warehouse_id);
+
+CREATE TABLE IF NOT EXISTS order_item_sources (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    -- Like inventory_adjustments.reference_id, not a foreign key: stock
+    -- is reserved for the order id carried by orders.created.
+    order_id UUID NOT NULL,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'reserved' CHECK (status IN ('reserved', 'released', 'fulfilled')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_item_sources_order_id ON order_item_sources(order_id, product_id);
+
+CREATE TABLE IF NOT EXISTS stock_transfers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    from_warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+    to_warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    reason TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (from_warehouse_id <> to_warehouse_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_transfers_product_id ON stock_transfers(product_id, created_at DESC);
+
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS shipping_country VARCHAR(2),
+    ADD COLUMN IF NOT EXISTS shipping_region VARCHAR(100);
+
+-- The warehouse of an admin adjustment; other entries change stock across
+-- warehouses (see order_item_sources).
+ALTER TABLE inventory_adjustments
+    ADD COLUMN IF NOT EXISTS warehouse_id UUID REFERENCES warehouses(id) ON DELETE SET NULL;
+
+-- Existing stock moves to one warehouse per inventory.location, or to "main".
+INSERT INTO warehouses (code, name, priority)
+SELECT DISTINCT COALESCE(NULLIF(location, ''), 'main'), COALESCE(NULLIF(location, ''), 'Main warehouse'), 0
+FROM inventory
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO warehouses (code, name, priority)
+VALUES ('main', 'Main warehouse', 0)
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO warehouse_stock (product_id, warehouse_id, available, reserved, on_hand)
+SELECT i.product_id, w.id, i.available, i.reserved, i.on_hand
+FROM inventory i
+JOIN warehouses w ON w.code = COALESCE(NULLIF(i.location, ''), 'main')
+ON CONFLICT (product_id, warehouse_id) DO NOTHING;
@@ -0,0 +1,14 @@
+-- Low-stock alerts.
+--
+-- A product is low on stock while available < low_stock_threshold (the
+-- condition of idx_inventory_low_stock). inventory-service sets
+-- low_stock_since when a product crosses into it and clears it when the
+-- product is restocked, publishing inventory.low_stock and
+-- inventory.restocked on each change. Products already low are announced by
+-- the first sweep after this migration.
+
+ALTER TABLE inventory ADD COLUMN IF NOT EXISTS low_stock_since TIMESTAMP WITH TIME ZONE;
+
+-- Recent sales per product, for the replenishment report.
+CREATE INDEX IF NOT EXISTS idx_inventory_adjustments_sales
+    ON inventory_adjustments(product_id, created_at) WHERE adjustment_type IN ('sale', 'release');
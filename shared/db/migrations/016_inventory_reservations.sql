@@ -0,0 +1,47 @@
+-- Durable reservations.
+--
+-- One row per reserved order line. A reservation is held from the moment
+-- stock is reserved and ends committed (the order was paid), released (it
+-- was cancelled) or expired (payment did not arrive by expires_at). Release
+-- and finalisation only act on held rows, so they are safe to repeat and no
+-- longer depend on the reservation keys in Redis, which remain an
+-- accelerator for expiry.
+--
+-- expires_at is NULL while the order still waits for preorder or backorder
+-- lines; the payment window starts when the last line is allocated.
+
+CREATE TABLE IF NOT EXISTS inventory_reservations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    -- Like order_item_sources.order_id, not a foreign key.
+    order_id UUID NOT NULL,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'held'
+        CHECK (status IN ('held', 'committed', 'released', 'expired')),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_reservations_order_id ON inventory_reservations(order_id);
+CREATE INDEX IF NOT EXISTS idx_inventory_reservations_expires_at
+    ON inventory_reservations(expires_at) WHERE status = 'held';
+
+-- Lines reserved for open orders, by their ledger entries: reserved and
+-- neither released nor fulfilled yet.
+INSERT INTO inventory_reservations (order_id, product_id, quantity, expires_at)
+SELECT oi.order_id, oi.product_id, oi.quantity,
+       CASE WHEN o.status = 'payment_required' THEN NOW() + INTERVAL '10 minutes' END
+FROM order_items oi
+JOIN orders o ON o.id = oi.order_id
+WHERE o.status IN ('payment_required', 'preordered', 'backordered')
+  AND EXISTS (
+    SELECT 1 FROM inventory_adjustments a
+    WHERE a.reference_id = oi.order_id AND a.product_id = oi.product_id AND a.adjustment_type = 'sale'
+  )
+  AND NOT EXISTS (
+    SELECT 1 FROM inventory_adjustments a
+    WHERE a.reference_id = oi.order_id AND a.product_id = oi.product_id
+      AND a.adjustment_type IN ('release', 'fulfillment')
+  )
+  AND NOT EXISTS (SELECT 1 FROM inventory_reservations r WHERE r.order_id = oi.order_id);
@@ -0,0 +1,27 @@
+-- Per-customer purchase limits.
+--
+-- A limit caps how many units of a product (with its variants) or of a
+-- category one customer may order, e.g. two per customer on launch day.
+-- Usage is counted from the customer's orders that are not cancelled, so
+-- cancelling an order gives its units back. With window_seconds the count
+-- covers a rolling window; without, it covers the limit's whole run from
+-- starts_at, e.g. a launch. A limit applies to orders placed between
+-- starts_at and ends_at when those are set.
+
+CREATE TABLE IF NOT EXISTS purchase_limits (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID REFERENCES products(id) ON DELETE CASCADE,
+    category VARCHAR(100),
+    max_quantity INTEGER NOT NULL CHECK (max_quantity > 0),
+    window_seconds INTEGER CHECK (window_seconds > 0),
+    starts_at TIMESTAMP WITH TIME ZONE,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK ((product_id IS NULL) <> (category IS NULL)),
+    CHECK (starts_at IS NULL OR ends_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_purchase_limits_product_id ON purchase_limits(product_id) WHERE product_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_purchase_limits_category ON purchase_limits(category) WHERE category IS NOT NULL;
@@ -0,0 +1,73 @@
+-- Raffles.
+--
+-- A raffle sells a scarce product to entrants drawn at random instead of
+-- first come, first served. Customers enter between entries_open_at and
+-- entries_close_at; at draw_at inventory-service ranks every entry and
+-- offers one unit each to the first `quantity` of them. An offer holds the
+-- unit (available -> reserved, a 'sale' ledger entry referencing the
+-- entry) for claim_window_seconds, during which only that customer can
+-- order it. Offers that lapse, and claims whose order is cancelled, pass
+-- the unit on to the next entry in rank order.
+--
+-- The draw is reproducible: seed_hash (hex SHA-256 of seed) is published
+-- when the raffle is created and the seed once it is drawn. An entry's
+-- draw_hash is hex SHA-256 of the seed followed by the entry id in its
+-- canonical text form; entries are ranked by draw_hash, then id.
+--
+-- Holds are taken from the product's inventory row only; warehouses are
+-- picked when the winner's order reserves the unit. While a raffle is
+-- scheduled or drawn its product can only be ordered by its winners.
+
+CREATE TABLE IF NOT EXISTS raffles (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    entries_open_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    entries_close_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    draw_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    claim_window_seconds INTEGER NOT NULL CHECK (claim_window_seconds > 0),
+    seed BYTEA NOT NULL,
+    seed_hash VARCHAR(64) NOT NULL,
+    -- scheduled -> drawn -> completed; cancelled by an admin before it
+    -- completes.
+    status VARCHAR(20) NOT NULL DEFAULT 'scheduled'
+        CHECK (status IN ('scheduled', 'drawn', 'completed', 'cancelled')),
+    drawn_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK (entries_close_at > entries_open_at),
+    CHECK (draw_at >= entries_close_at)
+);
+
+-- One live raffle per product.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_raffles_live_product
+    ON raffles(product_id) WHERE status IN ('scheduled', 'drawn');
+CREATE INDEX IF NOT EXISTS idx_raffles_due
+    ON raffles(draw_at) WHERE status IN ('scheduled', 'drawn');
+
+CREATE TABLE IF NOT EXISTS raffle_entries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    raffle_id UUID NOT NULL REFERENCES raffles(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- Set by the draw; rank 1 is offered first.
+    draw_hash VARCHAR(64),
+    rank INTEGER,
+    --   entered    waiting for the draw or for a unit to pass on
+    --   won        offered a held unit until claim_expires_at
+    --   claimed    ordered the unit as order_id
+    --   purchased  the order was paid
+    --   forfeited  the offer lapsed or the order was cancelled
+    --   lost       the raffle ended before a unit reached the entry
+    status VARCHAR(20) NOT NULL DEFAULT 'entered'
+        CHECK (status IN ('entered', 'won', 'claimed', 'purchased', 'forfeited', 'lost')),
+    won_at TIMESTAMP WITH TIME ZONE,
+    claim_expires_at TIMESTAMP WITH TIME ZONE,
+    order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (raffle_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_raffle_entries_rank ON raffle_entries(raffle_id, rank);
+CREATE INDEX IF NOT EXISTS idx_raffle_entries_user_id ON raffle_entries(user_id);
@@ -0,0 +1,166 @@
+-- Gift cards, store credit and the tender ledger.
+--
+-- Gift card and store credit balances live in an append-only double-entry
+-- ledger. Every ledger_transaction has two or more ledger_entries whose
+-- amounts sum to zero, and an account's balance_cents is the sum of its
+-- entries, kept by trigger. Accounts held for customers (gift cards, store
+-- credit) and the held account never go negative; the funding accounts go
+-- negative by the value they have put into circulation:
+--   gift_card     one per card
+--   store_credit  one per customer and currency
+--   promotions    funds cards issued by an admin
+--   sales         funds cards a customer paid for
+--   refunds       funds store credit issued on refunds
+--   held          tender set aside for an unpaid order
+--   redeemed      tender applied to a paid order
+-- Transactions:
+--   issue     promotions -> gift_card
+--   purchase  sales -> gift_card, once the provider charge succeeds
+--   hold      gift_card / store_credit -> held, when an order is placed
+--   capture   held -> redeemed, when the order is paid
+--   release   held -> gift_card / store_credit, when it is cancelled
+--   refund    refunds -> store_credit
+-- Entries and transactions cannot be updated or deleted; a mistake is
+-- corrected with another transaction.
+
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(20) NOT NULL CHECK (kind IN (
+        'gift_card', 'store_credit', 'promotions', 'sales', 'refunds', 'held', 'redeemed'
+    )),
+    -- The customer holding a store_credit account.
+    user_id UUID REFERENCES users(id) ON DELETE RESTRICT,
+    currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+    balance_cents BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT ledger_accounts_balance_check
+        CHECK (kind IN ('promotions', 'sales', 'refunds', 'redeemed') OR balance_cents >= 0),
+    CHECK ((kind = 'store_credit') = (user_id IS NOT NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_accounts_store_credit
+    ON ledger_accounts(user_id, currency) WHERE kind = 'store_credit';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_accounts_system
+    ON ledger_accounts(kind, currency) WHERE kind IN ('promotions', 'sales', 'refunds', 'held', 'redeemed');
+
+INSERT INTO ledger_accounts (kind, currency)
+SELECT k, 'USD' FROM unnest(ARRAY['promotions', 'sales', 'refunds', 'held', 'redeemed']) AS k
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('issue', 'purchase', 'hold', 'capture', 'release', 'refund')),
+    -- Not a foreign key: the ledger outlives the orders it refers to.
+    order_id UUID,
+    -- Provider payment id of a purchase, or the reason for a refund.
+    reference TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_transactions_order_id
+    ON ledger_transactions(order_id) WHERE order_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_id UUID NOT NULL REFERENCES ledger_transactions(id),
+    account_id UUID NOT NULL REFERENCES ledger_accounts(id),
+    amount_cents BIGINT NOT NULL CHECK (amount_cents <> 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- An account's statement, newest first.
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account
+    ON ledger_entries(account_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_transaction_id
+    ON ledger_entries(transaction_id);
+
+CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_transactions_append_only ON ledger_transactions;
+CREATE TRIGGER ledger_transactions_append_only
+    BEFORE UPDATE OR DELETE ON ledger_transactions
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+CREATE TRIGGER ledger_entries_append_only
+    BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+-- Entries move the balance; the balance check rejects an overdraft.
+CREATE OR REPLACE FUNCTION ledger_entries_apply() RETURNS trigger AS $$
+BEGIN
+    UPDATE ledger_accounts
+    SET balance_cents = balance_cents + NEW.amount_cents, updated_at = NOW()
+    WHERE id = NEW.account_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_apply ON ledger_entries;
+CREATE TRIGGER ledger_entries_apply
+    AFTER INSERT ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_entries_apply();
+
+-- Only ledger_entries_apply may move a balance.
+CREATE OR REPLACE FUNCTION ledger_accounts_guard_balance() RETURNS trigger AS $$
+BEGIN
+    IF NEW.balance_cents IS DISTINCT FROM OLD.balance_cents AND pg_trigger_depth() < 2 THEN
+        RAISE EXCEPTION 'ledger account balances change only through ledger_entries';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_accounts_guard_balance ON ledger_accounts;
+CREATE TRIGGER ledger_accounts_guard_balance
+    BEFORE UPDATE ON ledger_accounts
+    FOR EACH ROW EXECUTE FUNCTION ledger_accounts_guard_balance();
+
+-- A transaction's entries must sum to zero by the time it commits.
+CREATE OR REPLACE FUNCTION ledger_entries_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount_cents) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % does not balance', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_balanced ON ledger_entries;
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+    AFTER INSERT ON ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_entries_balanced();
+
+CREATE TABLE IF NOT EXISTS gift_cards (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    account_id UUID NOT NULL UNIQUE REFERENCES ledger_accounts(id),
+    -- Hex SHA-256 of the code; the code itself is shown once, at issue.
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    last4 VARCHAR(4) NOT NULL,
+    currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+    initial_amount_cents BIGINT NOT NULL CHECK (initial_amount_cents > 0),
+    source VARCHAR(20) NOT NULL CHECK (source IN ('admin', 'purchase')),
+    --   pending   bought, waiting for payment-service to charge the buyer
+    --   active    funded and spendable
+    --   failed    the purchase charge failed; never funded
+    --   disabled  blocked by an admin; its balance stays on the ledger
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'active', 'failed', 'disabled')),
+    purchased_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    issued_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    note TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    activated_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_gift_cards_purchased_by ON gift_cards(purchased_by) WHERE purchased_by IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_gift_cards_created_at ON gift_cards(created_at DESC, id DESC);
@@ -0,0 +1,10 @@
+-- Revoke sessions on password change and erasure.
+--
+-- Access tokens carry the token_version of the user they were issued to and
+-- are only accepted while it is still current. Changing the password or
+-- erasing the account bumps it, so tokens issued before stop working at
+-- once instead of when they expire. Tokens issued before this migration
+-- carry no version and are read as 0.
+
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,22 @@
+-- Single-use two-factor login challenges and a lockout after failed codes.
+--
+-- Login hands out a challenge token naming a row here; the row is consumed
+-- by the first attempt to complete the login, right or wrong, so a
+-- challenge cannot be replayed to keep guessing. Failed codes are counted
+-- per user across challenges: once two_factor_failures reaches the
+-- configured limit the account's second factor is locked until
+-- two_factor_locked_until and the count starts over.
+
+CREATE TABLE IF NOT EXISTS two_factor_challenges (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_two_factor_challenges_user_id ON two_factor_challenges(user_id);
+
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS two_factor_failures INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS two_factor_locked_until TIMESTAMP WITH TIME ZONE;
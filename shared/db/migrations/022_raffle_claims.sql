@@ -0,0 +1,17 @@
+-- Raffle claims are taken by the order.
+--
+-- core-api marks an offer claimed in the transaction that creates the
+-- order, so the raffle job cannot forfeit it while inventory-service has
+-- yet to reserve the order. handed_over_at records when inventory-service
+-- gave the held unit back to available for the order to reserve; a claim
+-- whose order is cancelled before that still holds its unit, and the raffle
+-- job releases it when it forfeits the claim.
+--
+-- Claims made before this migration were handed over as they were made.
+
+ALTER TABLE raffle_entries
+  ADD COLUMN IF NOT EXISTS handed_over_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE raffle_entries
+SET handed_over_at = updated_at
+WHERE status IN ('claimed', 'purchased') AND handed_over_at IS NULL;
@@ -0,0 +1,11 @@
+-- Raffle draws rank entrants by user.
+--
+-- An entry's draw_hash is now hex SHA-256 of the seed followed by the
+-- entrant's user id in its canonical text form, and entries are ranked by
+-- draw_hash, then user id. Entry ids are generated after seed_hash is
+-- published, so whoever knows the seed could have picked them to suit it;
+-- user ids are not chosen for the raffle. Raffles drawn before this
+-- migration keep the hashes of their entry ids.
+
+COMMENT ON COLUMN raffle_entries.draw_hash IS
+  'hex SHA-256 of the raffle seed followed by the user id; entries are ranked by it, then user id';
@@ -3,15 +3,22 @@ package events
 type Type string
 
 const (
-	TypeOrdersCreated    Type = "orders.created"
-	TypeOrdersPaid       Type = "orders.paid"
-	TypeOrdersCancelled  Type = "orders.cancelled"
+	TypeOrdersCreated   Type = "orders.created"
+	TypeOrdersPaid      Type = "orders.paid"
+	TypeOrdersCancelled Type = "orders.cancelled"
 
-	TypeInventoryReserved   Type = "inventory.reserved"
-	TypeInventoryReleased   Type = "inventory.released"
-	TypeInventoryOutOfStock Type = "inventory.out_of_stock"
+	TypeInventoryReserved      Type = "inventory.reserved"
+	TypeInventoryReleased      Type = "inventory.released"
+	TypeInventoryOutOfStock    Type = "inventory.out_of_stock"
+	TypeInventoryAwaitingStock Type = "inventory.awaiting_stock"
+	TypeInventoryAdjusted      Type = "inventory.adjusted"
+	TypeInventoryLowStock      Type = "inventory.low_stock"
+	TypeInventoryRestocked     Type = "inventory.restocked"
 
 	TypePaymentsSucceeded Type = "payments.succeeded"
 	TypePaymentsFailed    Type = "payments.failed"
-)
 
+	TypeProductsUpdated Type = "products.updated"
+
+	TypeGiftCardsPurchased Type = "gift_cards.purchased"
+)
@@ -12,5 +12,18 @@ const (
 	TopicInventoryReserved   = "inventory.reserved"
 	TopicInventoryReleased   = "inventory.released"
 	TopicInventoryOutOfStock = "inventory.out_of_stock"
-)
+	TopicInventoryAdjusted   = "inventory.adjusted"
+	// TopicInventoryAwaitingStock announces orders queued as preorders or
+	// backorders; they continue with inventory.reserved once filled.
+	TopicInventoryAwaitingStock = "inventory.awaiting_stock"
+	// TopicInventoryLowStock and TopicInventoryRestocked announce a product
+	// crossing its low_stock_threshold, down and back up.
+	TopicInventoryLowStock  = "inventory.low_stock"
+	TopicInventoryRestocked = "inventory.restocked"
+
+	TopicProductsUpdated = "products.updated"
 
+	// TopicGiftCardsPurchased carries gift cards waiting for payment-service
+	// to charge the buyer.
+	TopicGiftCardsPurchased = "gift_cards.purchased"
+)
@@ -6,13 +6,13 @@ type OrderItem struct {
 }
 
 type OrdersCreatedData struct {
-	OrderID   string     `json:"order_id"`
-	UserID    string     `json:"user_id"`
-	Items     []OrderItem `json:"items"`
-	Subtotal  float64    `json:"subtotal"`
-	Tax       float64    `json:"tax"`
-	Total     float64    `json:"total"`
-	Currency  string     `json:"currency"`
+	OrderID  string      `json:"order_id"`
+	UserID   string      `json:"user_id"`
+	Items    []OrderItem `json:"items"`
+	Subtotal float64     `json:"subtotal"`
+	Tax      float64     `json:"tax"`
+	Total    float64     `json:"total"`
+	Currency string      `json:"currency"`
 }
 
 type InventoryReservedData struct {
@@ -24,6 +24,37 @@ type InventoryOutOfStockData struct {
 	Reason  string `json:"reason,omitempty"`
 }
 
+// InventoryAwaitingStockData lists the lines of an order that wait for
+// stock; the others are already reserved.
+type InventoryAwaitingStockData struct {
+	OrderID  string      `json:"order_id"`
+	Preorder bool        `json:"preorder"`
+	Waiting  []OrderItem `json:"waiting"`
+}
+
+// InventoryAdjustedData describes a stock receipt, count correction or
+// write-off. Quantity is the signed change to on_hand.
+type InventoryAdjustedData struct {
+	AdjustmentID    string `json:"adjustment_id"`
+	ProductID       string `json:"product_id"`
+	AdjustmentType  string `json:"adjustment_type"`
+	Quantity        int    `json:"quantity"`
+	AvailableBefore int    `json:"available_before"`
+	AvailableAfter  int    `json:"available_after"`
+	OnHandBefore    int    `json:"on_hand_before"`
+	OnHandAfter     int    `json:"on_hand_after"`
+	Reason          string `json:"reason,omitempty"`
+	ReferenceID     string `json:"reference_id,omitempty"`
+}
+
+// InventoryStockLevelData is carried by inventory.low_stock and
+// inventory.restocked.
+type InventoryStockLevelData struct {
+	ProductID         string `json:"product_id"`
+	Available         int    `json:"available"`
+	LowStockThreshold int    `json:"low_stock_threshold"`
+}
+
 type InventoryReleasedData struct {
 	OrderID string `json:"order_id"`
 	Reason  string `json:"reason,omitempty"`
@@ -49,3 +80,32 @@ type OrdersCancelledData struct {
 	Reason  string `json:"reason,omitempty"`
 }
 
+// Product change actions carried in ProductsUpdatedData.Action.
+const (
+	ProductActionCreated     = "created"
+	ProductActionUpdated     = "updated"
+	ProductActionActivated   = "activated"
+	ProductActionDeactivated = "deactivated"
+	ProductActionDeleted     = "deleted"
+	// ProductActionPriceChanged is sent when a scheduled price window
+	// starts or ends.
+	ProductActionPriceChanged = "price_changed"
+)
+
+type ProductsUpdatedData struct {
+	ProductID string  `json:"product_id"`
+	SKU       string  `json:"sku"`
+	Action    string  `json:"action"`
+	Price     float64 `json:"price"`
+	IsActive  bool    `json:"is_active"`
+	Deleted   bool    `json:"deleted,omitempty"`
+}
+
+// GiftCardsPurchasedData announces a gift card bought by a customer;
+// payment-service charges them and funds the card.
+type GiftCardsPurchasedData struct {
+	GiftCardID  string `json:"gift_card_id"`
+	UserID      string `json:"user_id"`
+	AmountCents int64  `json:"amount_cents"`
+	Currency    string `json:"currency"`
+}
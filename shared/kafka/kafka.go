@@ -35,6 +35,26 @@ func (p *Producer) Publish(ctx context.Context, topic string, key, value []byte)
 	})
 }
 
+// Message is one record for PublishBatch.
+type Message struct {
+	Key   []byte
+	Value []byte
+}
+
+// PublishBatch writes msgs to topic in a single round trip, which matters
+// for bulk operations given the writer's batch timeout.
+func (p *Producer) PublishBatch(ctx context.Context, topic string, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	out := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = kafka.Message{Topic: topic, Key: m.Key, Value: m.Value, Time: now}
+	}
+	return p.w.WriteMessages(ctx, out...)
+}
+
 func (p *Producer) Close() error { return p.w.Close() }
 
 type Consumer struct {
@@ -65,4 +85,3 @@ func (c *Consumer) Commit(ctx context.Context, msg kafka.Message) error {
 	return c.r.CommitMessages(ctx, msg)
 }
 func (c *Consumer) Close() error { return c.r.Close() }
-
@@ -0,0 +1,66 @@
+// Package ledger writes to the double-entry ledger behind gift cards and
+// store credit (migration 019). The migration's triggers move account
+// balances as entries are written and reject a transaction at commit
+// unless its entries sum to zero, so every caller posts through here.
+package ledger
+
+import (
+	"context"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Transaction describes a ledger transaction. OrderID, Reference and
+// CreatedBy are optional.
+type Transaction struct {
+	Kind      string
+	OrderID   *uuid.UUID
+	Reference string
+	CreatedBy *uuid.UUID
+}
+
+// Posting moves Cents into an account, or out of it when negative.
+type Posting struct {
+	AccountID uuid.UUID
+	Cents     int64
+}
+
+// Post writes t and its postings in tx and returns the transaction's id.
+func Post(ctx context.Context, tx pgx.Tx, t Transaction, postings ...Posting) (uuid.UUID, error) {
+	var id uuid.UUID
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO ledger_transactions (kind, order_id, reference, created_by)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		RETURNING id
+	`, t.Kind, t.OrderID, t.Reference, t.CreatedBy).Scan(&id); err != nil {
+		return uuid.UUID{}, err
+	}
+	for _, p := range postings {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO ledger_entries (transaction_id, account_id, amount_cents) VALUES ($1, $2, $3)
+		`, id, p.AccountID, p.Cents); err != nil {
+			return uuid.UUID{}, err
+		}
+	}
+	return id, nil
+}
+
+// SystemAccount returns the id of the system account of kind (promotions,
+// sales, refunds, held or redeemed) in currency.
+func SystemAccount(ctx context.Context, tx pgx.Tx, kind, currency string) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := tx.QueryRow(ctx, `SELECT id FROM ledger_accounts WHERE kind = $1 AND currency = $2`, kind, currency).Scan(&id)
+	return id, err
+}
+
+// ToCents converts an amount in currency units to the ledger's cents.
+func ToCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// FromCents converts ledger cents to an amount in currency units.
+func FromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
@@ -0,0 +1,20 @@
+package ledger
+
+import "testing"
+
+func TestCents(t *testing.T) {
+	for amount, want := range map[float64]int64{
+		0:      0,
+		19.99:  1999,
+		0.1:    10,
+		1099.5: 109950,
+		-12.34: -1234,
+	} {
+		if got := ToCents(amount); got != want {
+			t.Errorf("ToCents(%v) = %d, want %d", amount, got, want)
+		}
+	}
+	if got := FromCents(1999); got != 19.99 {
+		t.Errorf("FromCents(1999) = %v, want 19.99", got)
+	}
+}
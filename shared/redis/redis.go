@@ -2,6 +2,8 @@ package redis
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"time"
 
@@ -29,3 +31,16 @@ func Key(prefix, id string) string {
 	return fmt.Sprintf("%s:%s", prefix, id)
 }
 
+// ProcessedEventKey is the dedupe key for an event handled by consumer.
+// Services that consume the same topic share Redis, so each needs keys of
+// its own or the first to handle an event would hide it from the rest.
+func ProcessedEventKey(consumer, eventID string) string {
+	sum := sha256.Sum256([]byte(eventID))
+	return "processed:event:" + consumer + ":" + hex.EncodeToString(sum[:])
+}
+
+// MarkEventProcessed records that consumer has handled the event and reports
+// whether it is the first to do so.
+func MarkEventProcessed(ctx context.Context, c *redis.Client, consumer, eventID string, ttl time.Duration) (bool, error) {
+	return c.SetNX(ctx, ProcessedEventKey(consumer, eventID), "1", ttl).Result()
+}
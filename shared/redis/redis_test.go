@@ -0,0 +1,71 @@
+package redis
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+)
+
+func TestProcessedEventKey(t *testing.T) {
+	eventID := uuid.NewString()
+	if ProcessedEventKey("payment-service", eventID) == ProcessedEventKey("inventory-service", eventID) {
+		t.Fatal("two consumers share a dedupe key")
+	}
+	if ProcessedEventKey("payment-service", eventID) != ProcessedEventKey("payment-service", eventID) {
+		t.Fatal("dedupe key is not stable")
+	}
+}
+
+// TestMarkEventProcessed_PerConsumer has two services see the same event, as
+// payment-service and inventory-service both do for orders.cancelled.
+func TestMarkEventProcessed_PerConsumer(t *testing.T) {
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	// A database of its own, away from a running service's keys.
+	db, _ := strconv.Atoi(os.Getenv("TEST_REDIS_DB"))
+	if db == 0 {
+		db = 15
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := redis.NewClient(&redis.Options{Addr: addr, DB: db})
+	t.Cleanup(func() { _ = c.Close() })
+	if err := c.Ping(ctx).Err(); err != nil {
+		t.Skipf("skipping integration test: cannot reach redis (%v)", err)
+	}
+
+	eventID := uuid.NewString()
+	consumers := []string{"payment-service", "inventory-service"}
+	t.Cleanup(func() {
+		for _, consumer := range consumers {
+			_ = c.Del(context.Background(), ProcessedEventKey(consumer, eventID)).Err()
+		}
+	})
+
+	for _, consumer := range consumers {
+		first, err := MarkEventProcessed(ctx, c, consumer, eventID, time.Minute)
+		if err != nil {
+			t.Fatalf("%s: mark: %v", consumer, err)
+		}
+		if !first {
+			t.Fatalf("%s skipped an event another service handled", consumer)
+		}
+	}
+	for _, consumer := range consumers {
+		again, err := MarkEventProcessed(ctx, c, consumer, eventID, time.Minute)
+		if err != nil {
+			t.Fatalf("%s: mark again: %v", consumer, err)
+		}
+		if again {
+			t.Fatalf("%s handled a redelivered event twice", consumer)
+		}
+	}
+}